@@ -0,0 +1,248 @@
+// Package cli implements the command line interface of the invoice service.
+//
+// Usage:
+//
+//	invoices [global flags] <command> [flags] [args]
+//
+// Global flags select the repository backend and output format, see Run.
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/antklim/go-dynamodb/dynamo"
+	"github.com/antklim/go-dynamodb/dynamo/dump"
+	"github.com/antklim/go-dynamodb/invoice"
+	"github.com/antklim/go-dynamodb/invoice/invoiceiface"
+	"github.com/antklim/go-dynamodb/memory"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Supported backends.
+const (
+	BackendDynamo = "dynamo"
+	BackendMemory = "memory"
+)
+
+// Supported output formats.
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+)
+
+// ErrUsage is returned when command line arguments are invalid. The usage
+// is already printed when Run returns it.
+var ErrUsage = errors.New("invalid usage")
+
+var errNoRepository = errors.New("command requires repository, CLI was created with service only")
+
+// Config holds values of global flags.
+type Config struct {
+	Backend  string
+	Table    string
+	Region   string
+	Endpoint string
+	Output   string
+	State    string // file keeping the memory repository between runs
+	Dump     string // DynamoDB JSON dump the memory repository starts from
+}
+
+// command runs a subcommand with its own arguments.
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, c *CLI, args []string) error
+}
+
+// CLI runs commands against the invoice service.
+type CLI struct {
+	out  io.Writer
+	repo invoice.Repository // used by commands working with rows, e.g. seed
+	svc  invoiceiface.Service
+	cfg  Config
+}
+
+// Option configures CLI.
+type Option func(*CLI)
+
+// WithRepository makes CLI use repo instead of the repository built from
+// backend flags.
+func WithRepository(repo invoice.Repository) Option {
+	return func(c *CLI) {
+		c.repo = repo
+	}
+}
+
+// WithService makes CLI use svc instead of the service of the repository.
+func WithService(svc invoiceiface.Service) Option {
+	return func(c *CLI) {
+		c.svc = svc
+	}
+}
+
+// New creates CLI writing command results and usage to out.
+func New(out io.Writer, opts ...Option) *CLI {
+	c := &CLI{out: out}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Run parses global flags and runs the command.
+func (c *CLI) Run(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("invoices", flag.ContinueOnError)
+	fs.SetOutput(c.out)
+	fs.StringVar(&c.cfg.Backend, "backend", BackendDynamo,
+		"repository backend: dynamo or memory, memory keeps data for a single run unless --state is set")
+	fs.StringVar(&c.cfg.Table, "table", "invoices", "DynamoDB table name")
+	fs.StringVar(&c.cfg.Region, "region", "ap-southeast-2", "AWS region")
+	fs.StringVar(&c.cfg.Endpoint, "endpoint", "", "DynamoDB endpoint, e.g. http://localhost:8000 for local DynamoDB")
+	fs.StringVar(&c.cfg.Output, "output", OutputTable, "output format: table or json")
+	fs.StringVar(&c.cfg.State, "state", "", "file the memory backend is loaded from and saved to, .gob or JSON")
+	fs.StringVar(&c.cfg.Dump, "dump", "", "DynamoDB JSON or JSON Lines (.jsonl) dump the memory backend starts from")
+	fs.Usage = func() { c.usage(fs) }
+
+	if err := fs.Parse(args); err != nil {
+		return usageError(err)
+	}
+	if c.cfg.Output != OutputTable && c.cfg.Output != OutputJSON {
+		fmt.Fprintf(c.out, "unknown output format %q\n", c.cfg.Output)
+		return ErrUsage
+	}
+
+	name := strings.Join(fs.Args(), " ")
+	cmd, rest := findCommand(fs.Args())
+	if cmd == nil {
+		if name != "" {
+			fmt.Fprintf(c.out, "unknown command %q\n", name)
+		}
+		c.usage(fs)
+		return ErrUsage
+	}
+
+	if c.repo == nil && c.svc == nil {
+		repo, err := newRepository(c.cfg)
+		if err != nil {
+			return err
+		}
+		c.repo = repo
+	}
+	if c.svc == nil {
+		c.svc = invoice.NewService(c.repo)
+	}
+
+	if err := cmd.run(ctx, c, rest); err != nil {
+		return err
+	}
+
+	if repo, ok := c.repo.(*memory.Repository); ok && c.cfg.State != "" {
+		return repo.Save(c.cfg.State)
+	}
+	return nil
+}
+
+func (c *CLI) usage(fs *flag.FlagSet) {
+	fmt.Fprintln(c.out, "Usage: invoices [global flags] <command> [flags] [args]")
+	fmt.Fprintln(c.out, "\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(c.out, "  %-16s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(c.out, "\nGlobal flags:")
+	fs.PrintDefaults()
+}
+
+// findCommand returns the command named by the leading args and the rest
+// of args.
+func findCommand(args []string) (*command, []string) {
+	for idx := range commands {
+		words := strings.Fields(commands[idx].name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == commands[idx].name {
+			return &commands[idx], args[len(words):]
+		}
+	}
+	return nil, nil
+}
+
+func newRepository(cfg Config) (invoice.Repository, error) {
+	switch cfg.Backend {
+	case BackendMemory:
+		return newMemoryRepository(cfg)
+	case BackendDynamo:
+		client, err := newDynamoClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return dynamo.NewRepository(client, cfg.Table), nil
+	default:
+		return nil, fmt.Errorf("%w: unknown backend %q", ErrUsage, cfg.Backend)
+	}
+}
+
+func newDynamoClient(cfg Config) (*dynamodb.DynamoDB, error) {
+	awsCfg := aws.NewConfig().WithRegion(cfg.Region)
+	if cfg.Endpoint != "" {
+		awsCfg = awsCfg.WithEndpoint(cfg.Endpoint)
+	}
+	sess, err := session.NewSession(awsCfg)
+	if err != nil {
+		return nil, err
+	}
+	return dynamodb.New(sess), nil
+}
+
+// newMemoryRepository starts the memory repository from the state file if
+// it exists, otherwise from the dump if it is set.
+func newMemoryRepository(cfg Config) (invoice.Repository, error) {
+	repo := memory.NewRepository()
+
+	if cfg.State != "" {
+		err := repo.Load(cfg.State)
+		if err == nil {
+			return repo, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	if cfg.Dump != "" {
+		f, err := os.Open(cfg.Dump)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		format := dump.FormatJSON
+		if filepath.Ext(cfg.Dump) == ".jsonl" {
+			format = dump.FormatJSONL
+		}
+		dec, err := dump.NewDecoder(f, format)
+		if err != nil {
+			return nil, err
+		}
+		st, err := dump.MemoryState(dec)
+		if err != nil {
+			return nil, err
+		}
+		repo.Restore(st)
+	}
+
+	return repo, nil
+}
+
+func usageError(err error) error {
+	if errors.Is(err, flag.ErrHelp) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrUsage, err)
+}
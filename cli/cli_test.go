@@ -0,0 +1,163 @@
+package cli_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/antklim/go-dynamodb/cli"
+	"github.com/antklim/go-dynamodb/invoice"
+	"github.com/antklim/go-dynamodb/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommands(t *testing.T) {
+	svc := invoice.NewService(memory.NewRepository())
+	run := func(args ...string) (string, error) {
+		var out bytes.Buffer
+		err := cli.New(&out, cli.WithService(svc)).Run(context.Background(), args)
+		return out.String(), err
+	}
+
+	out, err := run("--output", "json", "invoice", "create", "--id", "inv1", "--customer", "John Doe")
+	require.NoError(t, err)
+	var inv invoice.Invoice
+	require.NoError(t, json.Unmarshal([]byte(out), &inv))
+	assert.Equal(t, "inv1", inv.ID)
+	assert.Equal(t, "John Doe", inv.CustomerName)
+
+	_, err = run("item", "add", "--id", "i1", "--name", "Guitar", "--price", "75000", "inv1")
+	require.NoError(t, err)
+	_, err = run("item", "add", "--id", "i2", "--name", "Pick", "--price", "100", "--qty", "2", "inv1")
+	require.NoError(t, err)
+
+	out, err = run("invoice", "get", "inv1")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Customer:  John Doe")
+	assert.Contains(t, out, "Total:     75200")
+	assert.Contains(t, out, "Guitar")
+
+	_, err = run("item", "cancel", "inv1", "i2")
+	require.NoError(t, err)
+
+	out, err = run("--output", "json", "items", "list", "--status", "cancelled", "--invoice", "inv1")
+	require.NoError(t, err)
+	var items []invoice.Item
+	require.NoError(t, json.Unmarshal([]byte(out), &items))
+	require.Len(t, items, 1)
+	assert.Equal(t, "i2", items[0].ID)
+
+	file := filepath.Join(t.TempDir(), "items.json")
+	err = os.WriteFile(file, []byte(`[{"ID":"i3","Name":"Drums","Price":1000,"Qty":1,"Status":"NEW"}]`), 0o600)
+	require.NoError(t, err)
+	out, err = run("--output", "json", "replace", "--old", "i1", "--file", file, "inv1")
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal([]byte(out), &inv))
+	assert.Equal(t, uint(1000), inv.Total)
+
+	_, err = run("item", "delete", "inv1", "i3")
+	require.NoError(t, err)
+	_, err = run("item", "get", "inv1", "i3")
+	assert.ErrorIs(t, err, invoice.ErrItemNotFound)
+
+	_, err = run("invoice", "cancel", "inv1")
+	require.NoError(t, err)
+	out, err = run("--output", "json", "invoice", "get", "inv1")
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal([]byte(out), &inv))
+	assert.Equal(t, invoice.Cancelled, inv.Status)
+}
+
+func TestUsage(t *testing.T) {
+	var out bytes.Buffer
+	c := cli.New(&out, cli.WithService(invoice.NewService(memory.NewRepository())))
+
+	err := c.Run(context.Background(), []string{"invoice", "unknown"})
+	assert.ErrorIs(t, err, cli.ErrUsage)
+	assert.Contains(t, out.String(), "items list")
+
+	err = c.Run(context.Background(), []string{"item", "get", "inv1"})
+	assert.ErrorIs(t, err, cli.ErrUsage)
+
+	err = c.Run(context.Background(), []string{"items", "list"})
+	assert.ErrorIs(t, err, cli.ErrUsage)
+
+	err = c.Run(context.Background(), []string{"--output", "xml", "invoice", "get", "inv1"})
+	assert.ErrorIs(t, err, cli.ErrUsage)
+
+	err = cli.New(&out).Run(context.Background(), []string{"--backend", "unknown", "invoice", "get", "inv1"})
+	assert.ErrorIs(t, err, cli.ErrUsage)
+}
+
+func TestSeed(t *testing.T) {
+	repo := memory.NewRepository()
+	var out bytes.Buffer
+	c := cli.New(&out, cli.WithRepository(repo))
+
+	args := []string{"seed", "--invoices", "../data/invoices.json", "--items", "../data/items.json", "--dry-run"}
+	err := c.Run(context.Background(), args)
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "(dry run)")
+
+	items, err := repo.GetItemsByStatus(context.Background(), invoice.New)
+	require.NoError(t, err)
+	assert.Empty(t, items)
+
+	err = c.Run(context.Background(), args[:len(args)-1])
+	require.NoError(t, err)
+
+	items, err = repo.GetItemsByStatus(context.Background(), invoice.New)
+	require.NoError(t, err)
+	assert.NotEmpty(t, items)
+
+	err = c.Run(context.Background(), []string{"purge", "--all"})
+	assert.ErrorIs(t, err, cli.ErrUsage, "memory backend does not support purge")
+}
+
+func TestImport(t *testing.T) {
+	repo := memory.NewRepository()
+	var out bytes.Buffer
+	c := cli.New(&out, cli.WithRepository(repo))
+
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+	err := c.Run(context.Background(), []string{
+		"import", "--format", "json", "--file", "../data/db.json", "--checkpoint", checkpoint,
+	})
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "rows: 4, resumed after: 0")
+
+	raw, err := os.ReadFile(checkpoint)
+	require.NoError(t, err)
+	assert.Equal(t, "4", string(raw))
+
+	items, err := repo.GetInvoiceItems(context.Background(), "170bf55e-ca81-4a17-99ad-54f6411d610b")
+	require.NoError(t, err)
+	assert.Len(t, items, 3)
+
+	err = c.Run(context.Background(), []string{"export"})
+	assert.ErrorIs(t, err, cli.ErrUsage, "memory backend does not support export")
+}
+
+func TestMemoryState(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "state.json")
+	run := func(args ...string) (string, error) {
+		var out bytes.Buffer
+		err := cli.New(&out).Run(context.Background(), args)
+		return out.String(), err
+	}
+
+	_, err := run("--backend", "memory", "--state", state, "--dump", "../data/db.json", "items", "list", "--status", "NEW")
+	require.NoError(t, err)
+
+	out, err := run("--backend", "memory", "--state", state, "--output", "json",
+		"invoice", "get", "170bf55e-ca81-4a17-99ad-54f6411d610b")
+	require.NoError(t, err, "invoice is loaded from the state file")
+
+	var inv invoice.Invoice
+	require.NoError(t, json.Unmarshal([]byte(out), &inv))
+	assert.Len(t, inv.Items, 3)
+}
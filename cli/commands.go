@@ -0,0 +1,486 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antklim/go-dynamodb/dynamo"
+	"github.com/antklim/go-dynamodb/dynamo/dump"
+	"github.com/antklim/go-dynamodb/fixtures"
+	"github.com/antklim/go-dynamodb/invoice"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+)
+
+var commands = []command{
+	{name: "invoice create", summary: "store invoice from JSON file or flags", run: invoiceCreate},
+	{name: "invoice get", summary: "print invoice with its items", run: invoiceGet},
+	{name: "invoice cancel", summary: "cancel invoice and its items", run: invoiceCancel},
+	{name: "item add", summary: "add item to invoice", run: itemAdd},
+	{name: "item get", summary: "print invoice item", run: itemGet},
+	{name: "item delete", summary: "delete invoice item", run: itemDelete},
+	{name: "item cancel", summary: "cancel invoice item", run: itemCancel},
+	{name: "items list", summary: "list items by status", run: itemsList},
+	{name: "replace", summary: "replace invoice items with items from JSON file", run: replace},
+	{name: "seed", summary: "store invoices and items from JSON fixtures", run: seed},
+	{name: "purge", summary: "delete all rows with the key prefix, dynamo backend only", run: purge},
+	{name: "export", summary: "write invoices and items in DynamoDB JSON, dynamo backend only", run: export},
+	{name: "import", summary: "read invoices and items in DynamoDB JSON", run: importRows},
+	{name: "migrate", summary: "apply pending table migrations, dynamo backend only", run: migrate},
+	{name: "table ensure", summary: "create or update the table, dynamo backend only", run: tableEnsure},
+}
+
+// purger deletes rows by key prefix.
+type purger interface {
+	Purge(ctx context.Context, prefix string, opts dynamo.PurgeOptions) (int, error)
+}
+
+// newFlagSet creates flag set of the command. Usage lists positional args.
+func (c *CLI) newFlagSet(name, positional string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.out)
+	fs.Usage = func() {
+		fmt.Fprintf(c.out, "Usage: invoices %s [flags] %s\n", name, positional)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses flags and checks the number of positional args.
+func parse(fs *flag.FlagSet, args []string, positional int) error {
+	if err := fs.Parse(args); err != nil {
+		return usageError(err)
+	}
+	if fs.NArg() != positional {
+		fs.Usage()
+		return fmt.Errorf("%w: expected %d arguments, got %d", ErrUsage, positional, fs.NArg())
+	}
+	return nil
+}
+
+func invoiceCreate(ctx context.Context, c *CLI, args []string) error {
+	fs := c.newFlagSet("invoice create", "")
+	file := fs.String("file", "", "JSON file with invoice and its items, - reads stdin")
+	id := fs.String("id", "", "invoice ID, generated when empty")
+	number := fs.String("number", "", "invoice number")
+	customer := fs.String("customer", "", "customer name")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	inv := invoice.Invoice{
+		ID:           *id,
+		Number:       *number,
+		CustomerName: *customer,
+		Status:       invoice.New,
+		Date:         time.Now().UTC().Truncate(24 * time.Hour),
+	}
+	if *file != "" {
+		if err := readJSON(*file, &inv); err != nil {
+			return err
+		}
+	}
+	if inv.ID == "" {
+		inv.ID = uuid.NewString()
+	}
+
+	if err := c.svc.StoreInvoice(ctx, inv); err != nil {
+		return err
+	}
+	return c.printInvoice(ctx, inv.ID)
+}
+
+func invoiceGet(ctx context.Context, c *CLI, args []string) error {
+	fs := c.newFlagSet("invoice get", "<invoiceId>")
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+	return c.printInvoice(ctx, fs.Arg(0))
+}
+
+func invoiceCancel(ctx context.Context, c *CLI, args []string) error {
+	fs := c.newFlagSet("invoice cancel", "<invoiceId>")
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+	return c.svc.CancelInvoice(ctx, fs.Arg(0))
+}
+
+func itemAdd(ctx context.Context, c *CLI, args []string) error {
+	fs := c.newFlagSet("item add", "<invoiceId>")
+	id := fs.String("id", "", "item ID, generated when empty")
+	sku := fs.String("sku", "", "product SKU")
+	name := fs.String("name", "", "product name")
+	price := fs.Uint("price", 0, "product price")
+	qty := fs.Uint("qty", 1, "quantity")
+	status := fs.String("status", string(invoice.New), "item status")
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+
+	item := invoice.Item{
+		ID:        *id,
+		InvoiceID: fs.Arg(0),
+		SKU:       *sku,
+		Name:      *name,
+		Price:     *price,
+		Qty:       *qty,
+		Status:    invoice.Status(strings.ToUpper(*status)),
+	}
+	if item.ID == "" {
+		item.ID = uuid.NewString()
+	}
+
+	if err := c.svc.AddItem(ctx, item); err != nil {
+		return err
+	}
+	return c.printItem(ctx, item.InvoiceID, item.ID)
+}
+
+func itemGet(ctx context.Context, c *CLI, args []string) error {
+	fs := c.newFlagSet("item get", "<invoiceId> <itemId>")
+	if err := parse(fs, args, 2); err != nil {
+		return err
+	}
+	return c.printItem(ctx, fs.Arg(0), fs.Arg(1))
+}
+
+func itemDelete(ctx context.Context, c *CLI, args []string) error {
+	fs := c.newFlagSet("item delete", "<invoiceId> <itemId>")
+	if err := parse(fs, args, 2); err != nil {
+		return err
+	}
+	return c.svc.DeleteItem(ctx, fs.Arg(0), fs.Arg(1))
+}
+
+func itemCancel(ctx context.Context, c *CLI, args []string) error {
+	fs := c.newFlagSet("item cancel", "<invoiceId> <itemId>")
+	if err := parse(fs, args, 2); err != nil {
+		return err
+	}
+	return c.svc.CancelInvoiceItem(ctx, fs.Arg(0), fs.Arg(1))
+}
+
+func itemsList(ctx context.Context, c *CLI, args []string) error {
+	fs := c.newFlagSet("items list", "")
+	status := fs.String("status", "", "item status, required")
+	invoiceID := fs.String("invoice", "", "list items of the invoice only")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *status == "" {
+		fs.Usage()
+		return fmt.Errorf("%w: status is required", ErrUsage)
+	}
+
+	st := invoice.Status(strings.ToUpper(*status))
+	var (
+		items []invoice.Item
+		err   error
+	)
+	if *invoiceID != "" {
+		items, err = c.svc.GetInvoiceItemsByStatus(ctx, *invoiceID, st)
+	} else {
+		items, err = c.svc.GetItemsByStatus(ctx, st)
+	}
+	if err != nil {
+		return err
+	}
+	return c.print(items, func(w io.Writer) { writeItems(w, items) })
+}
+
+func replace(ctx context.Context, c *CLI, args []string) error {
+	fs := c.newFlagSet("replace", "<invoiceId>")
+	old := fs.String("old", "", "comma separated IDs of replaced items")
+	file := fs.String("file", "", "JSON file with the list of new items, - reads stdin")
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+
+	invoiceID := fs.Arg(0)
+	var newItems []invoice.Item
+	if *file != "" {
+		if err := readJSON(*file, &newItems); err != nil {
+			return err
+		}
+	}
+	for idx := range newItems {
+		if newItems[idx].InvoiceID == "" {
+			newItems[idx].InvoiceID = invoiceID
+		}
+	}
+
+	var oldItemIDs []string
+	if *old != "" {
+		oldItemIDs = strings.Split(*old, ",")
+	}
+
+	if err := c.svc.ReplaceItems(ctx, invoiceID, oldItemIDs, newItems); err != nil {
+		return err
+	}
+	return c.printInvoice(ctx, invoiceID)
+}
+
+func seed(ctx context.Context, c *CLI, args []string) error {
+	fs := c.newFlagSet("seed", "")
+	invoicesFile := fs.String("invoices", fixtures.InvoicesFile, "JSON file with invoices, empty skips invoices")
+	itemsFile := fs.String("items", fixtures.ItemsFile, "JSON file with items, empty skips items")
+	dryRun := fs.Bool("dry-run", false, "print what would be stored without storing")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if c.repo == nil {
+		return errNoRepository
+	}
+
+	fx, err := fixtures.Load(*invoicesFile, *itemsFile)
+	if err != nil {
+		return err
+	}
+	if !*dryRun {
+		if err := fx.Seed(ctx, c.repo); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(c.out, "invoices: %d, items: %d%s\n", len(fx.Invoices), len(fx.Items), dryRunNote(*dryRun))
+	return nil
+}
+
+func purge(ctx context.Context, c *CLI, args []string) error {
+	fs := c.newFlagSet("purge", "")
+	prefix := fs.String("prefix", "", "partition key prefix of deleted rows, e.g. INVOICE#, required unless --all")
+	all := fs.Bool("all", false, "delete all rows of the table")
+	dryRun := fs.Bool("dry-run", false, "count matching rows without deleting them")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *prefix == "" && !*all {
+		fs.Usage()
+		return fmt.Errorf("%w: prefix or all is required", ErrUsage)
+	}
+
+	p, ok := c.repo.(purger)
+	if !ok {
+		return fmt.Errorf("%w: purge is not supported by %s backend", ErrUsage, c.cfg.Backend)
+	}
+
+	n, err := p.Purge(ctx, *prefix, dynamo.PurgeOptions{DryRun: *dryRun})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "rows: %d%s\n", n, dryRunNote(*dryRun))
+	return nil
+}
+
+func export(ctx context.Context, c *CLI, args []string) error {
+	fs := c.newFlagSet("export", "")
+	format := fs.String("format", string(dump.FormatJSONL), "dump format: json or jsonl")
+	file := fs.String("file", "-", "output file, - writes stdout")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	repo, ok := c.repo.(*dynamo.Repository)
+	if !ok {
+		return fmt.Errorf("%w: export is not supported by %s backend", ErrUsage, c.cfg.Backend)
+	}
+
+	w := c.out
+	if *file != "-" {
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	_, err := dump.Export(ctx, repo, w, dump.Format(*format))
+	return err
+}
+
+func importRows(ctx context.Context, c *CLI, args []string) error {
+	fs := c.newFlagSet("import", "")
+	format := fs.String("format", string(dump.FormatJSONL), "dump format: json or jsonl")
+	file := fs.String("file", "-", "input file, - reads stdin")
+	checkpoint := fs.String("checkpoint", "", "file keeping the number of imported rows, import resumes from it")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if c.repo == nil {
+		return errNoRepository
+	}
+
+	r := io.Reader(os.Stdin)
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	dec, err := dump.NewDecoder(r, dump.Format(*format))
+	if err != nil {
+		return err
+	}
+
+	var opts dump.ImportOptions
+	if *checkpoint != "" {
+		if opts.Skip, err = readCheckpoint(*checkpoint); err != nil {
+			return err
+		}
+		opts.Checkpoint = func(imported int) error {
+			return ioutil.WriteFile(*checkpoint, []byte(strconv.Itoa(imported)), 0o644)
+		}
+	}
+
+	w := dump.RepositoryWriter(c.repo)
+	if repo, ok := c.repo.(*dynamo.Repository); ok {
+		w = dump.TableWriter(repo)
+	}
+
+	n, err := dump.Import(ctx, dec, w, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "rows: %d, resumed after: %d\n", n, opts.Skip)
+	return nil
+}
+
+func tableEnsure(ctx context.Context, c *CLI, args []string) error {
+	fs := c.newFlagSet("table ensure", "")
+	onDemand := fs.Bool("on-demand", false, "use on-demand billing instead of provisioned throughput")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if c.cfg.Backend != BackendDynamo {
+		return fmt.Errorf("%w: table ensure is not supported by %s backend", ErrUsage, c.cfg.Backend)
+	}
+
+	client, err := newDynamoClient(c.cfg)
+	if err != nil {
+		return err
+	}
+
+	schema := dynamo.NewRepository(client, c.cfg.Table).TableSchema()
+	schema.Tags = map[string]string{"project": "go-dynamodb"}
+	if *onDemand {
+		schema.BillingMode = dynamodb.BillingModePayPerRequest
+	}
+	if err := dynamo.EnsureTable(ctx, client, schema); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "table %s is active\n", schema.Name)
+	return nil
+}
+
+func migrate(ctx context.Context, c *CLI, args []string) error {
+	fs := c.newFlagSet("migrate", "")
+	dryRun := fs.Bool("dry-run", false, "report rows pending migrations would rewrite without writing them")
+	status := fs.Bool("status", false, "print the migration record of the table")
+	pageSize := fs.Int64("page-size", 0, "maximum number of rows evaluated per scan request")
+	readCapacity := fs.Float64("read-capacity", 0, "maximum read capacity units consumed per second, 0 means no limit")
+	writeCapacity := fs.Float64("write-capacity", 0, "maximum rewritten rows per second, 0 means no limit")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	repo, ok := c.repo.(*dynamo.Repository)
+	if !ok {
+		return fmt.Errorf("%w: migrate is not supported by %s backend", ErrUsage, c.cfg.Backend)
+	}
+
+	if *status {
+		state, err := repo.MigrationState(ctx)
+		if err != nil {
+			return err
+		}
+		return c.print(state, func(w io.Writer) { writeMigrationState(w, state) })
+	}
+
+	reports, err := repo.Migrate(ctx, dynamo.Migrations, dynamo.MigrateOptions{
+		DryRun:        *dryRun,
+		PageSize:      *pageSize,
+		ReadCapacity:  *readCapacity,
+		WriteCapacity: *writeCapacity,
+	})
+	if len(reports) > 0 {
+		if err := c.print(reports, func(w io.Writer) { writeMigrationReports(w, reports) }); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// readCheckpoint returns the number of rows imported by the previous run,
+// missing file means nothing was imported.
+func readCheckpoint(file string) (int, error) {
+	raw, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(raw)))
+}
+
+func dryRunNote(dryRun bool) string {
+	if dryRun {
+		return " (dry run)"
+	}
+	return ""
+}
+
+func (c *CLI) printInvoice(ctx context.Context, invoiceID string) error {
+	inv, err := c.svc.GetInvoice(ctx, invoiceID)
+	if err != nil {
+		return err
+	}
+	if inv == nil {
+		return invoice.ErrInvoiceNotFound
+	}
+	return c.print(inv, func(w io.Writer) { writeInvoice(w, *inv) })
+}
+
+func (c *CLI) printItem(ctx context.Context, invoiceID, itemID string) error {
+	item, err := c.svc.GetItem(ctx, invoiceID, itemID)
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return invoice.ErrItemNotFound
+	}
+	return c.print(item, func(w io.Writer) { writeItems(w, []invoice.Item{*item}) })
+}
+
+// readJSON decodes JSON file into v. File - is the standard input.
+func readJSON(file string, v interface{}) error {
+	r := io.Reader(os.Stdin)
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	if err := json.NewDecoder(r).Decode(v); err != nil {
+		return fmt.Errorf("read %s: %w", file, err)
+	}
+	return nil
+}
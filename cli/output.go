@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/antklim/go-dynamodb/dynamo"
+	"github.com/antklim/go-dynamodb/invoice"
+)
+
+// print writes v as JSON or calls table to write it as table.
+func (c *CLI) print(v interface{}, table func(io.Writer)) error {
+	if c.cfg.Output == OutputJSON {
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	table(w)
+	return w.Flush()
+}
+
+func writeInvoice(w io.Writer, inv invoice.Invoice) {
+	fmt.Fprintf(w, "ID:\t%s\n", inv.ID)
+	fmt.Fprintf(w, "Number:\t%s\n", inv.Number)
+	fmt.Fprintf(w, "Customer:\t%s\n", inv.CustomerName)
+	fmt.Fprintf(w, "Status:\t%s\n", inv.Status)
+	fmt.Fprintf(w, "Date:\t%s\n", formatTime(inv.Date))
+	fmt.Fprintf(w, "Items:\t%d\n", inv.ItemCount)
+	fmt.Fprintf(w, "Total:\t%d\n", inv.Total)
+	fmt.Fprintf(w, "Updated:\t%s\n", formatTime(inv.UpdatedAt))
+	if len(inv.Items) > 0 {
+		fmt.Fprintln(w)
+		writeItems(w, inv.Items)
+	}
+}
+
+func writeItems(w io.Writer, items []invoice.Item) {
+	fmt.Fprintln(w, "ID\tINVOICE\tSKU\tNAME\tPRICE\tQTY\tSTATUS\tVERSION\tUPDATED")
+	for _, item := range items {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%s\t%d\t%s\n",
+			item.ID, item.InvoiceID, item.SKU, item.Name, item.Price, item.Qty,
+			item.Status, item.Version, formatTime(item.UpdatedAt))
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func writeMigrationReports(w io.Writer, reports []dynamo.MigrationReport) {
+	fmt.Fprintln(w, "VERSION\tDESCRIPTION\tSCANNED\tREWRITTEN\tREKEYED\tRESUMED\tDRY RUN")
+	for _, r := range reports {
+		fmt.Fprintf(w, "%d\t%s\t%d\t%d\t%d\t%t\t%t\n",
+			r.Version, r.Description, r.Scanned, r.Rewritten, r.Rekeyed, r.Resumed, r.DryRun)
+	}
+}
+
+func writeMigrationState(w io.Writer, s dynamo.MigrationState) {
+	fmt.Fprintf(w, "Version:\t%d\n", s.Version)
+	if s.Running != 0 {
+		fmt.Fprintf(w, "Interrupted:\t%d\n", s.Running)
+		fmt.Fprintf(w, "Scanned:\t%d\n", s.Scanned)
+		fmt.Fprintf(w, "Rewritten:\t%d\n", s.Rewritten)
+	}
+	fmt.Fprintf(w, "Updated:\t%s\n", formatTime(s.UpdatedAt))
+}
@@ -0,0 +1,84 @@
+package dynamo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	batchWriteLimit = 25 // maximum number of requests in BatchWriteItem
+	maxBatchRetries = 5
+)
+
+// ErrUnprocessedItems is returned when batch writes still have unprocessed
+// items after all retries.
+var ErrUnprocessedItems = errors.New("unprocessed items left after retries")
+
+// PutRows writes raw rows to the table in batches, existing rows with the
+// same keys are overwritten. Rows are written as they are, bypassing the
+// history and outbox, callers are responsible for the key conventions.
+func (r *Repository) PutRows(ctx context.Context, rows []map[string]*dynamodb.AttributeValue) error {
+	for start := 0; start < len(rows); start += batchWriteLimit {
+		end := start + batchWriteLimit
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		requests := make([]*dynamodb.WriteRequest, 0, end-start)
+		for _, row := range rows[start:end] {
+			requests = append(requests, &dynamodb.WriteRequest{
+				PutRequest: &dynamodb.PutRequest{Item: row},
+			})
+		}
+		if err := r.batchWrite(ctx, requests); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// batchDelete deletes up to batchWriteLimit rows by their keys.
+func (r *Repository) batchDelete(ctx context.Context, keys []map[string]*dynamodb.AttributeValue) error {
+	requests := make([]*dynamodb.WriteRequest, len(keys))
+	for idx, key := range keys {
+		requests[idx] = &dynamodb.WriteRequest{
+			DeleteRequest: &dynamodb.DeleteRequest{Key: key},
+		}
+	}
+	return r.batchWrite(ctx, requests)
+}
+
+// batchWrite writes up to batchWriteLimit requests. Unprocessed requests
+// are retried with exponential backoff.
+func (r *Repository) batchWrite(ctx context.Context, requests []*dynamodb.WriteRequest) error {
+	table := aws.StringValue(r.table)
+	backoff := 50 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		input := &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]*dynamodb.WriteRequest{table: requests},
+		}
+		result, err := r.client.BatchWriteItemWithContext(ctx, input)
+		if err != nil {
+			return err
+		}
+
+		requests = result.UnprocessedItems[table]
+		if len(requests) == 0 {
+			return nil
+		}
+		if attempt == maxBatchRetries {
+			return ErrUnprocessedItems
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,375 @@
+package dynamo
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/antklim/go-dynamodb/invoice"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+const (
+	creditNoteCounter = "CREDIT_NOTE"
+	creditNoteRetries = 3
+)
+
+// CreditNoteLine describes dynamodb representation of invoice.CreditNoteLine
+type CreditNoteLine struct {
+	ItemID string `dynamodbav:"itemId"`
+	Qty    uint   `dynamodbav:"qty"`
+	Amount uint   `dynamodbav:"amount"`
+}
+
+// CreditNote describes dynamodb representation of invoice.CreditNote
+type CreditNote struct {
+	PK        string           `dynamodbav:"pk"`
+	SK        string           `dynamodbav:"sk"`
+	ID        string           `dynamodbav:"id"`
+	Number    string           `dynamodbav:"number"`
+	InvoiceID string           `dynamodbav:"invoiceId"`
+	Reason    string           `dynamodbav:"reason"`
+	Lines     []CreditNoteLine `dynamodbav:"lines"`
+	Date      string           `dynamodbav:"date"` // YYYYMMDD
+	CreatedAt time.Time        `dynamodbav:"createdAt"`
+}
+
+// NewCreditNote creates an instance of DynamoDB credit note from invoice.CreditNote.
+func NewCreditNote(cn invoice.CreditNote) CreditNote {
+	lines := make([]CreditNoteLine, len(cn.Lines))
+	for idx, line := range cn.Lines {
+		lines[idx] = CreditNoteLine(line)
+	}
+
+	return CreditNote{
+		PK:        defaultKeys.invoicePartitionKey(cn.InvoiceID),
+		SK:        defaultKeys.creditNoteSortKey(cn.ID),
+		ID:        cn.ID,
+		Number:    cn.Number,
+		InvoiceID: cn.InvoiceID,
+		Reason:    cn.Reason,
+		Lines:     lines,
+		Date:      cn.Date.Format(yyyymmddFormat),
+		CreatedAt: cn.CreatedAt,
+	}
+}
+
+// ToCreditNote creates an instance of invoice.CreditNote from DynamoDB credit note.
+func (cn *CreditNote) ToCreditNote() (*invoice.CreditNote, error) {
+	date, err := time.Parse(yyyymmddFormat, cn.Date)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]invoice.CreditNoteLine, len(cn.Lines))
+	for idx, line := range cn.Lines {
+		lines[idx] = invoice.CreditNoteLine(line)
+	}
+
+	return &invoice.CreditNote{
+		ID:        cn.ID,
+		Number:    cn.Number,
+		InvoiceID: cn.InvoiceID,
+		Reason:    cn.Reason,
+		Lines:     lines,
+		Date:      date,
+		CreatedAt: cn.CreatedAt,
+	}, nil
+}
+
+// counterValue returns current value of the counter, 0 when counter does not exist.
+func (r *Repository) counterValue(ctx context.Context, name string) (int, error) {
+	pk := r.keys.counterPrimaryKey(name)
+
+	input := &dynamodb.GetItemInput{
+		TableName:      r.table,
+		Key:            pk,
+		ConsistentRead: aws.Bool(true),
+	}
+
+	result, err := r.client.GetItemWithContext(ctx, input)
+	if err != nil {
+		return 0, err
+	}
+
+	counter := struct {
+		Value int `dynamodbav:"value"`
+	}{}
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &counter); err != nil {
+		return 0, err
+	}
+
+	return counter.Value, nil
+}
+
+// counterUpdate creates transaction item that moves counter from current to
+// the next value. The transaction fails when counter was moved concurrently.
+func (r *Repository) counterUpdate(name string, current int) (*dynamodb.TransactWriteItem, error) {
+	pk := r.keys.counterPrimaryKey(name)
+
+	upd := expression.Set(expression.Name("value"), expression.Value(current+1))
+	cond := expression.Name("value").Equal(expression.Value(current))
+	if current == 0 {
+		cond = expression.AttributeNotExists(expression.Name(r.keys.PartitionKey))
+	}
+
+	expr, err := expression.NewBuilder().WithUpdate(upd).WithCondition(cond).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	update := &dynamodb.Update{
+		TableName:                 r.table,
+		Key:                       pk,
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ConditionExpression:       expr.Condition(),
+		UpdateExpression:          expr.Update(),
+	}
+
+	return &dynamodb.TransactWriteItem{Update: update}, nil
+}
+
+// AddCreditNote stores the credit note with the next sequential number. The
+// number, the credit note and credited quantities and amounts of its items
+// are written in one transaction, so numbers have no gaps and credit notes
+// cannot exceed the invoice, see invoice.CheckCreditNote. Concurrent writes
+// are retried, invoice.ErrVersionConflict is returned when they keep
+// failing. It returns invoice.ErrCreditNoteConflict when the credit note
+// already exists.
+func (r *Repository) AddCreditNote(ctx context.Context, cn invoice.CreditNote) (*invoice.CreditNote, error) {
+	r, err := r.scoped(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cn.CreatedAt = r.clock.Now()
+	lines := cn.Lines
+
+	for attempt := 0; attempt < creditNoteRetries; attempt++ {
+		cn.Lines = append([]invoice.CreditNoteLine(nil), lines...)
+		err = r.putCreditNote(ctx, &cn)
+		if err == nil {
+			return &cn, nil
+		}
+		// the credit note put is the second transaction item
+		if conditionFailed(err, 1) {
+			return nil, invoice.ErrCreditNoteConflict
+		}
+		if !isTransactionCanceled(err) {
+			return nil, err
+		}
+	}
+
+	return nil, invoice.ErrVersionConflict
+}
+
+// putCreditNote checks the credit note against consistently read items and
+// issued credit notes and writes it with the next number.
+func (r *Repository) putCreditNote(ctx context.Context, cn *invoice.CreditNote) error {
+	number, err := r.counterValue(ctx, creditNoteCounter)
+	if err != nil {
+		return err
+	}
+	cn.Number = strconv.Itoa(number + 1)
+
+	issued, err := r.GetCreditNotes(ctx, cn.InvoiceID, invoice.ConsistentRead())
+	if err != nil {
+		return err
+	}
+
+	items, err := r.creditedItems(ctx, *cn)
+	if err != nil {
+		return err
+	}
+
+	if err := invoice.CheckCreditNote(cn, items, issued); err != nil {
+		return err
+	}
+
+	counter, err := r.counterUpdate(creditNoteCounter, number)
+	if err != nil {
+		return err
+	}
+
+	credits, err := r.itemCreditUpdates(items, issued, *cn)
+	if err != nil {
+		return err
+	}
+
+	putCreditNote, err := r.keys.creditNoteRow(*cn)
+	if err != nil {
+		return err
+	}
+
+	put := &dynamodb.Put{
+		TableName:           r.table,
+		Item:                putCreditNote,
+		ConditionExpression: r.keys.notExistsCondition(),
+	}
+
+	after := invoice.Snapshot{CreditNote: cn}
+	changes, err := r.changePuts(ctx, cn.InvoiceID, invoice.OpAddCreditNote, invoice.Snapshot{}, after)
+	if err != nil {
+		return err
+	}
+
+	transactItems := []*dynamodb.TransactWriteItem{counter, {Put: put}}
+	transactItems = append(transactItems, credits...)
+	return r.transact(ctx, append(transactItems, changes...))
+}
+
+// creditedItems consistently reads the items credited by the credit note.
+// Items that do not exist or were deleted are left out.
+func (r *Repository) creditedItems(ctx context.Context, cn invoice.CreditNote) ([]invoice.Item, error) {
+	var items []invoice.Item
+	for itemID := range invoice.Credited([]invoice.CreditNote{cn}) {
+		item, err := r.GetItem(ctx, cn.InvoiceID, itemID, invoice.ConsistentRead())
+		if err != nil {
+			return nil, err
+		}
+		if item != nil {
+			items = append(items, *item)
+		}
+	}
+	return items, nil
+}
+
+// itemCreditUpdates creates transaction items that set credited quantity and
+// amount of the items credited by the credit note and increment their
+// version, so that item updates read before the credit fail. The transaction
+// fails when items or their credits changed since they were read, or items
+// were deleted.
+func (r *Repository) itemCreditUpdates(items []invoice.Item, issued []invoice.CreditNote, cn invoice.CreditNote) (
+	[]*dynamodb.TransactWriteItem, error) {
+
+	before := invoice.Credited(issued)
+	after := invoice.Credited(append(issued, cn))
+
+	updates := make([]*dynamodb.TransactWriteItem, len(items))
+	for idx, item := range items {
+		upd := expression.
+			Set(expression.Name("creditedQty"), expression.Value(after[item.ID].Qty)).
+			Set(expression.Name("creditedAmount"), expression.Value(after[item.ID].Amount)).
+			Set(expression.Name("version"), nextVersion)
+		// credits of items written before credits were kept are not stored
+		credited := expression.AttributeNotExists(expression.Name("creditedQty")).Or(
+			expression.Name("creditedQty").Equal(expression.Value(before[item.ID].Qty)).
+				And(expression.Name("creditedAmount").Equal(expression.Value(before[item.ID].Amount))))
+		cond := expression.AttributeExists(expression.Name(r.keys.PartitionKey)).
+			And(notDeletedFilter).
+			And(versionCondition(item.Version)).
+			And(credited)
+		expr, err := expression.NewBuilder().WithUpdate(upd).WithCondition(cond).Build()
+		if err != nil {
+			return nil, err
+		}
+
+		updates[idx] = &dynamodb.TransactWriteItem{Update: &dynamodb.Update{
+			TableName:                 r.table,
+			Key:                       r.keys.itemPrimaryKey(item.InvoiceID, item.ID),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+			ConditionExpression:       expr.Condition(),
+			UpdateExpression:          expr.Update(),
+		}}
+	}
+
+	return updates, nil
+}
+
+func isTransactionCanceled(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeTransactionCanceledException
+}
+
+func (r *Repository) GetCreditNote(
+	ctx context.Context, invoiceID, creditNoteID string, opts ...invoice.ReadOption) (*invoice.CreditNote, error) {
+
+	r, err := r.scoped(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pk := r.keys.creditNotePrimaryKey(invoiceID, creditNoteID)
+
+	input := &dynamodb.GetItemInput{
+		TableName:      r.table,
+		Key:            pk,
+		ConsistentRead: r.consistentRead(opts),
+	}
+
+	result, err := r.client.GetItemWithContext(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	dbCreditNote := CreditNote{}
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &dbCreditNote); err != nil {
+		return nil, err
+	}
+
+	return dbCreditNote.ToCreditNote()
+}
+
+func (r *Repository) GetCreditNotes(
+	ctx context.Context, invoiceID string, opts ...invoice.ReadOption) ([]invoice.CreditNote, error) {
+
+	r, err := r.scoped(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pk := r.keys.invoicePartitionKey(invoiceID)
+	keyCond := expression.KeyAnd(
+		expression.Key(r.keys.PartitionKey).Equal(expression.Value(pk)),
+		expression.Key(r.keys.SortKey).BeginsWith(r.keys.keyPrefix(r.keys.Prefixes.CreditNote)),
+	)
+
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:                 r.table,
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ConsistentRead:            r.consistentRead(opts),
+	}
+
+	rows, err := collectRows(ctx, r.queryPages(input))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	dbCreditNotes := []*CreditNote{}
+	if err := dynamodbattribute.UnmarshalListOfMaps(rows, &dbCreditNotes); err != nil {
+		return nil, err
+	}
+
+	creditNotes := make([]invoice.CreditNote, len(dbCreditNotes))
+	for idx, dbCreditNote := range dbCreditNotes {
+		cn, err := dbCreditNote.ToCreditNote()
+		if err != nil {
+			return nil, err
+		}
+		creditNotes[idx] = *cn
+	}
+
+	return creditNotes, nil
+}
@@ -0,0 +1,145 @@
+// Package dump exports invoices table to DynamoDB JSON and imports it back.
+//
+// Two formats are supported. FormatJSON is the output of the DynamoDB Scan
+// API, the same as data/db.json. FormatJSONL has one {"Item": {...}} object
+// per line, the same as DynamoDB export to S3, and is suitable for large
+// tables as rows are streamed.
+package dump
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/antklim/go-dynamodb/dynamo"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/private/protocol/json/jsonutil"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Format of the dump.
+type Format string
+
+// Supported formats.
+const (
+	FormatJSON  Format = "json"
+	FormatJSONL Format = "jsonl"
+)
+
+// ErrUnknownFormat is returned for unsupported dump formats.
+var ErrUnknownFormat = errors.New("unknown format")
+
+// Row is a raw DynamoDB row.
+type Row = map[string]*dynamodb.AttributeValue
+
+// Export scans the table and writes invoice and item rows to w. Other rows,
+// such as history and outbox events, are skipped. It returns the number of
+// exported rows.
+func Export(ctx context.Context, repo *dynamo.Repository, w io.Writer, format Format) (int, error) {
+	if format != FormatJSON && format != FormatJSONL {
+		return 0, fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+	}
+
+	keys := repo.KeySchema()
+	var rows []Row
+	count := 0
+	err := repo.ParallelScan(ctx, dynamo.ScanOptions{}, func(_ context.Context, row Row) error {
+		if !keys.IsInvoiceRow(row) && !keys.IsItemRow(row) {
+			return nil
+		}
+		count++
+		if format == FormatJSON {
+			rows = append(rows, row)
+			return nil
+		}
+		return writeLine(w, &dynamodb.GetItemOutput{Item: row})
+	})
+	if err != nil {
+		return count, err
+	}
+
+	if format == FormatJSON {
+		out := &dynamodb.ScanOutput{
+			Items:        rows,
+			Count:        aws.Int64(int64(count)),
+			ScannedCount: aws.Int64(int64(count)),
+		}
+		if err := writeLine(w, out); err != nil {
+			return count, err
+		}
+	}
+
+	return count, nil
+}
+
+func writeLine(w io.Writer, v interface{}) error {
+	raw, err := jsonutil.BuildJSON(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(raw, '\n'))
+	return err
+}
+
+// Decoder reads rows of the dump.
+type Decoder struct {
+	format Format
+	lines  *bufio.Scanner
+	rows   []Row // rows of FormatJSON dump, read at once
+	line   int
+}
+
+// NewDecoder creates decoder reading dump in the format from r.
+func NewDecoder(r io.Reader, format Format) (*Decoder, error) {
+	d := &Decoder{format: format}
+
+	switch format {
+	case FormatJSON:
+		var out dynamodb.ScanOutput
+		if err := jsonutil.UnmarshalJSON(&out, r); err != nil {
+			return nil, err
+		}
+		d.rows = out.Items
+	case FormatJSONL:
+		d.lines = bufio.NewScanner(r)
+		d.lines.Buffer(make([]byte, 0, 64*1024), 4*1024*1024) // DynamoDB rows are up to 400KB
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+	}
+
+	return d, nil
+}
+
+// Next returns the next row. It returns io.EOF when there are no more rows.
+func (d *Decoder) Next() (Row, error) {
+	if d.format == FormatJSON {
+		if len(d.rows) == 0 {
+			return nil, io.EOF
+		}
+		row := d.rows[0]
+		d.rows = d.rows[1:]
+		return row, nil
+	}
+
+	for d.lines.Scan() {
+		d.line++
+		line := strings.TrimSpace(d.lines.Text())
+		if line == "" {
+			continue
+		}
+
+		var out dynamodb.GetItemOutput
+		if err := jsonutil.UnmarshalJSON(&out, strings.NewReader(line)); err != nil {
+			return nil, fmt.Errorf("line %d: %w", d.line, err)
+		}
+		return out.Item, nil
+	}
+
+	if err := d.lines.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
@@ -0,0 +1,200 @@
+package dump_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/antklim/go-dynamodb/dynamo"
+	"github.com/antklim/go-dynamodb/dynamo/dump"
+	"github.com/antklim/go-dynamodb/memory"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const invoiceID = "170bf55e-ca81-4a17-99ad-54f6411d610b"
+
+// tableClient scans and puts rows.
+type tableClient struct {
+	dynamodbiface.DynamoDBAPI
+	rows []dump.Row
+}
+
+func (c *tableClient) ScanWithContext(aws.Context, *dynamodb.ScanInput, ...request.Option) (
+	*dynamodb.ScanOutput, error) {
+
+	return &dynamodb.ScanOutput{Items: c.rows}, nil
+}
+
+func (c *tableClient) BatchWriteItemWithContext(
+	_ aws.Context, input *dynamodb.BatchWriteItemInput, _ ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+
+	for _, req := range input.RequestItems["invoices"] {
+		c.rows = append(c.rows, req.PutRequest.Item)
+	}
+	return &dynamodb.BatchWriteItemOutput{}, nil
+}
+
+func readRows(t *testing.T, dec *dump.Decoder) []dump.Row {
+	t.Helper()
+	var rows []dump.Row
+	for {
+		row, err := dec.Next()
+		if err != nil {
+			require.ErrorIs(t, err, io.EOF)
+			return rows
+		}
+		rows = append(rows, row)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	f, err := os.Open("../../data/db.json")
+	require.NoError(t, err)
+	defer f.Close()
+
+	dec, err := dump.NewDecoder(f, dump.FormatJSON)
+	require.NoError(t, err)
+	rows := readRows(t, dec)
+	require.Len(t, rows, 4)
+
+	history := dump.Row{"pk": {S: aws.String("INVOICE#1")}, "sk": {S: aws.String("HISTORY#1")}}
+	source := dynamo.NewRepository(&tableClient{rows: append(rows, history)}, "invoices")
+
+	for _, format := range []dump.Format{dump.FormatJSON, dump.FormatJSONL} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			n, err := dump.Export(context.Background(), source, &buf, format)
+			require.NoError(t, err)
+			assert.Equal(t, 4, n, "history row is not exported")
+
+			dec, err := dump.NewDecoder(&buf, format)
+			require.NoError(t, err)
+
+			target := &tableClient{}
+			n, err = dump.Import(context.Background(), dec, dump.TableWriter(dynamo.NewRepository(target, "invoices")),
+				dump.ImportOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, 4, n)
+			assert.Equal(t, rows, target.rows)
+		})
+	}
+}
+
+func TestImportToRepository(t *testing.T) {
+	f, err := os.Open("../../data/db.json")
+	require.NoError(t, err)
+	defer f.Close()
+
+	dec, err := dump.NewDecoder(f, dump.FormatJSON)
+	require.NoError(t, err)
+
+	repo := memory.NewRepository()
+	n, err := dump.Import(context.Background(), dec, dump.RepositoryWriter(repo), dump.ImportOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+
+	inv, err := repo.GetInvoice(context.Background(), invoiceID)
+	require.NoError(t, err)
+	require.NotNil(t, inv)
+	assert.Equal(t, "John Doe", inv.CustomerName)
+
+	items, err := repo.GetInvoiceItems(context.Background(), invoiceID)
+	require.NoError(t, err)
+	assert.Len(t, items, 3)
+}
+
+func TestMemoryState(t *testing.T) {
+	f, err := os.Open("../../data/db.json")
+	require.NoError(t, err)
+	defer f.Close()
+
+	dec, err := dump.NewDecoder(f, dump.FormatJSON)
+	require.NoError(t, err)
+
+	st, err := dump.MemoryState(dec)
+	require.NoError(t, err)
+	require.Len(t, st.Invoices, 1)
+	assert.Len(t, st.Items, 3)
+	assert.False(t, st.Invoices[0].CreatedAt.IsZero(), "timestamps are kept")
+
+	repo := memory.NewRepository(memory.WithState(st))
+	inv, err := repo.GetInvoice(context.Background(), invoiceID)
+	require.NoError(t, err)
+	require.NotNil(t, inv)
+	assert.Equal(t, st.Invoices[0].CreatedAt, inv.CreatedAt)
+}
+
+func TestImportResume(t *testing.T) {
+	var lines []string
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		lines = append(lines, `{"Item":{"pk":{"S":"INVOICE#`+id+`"},"sk":{"S":"INVOICE#`+id+`"},"id":{"S":"`+id+
+			`"},"date":{"S":"20210317"}}}`)
+	}
+	input := strings.Join(lines, "\n")
+
+	errWrite := errors.New("write failed")
+	var written []string
+	checkpoint, failAt := 0, 2
+	w := dump.WriterFunc(func(_ context.Context, rows []dump.Row) error {
+		if len(written) == failAt {
+			return errWrite
+		}
+		for _, row := range rows {
+			written = append(written, aws.StringValue(row["id"].S))
+		}
+		return nil
+	})
+	opts := dump.ImportOptions{
+		BatchSize:  2,
+		Checkpoint: func(imported int) error { checkpoint = imported; return nil },
+	}
+
+	dec, err := dump.NewDecoder(strings.NewReader(input), dump.FormatJSONL)
+	require.NoError(t, err)
+	_, err = dump.Import(context.Background(), dec, w, opts)
+	assert.ErrorIs(t, err, errWrite)
+	assert.Equal(t, 2, checkpoint)
+
+	written, failAt = nil, -1
+	opts.Skip = checkpoint
+	dec, err = dump.NewDecoder(strings.NewReader(input), dump.FormatJSONL)
+	require.NoError(t, err)
+	n, err := dump.Import(context.Background(), dec, w, opts)
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, []string{"3", "4", "5"}, written)
+}
+
+func TestImportValidation(t *testing.T) {
+	tt := []struct {
+		name string
+		line string
+	}{
+		{"unsupported row", `{"Item":{"pk":{"S":"COUNTER#1"},"sk":{"S":"COUNTER#1"}}}`},
+		{"invoice keys", `{"Item":{"pk":{"S":"INVOICE#2"},"sk":{"S":"INVOICE#1"},"id":{"S":"1"},"date":{"S":"20210317"}}}`},
+		{"invoice date", `{"Item":{"pk":{"S":"INVOICE#1"},"sk":{"S":"INVOICE#1"},"id":{"S":"1"},"date":{"S":"today"}}}`},
+		{"item keys", `{"Item":{"pk":{"S":"INVOICE#1"},"sk":{"S":"ITEM#2"},"id":{"S":"1"},"invoiceId":{"S":"1"}}}`},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			dec, err := dump.NewDecoder(strings.NewReader(tc.line), dump.FormatJSONL)
+			require.NoError(t, err)
+			_, err = dump.Import(context.Background(), dec, dump.RepositoryWriter(memory.NewRepository()),
+				dump.ImportOptions{})
+			assert.ErrorIs(t, err, dynamo.ErrInvalidRow)
+		})
+	}
+
+	_, err := dump.NewDecoder(strings.NewReader(""), dump.Format("csv"))
+	assert.ErrorIs(t, err, dump.ErrUnknownFormat)
+}
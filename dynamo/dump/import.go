@@ -0,0 +1,189 @@
+package dump
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/antklim/go-dynamodb/dynamo"
+	"github.com/antklim/go-dynamodb/invoice"
+	"github.com/antklim/go-dynamodb/memory"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+const defaultBatchSize = 25
+
+// Writer stores imported rows.
+type Writer interface {
+	Write(ctx context.Context, rows []Row) error
+}
+
+// WriterFunc is an adapter to allow the use of ordinary functions as writers.
+type WriterFunc func(ctx context.Context, rows []Row) error
+
+// Write calls f(ctx, rows).
+func (f WriterFunc) Write(ctx context.Context, rows []Row) error {
+	return f(ctx, rows)
+}
+
+// TableWriter writes rows to the table as they are.
+func TableWriter(repo *dynamo.Repository) Writer {
+	return WriterFunc(repo.PutRows)
+}
+
+// RepositoryWriter converts rows to invoices and items and adds them to
+// repo, e.g. memory.Repository. Invoices must precede their items, which
+// holds for dumps of the table as the invoice sort key sorts before items.
+func RepositoryWriter(repo invoice.Repository) Writer {
+	return KeyedRepositoryWriter(repo, dynamo.KeySchema{})
+}
+
+// KeyedRepositoryWriter is RepositoryWriter of rows in the keys layout.
+func KeyedRepositoryWriter(repo invoice.Repository, keys dynamo.KeySchema) Writer {
+	return WriterFunc(func(ctx context.Context, rows []Row) error {
+		for _, row := range rows {
+			if err := addRow(ctx, repo, keys, row); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func addRow(ctx context.Context, repo invoice.Repository, keys dynamo.KeySchema, row Row) error {
+	inv, item, err := decodeRow(keys, row)
+	if err != nil {
+		return err
+	}
+	if inv != nil {
+		return repo.AddInvoice(ctx, *inv)
+	}
+	return repo.AddItem(ctx, *item)
+}
+
+// decodeRow returns the invoice or the item of the valid row.
+func decodeRow(keys dynamo.KeySchema, row Row) (*invoice.Invoice, *invoice.Item, error) {
+	if keys.IsInvoiceRow(row) {
+		var dbInvoice dynamo.Invoice
+		if err := dynamodbattribute.UnmarshalMap(row, &dbInvoice); err != nil {
+			return nil, nil, err
+		}
+		inv, err := dbInvoice.ToInvoice()
+		return inv, nil, err
+	}
+
+	var dbItem dynamo.Item
+	if err := dynamodbattribute.UnmarshalMap(row, &dbItem); err != nil {
+		return nil, nil, err
+	}
+	item := dbItem.ToItem()
+	return nil, &item, nil
+}
+
+// ImportOptions configures Import.
+type ImportOptions struct {
+	// Skip is the number of rows imported by the previous run. Import
+	// resumes after them.
+	Skip int
+	// BatchSize is the number of rows passed to the writer at once,
+	// defaults to 25.
+	BatchSize int
+	// Checkpoint is called after every written batch with the total number
+	// of imported rows, including skipped ones. Persist it and pass as Skip
+	// to resume failed import.
+	Checkpoint func(imported int) error
+	// Keys is the key layout of the dumped rows, defaults to the default
+	// layout.
+	Keys dynamo.KeySchema
+}
+
+// Import validates rows read by the decoder and writes them in batches.
+// Skipped rows are validated too, so a malformed dump fails at the same row
+// on every run. It returns the number of imported rows including skipped
+// ones.
+func Import(ctx context.Context, dec *Decoder, w Writer, opts ImportOptions) (int, error) {
+	batchSize := opts.BatchSize
+	if batchSize < 1 {
+		batchSize = defaultBatchSize
+	}
+
+	imported := 0
+	var batch []Row
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := w.Write(ctx, batch); err != nil {
+			return err
+		}
+		imported += len(batch)
+		batch = batch[:0]
+		if opts.Checkpoint != nil {
+			return opts.Checkpoint(imported)
+		}
+		return nil
+	}
+
+	for n := 0; ; n++ {
+		if err := ctx.Err(); err != nil {
+			return imported, err
+		}
+
+		row, err := dec.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return imported, err
+		}
+		if err := opts.Keys.ValidateRow(row); err != nil {
+			return imported, fmt.Errorf("row %d: %w", n+1, err)
+		}
+
+		if n < opts.Skip {
+			imported++
+			continue
+		}
+
+		batch = append(batch, row)
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return imported, err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return imported, err
+	}
+	return imported, nil
+}
+
+// MemoryState reads invoices and items of the dump as the state of the
+// memory repository. Unlike RepositoryWriter it keeps timestamps and
+// versions of the rows, so the memory repository mirrors the table.
+func MemoryState(dec *Decoder) (memory.State, error) {
+	var st memory.State
+	for n := 1; ; n++ {
+		row, err := dec.Next()
+		if err == io.EOF {
+			return st, nil
+		}
+		if err != nil {
+			return memory.State{}, err
+		}
+		if err := dynamo.ValidateRow(row); err != nil {
+			return memory.State{}, fmt.Errorf("row %d: %w", n, err)
+		}
+
+		inv, item, err := decodeRow(dynamo.KeySchema{}, row)
+		if err != nil {
+			return memory.State{}, err
+		}
+		if inv != nil {
+			st.Invoices = append(st.Invoices, *inv)
+		} else {
+			st.Items = append(st.Items, *item)
+		}
+	}
+}
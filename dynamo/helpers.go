@@ -1,50 +1,56 @@
 package dynamo
 
 import (
-	"strings"
+	"context"
+	"errors"
+	"time"
 
 	"github.com/antklim/go-dynamodb/invoice"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
 )
 
-func invoicePartitionKey(invoiceID string) string {
-	elems := []string{invoicePkPrefix, invoiceID}
-	return strings.Join(elems, keySeparator)
-}
-
-func invoiceSortKey(invoiceID string) string {
-	elems := []string{invoiceSkPrefix, invoiceID}
-	return strings.Join(elems, keySeparator)
-}
+// nextVersion increments version of the row, rows written before versioning have version 0.
+var nextVersion = expression.Plus(
+	expression.IfNotExists(expression.Name("version"), expression.Value(0)),
+	expression.Value(1),
+)
 
-func invoicePrimaryKey(invoiceID string) (map[string]*dynamodb.AttributeValue, error) {
-	primaryKey := map[string]string{
-		"pk": invoicePartitionKey(invoiceID),
-		"sk": invoiceSortKey(invoiceID),
-	}
+// notDeletedFilter excludes deleted items.
+var notDeletedFilter = expression.AttributeNotExists(expression.Name("deletedAt"))
 
-	return dynamodbattribute.MarshalMap(primaryKey)
+// creditCovered checks that credited quantity and amount of the item row,
+// see itemCreditUpdates, do not exceed quantity and amount of the item.
+func creditCovered(item invoice.Item) expression.ConditionBuilder {
+	qty := expression.AttributeNotExists(expression.Name("creditedQty")).
+		Or(expression.Name("creditedQty").LessThanEqual(expression.Value(item.Qty)))
+	amount := expression.AttributeNotExists(expression.Name("creditedAmount")).
+		Or(expression.Name("creditedAmount").LessThanEqual(expression.Value(item.Price * item.Qty)))
+	return qty.And(amount)
 }
 
-func itemPartitionKey(invoiceID string) string {
-	elems := []string{itemPkPrefix, invoiceID}
-	return strings.Join(elems, keySeparator)
+// versionCondition checks that the row has the version.
+func versionCondition(version uint) expression.ConditionBuilder {
+	cond := expression.Name("version").Equal(expression.Value(version))
+	if version == 0 {
+		cond = cond.Or(expression.AttributeNotExists(expression.Name("version")))
+	}
+	return cond
 }
 
-func itemSortKey(itemID string) string {
-	elems := []string{itemSkPrefix, itemID}
-	return strings.Join(elems, keySeparator)
+// IsInvoiceRow reports whether the raw row of the default key layout is an
+// invoice.
+func IsInvoiceRow(row map[string]*dynamodb.AttributeValue) bool {
+	return KeySchema{}.IsInvoiceRow(row)
 }
 
-func itemPrimaryKey(invoiceID, itemID string) (map[string]*dynamodb.AttributeValue, error) {
-	primaryKey := map[string]string{
-		"pk": itemPartitionKey(invoiceID),
-		"sk": itemSortKey(itemID),
-	}
-
-	return dynamodbattribute.MarshalMap(primaryKey)
+// IsItemRow reports whether the raw row of the default key layout is an
+// invoice item.
+func IsItemRow(row map[string]*dynamodb.AttributeValue) bool {
+	return KeySchema{}.IsItemRow(row)
 }
 
 func toInvoice(rawItem map[string]*dynamodb.AttributeValue) (*invoice.Invoice, error) {
@@ -74,35 +80,27 @@ func toItem(rawItem map[string]*dynamodb.AttributeValue) (*invoice.Item, error)
 	return &item, nil
 }
 
-func toInvoiceItems(rawItems []map[string]*dynamodb.AttributeValue) ([]invoice.Item, error) {
-	if len(rawItems) == 0 {
-		return nil, nil
-	}
+// invoiceItemsToUpdates creates updates of the items. Updates are
+// conditioned on versions of the items, so that changes of invoice totals
+// computed from the items are not applied twice.
+func (r *Repository) invoiceItemsToUpdates(items []invoice.Item,
+	upd expression.UpdateBuilder, cond expression.ConditionBuilder) ([]*dynamodb.Update, error) {
 
-	dbItems := []*Item{}
-	if err := dynamodbattribute.UnmarshalListOfMaps(rawItems, &dbItems); err != nil {
-		return nil, err
-	}
-
-	invoiceItems := make([]invoice.Item, len(dbItems))
-	for idx, dbItem := range dbItems {
-		invoiceItems[idx] = dbItem.ToItem()
-	}
-
-	return invoiceItems, nil
-}
-
-func invoiceItemsToUpdates(items []invoice.Item, table *string, expr expression.Expression) ([]*dynamodb.Update, error) {
 	updates := make([]*dynamodb.Update, len(items))
 
 	for idx, item := range items {
-		pk, err := itemPrimaryKey(item.InvoiceID, item.ID)
+		pk := r.keys.itemPrimaryKey(item.InvoiceID, item.ID)
+
+		expr, err := expression.NewBuilder().
+			WithUpdate(upd).
+			WithCondition(cond.And(versionCondition(item.Version))).
+			Build()
 		if err != nil {
 			return nil, err
 		}
 
 		updates[idx] = &dynamodb.Update{
-			TableName:                 table,
+			TableName:                 r.table,
 			Key:                       pk,
 			ExpressionAttributeNames:  expr.Names(),
 			ExpressionAttributeValues: expr.Values(),
@@ -114,21 +112,187 @@ func invoiceItemsToUpdates(items []invoice.Item, table *string, expr expression.
 	return updates, nil
 }
 
-func invoiceItemsToPuts(items []invoice.Item, table *string) ([]*dynamodb.Put, error) {
+func (r *Repository) invoiceItemsToPuts(items []invoice.Item) ([]*dynamodb.Put, error) {
 	putItems := make([]*dynamodb.Put, len(items))
 
 	for idx, item := range items {
-		dbitem := NewItem(item)
-		putItem, err := dynamodbattribute.MarshalMap(dbitem)
+		putItem, err := r.keys.itemRow(item)
 		if err != nil {
 			return nil, err
 		}
 
 		putItems[idx] = &dynamodb.Put{
-			TableName: table,
-			Item:      putItem,
+			TableName:           r.table,
+			Item:                putItem,
+			ConditionExpression: r.keys.notExistsCondition(),
 		}
 	}
 
 	return putItems, nil
 }
+
+// invoiceItemsUpdate creates transaction item that adds the difference of
+// item totals to the invoice and sets its payment status by the new total.
+// It fails when the invoice has no totals or changed since it was read.
+func (r *Repository) invoiceItemsUpdate(dbInvoice *Invoice, payments []invoice.Payment,
+	before, after []invoice.Item, at time.Time) (*dynamodb.TransactWriteItem, error) {
+
+	pk := r.keys.invoicePrimaryKey(dbInvoice.ID)
+
+	countBefore, totalBefore := invoice.ItemTotals(before)
+	countAfter, totalAfter := invoice.ItemTotals(after)
+	total := int(dbInvoice.Total) + int(totalAfter) - int(totalBefore)
+	if total < 0 {
+		total = 0
+	}
+	status := invoice.PaymentStatus(invoice.Status(dbInvoice.Status), invoice.NewBalance(uint(total), payments))
+
+	upd := expression.
+		Set(expression.Name("status"), expression.Value(status)).
+		Set(expression.Name("updatedAt"), expression.Value(at)).
+		Add(expression.Name("itemCount"), expression.Value(int(countAfter)-int(countBefore))).
+		Add(expression.Name("total"), expression.Value(int(totalAfter)-int(totalBefore))).
+		Set(expression.Name("version"), nextVersion)
+	cond := expression.AttributeExists(expression.Name(r.keys.PartitionKey)).
+		And(expression.AttributeExists(expression.Name("itemCount"))).
+		And(expression.AttributeExists(expression.Name("total"))).
+		And(versionCondition(dbInvoice.Version))
+	expr, err := expression.NewBuilder().WithUpdate(upd).WithCondition(cond).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	update := &dynamodb.Update{
+		TableName:                 r.table,
+		Key:                       pk,
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ConditionExpression:       expr.Condition(),
+		UpdateExpression:          expr.Update(),
+	}
+
+	return &dynamodb.TransactWriteItem{Update: update}, nil
+}
+
+// itemChanges creates transaction items that update the invoice of changed
+// items and record the change. The invoice update goes first. The invoice
+// and its payments are read consistently, so that the payment status follows
+// the total. It returns invoice.ErrInvoiceNotFound when the invoice does not
+// exist.
+func (r *Repository) itemChanges(ctx context.Context, invoiceID string, op invoice.Operation,
+	before, after invoice.Snapshot, at time.Time) ([]*dynamodb.TransactWriteItem, error) {
+
+	dbInvoice, err := r.getInvoiceRow(ctx, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	if dbInvoice == nil {
+		return nil, invoice.ErrInvoiceNotFound
+	}
+
+	payments, err := r.GetPayments(ctx, invoiceID, invoice.ConsistentRead())
+	if err != nil {
+		return nil, err
+	}
+
+	invoiceUpdate, err := r.invoiceItemsUpdate(dbInvoice, payments, before.Items, after.Items, at)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := r.changePuts(ctx, invoiceID, op, before, after)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]*dynamodb.TransactWriteItem{invoiceUpdate}, changes...), nil
+}
+
+// transactItemChanges writes item rows together with item changes created
+// by itemChanges. When the invoice update fails invoices without totals get
+// them, and changes are created again from the invoice as it is now. It
+// returns invoice.ErrVersionConflict when the invoice keeps changing.
+func (r *Repository) transactItemChanges(ctx context.Context, invoiceID string,
+	writes []*dynamodb.TransactWriteItem, changes func() ([]*dynamodb.TransactWriteItem, error)) error {
+
+	for attempt := 0; attempt < invoiceRetries; attempt++ {
+		changeItems, err := changes()
+		if err != nil {
+			return err
+		}
+
+		transactItems := append(append([]*dynamodb.TransactWriteItem{}, writes...), changeItems...)
+		if err = r.transact(ctx, transactItems); !conditionFailed(err, len(writes)) {
+			return err
+		}
+
+		if _, err := r.backfillTotals(ctx, invoiceID); err != nil {
+			return err
+		}
+	}
+	return invoice.ErrVersionConflict
+}
+
+// backfillTotals sets item count and total of the invoice written before
+// they were maintained from its consistently read items. Item changes of
+// such invoice fail until it has totals, so the items do not change
+// concurrently. It reports whether the invoice exists.
+func (r *Repository) backfillTotals(ctx context.Context, invoiceID string) (bool, error) {
+	items, err := collectItems(r.IterateInvoiceItems(ctx, invoiceID, invoice.ConsistentRead()))
+	if err != nil {
+		return false, err
+	}
+
+	count, total := invoice.ItemTotals(items)
+	upd := expression.
+		Set(expression.Name("itemCount"), expression.IfNotExists(expression.Name("itemCount"), expression.Value(count))).
+		Set(expression.Name("total"), expression.IfNotExists(expression.Name("total"), expression.Value(total)))
+	cond := expression.AttributeExists(expression.Name(r.keys.PartitionKey))
+	expr, err := expression.NewBuilder().WithUpdate(upd).WithCondition(cond).Build()
+	if err != nil {
+		return false, err
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName:                 r.table,
+		Key:                       r.keys.invoicePrimaryKey(invoiceID),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ConditionExpression:       expr.Condition(),
+		UpdateExpression:          expr.Update(),
+	}
+
+	_, err = r.client.UpdateItemWithContext(ctx, input)
+	if isConditionalCheckFailed(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// isConditionalCheckFailed reports whether the condition of a single row
+// write failed.
+func isConditionalCheckFailed(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+// conditionFailed reports whether the transaction was canceled because the
+// condition of transaction item idx failed.
+func conditionFailed(err error, idx int) bool {
+	var canceled *dynamodb.TransactionCanceledException
+	if !errors.As(err, &canceled) || idx >= len(canceled.CancellationReasons) {
+		return false
+	}
+	return aws.StringValue(canceled.CancellationReasons[idx].Code) == "ConditionalCheckFailed"
+}
+
+// transact writes transaction items in a single TransactWriteItems call.
+func (r *Repository) transact(ctx context.Context, transactItems []*dynamodb.TransactWriteItem) error {
+	transaction := &dynamodb.TransactWriteItemsInput{TransactItems: transactItems}
+	if err := transaction.Validate(); err != nil {
+		return err
+	}
+
+	_, err := r.client.TransactWriteItemsWithContext(ctx, transaction)
+	return err
+}
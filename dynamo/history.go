@@ -0,0 +1,228 @@
+package dynamo
+
+import (
+	"context"
+	"time"
+
+	"github.com/antklim/go-dynamodb/invoice"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/google/uuid"
+)
+
+const sortableTime = "2006-01-02T15:04:05.000000000Z" // fixed width UTC time, sorts lexicographically
+
+// Snapshot describes dynamodb representation of invoice.Snapshot
+type Snapshot struct {
+	Invoice    *Invoice    `dynamodbav:"invoice,omitempty"`
+	Items      []Item      `dynamodbav:"items,omitempty"`
+	Payment    *Payment    `dynamodbav:"payment,omitempty"`
+	CreditNote *CreditNote `dynamodbav:"creditNote,omitempty"`
+}
+
+// NewSnapshot creates an instance of DynamoDB snapshot from invoice.Snapshot.
+func NewSnapshot(s invoice.Snapshot) Snapshot {
+	var snapshot Snapshot
+	if s.Invoice != nil {
+		inv := NewInvoice(*s.Invoice)
+		snapshot.Invoice = &inv
+	}
+
+	for _, item := range s.Items {
+		snapshot.Items = append(snapshot.Items, NewItem(item))
+	}
+
+	if s.Payment != nil {
+		p := NewPayment(*s.Payment)
+		snapshot.Payment = &p
+	}
+
+	if s.CreditNote != nil {
+		cn := NewCreditNote(*s.CreditNote)
+		snapshot.CreditNote = &cn
+	}
+
+	return snapshot
+}
+
+// ToSnapshot creates an instance of invoice.Snapshot from DynamoDB snapshot.
+func (s *Snapshot) ToSnapshot() (invoice.Snapshot, error) {
+	var snapshot invoice.Snapshot
+	if s.Invoice != nil {
+		inv, err := s.Invoice.ToInvoice()
+		if err != nil {
+			return snapshot, err
+		}
+		snapshot.Invoice = inv
+	}
+
+	for _, item := range s.Items {
+		snapshot.Items = append(snapshot.Items, item.ToItem())
+	}
+
+	if s.Payment != nil {
+		p := s.Payment.ToPayment()
+		snapshot.Payment = &p
+	}
+
+	if s.CreditNote != nil {
+		cn, err := s.CreditNote.ToCreditNote()
+		if err != nil {
+			return snapshot, err
+		}
+		snapshot.CreditNote = cn
+	}
+
+	return snapshot, nil
+}
+
+// Change describes dynamodb representation of invoice.Change
+type Change struct {
+	PK        string    `dynamodbav:"pk"`
+	SK        string    `dynamodbav:"sk"`
+	ID        string    `dynamodbav:"id"`
+	Tenant    string    `dynamodbav:"tenant,omitempty"`
+	InvoiceID string    `dynamodbav:"invoiceId"`
+	Operation string    `dynamodbav:"operation"`
+	Actor     string    `dynamodbav:"actor"`
+	At        time.Time `dynamodbav:"at"`
+	Before    Snapshot  `dynamodbav:"before"`
+	After     Snapshot  `dynamodbav:"after"`
+}
+
+// NewChange creates an instance of DynamoDB change from invoice.Change.
+func NewChange(c invoice.Change) Change {
+	return Change{
+		PK:        defaultKeys.invoicePartitionKey(c.InvoiceID),
+		SK:        defaultKeys.historySortKey(c.At, c.ID),
+		ID:        c.ID,
+		Tenant:    c.Tenant,
+		InvoiceID: c.InvoiceID,
+		Operation: string(c.Operation),
+		Actor:     c.Actor,
+		At:        c.At,
+		Before:    NewSnapshot(c.Before),
+		After:     NewSnapshot(c.After),
+	}
+}
+
+// ToChange creates an instance of invoice.Change from DynamoDB change.
+func (c *Change) ToChange() (*invoice.Change, error) {
+	before, err := c.Before.ToSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := c.After.ToSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	return &invoice.Change{
+		ID:        c.ID,
+		Tenant:    c.Tenant,
+		InvoiceID: c.InvoiceID,
+		Operation: invoice.Operation(c.Operation),
+		Actor:     c.Actor,
+		At:        c.At,
+		Before:    before,
+		After:     after,
+	}, nil
+}
+
+// changePuts creates transaction items that record the change of the
+// invoice in its history and the outbox event caused by the change.
+func (r *Repository) changePuts(ctx context.Context, invoiceID string, op invoice.Operation,
+	before, after invoice.Snapshot) ([]*dynamodb.TransactWriteItem, error) {
+
+	change := invoice.Change{
+		ID:        uuid.NewString(),
+		Tenant:    r.keys.Tenant,
+		InvoiceID: invoiceID,
+		Operation: op,
+		Actor:     invoice.ActorFromContext(ctx),
+		At:        r.clock.Now(),
+		Before:    before,
+		After:     after,
+	}
+
+	changeRow, err := r.keys.changeRow(change)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := []map[string]*dynamodb.AttributeValue{changeRow}
+	if event, ok := invoice.EventFromChange(change); ok {
+		eventRow, err := r.keys.eventRow(event)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, eventRow)
+	}
+
+	transactItems := make([]*dynamodb.TransactWriteItem, len(rows))
+	for idx, item := range rows {
+		put := &dynamodb.Put{
+			TableName:           r.table,
+			Item:                item,
+			ConditionExpression: r.keys.notExistsCondition(),
+		}
+		transactItems[idx] = &dynamodb.TransactWriteItem{Put: put}
+	}
+
+	return transactItems, nil
+}
+
+func (r *Repository) GetInvoiceHistory(
+	ctx context.Context, invoiceID string, opts ...invoice.ReadOption) ([]invoice.Change, error) {
+
+	r, err := r.scoped(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pk := r.keys.invoicePartitionKey(invoiceID)
+	keyCond := expression.KeyAnd(
+		expression.Key(r.keys.PartitionKey).Equal(expression.Value(pk)),
+		expression.Key(r.keys.SortKey).BeginsWith(r.keys.keyPrefix(r.keys.Prefixes.History)),
+	)
+
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:                 r.table,
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ConsistentRead:            r.consistentRead(opts),
+	}
+
+	rows, err := collectRows(ctx, r.queryPages(input))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	dbChanges := []*Change{}
+	if err := dynamodbattribute.UnmarshalListOfMaps(rows, &dbChanges); err != nil {
+		return nil, err
+	}
+
+	changes := make([]invoice.Change, len(dbChanges))
+	for idx, dbChange := range dbChanges {
+		change, err := dbChange.ToChange()
+		if err != nil {
+			return nil, err
+		}
+		changes[idx] = *change
+	}
+
+	return changes, nil
+}
@@ -0,0 +1,152 @@
+package dynamo
+
+import (
+	"context"
+
+	"github.com/antklim/go-dynamodb/invoice"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+type rawItem = map[string]*dynamodb.AttributeValue
+
+// pageFetcher fetches a page of raw items starting from startKey. It returns
+// the key to continue from, nil key means there are no more pages.
+type pageFetcher func(ctx context.Context, startKey rawItem) (page []rawItem, lastKey rawItem, err error)
+
+// itemIterator implements invoice.ItemIterator. Pages are fetched lazily
+// when all items of the current page have been consumed.
+type itemIterator struct {
+	ctx     context.Context
+	fetch   pageFetcher
+	page    []rawItem
+	lastKey rawItem
+	started bool
+	closed  bool
+	item    invoice.Item
+	err     error
+}
+
+func newItemIterator(ctx context.Context, fetch pageFetcher) *itemIterator {
+	return &itemIterator{ctx: ctx, fetch: fetch}
+}
+
+func (it *itemIterator) Next() bool {
+	for {
+		if it.err != nil || it.closed {
+			return false
+		}
+
+		if err := it.ctx.Err(); err != nil {
+			it.err = err
+			return false
+		}
+
+		if len(it.page) > 0 {
+			item, err := toItem(it.page[0])
+			it.page = it.page[1:]
+			if err != nil {
+				it.err = err
+				return false
+			}
+
+			it.item = *item
+			return true
+		}
+
+		if it.started && it.lastKey == nil {
+			return false
+		}
+
+		it.started = true
+		it.page, it.lastKey, it.err = it.fetch(it.ctx, it.lastKey)
+	}
+}
+
+func (it *itemIterator) Item() invoice.Item {
+	return it.item
+}
+
+func (it *itemIterator) Err() error {
+	return it.err
+}
+
+func (it *itemIterator) Close() error {
+	it.closed = true
+	it.page = nil
+	return nil
+}
+
+func (r *Repository) scanPages(input *dynamodb.ScanInput) pageFetcher {
+	return func(ctx context.Context, startKey rawItem) ([]rawItem, rawItem, error) {
+		input.ExclusiveStartKey = startKey
+		result, err := r.client.ScanWithContext(ctx, input)
+		if err != nil {
+			return nil, nil, err
+		}
+		return result.Items, result.LastEvaluatedKey, nil
+	}
+}
+
+func (r *Repository) queryPages(input *dynamodb.QueryInput) pageFetcher {
+	return func(ctx context.Context, startKey rawItem) ([]rawItem, rawItem, error) {
+		input.ExclusiveStartKey = startKey
+		result, err := r.client.QueryWithContext(ctx, input)
+		if err != nil {
+			return nil, nil, err
+		}
+		return result.Items, result.LastEvaluatedKey, nil
+	}
+}
+
+// collectItems drains the iterator. It returns nil when iterator has no items.
+func collectItems(it invoice.ItemIterator) ([]invoice.Item, error) {
+	defer it.Close()
+
+	var items []invoice.Item
+	for it.Next() {
+		items = append(items, it.Item())
+	}
+
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// collectRows fetches all pages of raw items.
+func collectRows(ctx context.Context, fetch pageFetcher) ([]rawItem, error) {
+	var rows []rawItem
+	var startKey rawItem
+	for {
+		page, lastKey, err := fetch(ctx, startKey)
+		if err != nil {
+			return nil, err
+		}
+
+		rows = append(rows, page...)
+		if lastKey == nil {
+			return rows, nil
+		}
+		startKey = lastKey
+	}
+}
+
+// errIterator is an iterator that yields no items and reports err.
+type errIterator struct {
+	err error
+}
+
+func (it errIterator) Next() bool         { return false }
+func (it errIterator) Item() invoice.Item { return invoice.Item{} }
+func (it errIterator) Err() error         { return it.err }
+func (it errIterator) Close() error       { return nil }
+
+// consistentRead returns the ConsistentRead parameter of the read with opts.
+func (r *Repository) consistentRead(opts []invoice.ReadOption) *bool {
+	if r.consistent || invoice.NewReadOptions(opts...).ConsistentRead {
+		return aws.Bool(true)
+	}
+	return nil
+}
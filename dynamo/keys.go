@@ -0,0 +1,361 @@
+package dynamo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/antklim/go-dynamodb/invoice"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+const (
+	defaultPartitionKey = "pk"
+	defaultSortKey      = "sk"
+	defaultSeparator    = "#"
+)
+
+// KeyPrefixes are the first segments of keys of every row type. Empty
+// prefixes take default values.
+type KeyPrefixes struct {
+	Invoice    string // INVOICE, partition key of invoices and sort key of invoice rows
+	Item       string // ITEM, sort key of items, items are in the invoice partition
+	Payment    string // PAYMENT, sort key of payments in the invoice partition
+	CreditNote string // CREDIT_NOTE, sort key of credit notes in the invoice partition
+	History    string // HISTORY, sort key of changes in the invoice partition
+	Counter    string // COUNTER, partition and sort key of counters
+	Outbox     string // OUTBOX, partition key of outbox events
+	Event      string // EVENT, sort key of outbox events
+	Migration  string // MIGRATION, partition key of the migration record
+	Tenant     string // TENANT, precedes the tenant segment of partition keys
+}
+
+// KeySchema is the key layout of the table rows. Zero fields take default
+// values, so KeySchema{} is the default layout: pk and sk attributes and
+// keys such as INVOICE#<invoiceId>.
+//
+// When Tenant is set partition keys of invoices, their rows and counters
+// start with the tenant segment, e.g. TENANT#<tenant>#INVOICE#<invoiceId>,
+// and rows of other tenants are ignored. Without Tenant rows of all tenants
+// are ignored. The outbox and the migration record are shared by all
+// tenants, outbox events carry the tenant of their invoice.
+type KeySchema struct {
+	PartitionKey string // partition key attribute, defaults to pk
+	SortKey      string // sort key attribute, defaults to sk
+	Separator    string // separator of key segments, defaults to #
+	Prefixes     KeyPrefixes
+	Tenant       string
+}
+
+// WithKeyAttributes sets names of the partition and sort key attributes.
+func WithKeyAttributes(partitionKey, sortKey string) Option {
+	return func(r *Repository) {
+		r.keys.PartitionKey, r.keys.SortKey = partitionKey, sortKey
+	}
+}
+
+// WithKeySeparator sets the separator of key segments.
+func WithKeySeparator(sep string) Option {
+	return func(r *Repository) {
+		r.keys.Separator = sep
+	}
+}
+
+// WithKeyPrefixes sets key prefixes, empty prefixes keep default values.
+func WithKeyPrefixes(p KeyPrefixes) Option {
+	return func(r *Repository) {
+		r.keys.Prefixes = p
+	}
+}
+
+// WithTenant adds the tenant segment to partition keys, see KeySchema.
+func WithTenant(tenant string) Option {
+	return func(r *Repository) {
+		r.keys.Tenant = tenant
+	}
+}
+
+// KeySchema returns the key layout of the repository.
+func (r *Repository) KeySchema() KeySchema {
+	return r.keys
+}
+
+// IsInvoiceRow reports whether the raw row is an invoice.
+func (s KeySchema) IsInvoiceRow(row map[string]*dynamodb.AttributeValue) bool {
+	s = s.normalized()
+	return s.ownsPartition(row) && s.hasSortKeyPrefix(row, s.Prefixes.Invoice)
+}
+
+// IsItemRow reports whether the raw row is an invoice item.
+func (s KeySchema) IsItemRow(row map[string]*dynamodb.AttributeValue) bool {
+	s = s.normalized()
+	return s.ownsPartition(row) && s.hasSortKeyPrefix(row, s.Prefixes.Item)
+}
+
+// normalized returns the schema with default values of zero fields.
+func (s KeySchema) normalized() KeySchema {
+	setDefault := func(v *string, def string) {
+		if *v == "" {
+			*v = def
+		}
+	}
+
+	setDefault(&s.PartitionKey, defaultPartitionKey)
+	setDefault(&s.SortKey, defaultSortKey)
+	setDefault(&s.Separator, defaultSeparator)
+	setDefault(&s.Prefixes.Invoice, "INVOICE")
+	setDefault(&s.Prefixes.Item, "ITEM")
+	setDefault(&s.Prefixes.Payment, "PAYMENT")
+	setDefault(&s.Prefixes.CreditNote, "CREDIT_NOTE")
+	setDefault(&s.Prefixes.History, "HISTORY")
+	setDefault(&s.Prefixes.Counter, "COUNTER")
+	setDefault(&s.Prefixes.Outbox, "OUTBOX")
+	setDefault(&s.Prefixes.Event, "EVENT")
+	setDefault(&s.Prefixes.Migration, "MIGRATION")
+	setDefault(&s.Prefixes.Tenant, "TENANT")
+	return s
+}
+
+// defaultKeys is the default layout used by row constructors such as
+// NewInvoice and by package level row functions.
+var defaultKeys = KeySchema{}.normalized()
+
+// Methods below expect normalized schema.
+
+func (s KeySchema) join(elems ...string) string {
+	return strings.Join(elems, s.Separator)
+}
+
+// partitionKey prepends the tenant segment to elems.
+func (s KeySchema) partitionKey(elems ...string) string {
+	if s.Tenant != "" {
+		elems = append([]string{s.Prefixes.Tenant, s.Tenant}, elems...)
+	}
+	return s.join(elems...)
+}
+
+// keyPrefix is the begins_with operand matching keys with the prefix.
+func (s KeySchema) keyPrefix(prefix string) string {
+	return prefix + s.Separator
+}
+
+func (s KeySchema) invoicePartitionKey(invoiceID string) string {
+	return s.partitionKey(s.Prefixes.Invoice, invoiceID)
+}
+
+func (s KeySchema) invoiceSortKey(invoiceID string) string {
+	return s.join(s.Prefixes.Invoice, invoiceID)
+}
+
+func (s KeySchema) itemPartitionKey(invoiceID string) string {
+	return s.invoicePartitionKey(invoiceID) // invoice items are in the same partition as the invoice
+}
+
+func (s KeySchema) itemSortKey(itemID string) string {
+	return s.join(s.Prefixes.Item, itemID)
+}
+
+// invoiceRowsRange returns sort key bounds of the invoice row and its items.
+// Prefixes may be configured in any order, so the range may also cover rows
+// of other types sorted between them.
+func (s KeySchema) invoiceRowsRange(invoiceID string) (string, string) {
+	invoiceKey := s.invoiceSortKey(invoiceID)
+	itemsFrom := s.keyPrefix(s.Prefixes.Item)
+	itemsTo := itemsFrom + string(utf8.MaxRune)
+	if invoiceKey < itemsFrom {
+		return invoiceKey, itemsTo
+	}
+	if invoiceKey > itemsTo {
+		return itemsFrom, invoiceKey
+	}
+	return itemsFrom, itemsTo
+}
+
+func (s KeySchema) paymentSortKey(paymentID string) string {
+	return s.join(s.Prefixes.Payment, paymentID)
+}
+
+func (s KeySchema) creditNoteSortKey(creditNoteID string) string {
+	return s.join(s.Prefixes.CreditNote, creditNoteID)
+}
+
+func (s KeySchema) historySortKey(at time.Time, changeID string) string {
+	return s.join(s.Prefixes.History, at.UTC().Format(sortableTime), changeID)
+}
+
+func (s KeySchema) counterKey(name string) string {
+	return s.partitionKey(s.Prefixes.Counter, name)
+}
+
+func (s KeySchema) outboxPartitionKey() string {
+	return s.Prefixes.Outbox
+}
+
+func (s KeySchema) outboxSortKey(at time.Time, eventID string) string {
+	return s.join(s.Prefixes.Event, at.UTC().Format(sortableTime), eventID)
+}
+
+func (s KeySchema) primaryKey(pk, sk string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		s.PartitionKey: {S: aws.String(pk)},
+		s.SortKey:      {S: aws.String(sk)},
+	}
+}
+
+func (s KeySchema) invoicePrimaryKey(invoiceID string) map[string]*dynamodb.AttributeValue {
+	return s.primaryKey(s.invoicePartitionKey(invoiceID), s.invoiceSortKey(invoiceID))
+}
+
+func (s KeySchema) itemPrimaryKey(invoiceID, itemID string) map[string]*dynamodb.AttributeValue {
+	return s.primaryKey(s.itemPartitionKey(invoiceID), s.itemSortKey(itemID))
+}
+
+func (s KeySchema) paymentPrimaryKey(invoiceID, paymentID string) map[string]*dynamodb.AttributeValue {
+	return s.primaryKey(s.invoicePartitionKey(invoiceID), s.paymentSortKey(paymentID))
+}
+
+func (s KeySchema) creditNotePrimaryKey(invoiceID, creditNoteID string) map[string]*dynamodb.AttributeValue {
+	return s.primaryKey(s.invoicePartitionKey(invoiceID), s.creditNoteSortKey(creditNoteID))
+}
+
+func (s KeySchema) counterPrimaryKey(name string) map[string]*dynamodb.AttributeValue {
+	return s.primaryKey(s.counterKey(name), s.counterKey(name))
+}
+
+func (s KeySchema) migrationPrimaryKey() map[string]*dynamodb.AttributeValue {
+	return s.primaryKey(s.Prefixes.Migration, migrationSk)
+}
+
+// notExistsCondition prevents a put from overwriting an existing row.
+func (s KeySchema) notExistsCondition() *string {
+	return aws.String("attribute_not_exists(" + s.PartitionKey + ")")
+}
+
+// rowKey returns the key attributes of the row.
+func (s KeySchema) rowKey(row map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{s.PartitionKey: row[s.PartitionKey], s.SortKey: row[s.SortKey]}
+}
+
+// marshalRow marshals v, a row struct with pk and sk fields, and sets its
+// key attributes.
+func (s KeySchema) marshalRow(v interface{}, pk, sk string) (map[string]*dynamodb.AttributeValue, error) {
+	row, err := dynamodbattribute.MarshalMap(v)
+	if err != nil {
+		return nil, err
+	}
+
+	delete(row, defaultPartitionKey)
+	delete(row, defaultSortKey)
+	row[s.PartitionKey] = &dynamodb.AttributeValue{S: aws.String(pk)}
+	row[s.SortKey] = &dynamodb.AttributeValue{S: aws.String(sk)}
+	return row, nil
+}
+
+func (s KeySchema) invoiceRow(inv invoice.Invoice) (map[string]*dynamodb.AttributeValue, error) {
+	return s.marshalRow(NewInvoice(inv), s.invoicePartitionKey(inv.ID), s.invoiceSortKey(inv.ID))
+}
+
+func (s KeySchema) itemRow(item invoice.Item) (map[string]*dynamodb.AttributeValue, error) {
+	return s.marshalRow(NewItem(item), s.itemPartitionKey(item.InvoiceID), s.itemSortKey(item.ID))
+}
+
+func (s KeySchema) paymentRow(p invoice.Payment) (map[string]*dynamodb.AttributeValue, error) {
+	return s.marshalRow(NewPayment(p), s.invoicePartitionKey(p.InvoiceID), s.paymentSortKey(p.ID))
+}
+
+func (s KeySchema) creditNoteRow(cn invoice.CreditNote) (map[string]*dynamodb.AttributeValue, error) {
+	return s.marshalRow(NewCreditNote(cn), s.invoicePartitionKey(cn.InvoiceID), s.creditNoteSortKey(cn.ID))
+}
+
+func (s KeySchema) changeRow(c invoice.Change) (map[string]*dynamodb.AttributeValue, error) {
+	return s.marshalRow(NewChange(c), s.invoicePartitionKey(c.InvoiceID), s.historySortKey(c.At, c.ID))
+}
+
+func (s KeySchema) eventRow(e invoice.Event) (map[string]*dynamodb.AttributeValue, error) {
+	return s.marshalRow(NewEvent(e), s.outboxPartitionKey(), s.outboxSortKey(e.OccurredAt, e.ID))
+}
+
+func (s KeySchema) keyValue(row map[string]*dynamodb.AttributeValue, attr string) string {
+	if v := row[attr]; v != nil {
+		return aws.StringValue(v.S)
+	}
+	return ""
+}
+
+func (s KeySchema) hasSortKeyPrefix(row map[string]*dynamodb.AttributeValue, prefix string) bool {
+	return strings.HasPrefix(s.keyValue(row, s.SortKey), s.keyPrefix(prefix))
+}
+
+// tenantPrefix is the begins_with operand matching partition keys of the
+// tenant, or of all tenants without tenant.
+func (s KeySchema) tenantPrefix() string {
+	if s.Tenant == "" {
+		return s.keyPrefix(s.Prefixes.Tenant)
+	}
+	return s.keyPrefix(s.join(s.Prefixes.Tenant, s.Tenant))
+}
+
+// ownsPartition reports whether the row belongs to the tenant of the schema.
+func (s KeySchema) ownsPartition(row map[string]*dynamodb.AttributeValue) bool {
+	tenantRow := strings.HasPrefix(s.keyValue(row, s.PartitionKey), s.tenantPrefix())
+	return tenantRow == (s.Tenant != "")
+}
+
+// rowTenant returns the tenant of the row partition, or empty string for
+// rows stored without tenant.
+func (s KeySchema) rowTenant(row map[string]*dynamodb.AttributeValue) string {
+	pk := s.keyValue(row, s.PartitionKey)
+	if !strings.HasPrefix(pk, s.keyPrefix(s.Prefixes.Tenant)) {
+		return ""
+	}
+	tenant := strings.TrimPrefix(pk, s.keyPrefix(s.Prefixes.Tenant))
+	if idx := strings.Index(tenant, s.Separator); idx >= 0 {
+		tenant = tenant[:idx]
+	}
+	return tenant
+}
+
+// tenantFilter matches rows owned by the tenant of the schema in scans.
+func (s KeySchema) tenantFilter() expression.ConditionBuilder {
+	cond := expression.Name(s.PartitionKey).BeginsWith(s.tenantPrefix())
+	if s.Tenant == "" {
+		return expression.Not(cond)
+	}
+	return cond
+}
+
+// scoped returns the repository bound to the tenant of ctx, see
+// invoice.WithTenant. Methods reading or writing invoices shadow their
+// receiver with it, so keys of the call carry the tenant.
+func (r *Repository) scoped(ctx context.Context) (*Repository, error) {
+	tenant, err := invoice.ResolveTenant(ctx, r.keys.Tenant)
+	if err != nil {
+		return nil, err
+	}
+	// tenant containing the separator would match keys of other tenants
+	if tenant != "" && strings.Contains(tenant, r.keys.Separator) {
+		return nil, fmt.Errorf("%w: %q", invoice.ErrInvalidTenant, tenant)
+	}
+	return r.withTenant(tenant), nil
+}
+
+// rowScoped returns the repository bound to the tenant of the raw row.
+// Migrations use it to read rows related to rows of any tenant.
+func (r *Repository) rowScoped(row map[string]*dynamodb.AttributeValue) *Repository {
+	return r.withTenant(r.keys.rowTenant(row))
+}
+
+func (r *Repository) withTenant(tenant string) *Repository {
+	if tenant == r.keys.Tenant {
+		return r
+	}
+
+	scoped := *r
+	scoped.keys.Tenant = tenant
+	return &scoped
+}
@@ -0,0 +1,467 @@
+package dynamo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+const migrationSk = "STATE"
+
+// migrationRetries is the number of attempts to rewrite a row when it
+// changes concurrently.
+const migrationRetries = 3
+
+var (
+	// ErrInvalidMigration is returned for migrations without rewrite or
+	// not in strictly increasing version order.
+	ErrInvalidMigration = errors.New("invalid migration")
+	// ErrUnknownMigration is returned when the table was migrated to a
+	// version newer than the latest known migration.
+	ErrUnknownMigration = errors.New("table migrated to unknown version")
+)
+
+// RewriteFunc returns the rewritten row, or nil when the row needs no
+// change. It must return nil for rows it has already rewritten, so that an
+// interrupted migration can be run again. A rewritten row with other keys
+// replaces the original row.
+type RewriteFunc func(ctx context.Context, r *Repository, row map[string]*dynamodb.AttributeValue) (
+	map[string]*dynamodb.AttributeValue, error)
+
+// Migration is a versioned rewrite of the table rows.
+type Migration struct {
+	Version     int // greater than the version of the previous migration
+	Description string
+	Rewrite     RewriteFunc
+}
+
+// MigrateOptions configures Migrate.
+type MigrateOptions struct {
+	DryRun        bool    // count rows to rewrite without writing them
+	PageSize      int64   // maximum number of rows evaluated per scan request, 0 means DynamoDB default
+	ReadCapacity  float64 // maximum read capacity units consumed per second, 0 means no limit
+	WriteCapacity float64 // maximum rewritten rows per second, 0 means no limit
+}
+
+// MigrationReport describes the applied, or in dry run pending, migration.
+type MigrationReport struct {
+	Version     int
+	Description string
+	Scanned     int // rows evaluated, including rows scanned by the interrupted run
+	Rewritten   int // rows rewritten in place or replaced
+	Rekeyed     int // rewritten rows with changed keys
+	Resumed     bool
+	DryRun      bool
+}
+
+// MigrationState is the migration record kept in the table.
+type MigrationState struct {
+	Version int // version of the last completed migration
+	Running int // version of the interrupted migration, 0 if none
+	// Checkpoint is the scan start key the interrupted migration resumes from.
+	Checkpoint map[string]*dynamodb.AttributeValue
+	Scanned    int
+	Rewritten  int
+	Rekeyed    int
+	UpdatedAt  time.Time
+}
+
+// MigrationState reads the migration record of the table. Tables never
+// migrated have zero version.
+func (r *Repository) MigrationState(ctx context.Context) (MigrationState, error) {
+	result, err := r.client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      r.table,
+		Key:            r.keys.migrationPrimaryKey(),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return MigrationState{}, err
+	}
+	if result.Item == nil {
+		return MigrationState{}, nil
+	}
+	return decodeMigrationState(result.Item)
+}
+
+// Migrate applies migrations newer than the table version in order. Every
+// migration scans the whole table and writes rewritten rows one by one,
+// bypassing the history and outbox. Every write is conditioned on the row
+// being unchanged since it was read, rows changed concurrently by the
+// application are read again and rewritten, see writeMigrated. Rows of all
+// tenants are scanned. Progress is checkpointed in the
+// migration record after every scanned page, so a failed run resumes where
+// it stopped. Migrate must not run concurrently with itself.
+//
+// In dry run migrations are evaluated against the current rows, nothing is
+// written. It returns reports of applied or pending migrations.
+func (r *Repository) Migrate(ctx context.Context, migrations []Migration, opts MigrateOptions) (
+	[]MigrationReport, error) {
+
+	if err := validateMigrations(migrations); err != nil {
+		return nil, err
+	}
+
+	state, err := r.MigrationState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if n := len(migrations); state.Version > 0 && (n == 0 || state.Version > migrations[n-1].Version) {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownMigration, state.Version)
+	}
+
+	var reports []MigrationReport
+	for _, m := range migrations {
+		if m.Version <= state.Version {
+			continue
+		}
+
+		report, err := r.migrate(ctx, m, &state, opts)
+		reports = append(reports, report)
+		if err != nil {
+			return reports, fmt.Errorf("migration %d: %w", m.Version, err)
+		}
+	}
+	return reports, nil
+}
+
+func (r *Repository) migrate(ctx context.Context, m Migration, state *MigrationState, opts MigrateOptions) (
+	MigrationReport, error) {
+
+	report := MigrationReport{Version: m.Version, Description: m.Description, DryRun: opts.DryRun}
+
+	input := &dynamodb.ScanInput{TableName: r.table}
+	if opts.PageSize > 0 {
+		input.Limit = aws.Int64(opts.PageSize)
+	}
+	if state.Running == m.Version && !opts.DryRun {
+		input.ExclusiveStartKey = state.Checkpoint
+		report.Scanned, report.Rewritten, report.Rekeyed = state.Scanned, state.Rewritten, state.Rekeyed
+		report.Resumed = true
+	}
+
+	var readLimiter, writeLimiter *capacityLimiter
+	if opts.ReadCapacity > 0 {
+		readLimiter = newCapacityLimiter(opts.ReadCapacity)
+		input.ReturnConsumedCapacity = aws.String(dynamodb.ReturnConsumedCapacityTotal)
+	}
+	if opts.WriteCapacity > 0 {
+		writeLimiter = newCapacityLimiter(opts.WriteCapacity)
+	}
+
+	for {
+		if err := readLimiter.wait(ctx); err != nil {
+			return report, err
+		}
+		result, err := r.client.ScanWithContext(ctx, input)
+		if err != nil {
+			return report, err
+		}
+		if result.ConsumedCapacity != nil {
+			readLimiter.consume(aws.Float64Value(result.ConsumedCapacity.CapacityUnits))
+		}
+
+		for _, row := range result.Items {
+			if r.keys.isMigrationRow(row) {
+				continue
+			}
+			report.Scanned++
+
+			rewritten, err := m.Rewrite(ctx, r, row)
+			if err != nil {
+				return report, err
+			}
+			if rewritten == nil {
+				continue
+			}
+
+			if opts.DryRun {
+				report.Rewritten++
+				if !r.keys.sameRowKey(row, rewritten) {
+					report.Rekeyed++
+				}
+				continue
+			}
+
+			written, rekeyed, err := r.writeMigrated(ctx, m, row, rewritten, writeLimiter)
+			if err != nil {
+				return report, err
+			}
+			if written {
+				report.Rewritten++
+			}
+			if rekeyed {
+				report.Rekeyed++
+			}
+		}
+
+		if !opts.DryRun {
+			*state = MigrationState{
+				Version:    state.Version,
+				Running:    m.Version,
+				Checkpoint: result.LastEvaluatedKey,
+				Scanned:    report.Scanned,
+				Rewritten:  report.Rewritten,
+				Rekeyed:    report.Rekeyed,
+			}
+			if result.LastEvaluatedKey == nil {
+				*state = MigrationState{Version: m.Version}
+			}
+			if err := r.putMigrationState(ctx, state); err != nil {
+				return report, err
+			}
+		}
+
+		if result.LastEvaluatedKey == nil {
+			return report, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+// writeMigrated writes the rewritten row conditioned on the scanned row
+// being unchanged, see unchangedCondition. Rows changed concurrently are read
+// again and rewritten, rows deleted or not needing rewrite any more are
+// skipped. It reports whether the row was written and whether it was
+// rekeyed.
+func (r *Repository) writeMigrated(ctx context.Context, m Migration, row, rewritten map[string]*dynamodb.AttributeValue,
+	limiter *capacityLimiter) (bool, bool, error) {
+
+	var err error
+	for attempt := 0; attempt < migrationRetries; attempt++ {
+		if attempt > 0 {
+			if row, err = r.getRow(ctx, r.keys.rowKey(row)); err != nil || row == nil {
+				return false, false, err
+			}
+			if rewritten, err = m.Rewrite(ctx, r, row); err != nil || rewritten == nil {
+				return false, false, err
+			}
+		}
+
+		if err = limiter.wait(ctx); err != nil {
+			return false, false, err
+		}
+		rekeyed := !r.keys.sameRowKey(row, rewritten)
+		if rekeyed {
+			err = r.replaceRow(ctx, row, rewritten)
+		} else {
+			err = r.putRow(ctx, row, rewritten)
+		}
+		limiter.consume(1)
+
+		if err == nil {
+			return true, rekeyed, nil
+		}
+		if !isConditionalCheckFailed(err) && !conditionFailed(err, 1) {
+			return false, false, err
+		}
+	}
+	return false, false, err
+}
+
+// putRow replaces the scanned row with the rewritten one of the same key.
+// The version of versioned rows is incremented, so readers of the scanned
+// row fail their conditional writes.
+func (r *Repository) putRow(ctx context.Context, row, rewritten map[string]*dynamodb.AttributeValue) error {
+	cond, err := r.unchangedCondition(row)
+	if err != nil {
+		return err
+	}
+	if rewritten, err = bumpVersion(row, rewritten); err != nil {
+		return err
+	}
+	expr, err := expression.NewBuilder().WithCondition(cond).Build()
+	if err != nil {
+		return err
+	}
+
+	_, err = r.client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:                 r.table,
+		Item:                      rewritten,
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ConditionExpression:       expr.Condition(),
+	})
+	return err
+}
+
+// replaceRow puts the rewritten row and deletes the scanned row of another
+// key in one transaction. The rewritten row must not exist, the delete is
+// the second transaction item.
+func (r *Repository) replaceRow(ctx context.Context, row, rewritten map[string]*dynamodb.AttributeValue) error {
+	cond, err := r.unchangedCondition(row)
+	if err != nil {
+		return err
+	}
+	expr, err := expression.NewBuilder().WithCondition(cond).Build()
+	if err != nil {
+		return err
+	}
+
+	return r.transact(ctx, []*dynamodb.TransactWriteItem{
+		{Put: &dynamodb.Put{
+			TableName:           r.table,
+			Item:                rewritten,
+			ConditionExpression: r.keys.notExistsCondition(),
+		}},
+		{Delete: &dynamodb.Delete{
+			TableName:                 r.table,
+			Key:                       r.keys.rowKey(row),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+			ConditionExpression:       expr.Condition(),
+		}},
+	})
+}
+
+// getRow reads the raw row consistently, it returns nil when the row does
+// not exist.
+func (r *Repository) getRow(ctx context.Context, key map[string]*dynamodb.AttributeValue) (
+	map[string]*dynamodb.AttributeValue, error) {
+
+	result, err := r.client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      r.table,
+		Key:            key,
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.Item, nil
+}
+
+// unchangedCondition checks that the row exists with the version and update
+// time it was scanned with. Application writes of invoices and items
+// increment their version, other rows set updatedAt when they change.
+func (r *Repository) unchangedCondition(row map[string]*dynamodb.AttributeValue) (expression.ConditionBuilder, error) {
+	version, err := rowVersion(row)
+	if err != nil {
+		return expression.ConditionBuilder{}, err
+	}
+
+	cond := expression.AttributeExists(expression.Name(r.keys.PartitionKey)).And(versionCondition(version))
+	if v := row["updatedAt"]; v != nil && v.S != nil {
+		return cond.And(expression.Name("updatedAt").Equal(expression.Value(*v.S))), nil
+	}
+	return cond.And(expression.AttributeNotExists(expression.Name("updatedAt"))), nil
+}
+
+// bumpVersion returns the rewritten row with the version following the
+// version of the scanned row. Rows without version are returned as is.
+func bumpVersion(row, rewritten map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, error) {
+	if row["version"] == nil {
+		return rewritten, nil
+	}
+	version, err := rowVersion(row)
+	if err != nil {
+		return nil, err
+	}
+
+	bumped := make(map[string]*dynamodb.AttributeValue, len(rewritten))
+	for k, v := range rewritten {
+		bumped[k] = v
+	}
+	bumped["version"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatUint(uint64(version)+1, 10))}
+	return bumped, nil
+}
+
+// rowVersion returns the version of the raw row, rows written before
+// versioning have version 0.
+func rowVersion(row map[string]*dynamodb.AttributeValue) (uint, error) {
+	n := storedNumber(row, "version")
+	if n == "" {
+		return 0, nil
+	}
+	version, err := strconv.ParseUint(n, 10, 0)
+	if err != nil {
+		return 0, fmt.Errorf("row version: %w", err)
+	}
+	return uint(version), nil
+}
+
+func (r *Repository) putMigrationState(ctx context.Context, state *MigrationState) error {
+	state.UpdatedAt = r.clock.Now()
+	_, err := r.client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: r.table,
+		Item:      r.keys.encodeMigrationState(*state),
+	})
+	return err
+}
+
+func validateMigrations(migrations []Migration) error {
+	prev := 0
+	for _, m := range migrations {
+		if m.Version <= prev {
+			return fmt.Errorf("%w: version %d follows %d", ErrInvalidMigration, m.Version, prev)
+		}
+		if m.Rewrite == nil {
+			return fmt.Errorf("%w: version %d has no rewrite", ErrInvalidMigration, m.Version)
+		}
+		prev = m.Version
+	}
+	return nil
+}
+
+func (s KeySchema) isMigrationRow(row map[string]*dynamodb.AttributeValue) bool {
+	return s.keyValue(row, s.PartitionKey) == s.Prefixes.Migration
+}
+
+func (s KeySchema) sameRowKey(a, b map[string]*dynamodb.AttributeValue) bool {
+	return reflect.DeepEqual(s.rowKey(a), s.rowKey(b))
+}
+
+// encodeMigrationState builds the migration row by hand, as checkpoint is
+// a raw DynamoDB key.
+func (keys KeySchema) encodeMigrationState(s MigrationState) map[string]*dynamodb.AttributeValue {
+	row := keys.migrationPrimaryKey()
+	row["version"] = numberAttr(s.Version)
+	row["running"] = numberAttr(s.Running)
+	row["scanned"] = numberAttr(s.Scanned)
+	row["rewritten"] = numberAttr(s.Rewritten)
+	row["rekeyed"] = numberAttr(s.Rekeyed)
+	row["updatedAt"] = &dynamodb.AttributeValue{S: aws.String(s.UpdatedAt.Format(time.RFC3339Nano))}
+	if s.Checkpoint != nil {
+		row["checkpoint"] = &dynamodb.AttributeValue{M: s.Checkpoint}
+	}
+	return row
+}
+
+func decodeMigrationState(row map[string]*dynamodb.AttributeValue) (MigrationState, error) {
+	var (
+		s   MigrationState
+		err error
+	)
+	for name, dst := range map[string]*int{
+		"version":   &s.Version,
+		"running":   &s.Running,
+		"scanned":   &s.Scanned,
+		"rewritten": &s.Rewritten,
+		"rekeyed":   &s.Rekeyed,
+	} {
+		if row[name] == nil {
+			continue
+		}
+		if *dst, err = strconv.Atoi(aws.StringValue(row[name].N)); err != nil {
+			return MigrationState{}, fmt.Errorf("migration %s: %w", name, err)
+		}
+	}
+	if row["updatedAt"] != nil {
+		if s.UpdatedAt, err = time.Parse(time.RFC3339Nano, aws.StringValue(row["updatedAt"].S)); err != nil {
+			return MigrationState{}, err
+		}
+	}
+	if row["checkpoint"] != nil {
+		s.Checkpoint = row["checkpoint"].M
+	}
+	return s, nil
+}
+
+func numberAttr(n int) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(n))}
+}
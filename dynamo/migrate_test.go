@@ -0,0 +1,360 @@
+package dynamo_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/antklim/go-dynamodb/dynamo"
+	"github.com/antklim/go-dynamodb/dynamo/dump"
+	"github.com/antklim/go-dynamodb/invoice"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rowsClient keeps rows sorted by keys. Scan pages are resumed from the
+// last evaluated key, query returns items of the invoice. Conditions of
+// writes are not evaluated, concurrent changes are simulated by
+// changeOnWrite.
+type rowsClient struct {
+	dynamodbiface.DynamoDBAPI
+	rows         map[string]map[string]*dynamodb.AttributeValue
+	writes       int // conditional writes
+	failAtWrite  int // conditional write failing once, 0 means none
+	scannedPages int
+	// changeOnWrite changes rows before the first conditional write, which
+	// then fails its condition
+	changeOnWrite func(c *rowsClient)
+}
+
+func newRowsClient(t *testing.T) *rowsClient {
+	f, err := os.Open("../data/db.json")
+	require.NoError(t, err)
+	defer f.Close()
+
+	dec, err := dump.NewDecoder(f, dump.FormatJSON)
+	require.NoError(t, err)
+
+	c := &rowsClient{rows: make(map[string]map[string]*dynamodb.AttributeValue)}
+	for {
+		row, err := dec.Next()
+		if err != nil {
+			break
+		}
+		c.put(row)
+	}
+	return c
+}
+
+func rowID(row map[string]*dynamodb.AttributeValue) string {
+	return aws.StringValue(row["pk"].S) + "|" + aws.StringValue(row["sk"].S)
+}
+
+func (c *rowsClient) put(row map[string]*dynamodb.AttributeValue) {
+	c.rows[rowID(row)] = row
+}
+
+func (c *rowsClient) sortedIDs() []string {
+	ids := make([]string, 0, len(c.rows))
+	for id := range c.rows {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (c *rowsClient) ScanWithContext(_ aws.Context, input *dynamodb.ScanInput, _ ...request.Option) (
+	*dynamodb.ScanOutput, error) {
+
+	c.scannedPages++
+	start := ""
+	if input.ExclusiveStartKey != nil {
+		start = rowID(input.ExclusiveStartKey)
+	}
+
+	out := &dynamodb.ScanOutput{}
+	for _, id := range c.sortedIDs() {
+		if id <= start {
+			continue
+		}
+		if input.Limit != nil && int64(len(out.Items)) == *input.Limit {
+			last := out.Items[len(out.Items)-1]
+			out.LastEvaluatedKey = map[string]*dynamodb.AttributeValue{"pk": last["pk"], "sk": last["sk"]}
+			break
+		}
+		out.Items = append(out.Items, c.rows[id])
+	}
+	return out, nil
+}
+
+func (c *rowsClient) QueryWithContext(_ aws.Context, input *dynamodb.QueryInput, _ ...request.Option) (
+	*dynamodb.QueryOutput, error) {
+
+	var pk string
+	for _, v := range input.ExpressionAttributeValues {
+		// sort key bounds of invoice rows start with INVOICE# as well, tenant
+		// partition keys are longer
+		s := aws.StringValue(v.S)
+		if (strings.HasPrefix(s, "INVOICE#") || strings.HasPrefix(s, "TENANT#")) && len(s) > len(pk) {
+			pk = s
+		}
+	}
+
+	// queries without sort key condition read the whole partition
+	prefix := ""
+	if strings.Contains(aws.StringValue(input.KeyConditionExpression), "begins_with") {
+		prefix = "ITEM#"
+	}
+
+	out := &dynamodb.QueryOutput{}
+	for _, id := range c.sortedIDs() {
+		row := c.rows[id]
+		if aws.StringValue(row["pk"].S) == pk && strings.HasPrefix(aws.StringValue(row["sk"].S), prefix) {
+			out.Items = append(out.Items, row)
+		}
+	}
+	return out, nil
+}
+
+func (c *rowsClient) GetItemWithContext(_ aws.Context, input *dynamodb.GetItemInput, _ ...request.Option) (
+	*dynamodb.GetItemOutput, error) {
+
+	return &dynamodb.GetItemOutput{Item: c.rows[rowID(input.Key)]}, nil
+}
+
+func (c *rowsClient) PutItemWithContext(_ aws.Context, input *dynamodb.PutItemInput, _ ...request.Option) (
+	*dynamodb.PutItemOutput, error) {
+
+	if input.ConditionExpression != nil {
+		if err := c.conditionalWrite(); err != nil {
+			return nil, err
+		}
+	}
+	c.put(input.Item)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (c *rowsClient) TransactWriteItemsWithContext(_ aws.Context, input *dynamodb.TransactWriteItemsInput,
+	_ ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+
+	if err := c.conditionalWrite(); err != nil {
+		if errors.Is(err, errRowChanged) {
+			// the deleted row is the second transaction item
+			return nil, &dynamodb.TransactionCanceledException{CancellationReasons: []*dynamodb.CancellationReason{
+				{Code: aws.String("None")}, {Code: aws.String("ConditionalCheckFailed")},
+			}}
+		}
+		return nil, err
+	}
+
+	for _, item := range input.TransactItems {
+		if item.Put != nil {
+			c.put(item.Put.Item)
+		}
+		if item.Delete != nil {
+			delete(c.rows, rowID(item.Delete.Key))
+		}
+	}
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+var errRowChanged = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "row changed", nil)
+
+func (c *rowsClient) conditionalWrite() error {
+	c.writes++
+	if c.writes == c.failAtWrite {
+		return errors.New("throttled")
+	}
+	if c.changeOnWrite != nil {
+		c.changeOnWrite(c)
+		c.changeOnWrite = nil
+		return errRowChanged
+	}
+	return nil
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	invoiceKey := "INVOICE#170bf55e-ca81-4a17-99ad-54f6411d610b|INVOICE#170bf55e-ca81-4a17-99ad-54f6411d610b"
+	itemKey := "INVOICE#170bf55e-ca81-4a17-99ad-54f6411d610b|ITEM#28b02a88-0a3f-4abc-b7ae-1bffc374b7de"
+
+	t.Run("backfills invoice totals", func(t *testing.T) {
+		client := newRowsClient(t)
+		repo := dynamo.NewRepository(client, "invoices")
+
+		reports, err := repo.Migrate(ctx, dynamo.Migrations, dynamo.MigrateOptions{DryRun: true})
+		require.NoError(t, err)
+		require.Len(t, reports, 1)
+		assert.Equal(t, 4, reports[0].Scanned)
+		assert.Equal(t, 1, reports[0].Rewritten)
+		assert.Nil(t, client.rows[invoiceKey]["itemCount"], "dry run does not write")
+
+		state, err := repo.MigrationState(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 0, state.Version)
+
+		reports, err = repo.Migrate(ctx, dynamo.Migrations, dynamo.MigrateOptions{})
+		require.NoError(t, err)
+		require.Len(t, reports, 1)
+		assert.Equal(t, 1, reports[0].Rewritten)
+
+		inv, err := repo.GetInvoice(ctx, "170bf55e-ca81-4a17-99ad-54f6411d610b")
+		require.NoError(t, err)
+		assert.Equal(t, uint(3), inv.ItemCount)
+		assert.NotZero(t, inv.Total)
+		assert.Len(t, inv.Items, 3, "invoice is read with its items")
+
+		state, err = repo.MigrationState(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, state.Version)
+		assert.Zero(t, state.Running)
+
+		reports, err = repo.Migrate(ctx, dynamo.Migrations, dynamo.MigrateOptions{})
+		require.NoError(t, err)
+		assert.Empty(t, reports, "applied migrations are skipped")
+	})
+
+	t.Run("recomputes negative and stale totals", func(t *testing.T) {
+		client := newRowsClient(t)
+		client.rows[invoiceKey]["itemCount"] = &dynamodb.AttributeValue{N: aws.String("-1")}
+		client.rows[invoiceKey]["total"] = &dynamodb.AttributeValue{N: aws.String("5")}
+		repo := dynamo.NewRepository(client, "invoices")
+
+		reports, err := repo.Migrate(ctx, dynamo.Migrations, dynamo.MigrateOptions{})
+		require.NoError(t, err)
+		require.Len(t, reports, 1)
+		assert.Equal(t, 1, reports[0].Rewritten)
+
+		inv, err := repo.GetInvoice(ctx, "170bf55e-ca81-4a17-99ad-54f6411d610b")
+		require.NoError(t, err)
+		assert.Equal(t, uint(3), inv.ItemCount)
+		assert.NotEqual(t, uint(5), inv.Total)
+	})
+
+	t.Run("rewrites rows changed concurrently", func(t *testing.T) {
+		client := newRowsClient(t)
+		client.changeOnWrite = func(c *rowsClient) {
+			item := make(map[string]*dynamodb.AttributeValue)
+			for k, v := range c.rows[itemKey] {
+				item[k] = v
+			}
+			item["sk"] = &dynamodb.AttributeValue{S: aws.String("ITEM#added")}
+			item["id"] = &dynamodb.AttributeValue{S: aws.String("added")}
+			c.put(item)
+			c.rows[invoiceKey]["version"] = &dynamodb.AttributeValue{N: aws.String("1")}
+		}
+		repo := dynamo.NewRepository(client, "invoices")
+
+		reports, err := repo.Migrate(ctx, dynamo.Migrations, dynamo.MigrateOptions{})
+		require.NoError(t, err)
+		require.Len(t, reports, 1)
+		assert.Equal(t, 1, reports[0].Rewritten)
+		assert.Equal(t, 2, client.writes, "invoice is read and written again")
+
+		inv, err := repo.GetInvoice(ctx, "170bf55e-ca81-4a17-99ad-54f6411d610b")
+		require.NoError(t, err)
+		assert.Equal(t, uint(4), inv.ItemCount, "added item is counted")
+		assert.Equal(t, "2", aws.StringValue(client.rows[invoiceKey]["version"].N))
+	})
+
+	t.Run("backfills invoices of tenants", func(t *testing.T) {
+		client := newRowsClient(t)
+		for id, row := range client.rows {
+			delete(client.rows, id)
+			row["pk"] = &dynamodb.AttributeValue{S: aws.String("TENANT#acme#" + aws.StringValue(row["pk"].S))}
+			client.put(row)
+		}
+		repo := dynamo.NewRepository(client, "invoices")
+
+		reports, err := repo.Migrate(ctx, dynamo.Migrations, dynamo.MigrateOptions{})
+		require.NoError(t, err)
+		require.Len(t, reports, 1)
+		assert.Equal(t, 1, reports[0].Rewritten)
+
+		inv, err := repo.GetInvoice(invoice.WithTenant(ctx, "acme"), "170bf55e-ca81-4a17-99ad-54f6411d610b")
+		require.NoError(t, err)
+		assert.Equal(t, uint(3), inv.ItemCount)
+		assert.NotZero(t, inv.Total)
+	})
+
+	t.Run("resumes from checkpoint", func(t *testing.T) {
+		client := newRowsClient(t)
+		repo := dynamo.NewRepository(client, "invoices")
+
+		// moves items to LINE# sort keys
+		migrations := []dynamo.Migration{{
+			Version: 1,
+			Rewrite: func(_ context.Context, _ *dynamo.Repository, row map[string]*dynamodb.AttributeValue) (
+				map[string]*dynamodb.AttributeValue, error) {
+
+				sk := aws.StringValue(row["sk"].S)
+				if !strings.HasPrefix(sk, "ITEM#") {
+					return nil, nil
+				}
+				rewritten := make(map[string]*dynamodb.AttributeValue)
+				for k, v := range row {
+					rewritten[k] = v
+				}
+				rewritten["sk"] = &dynamodb.AttributeValue{S: aws.String("LINE#" + strings.TrimPrefix(sk, "ITEM#"))}
+				return rewritten, nil
+			},
+		}}
+
+		client.failAtWrite = 2
+		opts := dynamo.MigrateOptions{PageSize: 2}
+		reports, err := repo.Migrate(ctx, migrations, opts)
+		require.Error(t, err)
+		require.Len(t, reports, 1)
+
+		state, err := repo.MigrationState(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 0, state.Version)
+		assert.Equal(t, 1, state.Running)
+		assert.NotNil(t, state.Checkpoint)
+		assert.Equal(t, 2, state.Scanned)
+
+		reports, err = repo.Migrate(ctx, migrations, opts)
+		require.NoError(t, err)
+		require.Len(t, reports, 1)
+		assert.True(t, reports[0].Resumed)
+		assert.Equal(t, 3, reports[0].Rekeyed)
+
+		lines := 0
+		for id := range client.rows {
+			assert.NotContains(t, id, "|ITEM#")
+			if strings.Contains(id, "|LINE#") {
+				lines++
+			}
+		}
+		assert.Equal(t, 3, lines)
+	})
+
+	t.Run("rejects invalid migrations", func(t *testing.T) {
+		repo := dynamo.NewRepository(newRowsClient(t), "invoices")
+		noop := func(context.Context, *dynamo.Repository, map[string]*dynamodb.AttributeValue) (
+			map[string]*dynamodb.AttributeValue, error) {
+			return nil, nil
+		}
+
+		_, err := repo.Migrate(ctx, []dynamo.Migration{{Version: 2, Rewrite: noop}, {Version: 1, Rewrite: noop}},
+			dynamo.MigrateOptions{})
+		assert.ErrorIs(t, err, dynamo.ErrInvalidMigration)
+
+		_, err = repo.Migrate(ctx, []dynamo.Migration{{Version: 1}}, dynamo.MigrateOptions{})
+		assert.ErrorIs(t, err, dynamo.ErrInvalidMigration)
+
+		_, err = repo.Migrate(ctx, []dynamo.Migration{{Version: 1, Rewrite: noop}, {Version: 2, Rewrite: noop}},
+			dynamo.MigrateOptions{})
+		require.NoError(t, err)
+		_, err = repo.Migrate(ctx, []dynamo.Migration{{Version: 1, Rewrite: noop}}, dynamo.MigrateOptions{})
+		assert.ErrorIs(t, err, dynamo.ErrUnknownMigration)
+	})
+}
@@ -0,0 +1,71 @@
+package dynamo
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/antklim/go-dynamodb/invoice"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// Migrations of the table in version order. New migrations are appended,
+// released ones are never changed.
+var Migrations = []Migration{
+	{
+		Version:     1,
+		Description: "backfill item count and total of invoices",
+		Rewrite:     backfillInvoiceTotals,
+	},
+}
+
+// backfillInvoiceTotals sets itemCount and total of invoices written before
+// the totals were maintained, and fixes totals that disagree with the items.
+// Invoices of every tenant are backfilled from items of their partition.
+func backfillInvoiceTotals(ctx context.Context, r *Repository, row map[string]*dynamodb.AttributeValue) (
+	map[string]*dynamodb.AttributeValue, error) {
+
+	r = r.rowScoped(row)
+	if !r.keys.IsInvoiceRow(row) {
+		return nil, nil
+	}
+
+	// stored totals may be negative, so they are not decoded as Invoice
+	var inv struct {
+		ID string `dynamodbav:"id"`
+	}
+	if err := dynamodbattribute.UnmarshalMap(row, &inv); err != nil {
+		return nil, err
+	}
+	items, err := r.GetInvoiceItems(ctx, inv.ID, invoice.ConsistentRead())
+	if err != nil {
+		return nil, err
+	}
+
+	count, total := invoice.ItemTotals(items)
+	if storedNumber(row, "itemCount") == strconv.FormatUint(uint64(count), 10) &&
+		storedNumber(row, "total") == strconv.FormatUint(uint64(total), 10) {
+		return nil, nil
+	}
+
+	rewritten := make(map[string]*dynamodb.AttributeValue, len(row)+2)
+	for k, v := range row {
+		rewritten[k] = v
+	}
+	if rewritten["itemCount"], err = dynamodbattribute.Marshal(count); err != nil {
+		return nil, err
+	}
+	if rewritten["total"], err = dynamodbattribute.Marshal(total); err != nil {
+		return nil, err
+	}
+	return rewritten, nil
+}
+
+// storedNumber returns the number attribute of the row, or empty string when
+// the row has no such number.
+func storedNumber(row map[string]*dynamodb.AttributeValue, name string) string {
+	if v := row[name]; v != nil && v.N != nil {
+		return *v.N
+	}
+	return ""
+}
@@ -0,0 +1,116 @@
+package dynamo
+
+import (
+	"context"
+	"time"
+
+	"github.com/antklim/go-dynamodb/invoice"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// Event describes dynamodb representation of invoice.Event in the outbox
+type Event struct {
+	PK         string    `dynamodbav:"pk"`
+	SK         string    `dynamodbav:"sk"`
+	ID         string    `dynamodbav:"id"`
+	Type       string    `dynamodbav:"type"`
+	Tenant     string    `dynamodbav:"tenant,omitempty"`
+	InvoiceID  string    `dynamodbav:"invoiceId"`
+	OccurredAt time.Time `dynamodbav:"occurredAt"`
+	Data       Snapshot  `dynamodbav:"data"`
+}
+
+// NewEvent creates an instance of DynamoDB outbox event from invoice.Event.
+func NewEvent(e invoice.Event) Event {
+	return Event{
+		PK:         defaultKeys.outboxPartitionKey(), // all outbox events are in one partition
+		SK:         defaultKeys.outboxSortKey(e.OccurredAt, e.ID),
+		ID:         e.ID,
+		Type:       string(e.Type),
+		Tenant:     e.Tenant,
+		InvoiceID:  e.InvoiceID,
+		OccurredAt: e.OccurredAt,
+		Data:       NewSnapshot(e.Data),
+	}
+}
+
+// ToEvent creates an instance of invoice.Event from DynamoDB outbox event.
+func (e *Event) ToEvent() (*invoice.Event, error) {
+	data, err := e.Data.ToSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	return &invoice.Event{
+		ID:         e.ID,
+		Type:       invoice.EventType(e.Type),
+		Tenant:     e.Tenant,
+		InvoiceID:  e.InvoiceID,
+		OccurredAt: e.OccurredAt,
+		Data:       data,
+	}, nil
+}
+
+// PendingEvents returns up to limit oldest events from the outbox. Limits
+// below 1 return no events. The outbox is shared by all tenants, events
+// carry their tenant.
+func (r *Repository) PendingEvents(ctx context.Context, limit int) ([]invoice.Event, error) {
+	if limit < 1 {
+		return nil, nil
+	}
+
+	keyCond := expression.KeyAnd(
+		expression.Key(r.keys.PartitionKey).Equal(expression.Value(r.keys.outboxPartitionKey())),
+		expression.Key(r.keys.SortKey).BeginsWith(r.keys.keyPrefix(r.keys.Prefixes.Event)),
+	)
+
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:                 r.table,
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		KeyConditionExpression:    expr.KeyCondition(),
+		Limit:                     aws.Int64(int64(limit)),
+	}
+
+	result, err := r.client.QueryWithContext(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	dbEvents := []*Event{}
+	if err := dynamodbattribute.UnmarshalListOfMaps(result.Items, &dbEvents); err != nil {
+		return nil, err
+	}
+
+	events := make([]invoice.Event, len(dbEvents))
+	for idx, dbEvent := range dbEvents {
+		event, err := dbEvent.ToEvent()
+		if err != nil {
+			return nil, err
+		}
+		events[idx] = *event
+	}
+
+	return events, nil
+}
+
+// AckEvent removes published event from the outbox.
+func (r *Repository) AckEvent(ctx context.Context, event invoice.Event) error {
+	key := r.keys.primaryKey(r.keys.outboxPartitionKey(), r.keys.outboxSortKey(event.OccurredAt, event.ID))
+
+	input := &dynamodb.DeleteItemInput{
+		TableName: r.table,
+		Key:       key,
+	}
+
+	_, err := r.client.DeleteItemWithContext(ctx, input)
+	return err
+}
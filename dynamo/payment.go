@@ -0,0 +1,347 @@
+package dynamo
+
+import (
+	"context"
+	"time"
+
+	"github.com/antklim/go-dynamodb/invoice"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// invoiceRetries is the number of attempts to write a payment or item change
+// when the invoice changes concurrently.
+const invoiceRetries = 3
+
+// Payment describes dynamodb representation of invoice.Payment
+type Payment struct {
+	PK         string    `dynamodbav:"pk"`
+	SK         string    `dynamodbav:"sk"`
+	ID         string    `dynamodbav:"id"`
+	InvoiceID  string    `dynamodbav:"invoiceId"`
+	Amount     uint      `dynamodbav:"amount"`
+	Method     string    `dynamodbav:"method"`
+	Reference  string    `dynamodbav:"reference"`
+	ReceivedAt time.Time `dynamodbav:"receivedAt"`
+	ReversedAt time.Time `dynamodbav:"reversedAt"`
+	CreatedAt  time.Time `dynamodbav:"createdAt"`
+	UpdatedAt  time.Time `dynamodbav:"updatedAt"`
+}
+
+// NewPayment creates an instance of DynamoDB payment from invoice.Payment.
+func NewPayment(p invoice.Payment) Payment {
+	return Payment{
+		PK:         defaultKeys.invoicePartitionKey(p.InvoiceID),
+		SK:         defaultKeys.paymentSortKey(p.ID),
+		ID:         p.ID,
+		InvoiceID:  p.InvoiceID,
+		Amount:     p.Amount,
+		Method:     p.Method,
+		Reference:  p.Reference,
+		ReceivedAt: p.ReceivedAt,
+		ReversedAt: p.ReversedAt,
+		CreatedAt:  p.CreatedAt,
+		UpdatedAt:  p.UpdatedAt,
+	}
+}
+
+// ToPayment creates an instance of invoice.Payment from DynamoDB payment.
+func (p *Payment) ToPayment() invoice.Payment {
+	return invoice.Payment{
+		ID:         p.ID,
+		InvoiceID:  p.InvoiceID,
+		Amount:     p.Amount,
+		Method:     p.Method,
+		Reference:  p.Reference,
+		ReceivedAt: p.ReceivedAt,
+		ReversedAt: p.ReversedAt,
+		CreatedAt:  p.CreatedAt,
+		UpdatedAt:  p.UpdatedAt,
+	}
+}
+
+// invoiceStatusUpdate creates transaction item that sets invoice status and
+// increments version of the invoice row. The transaction fails when the
+// invoice row changed since it was read.
+func (r *Repository) invoiceStatusUpdate(inv *Invoice, status invoice.Status, at time.Time) (
+	*dynamodb.TransactWriteItem, error) {
+
+	pk := r.keys.invoicePrimaryKey(inv.ID)
+
+	upd := expression.
+		Set(expression.Name("status"), expression.Value(status)).
+		Set(expression.Name("updatedAt"), expression.Value(at)).
+		Set(expression.Name("version"), nextVersion)
+	cond := expression.AttributeExists(expression.Name(r.keys.PartitionKey)).And(versionCondition(inv.Version))
+	expr, err := expression.NewBuilder().WithUpdate(upd).WithCondition(cond).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	update := &dynamodb.Update{
+		TableName:                 r.table,
+		Key:                       pk,
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ConditionExpression:       expr.Condition(),
+		UpdateExpression:          expr.Update(),
+	}
+
+	return &dynamodb.TransactWriteItem{Update: update}, nil
+}
+
+// paymentStatusChange reads the invoice row and its payments, applies change
+// to the payments and returns the invoice before and after the status set by
+// its balance. It returns invoice.ErrInvoiceNotFound when the invoice does
+// not exist.
+func (r *Repository) paymentStatusChange(ctx context.Context, invoiceID string,
+	change func([]invoice.Payment) []invoice.Payment, at time.Time) (*Invoice, *invoice.Invoice, *invoice.Invoice, error) {
+
+	dbInvoice, err := r.getInvoiceRow(ctx, invoiceID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if dbInvoice == nil {
+		return nil, nil, nil, invoice.ErrInvoiceNotFound
+	}
+
+	inv, err := dbInvoice.ToInvoice()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	payments, err := r.GetPayments(ctx, invoiceID, invoice.ConsistentRead())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	updated := *inv
+	updated.Status = invoice.PaymentStatus(inv.Status, invoice.NewBalance(inv.Total, change(payments)))
+	updated.UpdatedAt = at
+	return dbInvoice, inv, &updated, nil
+}
+
+// transactPayment writes the payment change. Payment changes are retried
+// when the invoice row changed concurrently, the status update is the first
+// transaction item.
+func (r *Repository) transactPayment(ctx context.Context, write func() ([]*dynamodb.TransactWriteItem, error)) error {
+	var err error
+	for attempt := 0; attempt < invoiceRetries; attempt++ {
+		var transactItems []*dynamodb.TransactWriteItem
+		if transactItems, err = write(); err != nil {
+			return err
+		}
+		if err = r.transact(ctx, transactItems); !conditionFailed(err, 0) {
+			return err
+		}
+	}
+	return err
+}
+
+// AddPayment stores the payment and sets invoice status by its balance in
+// one transaction. Cancelled invoices do not accept payments.
+func (r *Repository) AddPayment(ctx context.Context, p invoice.Payment) error {
+	r, err := r.scoped(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := r.clock.Now()
+	p.CreatedAt, p.UpdatedAt = now, now
+	putPayment, err := r.keys.paymentRow(p)
+	if err != nil {
+		return err
+	}
+
+	put := &dynamodb.Put{
+		TableName:           r.table,
+		Item:                putPayment,
+		ConditionExpression: r.keys.notExistsCondition(),
+	}
+
+	return r.transactPayment(ctx, func() ([]*dynamodb.TransactWriteItem, error) {
+		dbInvoice, inv, updated, err := r.paymentStatusChange(ctx, p.InvoiceID,
+			func(payments []invoice.Payment) []invoice.Payment {
+				return append(payments, p)
+			}, now)
+		if err != nil {
+			return nil, err
+		}
+		if inv.Status == invoice.Cancelled {
+			return nil, invoice.ErrInvoiceCancelled
+		}
+
+		statusUpdate, err := r.invoiceStatusUpdate(dbInvoice, updated.Status, now)
+		if err != nil {
+			return nil, err
+		}
+
+		before := invoice.Snapshot{Invoice: inv}
+		after := invoice.Snapshot{Invoice: updated, Payment: &p}
+		changes, err := r.changePuts(ctx, p.InvoiceID, invoice.OpAddPayment, before, after)
+		if err != nil {
+			return nil, err
+		}
+
+		transactItems := []*dynamodb.TransactWriteItem{statusUpdate, {Put: put}}
+		return append(transactItems, changes...), nil
+	})
+}
+
+func (r *Repository) GetPayment(
+	ctx context.Context, invoiceID, paymentID string, opts ...invoice.ReadOption) (*invoice.Payment, error) {
+
+	r, err := r.scoped(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pk := r.keys.paymentPrimaryKey(invoiceID, paymentID)
+
+	input := &dynamodb.GetItemInput{
+		TableName:      r.table,
+		Key:            pk,
+		ConsistentRead: r.consistentRead(opts),
+	}
+
+	result, err := r.client.GetItemWithContext(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	dbPayment := Payment{}
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &dbPayment); err != nil {
+		return nil, err
+	}
+
+	payment := dbPayment.ToPayment()
+	return &payment, nil
+}
+
+func (r *Repository) GetPayments(
+	ctx context.Context, invoiceID string, opts ...invoice.ReadOption) ([]invoice.Payment, error) {
+
+	r, err := r.scoped(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pk := r.keys.invoicePartitionKey(invoiceID)
+	keyCond := expression.KeyAnd(
+		expression.Key(r.keys.PartitionKey).Equal(expression.Value(pk)),
+		expression.Key(r.keys.SortKey).BeginsWith(r.keys.keyPrefix(r.keys.Prefixes.Payment)),
+	)
+
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:                 r.table,
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ConsistentRead:            r.consistentRead(opts),
+	}
+
+	rows, err := collectRows(ctx, r.queryPages(input))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	dbPayments := []*Payment{}
+	if err := dynamodbattribute.UnmarshalListOfMaps(rows, &dbPayments); err != nil {
+		return nil, err
+	}
+
+	payments := make([]invoice.Payment, len(dbPayments))
+	for idx, dbPayment := range dbPayments {
+		payments[idx] = dbPayment.ToPayment()
+	}
+
+	return payments, nil
+}
+
+// ReversePayment marks the payment reversed and sets invoice status by its
+// balance in one transaction.
+func (r *Repository) ReversePayment(ctx context.Context, invoiceID, paymentID string) error {
+	r, err := r.scoped(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := r.clock.Now()
+	return r.transactPayment(ctx, func() ([]*dynamodb.TransactWriteItem, error) {
+		p, err := r.GetPayment(ctx, invoiceID, paymentID, invoice.ConsistentRead())
+		if err != nil {
+			return nil, err
+		}
+		if p == nil {
+			return nil, invoice.ErrPaymentNotFound
+		}
+		if p.Reversed() {
+			return nil, invoice.ErrPaymentReversed
+		}
+
+		reversed := *p
+		reversed.ReversedAt = now
+		reversed.UpdatedAt = now
+
+		dbInvoice, inv, updated, err := r.paymentStatusChange(ctx, invoiceID,
+			func(payments []invoice.Payment) []invoice.Payment {
+				for idx := range payments {
+					if payments[idx].ID == paymentID {
+						payments[idx] = reversed
+					}
+				}
+				return payments
+			}, now)
+		if err != nil {
+			return nil, err
+		}
+
+		statusUpdate, err := r.invoiceStatusUpdate(dbInvoice, updated.Status, now)
+		if err != nil {
+			return nil, err
+		}
+
+		upd := expression.
+			Set(expression.Name("reversedAt"), expression.Value(now)).
+			Set(expression.Name("updatedAt"), expression.Value(now))
+		// payment must not be reversed concurrently
+		cond := expression.Name("reversedAt").Equal(expression.Value(p.ReversedAt))
+		expr, err := expression.NewBuilder().WithUpdate(upd).WithCondition(cond).Build()
+		if err != nil {
+			return nil, err
+		}
+
+		update := &dynamodb.Update{
+			TableName:                 r.table,
+			Key:                       r.keys.paymentPrimaryKey(invoiceID, paymentID),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+			ConditionExpression:       expr.Condition(),
+			UpdateExpression:          expr.Update(),
+		}
+
+		before := invoice.Snapshot{Invoice: inv, Payment: p}
+		after := invoice.Snapshot{Invoice: updated, Payment: &reversed}
+		changes, err := r.changePuts(ctx, invoiceID, invoice.OpReversePayment, before, after)
+		if err != nil {
+			return nil, err
+		}
+
+		transactItems := []*dynamodb.TransactWriteItem{statusUpdate, {Update: update}}
+		return append(transactItems, changes...), nil
+	})
+}
@@ -0,0 +1,77 @@
+package dynamo
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// PurgeOptions configures Purge.
+type PurgeOptions struct {
+	DryRun bool // count matching rows without deleting them
+}
+
+// Purge deletes all rows which partition key starts with prefix, empty
+// prefix matches every row. Rows are deleted in batches, unprocessed
+// deletes are retried with backoff. It returns the number of matched rows.
+//
+// Purge bypasses the history and outbox, it is meant for cleaning test and
+// development tables.
+func (r *Repository) Purge(ctx context.Context, prefix string, opts PurgeOptions) (int, error) {
+	proj := expression.NamesList(expression.Name(r.keys.PartitionKey), expression.Name(r.keys.SortKey))
+	builder := expression.NewBuilder().WithProjection(proj)
+	if prefix != "" {
+		builder = builder.WithFilter(expression.Name(r.keys.PartitionKey).BeginsWith(prefix))
+	}
+	expr, err := builder.Build()
+	if err != nil {
+		return 0, err
+	}
+
+	input := &dynamodb.ScanInput{
+		TableName:                 r.table,
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		FilterExpression:          expr.Filter(),
+		ProjectionExpression:      expr.Projection(),
+	}
+
+	var (
+		keys  []map[string]*dynamodb.AttributeValue
+		count int
+	)
+	for {
+		result, err := r.client.ScanWithContext(ctx, input)
+		if err != nil {
+			return count, err
+		}
+
+		for _, row := range result.Items {
+			count++
+			if opts.DryRun {
+				continue
+			}
+			keys = append(keys, row)
+			if len(keys) == batchWriteLimit {
+				if err := r.batchDelete(ctx, keys); err != nil {
+					return count, err
+				}
+				keys = nil
+			}
+		}
+
+		if result.LastEvaluatedKey == nil {
+			break
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+
+	if len(keys) > 0 {
+		if err := r.batchDelete(ctx, keys); err != nil {
+			return count, err
+		}
+	}
+
+	return count, nil
+}
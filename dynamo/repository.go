@@ -12,14 +12,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
 )
 
-const (
-	keySeparator    = "#"
-	invoicePkPrefix = "INVOICE"
-	invoiceSkPrefix = "INVOICE"
-	itemPkPrefix    = "INVOICE" // invoice items are in the same partition as the invoice
-	itemSkPrefix    = "ITEM"
-	yyyymmddFormat  = "20060102"
-)
+const yyyymmddFormat = "20060102"
 
 // Invoice describes dynamodb representation of invoice.Invoice
 type Invoice struct {
@@ -30,14 +23,17 @@ type Invoice struct {
 	CustomerName string    `dynamodbav:"customerName"`
 	Status       string    `dynamodbav:"status"`
 	Date         string    `dynamodbav:"date"` // YYYYMMDD
+	ItemCount    uint      `dynamodbav:"itemCount"`
+	Total        uint      `dynamodbav:"total"`
+	Version      uint      `dynamodbav:"version"` // incremented on every invoice row update, see invoiceStatusUpdate
 	CreatedAt    time.Time `dynamodbav:"createdAt"`
 	UpdatedAt    time.Time `dynamodbav:"updatedAt"`
 }
 
 // NewInvoice creates an instance of DynamoDB invoice from invoice.Invoice.
 func NewInvoice(inv invoice.Invoice) Invoice {
-	pk := invoicePartitionKey(inv.ID)
-	sk := invoiceSortKey(inv.ID)
+	pk := defaultKeys.invoicePartitionKey(inv.ID)
+	sk := defaultKeys.invoiceSortKey(inv.ID)
 
 	return Invoice{
 		PK:           pk,
@@ -47,6 +43,8 @@ func NewInvoice(inv invoice.Invoice) Invoice {
 		CustomerName: inv.CustomerName,
 		Status:       string(inv.Status),
 		Date:         inv.Date.Format(yyyymmddFormat),
+		ItemCount:    inv.ItemCount,
+		Total:        inv.Total,
 		CreatedAt:    inv.CreatedAt,
 		UpdatedAt:    inv.UpdatedAt,
 	}
@@ -66,6 +64,8 @@ func (inv *Invoice) ToInvoice() (*invoice.Invoice, error) {
 		Status:       invoice.Status(inv.Status),
 		Date:         date,
 		Items:        nil,
+		ItemCount:    inv.ItemCount,
+		Total:        inv.Total,
 		CreatedAt:    inv.CreatedAt,
 		UpdatedAt:    inv.UpdatedAt,
 	}, nil
@@ -73,25 +73,29 @@ func (inv *Invoice) ToInvoice() (*invoice.Invoice, error) {
 
 // Item describes dynamodb representation of invoice.Item
 type Item struct {
-	PK        string    `dynamodbav:"pk"`
-	SK        string    `dynamodbav:"sk"`
-	ID        string    `dynamodbav:"id"`
-	InvoiceID string    `dynamodbav:"invoiceId"`
-	SKU       string    `dynamodbav:"sku"`
-	Name      string    `dynamodbav:"name"`
-	Price     uint      `dynamodbav:"price"`
-	Qty       uint      `dynamodbav:"qty"`
-	Status    string    `dynamodbav:"status"`
-	CreatedAt time.Time `dynamodbav:"createdAt"`
-	UpdatedAt time.Time `dynamodbav:"updatedAt"`
+	PK        string     `dynamodbav:"pk"`
+	SK        string     `dynamodbav:"sk"`
+	ID        string     `dynamodbav:"id"`
+	InvoiceID string     `dynamodbav:"invoiceId"`
+	SKU       string     `dynamodbav:"sku"`
+	Name      string     `dynamodbav:"name"`
+	Price     uint       `dynamodbav:"price"`
+	Qty       uint       `dynamodbav:"qty"`
+	Status    string     `dynamodbav:"status"`
+	Version   uint       `dynamodbav:"version"`
+	CreatedAt time.Time  `dynamodbav:"createdAt"`
+	UpdatedAt time.Time  `dynamodbav:"updatedAt"`
+	DeletedAt *time.Time `dynamodbav:"deletedAt,omitempty"`
+	DeletedBy string     `dynamodbav:"deletedBy,omitempty"`
+	ExpiresAt int64      `dynamodbav:"expiresAt,omitempty"` // unix time when DynamoDB TTL purges deleted item
 }
 
 // NewItem creates an instance of DynamoDB item from invoice.Item.
 func NewItem(item invoice.Item) Item {
-	pk := itemPartitionKey(item.InvoiceID)
-	sk := itemSortKey(item.ID)
+	pk := defaultKeys.itemPartitionKey(item.InvoiceID)
+	sk := defaultKeys.itemSortKey(item.ID)
 
-	return Item{
+	dbItem := Item{
 		PK:        pk,
 		SK:        sk,
 		ID:        item.ID,
@@ -101,14 +105,22 @@ func NewItem(item invoice.Item) Item {
 		Price:     item.Price,
 		Qty:       item.Qty,
 		Status:    string(item.Status),
+		Version:   item.Version,
 		CreatedAt: item.CreatedAt,
 		UpdatedAt: item.UpdatedAt,
+		DeletedBy: item.DeletedBy,
+	}
+	if item.Deleted() {
+		deletedAt := item.DeletedAt
+		dbItem.DeletedAt = &deletedAt
 	}
+
+	return dbItem
 }
 
 // ToItem creates an instance of invoice.Item from DynamoDB item.
 func (item *Item) ToItem() invoice.Item {
-	return invoice.Item{
+	i := invoice.Item{
 		ID:        item.ID,
 		InvoiceID: item.InvoiceID,
 		SKU:       item.SKU,
@@ -116,16 +128,24 @@ func (item *Item) ToItem() invoice.Item {
 		Price:     item.Price,
 		Qty:       item.Qty,
 		Status:    invoice.Status(item.Status),
+		Version:   item.Version,
 		CreatedAt: item.CreatedAt,
 		UpdatedAt: item.UpdatedAt,
+		DeletedBy: item.DeletedBy,
 	}
+	if item.DeletedAt != nil {
+		i.DeletedAt = *item.DeletedAt
+	}
+
+	return i
 }
 
 // Product describes product properties of Item
 type Product struct {
-	SKU   string `dynamodbav:"sku"`
-	Name  string `dynamodbav:"name"`
-	Price uint   `dynamodbav:"price"`
+	SKU       string     `dynamodbav:"sku"`
+	Name      string     `dynamodbav:"name"`
+	Price     uint       `dynamodbav:"price"`
+	DeletedAt *time.Time `dynamodbav:"deletedAt,omitempty"` // projected to exclude deleted items
 }
 
 func (p *Product) ToProduct() *invoice.Product {
@@ -137,18 +157,64 @@ func (p *Product) ToProduct() *invoice.Product {
 }
 
 type Repository struct {
-	client dynamodbiface.DynamoDBAPI
-	table  *string
+	client     dynamodbiface.DynamoDBAPI
+	table      *string
+	retention  time.Duration
+	clock      invoice.Clock
+	keys       KeySchema
+	consistent bool
+}
+
+// Option configures repository.
+type Option func(*Repository)
+
+// WithRetention makes DynamoDB TTL purge deleted items after the retention
+// period. Deleted items are kept until restored when retention is not set.
+// TTL must be enabled on the expiresAt attribute of the table.
+func WithRetention(d time.Duration) Option {
+	return func(r *Repository) {
+		r.retention = d
+	}
+}
+
+// WithClock sets the clock used for createdAt and updatedAt of written rows.
+func WithClock(c invoice.Clock) Option {
+	return func(r *Repository) {
+		r.clock = c
+	}
+}
+
+// WithConsistentRead makes all reads strongly consistent, as if every read
+// had the invoice.ConsistentRead option. Consistent reads consume twice the
+// read capacity of eventually consistent reads.
+func WithConsistentRead() Option {
+	return func(r *Repository) {
+		r.consistent = true
+	}
 }
 
 // NewRepository ...
-func NewRepository(client dynamodbiface.DynamoDBAPI, table string) *Repository {
-	return &Repository{client: client, table: aws.String(table)}
+func NewRepository(client dynamodbiface.DynamoDBAPI, table string, opts ...Option) *Repository {
+	r := &Repository{client: client, table: aws.String(table), clock: invoice.SystemClock()}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.keys = r.keys.normalized()
+	return r
 }
 
 func (r *Repository) AddInvoice(ctx context.Context, inv invoice.Invoice) error {
-	dbinv := NewInvoice(inv)
-	putInvoiceItem, err := dynamodbattribute.MarshalMap(dbinv)
+	r, err := r.scoped(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := r.clock.Now()
+	inv.CreatedAt, inv.UpdatedAt = now, now
+	inv.Items = invoice.Created(inv.Items, now)
+	inv.ItemCount, inv.Total = invoice.ItemTotals(inv.Items)
+
+	putInvoiceItem, err := r.keys.invoiceRow(inv)
 	if err != nil {
 		return err
 	}
@@ -160,8 +226,7 @@ func (r *Repository) AddInvoice(ctx context.Context, inv invoice.Invoice) error
 	}})
 
 	for _, item := range inv.Items {
-		dbitem := NewItem(item)
-		putInvoiceItemItem, err := dynamodbattribute.MarshalMap(dbitem)
+		putInvoiceItemItem, err := r.keys.itemRow(item)
 		if err != nil {
 			return err
 		}
@@ -172,59 +237,251 @@ func (r *Repository) AddInvoice(ctx context.Context, inv invoice.Invoice) error
 		}})
 	}
 
-	transaction := &dynamodb.TransactWriteItemsInput{TransactItems: transactItems}
-	if err := transaction.Validate(); err != nil {
+	invWithoutItems := inv
+	invWithoutItems.Items = nil
+	after := invoice.Snapshot{Invoice: &invWithoutItems, Items: inv.Items}
+	changes, err := r.changePuts(ctx, inv.ID, invoice.OpAddInvoice, invoice.Snapshot{}, after)
+	if err != nil {
 		return err
 	}
+	transactItems = append(transactItems, changes...)
 
-	_, err = r.client.TransactWriteItemsWithContext(ctx, transaction)
-	return err
+	return r.transact(ctx, transactItems)
 }
 
-func (r *Repository) GetInvoice(ctx context.Context, invoiceID string) (*invoice.Invoice, error) {
-	pk, err := invoicePrimaryKey(invoiceID)
+// GetInvoice gets the invoice with its items in a single Query of the
+// invoice partition. Other rows of the partition, such as payments and
+// history, are filtered out.
+func (r *Repository) GetInvoice(
+	ctx context.Context, invoiceID string, opts ...invoice.ReadOption) (*invoice.Invoice, error) {
+
+	r, err := r.scoped(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pk := r.keys.invoicePartitionKey(invoiceID)
+	from, to := r.keys.invoiceRowsRange(invoiceID)
+	keyCond := expression.KeyAnd(
+		expression.Key(r.keys.PartitionKey).Equal(expression.Value(pk)),
+		expression.Key(r.keys.SortKey).Between(expression.Value(from), expression.Value(to)),
+	)
+
+	itemsFilt := expression.Name(r.keys.SortKey).BeginsWith(r.keys.keyPrefix(r.keys.Prefixes.Item))
+	if !invoice.NewReadOptions(opts...).IncludeDeleted {
+		itemsFilt = itemsFilt.And(notDeletedFilter)
+	}
+	filt := expression.Name(r.keys.SortKey).Equal(expression.Value(r.keys.invoiceSortKey(invoiceID))).Or(itemsFilt)
+
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).WithFilter(filt).Build()
 	if err != nil {
 		return nil, err
 	}
 
+	input := &dynamodb.QueryInput{
+		TableName:                 r.table,
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ConsistentRead:            r.consistentRead(opts),
+	}
+
+	rows, err := collectRows(ctx, r.queryPages(input))
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		inv   *invoice.Invoice
+		items []invoice.Item
+	)
+	for _, row := range rows {
+		switch {
+		case r.keys.IsInvoiceRow(row):
+			if inv, err = toInvoice(row); err != nil {
+				return nil, err
+			}
+		case r.keys.IsItemRow(row):
+			item, err := toItem(row)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, *item)
+		}
+	}
+
+	if inv == nil {
+		return nil, nil
+	}
+	inv.Items = items
+	return inv, nil
+}
+
+// CancelInvoice sets status of the invoice and its items cancelled in one
+// transaction. The transaction fails with invoice.ErrVersionConflict when
+// the invoice or its items changed since they were read. It returns
+// invoice.ErrInvoiceNotFound when the invoice does not exist.
+func (r *Repository) CancelInvoice(ctx context.Context, invoiceID string) error {
+	r, err := r.scoped(ctx)
+	if err != nil {
+		return err
+	}
+
+	dbInvoice, err := r.getInvoiceRow(ctx, invoiceID)
+	if err != nil {
+		return err
+	}
+	if dbInvoice == nil {
+		return invoice.ErrInvoiceNotFound
+	}
+	inv, err := dbInvoice.ToInvoice()
+	if err != nil {
+		return err
+	}
+	if inv.Status == invoice.Cancelled {
+		return nil
+	}
+
+	items, err := r.GetInvoiceItems(ctx, invoiceID, invoice.ConsistentRead())
+	if err != nil {
+		return err
+	}
+
+	now := r.clock.Now()
+	var before, after invoice.Snapshot
+	for _, item := range items {
+		if item.Status == invoice.Cancelled {
+			continue
+		}
+		before.Items = append(before.Items, item)
+		item.Status = invoice.Cancelled
+		item.UpdatedAt = now
+		item.Version++
+		after.Items = append(after.Items, item)
+	}
+
+	// cancelled items are not counted, see invoice.ItemTotals
+	cancelled := *inv
+	cancelled.Status = invoice.Cancelled
+	cancelled.ItemCount, cancelled.Total = 0, 0
+	cancelled.UpdatedAt = now
+	before.Invoice, after.Invoice = inv, &cancelled
+
+	upd := expression.
+		Set(expression.Name("status"), expression.Value(invoice.Cancelled)).
+		Set(expression.Name("itemCount"), expression.Value(cancelled.ItemCount)).
+		Set(expression.Name("total"), expression.Value(cancelled.Total)).
+		Set(expression.Name("updatedAt"), expression.Value(now)).
+		Set(expression.Name("version"), nextVersion)
+	cond := expression.AttributeExists(expression.Name(r.keys.PartitionKey)).And(versionCondition(dbInvoice.Version))
+	expr, err := expression.NewBuilder().WithUpdate(upd).WithCondition(cond).Build()
+	if err != nil {
+		return err
+	}
+
+	invoiceUpdate := &dynamodb.Update{
+		TableName:                 r.table,
+		Key:                       r.keys.invoicePrimaryKey(invoiceID),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ConditionExpression:       expr.Condition(),
+		UpdateExpression:          expr.Update(),
+	}
+	transactItems := []*dynamodb.TransactWriteItem{{Update: invoiceUpdate}}
+
+	itemUpd := expression.
+		Set(expression.Name("status"), expression.Value(invoice.Cancelled)).
+		Set(expression.Name("updatedAt"), expression.Value(now)).
+		Set(expression.Name("version"), nextVersion)
+	itemCond := expression.AttributeExists(expression.Name(r.keys.PartitionKey)).And(notDeletedFilter)
+	updates, err := r.invoiceItemsToUpdates(before.Items, itemUpd, itemCond)
+	if err != nil {
+		return err
+	}
+	for _, update := range updates {
+		transactItems = append(transactItems, &dynamodb.TransactWriteItem{Update: update})
+	}
+
+	changes, err := r.changePuts(ctx, invoiceID, invoice.OpCancelInvoice, before, after)
+	if err != nil {
+		return err
+	}
+
+	err = r.transact(ctx, append(transactItems, changes...))
+	if isTransactionCanceled(err) {
+		return invoice.ErrVersionConflict
+	}
+	return err
+}
+
+// getInvoiceRow consistently reads the invoice row. Writes conditioned on
+// the version of the row use it. It returns nil when invoice does not exist.
+func (r *Repository) getInvoiceRow(ctx context.Context, invoiceID string) (*Invoice, error) {
 	input := &dynamodb.GetItemInput{
-		TableName: r.table,
-		Key:       pk,
+		TableName:      r.table,
+		Key:            r.keys.invoicePrimaryKey(invoiceID),
+		ConsistentRead: aws.Bool(true),
 	}
 
 	result, err := r.client.GetItemWithContext(ctx, input)
-	if err != nil {
+	if err != nil || result.Item == nil {
 		return nil, err
 	}
 
-	return toInvoice(result.Item)
+	dbInvoice := Invoice{}
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &dbInvoice); err != nil {
+		return nil, err
+	}
+	return &dbInvoice, nil
 }
 
 func (r *Repository) AddItem(ctx context.Context, item invoice.Item) error {
-	dbitem := NewItem(item)
-	putItem, err := dynamodbattribute.MarshalMap(dbitem)
+	r, err := r.scoped(ctx)
 	if err != nil {
 		return err
 	}
 
-	input := &dynamodb.PutItemInput{
-		TableName: r.table,
-		Item:      putItem,
+	now := r.clock.Now()
+	item.CreatedAt, item.UpdatedAt = now, now
+
+	putItem, err := r.keys.itemRow(item)
+	if err != nil {
+		return err
 	}
 
-	_, err = r.client.PutItemWithContext(ctx, input)
+	// adding the item again would add it to invoice totals twice
+	put := &dynamodb.Put{
+		TableName:           r.table,
+		Item:                putItem,
+		ConditionExpression: r.keys.notExistsCondition(),
+	}
+
+	after := invoice.Snapshot{Items: []invoice.Item{item}}
+	writes := []*dynamodb.TransactWriteItem{{Put: put}}
+	err = r.transactItemChanges(ctx, item.InvoiceID, writes, func() ([]*dynamodb.TransactWriteItem, error) {
+		return r.itemChanges(ctx, item.InvoiceID, invoice.OpAddItem, invoice.Snapshot{}, after, now)
+	})
+	if conditionFailed(err, 0) {
+		return invoice.ErrItemConflict
+	}
 	return err
 }
 
-func (r *Repository) GetItem(ctx context.Context, invoiceID, itemID string) (*invoice.Item, error) {
-	pk, err := itemPrimaryKey(invoiceID, itemID)
+func (r *Repository) GetItem(
+	ctx context.Context, invoiceID, itemID string, opts ...invoice.ReadOption) (*invoice.Item, error) {
+
+	r, err := r.scoped(ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	pk := r.keys.itemPrimaryKey(invoiceID, itemID)
+
 	input := &dynamodb.GetItemInput{
-		TableName: r.table,
-		Key:       pk,
+		TableName:      r.table,
+		Key:            pk,
+		ConsistentRead: r.consistentRead(opts),
 	}
 
 	result, err := r.client.GetItemWithContext(ctx, input)
@@ -232,16 +489,34 @@ func (r *Repository) GetItem(ctx context.Context, invoiceID, itemID string) (*in
 		return nil, err
 	}
 
-	return toItem(result.Item)
+	item, err := toItem(result.Item)
+	if err != nil || item == nil {
+		return nil, err
+	}
+
+	if item.Deleted() && !invoice.NewReadOptions(opts...).IncludeDeleted {
+		return nil, nil
+	}
+
+	return item, nil
 }
 
-func (r *Repository) GetItemProduct(ctx context.Context, invoiceID, itemID string) (*invoice.Product, error) {
-	pk, err := itemPrimaryKey(invoiceID, itemID)
+func (r *Repository) GetItemProduct(
+	ctx context.Context, invoiceID, itemID string, opts ...invoice.ReadOption) (*invoice.Product, error) {
+
+	r, err := r.scoped(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	proj := expression.NamesList(expression.Name("sku"), expression.Name("name"), expression.Name("price"))
+	pk := r.keys.itemPrimaryKey(invoiceID, itemID)
+
+	proj := expression.NamesList(
+		expression.Name("sku"),
+		expression.Name("name"),
+		expression.Name("price"),
+		expression.Name("deletedAt"),
+	)
 	expr, err := expression.NewBuilder().WithProjection(proj).Build()
 	if err != nil {
 		return nil, err
@@ -252,6 +527,7 @@ func (r *Repository) GetItemProduct(ctx context.Context, invoiceID, itemID strin
 		Key:                      pk,
 		ExpressionAttributeNames: expr.Names(),
 		ProjectionExpression:     expr.Projection(),
+		ConsistentRead:           r.consistentRead(opts),
 	}
 
 	result, err := r.client.GetItemWithContext(ctx, input)
@@ -264,32 +540,233 @@ func (r *Repository) GetItemProduct(ctx context.Context, invoiceID, itemID strin
 		return nil, err
 	}
 
+	if product.DeletedAt != nil && !invoice.NewReadOptions(opts...).IncludeDeleted {
+		return nil, nil
+	}
+
 	return product.ToProduct(), nil
 }
 
+func (r *Repository) UpdateItem(
+	ctx context.Context, invoiceID, itemID string, upd invoice.ItemUpdate) error {
+
+	r, err := r.scoped(ctx)
+	if err != nil {
+		return err
+	}
+
+	item, err := r.GetItem(ctx, invoiceID, itemID, invoice.ConsistentRead())
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return invoice.ErrItemNotFound
+	}
+	if upd.ExpectedVersion != nil && *upd.ExpectedVersion != item.Version {
+		return invoice.ErrVersionConflict
+	}
+
+	pk := r.keys.itemPrimaryKey(invoiceID, itemID)
+
+	now := r.clock.Now()
+	set := expression.
+		Set(expression.Name("updatedAt"), expression.Value(now)).
+		Set(expression.Name("version"), nextVersion)
+	if upd.Name != nil {
+		set = set.Set(expression.Name("name"), expression.Value(*upd.Name))
+	}
+	if upd.Qty != nil {
+		set = set.Set(expression.Name("qty"), expression.Value(*upd.Qty))
+	}
+	if upd.Price != nil {
+		set = set.Set(expression.Name("price"), expression.Value(*upd.Price))
+	}
+
+	updated := *item
+	upd.Apply(&updated)
+	updated.UpdatedAt = now
+
+	// totals change by the difference from the read item
+	cond := expression.AttributeExists(expression.Name(r.keys.PartitionKey)).
+		And(notDeletedFilter).
+		And(versionCondition(item.Version))
+	if upd.Qty != nil || upd.Price != nil {
+		cond = cond.And(creditCovered(updated))
+	}
+
+	expr, err := expression.NewBuilder().WithUpdate(set).WithCondition(cond).Build()
+	if err != nil {
+		return err
+	}
+
+	update := &dynamodb.Update{
+		TableName:                 r.table,
+		Key:                       pk,
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ConditionExpression:       expr.Condition(),
+		UpdateExpression:          expr.Update(),
+	}
+
+	before := invoice.Snapshot{Items: []invoice.Item{*item}}
+	after := invoice.Snapshot{Items: []invoice.Item{updated}}
+	writes := []*dynamodb.TransactWriteItem{{Update: update}}
+	err = r.transactItemChanges(ctx, invoiceID, writes, func() ([]*dynamodb.TransactWriteItem, error) {
+		return r.itemChanges(ctx, invoiceID, invoice.OpUpdateItem, before, after, now)
+	})
+	if isTransactionCanceled(err) {
+		return invoice.ErrVersionConflict
+	}
+	return err
+}
+
+// DeleteItem marks the item deleted by the actor of ctx. Deleted items are
+// excluded from reads and can be restored. Deleting a missing or already
+// deleted item does nothing.
 func (r *Repository) DeleteItem(ctx context.Context, invoiceID, itemID string) error {
-	pk, err := itemPrimaryKey(invoiceID, itemID)
+	r, err := r.scoped(ctx)
 	if err != nil {
 		return err
 	}
 
-	input := &dynamodb.DeleteItemInput{
-		TableName: r.table,
-		Key:       pk,
+	item, err := r.GetItem(ctx, invoiceID, itemID, invoice.ConsistentRead())
+	if err != nil || item == nil {
+		return err
+	}
+
+	pk := r.keys.itemPrimaryKey(invoiceID, itemID)
+
+	now := r.clock.Now()
+	actor := invoice.ActorFromContext(ctx)
+	upd := expression.
+		Set(expression.Name("deletedAt"), expression.Value(now)).
+		Set(expression.Name("updatedAt"), expression.Value(now)).
+		Set(expression.Name("version"), nextVersion)
+	if actor != "" {
+		upd = upd.Set(expression.Name("deletedBy"), expression.Value(actor))
+	}
+	if r.retention > 0 {
+		upd = upd.Set(expression.Name("expiresAt"), expression.Value(now.Add(r.retention).Unix()))
+	}
+	cond := expression.AttributeExists(expression.Name(r.keys.PartitionKey)).
+		And(notDeletedFilter).
+		And(versionCondition(item.Version))
+	expr, err := expression.NewBuilder().WithUpdate(upd).WithCondition(cond).Build()
+	if err != nil {
+		return err
+	}
+
+	update := &dynamodb.Update{
+		TableName:                 r.table,
+		Key:                       pk,
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ConditionExpression:       expr.Condition(),
+		UpdateExpression:          expr.Update(),
 	}
 
-	_, err = r.client.DeleteItemWithContext(ctx, input)
+	deleted := *item
+	deleted.DeletedAt = now
+	deleted.DeletedBy = actor
+	deleted.UpdatedAt = now
+	deleted.Version++
+	before := invoice.Snapshot{Items: []invoice.Item{*item}}
+	after := invoice.Snapshot{Items: []invoice.Item{deleted}}
+	writes := []*dynamodb.TransactWriteItem{{Update: update}}
+	err = r.transactItemChanges(ctx, invoiceID, writes, func() ([]*dynamodb.TransactWriteItem, error) {
+		return r.itemChanges(ctx, invoiceID, invoice.OpDeleteItem, before, after, now)
+	})
+	if conditionFailed(err, 0) {
+		return invoice.ErrVersionConflict
+	}
 	return err
 }
 
-func (r *Repository) GetItemsByStatus(ctx context.Context, status invoice.Status) ([]invoice.Item, error) {
+// RestoreItem restores deleted item. It returns invoice.ErrItemNotFound when
+// the item does not exist or was already purged.
+func (r *Repository) RestoreItem(ctx context.Context, invoiceID, itemID string) error {
+	r, err := r.scoped(ctx)
+	if err != nil {
+		return err
+	}
+
+	item, err := r.GetItem(ctx, invoiceID, itemID, invoice.IncludeDeleted(), invoice.ConsistentRead())
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return invoice.ErrItemNotFound
+	}
+	if !item.Deleted() {
+		return nil
+	}
+
+	pk := r.keys.itemPrimaryKey(invoiceID, itemID)
+
+	now := r.clock.Now()
+	upd := expression.
+		Remove(expression.Name("deletedAt")).
+		Remove(expression.Name("deletedBy")).
+		Remove(expression.Name("expiresAt")).
+		Set(expression.Name("updatedAt"), expression.Value(now)).
+		Set(expression.Name("version"), nextVersion)
+	cond := expression.AttributeExists(expression.Name("deletedAt")).And(versionCondition(item.Version))
+	expr, err := expression.NewBuilder().WithUpdate(upd).WithCondition(cond).Build()
+	if err != nil {
+		return err
+	}
+
+	update := &dynamodb.Update{
+		TableName:                 r.table,
+		Key:                       pk,
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ConditionExpression:       expr.Condition(),
+		UpdateExpression:          expr.Update(),
+	}
+
+	restored := *item
+	restored.DeletedAt = time.Time{}
+	restored.DeletedBy = ""
+	restored.UpdatedAt = now
+	restored.Version++
+	before := invoice.Snapshot{Items: []invoice.Item{*item}}
+	after := invoice.Snapshot{Items: []invoice.Item{restored}}
+	writes := []*dynamodb.TransactWriteItem{{Update: update}}
+	err = r.transactItemChanges(ctx, invoiceID, writes, func() ([]*dynamodb.TransactWriteItem, error) {
+		return r.itemChanges(ctx, invoiceID, invoice.OpRestoreItem, before, after, now)
+	})
+	if conditionFailed(err, 0) {
+		return invoice.ErrVersionConflict
+	}
+	return err
+}
+
+func (r *Repository) GetItemsByStatus(
+	ctx context.Context, status invoice.Status, opts ...invoice.ReadOption) ([]invoice.Item, error) {
+
+	return collectItems(r.IterateItemsByStatus(ctx, status, opts...))
+}
+
+func (r *Repository) IterateItemsByStatus(
+	ctx context.Context, status invoice.Status, opts ...invoice.ReadOption) invoice.ItemIterator {
+
+	r, err := r.scoped(ctx)
+	if err != nil {
+		return errIterator{err}
+	}
+
 	filt := expression.And(
-		expression.Name("sk").BeginsWith(itemSkPrefix+keySeparator),
+		expression.Name(r.keys.SortKey).BeginsWith(r.keys.keyPrefix(r.keys.Prefixes.Item)),
 		expression.Name("status").Equal(expression.Value(status)),
+		r.keys.tenantFilter(),
 	)
+	if !invoice.NewReadOptions(opts...).IncludeDeleted {
+		filt = filt.And(notDeletedFilter)
+	}
 	expr, err := expression.NewBuilder().WithFilter(filt).Build()
 	if err != nil {
-		return nil, err
+		return errIterator{err}
 	}
 
 	input := &dynamodb.ScanInput{
@@ -297,30 +774,40 @@ func (r *Repository) GetItemsByStatus(ctx context.Context, status invoice.Status
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
 		FilterExpression:          expr.Filter(),
+		ConsistentRead:            r.consistentRead(opts),
 	}
 
-	result, err := r.client.ScanWithContext(ctx, input)
-	if err != nil {
-		return nil, err
-	}
-
-	return toInvoiceItems(result.Items)
+	return newItemIterator(ctx, r.scanPages(input))
 }
 
 func (r *Repository) GetInvoiceItems(
-	ctx context.Context, invoiceID string) ([]invoice.Item, error) {
+	ctx context.Context, invoiceID string, opts ...invoice.ReadOption) ([]invoice.Item, error) {
+
+	return collectItems(r.IterateInvoiceItems(ctx, invoiceID, opts...))
+}
+
+func (r *Repository) IterateInvoiceItems(
+	ctx context.Context, invoiceID string, opts ...invoice.ReadOption) invoice.ItemIterator {
 
-	pk := itemPartitionKey(invoiceID)
+	r, err := r.scoped(ctx)
+	if err != nil {
+		return errIterator{err}
+	}
+
+	pk := r.keys.itemPartitionKey(invoiceID)
 	keyCond := expression.KeyAnd(
-		expression.Key("pk").Equal(expression.Value(pk)),
-		expression.Key("sk").BeginsWith(itemSkPrefix+keySeparator),
+		expression.Key(r.keys.PartitionKey).Equal(expression.Value(pk)),
+		expression.Key(r.keys.SortKey).BeginsWith(r.keys.keyPrefix(r.keys.Prefixes.Item)),
 	)
 
-	expr, err := expression.NewBuilder().
-		WithKeyCondition(keyCond).
-		Build()
+	builder := expression.NewBuilder().WithKeyCondition(keyCond)
+	if !invoice.NewReadOptions(opts...).IncludeDeleted {
+		builder = builder.WithFilter(notDeletedFilter)
+	}
+
+	expr, err := builder.Build()
 	if err != nil {
-		return nil, err
+		return errIterator{err}
 	}
 
 	input := &dynamodb.QueryInput{
@@ -328,26 +815,31 @@ func (r *Repository) GetInvoiceItems(
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
 		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ConsistentRead:            r.consistentRead(opts),
 	}
 
-	result, err := r.client.QueryWithContext(ctx, input)
-	if err != nil {
-		return nil, err
-	}
-
-	return toInvoiceItems(result.Items)
+	return newItemIterator(ctx, r.queryPages(input))
 }
 
 func (r *Repository) GetInvoiceItemsByStatus(
-	ctx context.Context, invoiceID string, status invoice.Status) ([]invoice.Item, error) {
+	ctx context.Context, invoiceID string, status invoice.Status, opts ...invoice.ReadOption) ([]invoice.Item, error) {
+
+	r, err := r.scoped(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-	pk := itemPartitionKey(invoiceID)
+	pk := r.keys.itemPartitionKey(invoiceID)
 	keyCond := expression.KeyAnd(
-		expression.Key("pk").Equal(expression.Value(pk)),
-		expression.Key("sk").BeginsWith(itemSkPrefix+keySeparator),
+		expression.Key(r.keys.PartitionKey).Equal(expression.Value(pk)),
+		expression.Key(r.keys.SortKey).BeginsWith(r.keys.keyPrefix(r.keys.Prefixes.Item)),
 	)
 
 	filt := expression.Name("status").Equal(expression.Value(status))
+	if !invoice.NewReadOptions(opts...).IncludeDeleted {
+		filt = filt.And(notDeletedFilter)
+	}
 
 	expr, err := expression.NewBuilder().
 		WithKeyCondition(keyCond).
@@ -363,113 +855,200 @@ func (r *Repository) GetInvoiceItemsByStatus(
 		ExpressionAttributeValues: expr.Values(),
 		KeyConditionExpression:    expr.KeyCondition(),
 		FilterExpression:          expr.Filter(),
+		ConsistentRead:            r.consistentRead(opts),
 	}
 
-	result, err := r.client.QueryWithContext(ctx, input)
-	if err != nil {
-		return nil, err
-	}
-
-	return toInvoiceItems(result.Items)
+	return collectItems(newItemIterator(ctx, r.queryPages(input)))
 }
 
 func (r *Repository) UpdateInvoiceItemStatus(
 	ctx context.Context, invoiceID, itemID string, status invoice.Status) error {
 
-	pk, err := itemPrimaryKey(invoiceID, itemID)
+	r, err := r.scoped(ctx)
 	if err != nil {
 		return err
 	}
 
+	item, err := r.GetItem(ctx, invoiceID, itemID, invoice.ConsistentRead())
+	if err != nil || item == nil {
+		return err
+	}
+
+	pk := r.keys.itemPrimaryKey(invoiceID, itemID)
+
+	now := r.clock.Now()
 	upd := expression.
 		Set(expression.Name("status"), expression.Value(status)).
-		Set(expression.Name("updatedAt"), expression.Value(time.Now()))
-	expr, err := expression.NewBuilder().WithUpdate(upd).Build()
+		Set(expression.Name("updatedAt"), expression.Value(now)).
+		Set(expression.Name("version"), nextVersion)
+	cond := expression.AttributeExists(expression.Name(r.keys.PartitionKey)).
+		And(notDeletedFilter).
+		And(versionCondition(item.Version))
+	expr, err := expression.NewBuilder().WithUpdate(upd).WithCondition(cond).Build()
 	if err != nil {
 		return err
 	}
 
-	input := &dynamodb.UpdateItemInput{
+	update := &dynamodb.Update{
 		TableName:                 r.table,
 		Key:                       pk,
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
+		ConditionExpression:       expr.Condition(),
 		UpdateExpression:          expr.Update(),
 	}
 
-	_, err = r.client.UpdateItemWithContext(ctx, input)
+	updated := *item
+	updated.Status = status
+	updated.UpdatedAt = now
+	updated.Version++
+	before := invoice.Snapshot{Items: []invoice.Item{*item}}
+	after := invoice.Snapshot{Items: []invoice.Item{updated}}
+	writes := []*dynamodb.TransactWriteItem{{Update: update}}
+	err = r.transactItemChanges(ctx, invoiceID, writes, func() ([]*dynamodb.TransactWriteItem, error) {
+		return r.itemChanges(ctx, invoiceID, invoice.OpUpdateItemStatus, before, after, now)
+	})
+	if conditionFailed(err, 0) {
+		return invoice.ErrVersionConflict
+	}
 	return err
 }
 
 func (r *Repository) UpdateInvoiceItemsStatus(
 	ctx context.Context, invoiceID string, itemIDs []string, status invoice.Status) error {
 
+	r, err := r.scoped(ctx)
+	if err != nil {
+		return err
+	}
+
 	if len(itemIDs) == 0 {
 		return nil
 	}
 
-	upd := expression.
-		Set(expression.Name("status"), expression.Value(status)).
-		Set(expression.Name("updatedAt"), expression.Value(time.Now()))
-	expr, err := expression.NewBuilder().WithUpdate(upd).Build()
+	invoiceItems, err := r.GetInvoiceItems(ctx, invoiceID, invoice.ConsistentRead())
 	if err != nil {
 		return err
 	}
 
-	transactItems := make([]*dynamodb.TransactWriteItem, len(itemIDs))
-	for idx, itemID := range itemIDs {
-		pk, err := itemPrimaryKey(invoiceID, itemID)
-		if err != nil {
-			return err
-		}
+	itemsByID := make(map[string]invoice.Item, len(invoiceItems))
+	for _, item := range invoiceItems {
+		itemsByID[item.ID] = item
+	}
 
-		update := &dynamodb.Update{
-			TableName:                 r.table,
-			Key:                       pk,
-			ExpressionAttributeNames:  expr.Names(),
-			ExpressionAttributeValues: expr.Values(),
-			UpdateExpression:          expr.Update(),
+	var before, after invoice.Snapshot
+	for _, itemID := range itemIDs {
+		item, ok := itemsByID[itemID]
+		if !ok {
+			continue
 		}
-		transactItems[idx] = &dynamodb.TransactWriteItem{Update: update}
+
+		before.Items = append(before.Items, item)
 	}
 
-	transaction := &dynamodb.TransactWriteItemsInput{TransactItems: transactItems}
-	if err := transaction.Validate(); err != nil {
+	if len(before.Items) == 0 {
+		return nil
+	}
+
+	now := r.clock.Now()
+	upd := expression.
+		Set(expression.Name("status"), expression.Value(status)).
+		Set(expression.Name("updatedAt"), expression.Value(now)).
+		Set(expression.Name("version"), nextVersion)
+	cond := expression.AttributeExists(expression.Name(r.keys.PartitionKey)).And(notDeletedFilter)
+	updates, err := r.invoiceItemsToUpdates(before.Items, upd, cond)
+	if err != nil {
 		return err
 	}
 
-	_, err = r.client.TransactWriteItemsWithContext(ctx, transaction)
+	transactItems := make([]*dynamodb.TransactWriteItem, len(updates))
+	for idx, update := range updates {
+		transactItems[idx] = &dynamodb.TransactWriteItem{Update: update}
+
+		item := before.Items[idx]
+		item.Status = status
+		item.UpdatedAt = now
+		item.Version++
+		after.Items = append(after.Items, item)
+	}
+
+	err = r.transactItemChanges(ctx, invoiceID, transactItems, func() ([]*dynamodb.TransactWriteItem, error) {
+		return r.itemChanges(ctx, invoiceID, invoice.OpUpdateItemsStatus, before, after, now)
+	})
+	if isTransactionCanceled(err) {
+		return invoice.ErrVersionConflict
+	}
 	return err
 }
 
-// TODO: add a list of old items IDs to replace
+// ReplaceItems cancels old items and adds new items in one transaction. The
+// transaction fails with invoice.ErrItemConflict when an old item is not in
+// NEW status or a new item already exists. Repeated old item IDs fail with
+// invoice.ErrInvalidItemUpdate, a transaction cannot change an item twice.
 func (r *Repository) ReplaceItems(
-	ctx context.Context, invoiceID string, newItems []invoice.Item) error {
+	ctx context.Context, invoiceID string, oldItemIDs []string, newItems []invoice.Item) error {
 
-	items, err := r.GetInvoiceItemsByStatus(ctx, invoiceID, invoice.New)
+	r, err := r.scoped(ctx)
 	if err != nil {
 		return err
 	}
-	if len(items) == 0 {
+
+	if len(oldItemIDs) == 0 && len(newItems) == 0 {
 		return nil
 	}
+	if err := invoice.ValidateItemIDs("oldItemIds", oldItemIDs); err != nil {
+		return err
+	}
 
-	upd := expression.Set(expression.Name("status"), expression.Value(invoice.Cancelled))
-	expr, err := expression.NewBuilder().WithUpdate(upd).Build()
+	invoiceItems, err := r.GetInvoiceItems(ctx, invoiceID, invoice.ConsistentRead())
 	if err != nil {
 		return err
 	}
 
-	updates, err := invoiceItemsToUpdates(items, r.table, expr)
+	itemsByID := make(map[string]invoice.Item, len(invoiceItems))
+	for _, item := range invoiceItems {
+		itemsByID[item.ID] = item
+	}
+
+	items := make([]invoice.Item, len(oldItemIDs))
+	for idx, itemID := range oldItemIDs {
+		item, ok := itemsByID[itemID]
+		if !ok {
+			return invoice.ErrItemNotFound
+		}
+		if item.Status != invoice.New {
+			return invoice.ErrItemConflict
+		}
+		items[idx] = item
+	}
+
+	now := r.clock.Now()
+	newItems = invoice.Created(newItems, now)
+	upd := expression.
+		Set(expression.Name("status"), expression.Value(invoice.Cancelled)).
+		Set(expression.Name("updatedAt"), expression.Value(now)).
+		Set(expression.Name("version"), nextVersion)
+	cond := expression.Name("status").Equal(expression.Value(invoice.New)).And(notDeletedFilter)
+	updates, err := r.invoiceItemsToUpdates(items, upd, cond)
 	if err != nil {
 		return err
 	}
 
-	puts, err := invoiceItemsToPuts(newItems, r.table)
+	puts, err := r.invoiceItemsToPuts(newItems)
 	if err != nil {
 		return err
 	}
 
+	before := invoice.Snapshot{Items: items}
+	after := invoice.Snapshot{}
+	for _, item := range items {
+		item.Status = invoice.Cancelled
+		item.UpdatedAt = now
+		item.Version++
+		after.Items = append(after.Items, item)
+	}
+	after.Items = append(after.Items, newItems...)
+
 	transactionItems := []*dynamodb.TransactWriteItem{}
 	for _, update := range updates {
 		transactionItems = append(transactionItems, &dynamodb.TransactWriteItem{Update: update})
@@ -478,11 +1057,11 @@ func (r *Repository) ReplaceItems(
 		transactionItems = append(transactionItems, &dynamodb.TransactWriteItem{Put: put})
 	}
 
-	transaction := &dynamodb.TransactWriteItemsInput{TransactItems: transactionItems}
-	if err := transaction.Validate(); err != nil {
-		return err
+	err = r.transactItemChanges(ctx, invoiceID, transactionItems, func() ([]*dynamodb.TransactWriteItem, error) {
+		return r.itemChanges(ctx, invoiceID, invoice.OpReplaceItems, before, after, now)
+	})
+	if isTransactionCanceled(err) {
+		return invoice.ErrItemConflict
 	}
-
-	_, err = r.client.TransactWriteItemsWithContext(ctx, transaction)
 	return err
 }
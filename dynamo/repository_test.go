@@ -0,0 +1,1109 @@
+package dynamo_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/antklim/go-dynamodb/dynamo"
+	"github.com/antklim/go-dynamodb/invoice"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pagedClient serves query and scan results in pages of pageSize items.
+type pagedClient struct {
+	dynamodbiface.DynamoDBAPI
+	items    []map[string]*dynamodb.AttributeValue
+	pageSize int
+	calls    int
+}
+
+func (c *pagedClient) page(startKey map[string]*dynamodb.AttributeValue) (
+	[]map[string]*dynamodb.AttributeValue, map[string]*dynamodb.AttributeValue) {
+
+	c.calls++
+	start := 0
+	if startKey != nil {
+		start, _ = strconv.Atoi(*startKey["offset"].N)
+	}
+
+	end := start + c.pageSize
+	if end >= len(c.items) {
+		return c.items[start:], nil
+	}
+
+	lastKey := map[string]*dynamodb.AttributeValue{"offset": {N: aws.String(strconv.Itoa(end))}}
+	return c.items[start:end], lastKey
+}
+
+func (c *pagedClient) QueryWithContext(_ aws.Context, input *dynamodb.QueryInput, _ ...request.Option) (
+	*dynamodb.QueryOutput, error) {
+
+	items, lastKey := c.page(input.ExclusiveStartKey)
+	return &dynamodb.QueryOutput{Items: items, LastEvaluatedKey: lastKey}, nil
+}
+
+func (c *pagedClient) ScanWithContext(_ aws.Context, input *dynamodb.ScanInput, _ ...request.Option) (
+	*dynamodb.ScanOutput, error) {
+
+	items, lastKey := c.page(input.ExclusiveStartKey)
+	return &dynamodb.ScanOutput{Items: items, LastEvaluatedKey: lastKey}, nil
+}
+
+func newPagedClient(t *testing.T, n, pageSize int) *pagedClient {
+	client := &pagedClient{pageSize: pageSize}
+	for i := 0; i < n; i++ {
+		item := dynamo.NewItem(invoice.Item{ID: uuid.NewString(), InvoiceID: "1", Status: invoice.New})
+		raw, err := dynamodbattribute.MarshalMap(item)
+		require.NoError(t, err)
+		client.items = append(client.items, raw)
+	}
+	return client
+}
+
+func TestItemIterator(t *testing.T) {
+	t.Run("fetches pages lazily", func(t *testing.T) {
+		client := newPagedClient(t, 5, 2)
+		repo := dynamo.NewRepository(client, "invoices")
+
+		it := repo.IterateInvoiceItems(context.Background(), "1")
+		defer it.Close()
+		assert.Equal(t, 0, client.calls)
+
+		require.True(t, it.Next())
+		require.True(t, it.Next())
+		assert.Equal(t, 1, client.calls)
+
+		require.True(t, it.Next())
+		assert.Equal(t, 2, client.calls)
+
+		n := 3
+		for it.Next() {
+			n++
+		}
+		require.NoError(t, it.Err())
+		assert.Equal(t, 5, n)
+		assert.Equal(t, 3, client.calls)
+	})
+
+	t.Run("collects all pages", func(t *testing.T) {
+		client := newPagedClient(t, 5, 2)
+		repo := dynamo.NewRepository(client, "invoices")
+
+		items, err := repo.GetItemsByStatus(context.Background(), invoice.New)
+		require.NoError(t, err)
+		assert.Len(t, items, 5)
+	})
+
+	t.Run("stops when context cancelled", func(t *testing.T) {
+		client := newPagedClient(t, 5, 2)
+		repo := dynamo.NewRepository(client, "invoices")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		it := repo.IterateItemsByStatus(ctx, invoice.New)
+		defer it.Close()
+
+		require.True(t, it.Next())
+		cancel()
+		assert.False(t, it.Next())
+		assert.ErrorIs(t, it.Err(), context.Canceled)
+	})
+}
+
+// segmentedClient returns one item per scanned segment.
+type segmentedClient struct {
+	dynamodbiface.DynamoDBAPI
+	mu       sync.Mutex
+	segments []int64
+}
+
+func (c *segmentedClient) ScanWithContext(_ aws.Context, input *dynamodb.ScanInput, _ ...request.Option) (
+	*dynamodb.ScanOutput, error) {
+
+	c.mu.Lock()
+	c.segments = append(c.segments, aws.Int64Value(input.Segment))
+	c.mu.Unlock()
+
+	item := dynamo.NewItem(invoice.Item{ID: uuid.NewString(), InvoiceID: "1", Status: invoice.New})
+	raw, err := dynamodbattribute.MarshalMap(item)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dynamodb.ScanOutput{Items: []map[string]*dynamodb.AttributeValue{raw}}, nil
+}
+
+func TestParallelScan(t *testing.T) {
+	t.Run("scans all segments", func(t *testing.T) {
+		client := &segmentedClient{}
+		repo := dynamo.NewRepository(client, "invoices")
+
+		var mu sync.Mutex
+		n := 0
+		err := repo.ParallelScanItems(context.Background(), dynamo.ScanOptions{Workers: 4},
+			func(_ context.Context, item invoice.Item) error {
+				mu.Lock()
+				defer mu.Unlock()
+				n++
+				return nil
+			})
+		require.NoError(t, err)
+		assert.Equal(t, 4, n)
+		assert.ElementsMatch(t, []int64{0, 1, 2, 3}, client.segments)
+	})
+
+	t.Run("stops on callback error", func(t *testing.T) {
+		client := &segmentedClient{}
+		repo := dynamo.NewRepository(client, "invoices")
+
+		errStop := errors.New("stop")
+		err := repo.ParallelScan(context.Background(), dynamo.ScanOptions{Workers: 2},
+			func(context.Context, map[string]*dynamodb.AttributeValue) error {
+				return errStop
+			})
+		assert.ErrorIs(t, err, errStop)
+	})
+
+	t.Run("stops when context cancelled", func(t *testing.T) {
+		client := &segmentedClient{}
+		repo := dynamo.NewRepository(client, "invoices")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := repo.ParallelScan(ctx, dynamo.ScanOptions{Workers: 2},
+			func(context.Context, map[string]*dynamodb.AttributeValue) error {
+				return nil
+			})
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+// txClient records transactions and serves GetItem from rows.
+type txClient struct {
+	dynamodbiface.DynamoDBAPI
+	rows         []map[string]*dynamodb.AttributeValue
+	transactions []*dynamodb.TransactWriteItemsInput
+	updates      []*dynamodb.UpdateItemInput
+	txErr        error // returned by TransactWriteItems after recording the transaction
+	txErrs       int   // number of transactions failing with txErr, all when 0
+}
+
+func (c *txClient) GetItemWithContext(_ aws.Context, input *dynamodb.GetItemInput, _ ...request.Option) (
+	*dynamodb.GetItemOutput, error) {
+
+	if row := c.row(input.Key); row != nil {
+		return &dynamodb.GetItemOutput{Item: row}, nil
+	}
+	return &dynamodb.GetItemOutput{}, nil
+}
+
+// QueryWithContext returns rows of the sort key prefix of the query, item
+// rows when the query has none. The client serves a single invoice.
+func (c *txClient) QueryWithContext(_ aws.Context, input *dynamodb.QueryInput, _ ...request.Option) (
+	*dynamodb.QueryOutput, error) {
+
+	prefix := "ITEM#"
+	for _, v := range input.ExpressionAttributeValues {
+		if s := aws.StringValue(v.S); strings.HasSuffix(s, "#") {
+			prefix = s
+		}
+	}
+
+	out := &dynamodb.QueryOutput{}
+	for _, row := range c.rows {
+		if strings.HasPrefix(aws.StringValue(row["sk"].S), prefix) && (prefix != "ITEM#" || dynamo.IsItemRow(row)) {
+			out.Items = append(out.Items, row)
+		}
+	}
+	return out, nil
+}
+
+// UpdateItemWithContext records the update of the existing row.
+func (c *txClient) UpdateItemWithContext(_ aws.Context, input *dynamodb.UpdateItemInput, _ ...request.Option) (
+	*dynamodb.UpdateItemOutput, error) {
+
+	if c.row(input.Key) == nil {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "row does not exist", nil)
+	}
+	c.updates = append(c.updates, input)
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (c *txClient) TransactWriteItemsWithContext(
+	_ aws.Context, input *dynamodb.TransactWriteItemsInput, _ ...request.Option) (
+	*dynamodb.TransactWriteItemsOutput, error) {
+
+	c.transactions = append(c.transactions, input)
+	if c.txErr != nil && (c.txErrs == 0 || len(c.transactions) <= c.txErrs) {
+		return nil, c.txErr
+	}
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+func (c *txClient) row(key map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	for _, row := range c.rows {
+		if *row["pk"].S == *key["pk"].S && *row["sk"].S == *key["sk"].S {
+			return row
+		}
+	}
+	return nil
+}
+
+func (c *txClient) addRow(t *testing.T, row interface{}) {
+	raw, err := dynamodbattribute.MarshalMap(row)
+	require.NoError(t, err)
+	c.rows = append(c.rows, raw)
+}
+
+// addInvoice adds the row of a new invoice without items.
+func (c *txClient) addInvoice(t *testing.T, invoiceID string) {
+	c.addRow(t, dynamo.NewInvoice(invoice.Invoice{ID: invoiceID, Status: invoice.New, Date: time.Now()}))
+}
+
+func TestHistory(t *testing.T) {
+	t.Run("records soft deletion in the same transaction", func(t *testing.T) {
+		item := invoice.Item{ID: uuid.NewString(), InvoiceID: uuid.NewString(), Status: invoice.New}
+		client := &txClient{}
+		client.addInvoice(t, item.InvoiceID)
+		client.addRow(t, dynamo.NewItem(item))
+		repo := dynamo.NewRepository(client, "invoices")
+
+		ctx := invoice.WithActor(context.Background(), "auditor")
+		err := repo.DeleteItem(ctx, item.InvoiceID, item.ID)
+		require.NoError(t, err)
+
+		require.Len(t, client.transactions, 1)
+		transactItems := client.transactions[0].TransactItems
+		require.Len(t, transactItems, 3)
+		require.NotNil(t, transactItems[0].Update)
+		assert.Contains(t, aws.StringValue(transactItems[0].Update.ConditionExpression), "attribute_not_exists")
+
+		change := dynamo.Change{}
+		err = dynamodbattribute.UnmarshalMap(transactItems[2].Put.Item, &change)
+		require.NoError(t, err)
+		assert.Equal(t, "INVOICE#"+item.InvoiceID, change.PK)
+		assert.Equal(t, string(invoice.OpDeleteItem), change.Operation)
+		assert.Equal(t, "auditor", change.Actor)
+		require.Len(t, change.Before.Items, 1)
+		assert.Equal(t, item.ID, change.Before.Items[0].ID)
+		require.Len(t, change.After.Items, 1)
+		require.NotNil(t, change.After.Items[0].DeletedAt)
+		assert.Equal(t, "auditor", change.After.Items[0].DeletedBy)
+	})
+
+	t.Run("sets expiration of deleted item when retention set", func(t *testing.T) {
+		item := invoice.Item{ID: uuid.NewString(), InvoiceID: uuid.NewString(), Status: invoice.New}
+		client := &txClient{}
+		client.addInvoice(t, item.InvoiceID)
+		client.addRow(t, dynamo.NewItem(item))
+		repo := dynamo.NewRepository(client, "invoices", dynamo.WithRetention(24*time.Hour))
+
+		err := repo.DeleteItem(context.Background(), item.InvoiceID, item.ID)
+		require.NoError(t, err)
+
+		require.Len(t, client.transactions, 1)
+		update := client.transactions[0].TransactItems[0].Update
+		require.NotNil(t, update)
+		names := make([]string, 0, len(update.ExpressionAttributeNames))
+		for _, name := range update.ExpressionAttributeNames {
+			names = append(names, aws.StringValue(name))
+		}
+		assert.Contains(t, names, "expiresAt")
+	})
+
+	t.Run("restores deleted item", func(t *testing.T) {
+		item := invoice.Item{
+			ID:        uuid.NewString(),
+			InvoiceID: uuid.NewString(),
+			Status:    invoice.New,
+			DeletedAt: time.Now(),
+			DeletedBy: "auditor",
+		}
+		client := &txClient{}
+		client.addInvoice(t, item.InvoiceID)
+		client.addRow(t, dynamo.NewItem(item))
+		repo := dynamo.NewRepository(client, "invoices")
+
+		got, err := repo.GetItem(context.Background(), item.InvoiceID, item.ID)
+		require.NoError(t, err)
+		assert.Nil(t, got)
+
+		got, err = repo.GetItem(context.Background(), item.InvoiceID, item.ID, invoice.IncludeDeleted())
+		require.NoError(t, err)
+		require.NotNil(t, got)
+		assert.True(t, got.Deleted())
+
+		err = repo.RestoreItem(context.Background(), item.InvoiceID, item.ID)
+		require.NoError(t, err)
+
+		require.Len(t, client.transactions, 1)
+		change := dynamo.Change{}
+		err = dynamodbattribute.UnmarshalMap(client.transactions[0].TransactItems[2].Put.Item, &change)
+		require.NoError(t, err)
+		assert.Equal(t, string(invoice.OpRestoreItem), change.Operation)
+		require.Len(t, change.After.Items, 1)
+		assert.Nil(t, change.After.Items[0].DeletedAt)
+	})
+
+	t.Run("fails to restore missing item", func(t *testing.T) {
+		repo := dynamo.NewRepository(&txClient{}, "invoices")
+
+		err := repo.RestoreItem(context.Background(), uuid.NewString(), uuid.NewString())
+		assert.ErrorIs(t, err, invoice.ErrItemNotFound)
+	})
+
+	t.Run("skips deletion of missing item", func(t *testing.T) {
+		client := &txClient{}
+		repo := dynamo.NewRepository(client, "invoices")
+
+		err := repo.DeleteItem(context.Background(), uuid.NewString(), uuid.NewString())
+		require.NoError(t, err)
+		assert.Empty(t, client.transactions)
+	})
+}
+
+func TestOutbox(t *testing.T) {
+	t.Run("writes event in the same transaction", func(t *testing.T) {
+		item := invoice.Item{ID: uuid.NewString(), InvoiceID: uuid.NewString(), Status: invoice.New}
+		client := &txClient{}
+		client.addInvoice(t, item.InvoiceID)
+		repo := dynamo.NewRepository(client, "invoices")
+
+		err := repo.AddItem(context.Background(), item)
+		require.NoError(t, err)
+
+		require.Len(t, client.transactions, 1)
+		transactItems := client.transactions[0].TransactItems
+		require.Len(t, transactItems, 4)
+
+		event := dynamo.Event{}
+		err = dynamodbattribute.UnmarshalMap(transactItems[3].Put.Item, &event)
+		require.NoError(t, err)
+		assert.Equal(t, "OUTBOX", event.PK)
+		assert.Equal(t, string(invoice.ItemAdded), event.Type)
+		assert.Equal(t, item.InvoiceID, event.InvoiceID)
+		require.Len(t, event.Data.Items, 1)
+		assert.Equal(t, item.ID, event.Data.Items[0].ID)
+	})
+}
+
+func TestUpdateItem(t *testing.T) {
+	item := invoice.Item{ID: uuid.NewString(), InvoiceID: uuid.NewString(), Name: "Guitar", Qty: 1, Version: 3}
+	client := &txClient{}
+	client.addInvoice(t, item.InvoiceID)
+	client.addRow(t, dynamo.NewItem(item))
+	repo := dynamo.NewRepository(client, "invoices")
+
+	t.Run("sets only changed attributes", func(t *testing.T) {
+		qty, version := uint(2), uint(3)
+		err := repo.UpdateItem(context.Background(), item.InvoiceID, item.ID,
+			invoice.ItemUpdate{Qty: &qty, ExpectedVersion: &version})
+		require.NoError(t, err)
+
+		require.Len(t, client.transactions, 1)
+		update := client.transactions[0].TransactItems[0].Update
+		require.NotNil(t, update)
+
+		var names []string
+		for _, name := range update.ExpressionAttributeNames {
+			names = append(names, *name)
+		}
+		assert.ElementsMatch(t, []string{"pk", "deletedAt", "qty", "updatedAt", "version", "creditedQty", "creditedAmount"},
+			names, "changed attributes and credits covered by the new quantity")
+	})
+
+	t.Run("fails on version mismatch without writing", func(t *testing.T) {
+		qty, version := uint(2), uint(1)
+		err := repo.UpdateItem(context.Background(), item.InvoiceID, item.ID,
+			invoice.ItemUpdate{Qty: &qty, ExpectedVersion: &version})
+		assert.ErrorIs(t, err, invoice.ErrVersionConflict)
+		assert.Len(t, client.transactions, 1)
+	})
+}
+
+func TestClock(t *testing.T) {
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	item := invoice.Item{
+		ID:        uuid.NewString(),
+		InvoiceID: uuid.NewString(),
+		Qty:       1,
+		CreatedAt: now.Add(-time.Hour),
+	}
+	client := &txClient{}
+	client.addInvoice(t, item.InvoiceID)
+	repo := dynamo.NewRepository(client, "invoices", dynamo.WithClock(invoice.FixedClock(now)))
+
+	err := repo.AddItem(context.Background(), item)
+	require.NoError(t, err)
+
+	require.Len(t, client.transactions, 1)
+	transactItems := client.transactions[0].TransactItems
+
+	dbItem := dynamo.Item{}
+	err = dynamodbattribute.UnmarshalMap(transactItems[0].Put.Item, &dbItem)
+	require.NoError(t, err)
+	assert.Equal(t, now, dbItem.CreatedAt)
+	assert.Equal(t, now, dbItem.UpdatedAt)
+
+	change := dynamo.Change{}
+	err = dynamodbattribute.UnmarshalMap(transactItems[2].Put.Item, &change)
+	require.NoError(t, err)
+	assert.Equal(t, now, change.At)
+}
+
+func TestInvoiceItemsUpdate(t *testing.T) {
+	t.Run("updates invoice totals in the same transaction", func(t *testing.T) {
+		item := invoice.Item{ID: uuid.NewString(), InvoiceID: uuid.NewString(), Price: 100, Qty: 2, Status: invoice.New}
+		client := &txClient{}
+		client.addInvoice(t, item.InvoiceID)
+		client.addRow(t, dynamo.NewItem(item))
+		repo := dynamo.NewRepository(client, "invoices")
+
+		err := repo.UpdateInvoiceItemStatus(context.Background(), item.InvoiceID, item.ID, invoice.Cancelled)
+		require.NoError(t, err)
+
+		require.Len(t, client.transactions, 1)
+		update := client.transactions[0].TransactItems[1].Update
+		require.NotNil(t, update)
+		assert.Equal(t, "INVOICE#"+item.InvoiceID, aws.StringValue(update.Key["sk"].S))
+
+		var deltas []string
+		for _, value := range update.ExpressionAttributeValues {
+			if value.N != nil {
+				deltas = append(deltas, aws.StringValue(value.N))
+			}
+		}
+		assert.Subset(t, deltas, []string{"-1", "-200"}, "count and total")
+		assert.Contains(t, aws.StringValue(update.ConditionExpression), "attribute_not_exists", "read version")
+	})
+
+	t.Run("sets payment status by the new total", func(t *testing.T) {
+		inv := invoice.Invoice{ID: uuid.NewString(), Status: invoice.Paid, Date: time.Now(), ItemCount: 1, Total: 200}
+		item := invoice.Item{ID: uuid.NewString(), InvoiceID: inv.ID, Price: 100, Qty: 1, Status: invoice.New}
+		client := &txClient{}
+		client.addRow(t, dynamo.NewInvoice(inv))
+		client.addRow(t, dynamo.NewPayment(invoice.Payment{ID: uuid.NewString(), InvoiceID: inv.ID, Amount: 200}))
+		repo := dynamo.NewRepository(client, "invoices")
+
+		require.NoError(t, repo.AddItem(context.Background(), item))
+
+		require.Len(t, client.transactions, 1)
+		update := client.transactions[0].TransactItems[1].Update
+		require.NotNil(t, update)
+		var statuses []string
+		for _, value := range update.ExpressionAttributeValues {
+			if value.S != nil {
+				statuses = append(statuses, aws.StringValue(value.S))
+			}
+		}
+		assert.Contains(t, statuses, string(invoice.PartiallyPaid), "total grew over the paid amount")
+	})
+
+	t.Run("fails when invoice does not exist", func(t *testing.T) {
+		client := &txClient{}
+		repo := dynamo.NewRepository(client, "invoices")
+
+		item := invoice.Item{ID: uuid.NewString(), InvoiceID: uuid.NewString(), Qty: 1}
+		err := repo.AddItem(context.Background(), item)
+		assert.ErrorIs(t, err, invoice.ErrInvoiceNotFound)
+		assert.Empty(t, client.transactions, "invoice is read before the write")
+	})
+
+	t.Run("conditions item deltas on item version", func(t *testing.T) {
+		item := invoice.Item{ID: uuid.NewString(), InvoiceID: uuid.NewString(), Price: 100, Qty: 2,
+			Status: invoice.New, Version: 3}
+		client := &txClient{}
+		client.addInvoice(t, item.InvoiceID)
+		client.addRow(t, dynamo.NewItem(item))
+		repo := dynamo.NewRepository(client, "invoices")
+
+		err := repo.UpdateInvoiceItemStatus(context.Background(), item.InvoiceID, item.ID, invoice.Cancelled)
+		require.NoError(t, err)
+
+		require.Len(t, client.transactions, 1)
+		update := client.transactions[0].TransactItems[0].Update
+		require.NotNil(t, update)
+		assert.Contains(t, aws.StringValue(update.ConditionExpression), "=")
+
+		var values []string
+		for _, value := range update.ExpressionAttributeValues {
+			values = append(values, aws.StringValue(value.N))
+		}
+		assert.Contains(t, values, "3", "read version")
+	})
+
+	t.Run("backfills totals of legacy invoice and retries", func(t *testing.T) {
+		inv := invoice.Invoice{ID: uuid.NewString(), Status: invoice.New, Date: time.Now()}
+		item := invoice.Item{ID: uuid.NewString(), InvoiceID: inv.ID, Price: 100, Qty: 2, Status: invoice.New}
+		client := &txClient{txErrs: 1, txErr: &dynamodb.TransactionCanceledException{
+			CancellationReasons: []*dynamodb.CancellationReason{
+				{Code: aws.String("None")},
+				{Code: aws.String("ConditionalCheckFailed")},
+				{Code: aws.String("None")},
+			},
+		}}
+		client.addRow(t, dynamo.NewInvoice(inv))
+		client.addRow(t, dynamo.NewItem(item))
+		repo := dynamo.NewRepository(client, "invoices")
+
+		err := repo.UpdateInvoiceItemStatus(context.Background(), item.InvoiceID, item.ID, invoice.Cancelled)
+		require.NoError(t, err)
+		assert.Len(t, client.transactions, 2)
+
+		require.Len(t, client.updates, 1)
+		assert.Contains(t, aws.StringValue(client.updates[0].UpdateExpression), "if_not_exists")
+		var values []string
+		for _, value := range client.updates[0].ExpressionAttributeValues {
+			values = append(values, aws.StringValue(value.N))
+		}
+		assert.ElementsMatch(t, []string{"1", "200"}, values, "recomputed count and total")
+	})
+}
+
+// conflictClient serves rows, has no query results and cancels the first
+// conflicts transactions because a condition failed.
+type conflictClient struct {
+	txClient
+	conflicts int
+}
+
+func (c *conflictClient) QueryWithContext(aws.Context, *dynamodb.QueryInput, ...request.Option) (
+	*dynamodb.QueryOutput, error) {
+
+	return &dynamodb.QueryOutput{}, nil
+}
+
+func (c *conflictClient) TransactWriteItemsWithContext(
+	_ aws.Context, input *dynamodb.TransactWriteItemsInput, _ ...request.Option) (
+	*dynamodb.TransactWriteItemsOutput, error) {
+
+	c.transactions = append(c.transactions, input)
+	if c.conflicts > 0 {
+		c.conflicts--
+		return nil, &dynamodb.TransactionCanceledException{
+			CancellationReasons: []*dynamodb.CancellationReason{{Code: aws.String("ConditionalCheckFailed")}},
+		}
+	}
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+func TestCancelInvoice(t *testing.T) {
+	ctx := context.Background()
+	inv := invoice.Invoice{ID: uuid.NewString(), Status: invoice.New, Date: time.Now(), ItemCount: 1, Total: 200}
+	item := invoice.Item{ID: uuid.NewString(), InvoiceID: inv.ID, Price: 100, Qty: 2, Status: invoice.New}
+
+	t.Run("cancels invoice and its items in one transaction", func(t *testing.T) {
+		client := &txClient{}
+		client.addRow(t, dynamo.NewInvoice(inv))
+		client.addRow(t, dynamo.NewItem(item))
+		repo := dynamo.NewRepository(client, "invoices")
+
+		require.NoError(t, repo.CancelInvoice(ctx, inv.ID))
+		require.Len(t, client.transactions, 1)
+		transactItems := client.transactions[0].TransactItems
+		require.Len(t, transactItems, 4, "invoice, item, change and event")
+		assert.Equal(t, "INVOICE#"+inv.ID, aws.StringValue(transactItems[0].Update.Key["sk"].S))
+		assert.Equal(t, "ITEM#"+item.ID, aws.StringValue(transactItems[1].Update.Key["sk"].S))
+
+		event := dynamo.Event{}
+		require.NoError(t, dynamodbattribute.UnmarshalMap(transactItems[3].Put.Item, &event))
+		assert.Equal(t, string(invoice.InvoiceCancelled), event.Type)
+	})
+
+	t.Run("maps concurrent changes to version conflict", func(t *testing.T) {
+		client := &conflictClient{conflicts: 1}
+		client.addRow(t, dynamo.NewInvoice(inv))
+		repo := dynamo.NewRepository(client, "invoices")
+
+		err := repo.CancelInvoice(ctx, inv.ID)
+		assert.ErrorIs(t, err, invoice.ErrVersionConflict)
+	})
+
+	t.Run("fails when invoice does not exist", func(t *testing.T) {
+		repo := dynamo.NewRepository(&txClient{}, "invoices")
+		assert.ErrorIs(t, repo.CancelInvoice(ctx, inv.ID), invoice.ErrInvoiceNotFound)
+	})
+}
+
+func TestReplaceItems(t *testing.T) {
+	ctx := context.Background()
+	inv := invoice.Invoice{ID: uuid.NewString(), Status: invoice.New, Date: time.Now(), ItemCount: 1, Total: 200}
+	old := invoice.Item{ID: uuid.NewString(), InvoiceID: inv.ID, Price: 100, Qty: 2, Status: invoice.New}
+	replacement := invoice.Item{ID: uuid.NewString(), InvoiceID: inv.ID, Price: 50, Qty: 1, Status: invoice.New}
+
+	newClient := func(t *testing.T, items ...invoice.Item) *txClient {
+		client := &txClient{}
+		client.addRow(t, dynamo.NewInvoice(inv))
+		for _, item := range items {
+			client.addRow(t, dynamo.NewItem(item))
+		}
+		return client
+	}
+
+	// keys returns table keys of the transaction items, DynamoDB rejects
+	// transactions writing a key twice.
+	keys := func(items []*dynamodb.TransactWriteItem) []string {
+		var result []string
+		for _, ti := range items {
+			var key map[string]*dynamodb.AttributeValue
+			switch {
+			case ti.Update != nil:
+				key = ti.Update.Key
+			case ti.Put != nil:
+				key = ti.Put.Item
+			case ti.Delete != nil:
+				key = ti.Delete.Key
+			case ti.ConditionCheck != nil:
+				key = ti.ConditionCheck.Key
+			}
+			result = append(result, aws.StringValue(key["pk"].S)+"/"+aws.StringValue(key["sk"].S))
+		}
+		return result
+	}
+
+	t.Run("cancels old items and puts new items in one transaction", func(t *testing.T) {
+		client := newClient(t, old)
+		repo := dynamo.NewRepository(client, "invoices")
+
+		require.NoError(t, repo.ReplaceItems(ctx, inv.ID, []string{old.ID}, []invoice.Item{replacement}))
+		require.Len(t, client.transactions, 1)
+		transactItems := client.transactions[0].TransactItems
+		assert.Equal(t, "ITEM#"+old.ID, aws.StringValue(transactItems[0].Update.Key["sk"].S))
+		assert.Equal(t, "ITEM#"+replacement.ID, aws.StringValue(transactItems[1].Put.Item["sk"].S))
+
+		written := keys(transactItems)
+		assert.Contains(t, written, "INVOICE#"+inv.ID+"/INVOICE#"+inv.ID, "invoice totals")
+		seen := make(map[string]bool, len(written))
+		for _, key := range written {
+			assert.False(t, seen[key], "key %s written twice", key)
+			seen[key] = true
+		}
+	})
+
+	t.Run("rejects repeated old items without writing", func(t *testing.T) {
+		client := newClient(t, old)
+		repo := dynamo.NewRepository(client, "invoices")
+
+		err := repo.ReplaceItems(ctx, inv.ID, []string{old.ID, old.ID}, []invoice.Item{replacement})
+		assert.ErrorIs(t, err, invoice.ErrInvalidItemUpdate)
+		assert.Empty(t, client.transactions)
+	})
+
+	t.Run("rejects old items not in NEW status", func(t *testing.T) {
+		pending := old
+		pending.Status = invoice.Pending
+		client := newClient(t, pending)
+		repo := dynamo.NewRepository(client, "invoices")
+
+		err := repo.ReplaceItems(ctx, inv.ID, []string{old.ID}, []invoice.Item{replacement})
+		assert.ErrorIs(t, err, invoice.ErrItemConflict)
+		assert.Empty(t, client.transactions)
+	})
+
+	t.Run("fails when old item does not exist", func(t *testing.T) {
+		client := newClient(t)
+		repo := dynamo.NewRepository(client, "invoices")
+
+		err := repo.ReplaceItems(ctx, inv.ID, []string{old.ID}, nil)
+		assert.ErrorIs(t, err, invoice.ErrItemNotFound)
+		assert.Empty(t, client.transactions)
+	})
+}
+
+func TestAddPayment(t *testing.T) {
+	ctx := context.Background()
+	inv := invoice.Invoice{ID: uuid.NewString(), Status: invoice.New, Date: time.Now(), Total: 1000}
+	payment := invoice.Payment{ID: uuid.NewString(), InvoiceID: inv.ID, Amount: 400}
+
+	t.Run("conditions status on invoice version and retries conflicts", func(t *testing.T) {
+		client := &conflictClient{conflicts: 1}
+		row := dynamo.NewInvoice(inv)
+		row.Version = 7
+		client.addRow(t, row)
+		repo := dynamo.NewRepository(client, "invoices")
+
+		require.NoError(t, repo.AddPayment(ctx, payment))
+		require.Len(t, client.transactions, 2)
+
+		update := client.transactions[1].TransactItems[0].Update
+		require.NotNil(t, update)
+		var names []string
+		for _, name := range update.ExpressionAttributeNames {
+			names = append(names, aws.StringValue(name))
+		}
+		assert.Contains(t, names, "version")
+
+		var values []string
+		for _, value := range update.ExpressionAttributeValues {
+			values = append(values, aws.StringValue(value.S)+aws.StringValue(value.N))
+		}
+		assert.Contains(t, values, "7", "expected version")
+		assert.Contains(t, values, string(invoice.PartiallyPaid))
+	})
+
+	t.Run("gives up after retries", func(t *testing.T) {
+		client := &conflictClient{conflicts: 10}
+		client.addRow(t, dynamo.NewInvoice(inv))
+		repo := dynamo.NewRepository(client, "invoices")
+
+		err := repo.AddPayment(ctx, payment)
+		var canceled *dynamodb.TransactionCanceledException
+		assert.ErrorAs(t, err, &canceled)
+		assert.Len(t, client.transactions, 3)
+	})
+
+	t.Run("rejects cancelled invoice", func(t *testing.T) {
+		client := &conflictClient{}
+		cancelled := inv
+		cancelled.Status = invoice.Cancelled
+		client.addRow(t, dynamo.NewInvoice(cancelled))
+		repo := dynamo.NewRepository(client, "invoices")
+
+		err := repo.AddPayment(ctx, payment)
+		assert.ErrorIs(t, err, invoice.ErrInvoiceCancelled)
+		assert.Empty(t, client.transactions)
+	})
+}
+
+func TestAddCreditNote(t *testing.T) {
+	ctx := context.Background()
+	item := invoice.Item{ID: uuid.NewString(), InvoiceID: uuid.NewString(), Price: 100, Qty: 2, Version: 3}
+	cn := invoice.CreditNote{
+		ID:        uuid.NewString(),
+		InvoiceID: item.InvoiceID,
+		Lines:     []invoice.CreditNoteLine{{ItemID: item.ID, Qty: 1}},
+		Date:      time.Now(),
+	}
+
+	t.Run("writes item credits in the same transaction", func(t *testing.T) {
+		client := &conflictClient{conflicts: 1}
+		client.addRow(t, dynamo.NewItem(item))
+		repo := dynamo.NewRepository(client, "invoices")
+
+		stored, err := repo.AddCreditNote(ctx, cn)
+		require.NoError(t, err)
+		assert.Equal(t, uint(100), stored.Total())
+		require.Len(t, client.transactions, 2, "conflicts are retried")
+
+		update := client.transactions[1].TransactItems[2].Update
+		require.NotNil(t, update)
+		assert.Equal(t, "ITEM#"+item.ID, aws.StringValue(update.Key["sk"].S))
+
+		var names, values []string
+		for _, name := range update.ExpressionAttributeNames {
+			names = append(names, aws.StringValue(name))
+		}
+		for _, value := range update.ExpressionAttributeValues {
+			values = append(values, aws.StringValue(value.N))
+		}
+		assert.Subset(t, names, []string{"version", "creditedQty", "creditedAmount"})
+		assert.Subset(t, values, []string{"3", "1", "100"}, "item version and new credits")
+	})
+
+	t.Run("rejects credit of cancelled item", func(t *testing.T) {
+		cancelled := item
+		cancelled.Status = invoice.Cancelled
+		client := &conflictClient{}
+		client.addRow(t, dynamo.NewItem(cancelled))
+		repo := dynamo.NewRepository(client, "invoices")
+
+		_, err := repo.AddCreditNote(ctx, cn)
+		assert.ErrorIs(t, err, invoice.ErrItemCancelled)
+		assert.Empty(t, client.transactions)
+	})
+
+	t.Run("maps existing credit note to conflict", func(t *testing.T) {
+		client := &txClient{txErr: &dynamodb.TransactionCanceledException{
+			CancellationReasons: []*dynamodb.CancellationReason{
+				{Code: aws.String("None")},
+				{Code: aws.String("ConditionalCheckFailed")},
+				{Code: aws.String("None")},
+			},
+		}}
+		client.addRow(t, dynamo.NewItem(item))
+		repo := dynamo.NewRepository(client, "invoices")
+
+		_, err := repo.AddCreditNote(ctx, cn)
+		assert.ErrorIs(t, err, invoice.ErrCreditNoteConflict)
+		assert.Len(t, client.transactions, 1, "existing credit note is not retried")
+	})
+
+	t.Run("gives up on repeated conflicts", func(t *testing.T) {
+		client := &conflictClient{conflicts: 3}
+		client.addRow(t, dynamo.NewItem(item))
+		repo := dynamo.NewRepository(client, "invoices")
+
+		_, err := repo.AddCreditNote(ctx, cn)
+		assert.ErrorIs(t, err, invoice.ErrVersionConflict)
+		assert.Len(t, client.transactions, 3)
+	})
+}
+
+// batchClient pages scanned rows and records batch deletes. The first batch
+// write leaves one request unprocessed.
+type batchClient struct {
+	pagedClient
+	scans   []*dynamodb.ScanInput
+	deleted int
+	batches int
+}
+
+func (c *batchClient) ScanWithContext(ctx aws.Context, input *dynamodb.ScanInput, opts ...request.Option) (
+	*dynamodb.ScanOutput, error) {
+
+	c.scans = append(c.scans, input)
+	return c.pagedClient.ScanWithContext(ctx, input, opts...)
+}
+
+func (c *batchClient) BatchWriteItemWithContext(
+	_ aws.Context, input *dynamodb.BatchWriteItemInput, _ ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+
+	c.batches++
+	requests := input.RequestItems["invoices"]
+	if c.batches == 1 {
+		c.deleted += len(requests) - 1
+		unprocessed := map[string][]*dynamodb.WriteRequest{"invoices": requests[:1]}
+		return &dynamodb.BatchWriteItemOutput{UnprocessedItems: unprocessed}, nil
+	}
+	c.deleted += len(requests)
+	return &dynamodb.BatchWriteItemOutput{}, nil
+}
+
+func TestPurge(t *testing.T) {
+	t.Run("deletes matching rows in batches", func(t *testing.T) {
+		client := &batchClient{pagedClient: *newPagedClient(t, 30, 10)}
+		repo := dynamo.NewRepository(client, "invoices")
+
+		n, err := repo.Purge(context.Background(), "INVOICE#", dynamo.PurgeOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, 30, n)
+		assert.Equal(t, 30, client.deleted)
+		assert.Equal(t, 3, client.batches, "25 rows batch, its retry and 5 rows batch")
+
+		require.NotEmpty(t, client.scans)
+		assert.Contains(t, aws.StringValue(client.scans[0].FilterExpression), "begins_with")
+	})
+
+	t.Run("counts rows in dry run", func(t *testing.T) {
+		client := &batchClient{pagedClient: *newPagedClient(t, 30, 10)}
+		repo := dynamo.NewRepository(client, "invoices")
+
+		n, err := repo.Purge(context.Background(), "", dynamo.PurgeOptions{DryRun: true})
+		require.NoError(t, err)
+		assert.Equal(t, 30, n)
+		assert.Zero(t, client.batches)
+		assert.Nil(t, client.scans[0].FilterExpression)
+	})
+}
+
+func TestKeySchema(t *testing.T) {
+	inv := invoice.Invoice{
+		ID:     uuid.NewString(),
+		Status: invoice.New,
+		Items:  []invoice.Item{{ID: uuid.NewString(), Status: invoice.New}},
+	}
+	inv.Items[0].InvoiceID = inv.ID
+
+	client := &txClient{}
+	repo := dynamo.NewRepository(client, "invoices",
+		dynamo.WithKeyAttributes("PK", "SK"),
+		dynamo.WithKeySeparator("|"),
+		dynamo.WithKeyPrefixes(dynamo.KeyPrefixes{Item: "LINE"}),
+		dynamo.WithTenant("acme"))
+	require.NoError(t, repo.AddInvoice(context.Background(), inv))
+	require.Len(t, client.transactions, 1)
+
+	keys := repo.KeySchema()
+	assert.Equal(t, "PK", keys.PartitionKey)
+	assert.Equal(t, "INVOICE", keys.Prefixes.Invoice)
+
+	items := client.transactions[0].TransactItems
+	invoiceRow, itemRow, changeRow := items[0].Put.Item, items[1].Put.Item, items[2].Put.Item
+	for _, row := range []map[string]*dynamodb.AttributeValue{invoiceRow, itemRow, changeRow} {
+		assert.NotContains(t, row, "pk")
+		assert.NotContains(t, row, "sk")
+	}
+	assert.Equal(t, "TENANT|acme|INVOICE|"+inv.ID, aws.StringValue(invoiceRow["PK"].S))
+	assert.Equal(t, "INVOICE|"+inv.ID, aws.StringValue(invoiceRow["SK"].S))
+	assert.Equal(t, "TENANT|acme|INVOICE|"+inv.ID, aws.StringValue(itemRow["PK"].S))
+	assert.Equal(t, "LINE|"+inv.Items[0].ID, aws.StringValue(itemRow["SK"].S))
+	assert.Regexp(t, `^HISTORY\|`, aws.StringValue(changeRow["SK"].S))
+	assert.Equal(t, "attribute_not_exists(PK)", aws.StringValue(items[2].Put.ConditionExpression))
+
+	assert.True(t, keys.IsInvoiceRow(invoiceRow))
+	assert.True(t, keys.IsItemRow(itemRow))
+	assert.NoError(t, keys.ValidateRow(itemRow))
+	assert.False(t, dynamo.IsItemRow(itemRow))
+	assert.ErrorIs(t, dynamo.ValidateRow(itemRow), dynamo.ErrInvalidRow)
+
+	other := dynamo.KeySchema{PartitionKey: "PK", SortKey: "SK", Separator: "|", Tenant: "globex"}
+	assert.False(t, other.IsInvoiceRow(invoiceRow))
+}
+
+func TestTenantFromContext(t *testing.T) {
+	inv := invoice.Invoice{ID: uuid.NewString(), Status: invoice.New}
+
+	t.Run("adds tenant of context to keys", func(t *testing.T) {
+		client := &txClient{}
+		repo := dynamo.NewRepository(client, "invoices")
+
+		ctx := invoice.WithTenant(context.Background(), "acme")
+		require.NoError(t, repo.AddInvoice(ctx, inv))
+
+		row := client.transactions[0].TransactItems[0].Put.Item
+		assert.Equal(t, "TENANT#acme#INVOICE#"+inv.ID, aws.StringValue(row["pk"].S))
+		assert.False(t, dynamo.IsInvoiceRow(row), "rows without tenant exclude tenant rows")
+		assert.True(t, dynamo.KeySchema{Tenant: "acme"}.IsInvoiceRow(row))
+
+		event := dynamo.Event{}
+		err := dynamodbattribute.UnmarshalMap(client.transactions[0].TransactItems[2].Put.Item, &event)
+		require.NoError(t, err)
+		assert.Equal(t, string(invoice.InvoiceCreated), event.Type)
+		assert.Equal(t, "acme", event.Tenant, "events of the shared outbox carry tenant")
+	})
+
+	t.Run("rejects tenant containing key separator", func(t *testing.T) {
+		client := &txClient{}
+		repo := dynamo.NewRepository(client, "invoices", dynamo.WithKeySeparator("|"))
+
+		for _, tenant := range []string{"", "acme#x", "acme|x"} {
+			ctx := invoice.WithTenant(context.Background(), tenant)
+			assert.ErrorIs(t, repo.AddInvoice(ctx, inv), invoice.ErrInvalidTenant, "tenant %q", tenant)
+		}
+		assert.Empty(t, client.transactions)
+	})
+
+	t.Run("rejects context of other tenant", func(t *testing.T) {
+		client := &txClient{}
+		repo := dynamo.NewRepository(client, "invoices", dynamo.WithTenant("acme"))
+
+		ctx := invoice.WithTenant(context.Background(), "globex")
+		assert.ErrorIs(t, repo.AddInvoice(ctx, inv), invoice.ErrTenantMismatch)
+		_, err := repo.GetInvoice(ctx, inv.ID)
+		assert.ErrorIs(t, err, invoice.ErrTenantMismatch)
+		assert.Empty(t, client.transactions)
+	})
+}
+
+// readClient records consistency of reads.
+type readClient struct {
+	dynamodbiface.DynamoDBAPI
+	consistent []bool
+}
+
+func (c *readClient) GetItemWithContext(_ aws.Context, input *dynamodb.GetItemInput, _ ...request.Option) (
+	*dynamodb.GetItemOutput, error) {
+
+	c.consistent = append(c.consistent, aws.BoolValue(input.ConsistentRead))
+	return &dynamodb.GetItemOutput{}, nil
+}
+
+func (c *readClient) QueryWithContext(_ aws.Context, input *dynamodb.QueryInput, _ ...request.Option) (
+	*dynamodb.QueryOutput, error) {
+
+	c.consistent = append(c.consistent, aws.BoolValue(input.ConsistentRead))
+	return &dynamodb.QueryOutput{}, nil
+}
+
+func TestConsistentRead(t *testing.T) {
+	ctx := context.Background()
+	read := func(repo *dynamo.Repository, opts ...invoice.ReadOption) {
+		_, err := repo.GetInvoice(ctx, "1", opts...)
+		require.NoError(t, err)
+		_, err = repo.GetItem(ctx, "1", "2", opts...)
+		require.NoError(t, err)
+		_, err = repo.GetInvoiceItems(ctx, "1", opts...)
+		require.NoError(t, err)
+		_, err = repo.GetPayments(ctx, "1", opts...)
+		require.NoError(t, err)
+	}
+
+	t.Run("reads are eventually consistent by default", func(t *testing.T) {
+		client := &readClient{}
+		read(dynamo.NewRepository(client, "invoices"))
+		assert.Equal(t, []bool{false, false, false, false}, client.consistent)
+	})
+
+	t.Run("read option makes the read consistent", func(t *testing.T) {
+		client := &readClient{}
+		read(dynamo.NewRepository(client, "invoices"), invoice.ConsistentRead())
+		assert.Equal(t, []bool{true, true, true, true}, client.consistent)
+	})
+
+	t.Run("repository option makes all reads consistent", func(t *testing.T) {
+		client := &readClient{}
+		read(dynamo.NewRepository(client, "invoices", dynamo.WithConsistentRead()))
+		assert.Equal(t, []bool{true, true, true, true}, client.consistent)
+	})
+}
+
+// partitionClient serves every row of the queried partition, ignoring filters.
+type partitionClient struct {
+	txClient
+	queries []*dynamodb.QueryInput
+}
+
+func (c *partitionClient) QueryWithContext(_ aws.Context, input *dynamodb.QueryInput, _ ...request.Option) (
+	*dynamodb.QueryOutput, error) {
+
+	c.queries = append(c.queries, input)
+	out := &dynamodb.QueryOutput{}
+	for _, row := range c.rows {
+		if *row["pk"].S == *input.ExpressionAttributeValues[":0"].S {
+			out.Items = append(out.Items, row)
+		}
+	}
+	return out, nil
+}
+
+func TestGetInvoice(t *testing.T) {
+	ctx := context.Background()
+	inv := invoice.Invoice{ID: uuid.NewString(), Date: time.Now().UTC().Truncate(24 * time.Hour)}
+	items := []invoice.Item{
+		{ID: "1", InvoiceID: inv.ID, Status: invoice.New},
+		{ID: "2", InvoiceID: inv.ID, Status: invoice.Cancelled},
+	}
+
+	client := &partitionClient{}
+	client.addRow(t, dynamo.NewInvoice(inv))
+	for _, item := range items {
+		client.addRow(t, dynamo.NewItem(item))
+	}
+	client.addRow(t, dynamo.NewPayment(invoice.Payment{ID: "3", InvoiceID: inv.ID, ReceivedAt: time.Now()}))
+	client.addRow(t, dynamo.NewItem(invoice.Item{ID: "4", InvoiceID: uuid.NewString()}))
+
+	repo := dynamo.NewRepository(client, "invoices")
+
+	got, err := repo.GetInvoice(ctx, inv.ID)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, inv.ID, got.ID)
+	assert.Equal(t, items, got.Items)
+	require.Len(t, client.queries, 1, "invoice and items are read in one query")
+	assert.NotNil(t, client.queries[0].FilterExpression)
+	assert.Contains(t, aws.StringValue(client.queries[0].KeyConditionExpression), "BETWEEN")
+
+	var bounds []string
+	for _, value := range client.queries[0].ExpressionAttributeValues {
+		bounds = append(bounds, aws.StringValue(value.S))
+	}
+	assert.Contains(t, bounds, "INVOICE#"+inv.ID, "range starts at the invoice row")
+	assert.Contains(t, bounds, "ITEM#"+string(utf8.MaxRune), "range ends after the items")
+
+	got, err = repo.GetInvoice(ctx, uuid.NewString())
+	require.NoError(t, err)
+	assert.Nil(t, got)
+}
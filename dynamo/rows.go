@@ -0,0 +1,57 @@
+package dynamo
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// ErrInvalidRow is returned for raw rows that do not follow the key
+// conventions of invoices and items.
+var ErrInvalidRow = errors.New("invalid row")
+
+// ValidateRow checks that the raw row is an invoice or an item and that its
+// keys are built from its attributes in the default key layout.
+func ValidateRow(row map[string]*dynamodb.AttributeValue) error {
+	return KeySchema{}.ValidateRow(row)
+}
+
+// ValidateRow checks that the raw row is an invoice or an item and that its
+// keys are built from its attributes in the key layout.
+func (s KeySchema) ValidateRow(row map[string]*dynamodb.AttributeValue) error {
+	s = s.normalized()
+	pk, sk := s.keyValue(row, s.PartitionKey), s.keyValue(row, s.SortKey)
+	switch {
+	case s.IsInvoiceRow(row):
+		var inv Invoice
+		if err := dynamodbattribute.UnmarshalMap(row, &inv); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidRow, err)
+		}
+		if inv.ID == "" {
+			return fmt.Errorf("%w: invoice id is empty", ErrInvalidRow)
+		}
+		if pk != s.invoicePartitionKey(inv.ID) || sk != s.invoiceSortKey(inv.ID) {
+			return fmt.Errorf("%w: keys %s, %s do not match invoice %s", ErrInvalidRow, pk, sk, inv.ID)
+		}
+		if _, err := inv.ToInvoice(); err != nil {
+			return fmt.Errorf("%w: invoice %s: %v", ErrInvalidRow, inv.ID, err)
+		}
+	case s.IsItemRow(row):
+		var item Item
+		if err := dynamodbattribute.UnmarshalMap(row, &item); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidRow, err)
+		}
+		if item.ID == "" || item.InvoiceID == "" {
+			return fmt.Errorf("%w: item id or invoice id is empty", ErrInvalidRow)
+		}
+		if pk != s.itemPartitionKey(item.InvoiceID) || sk != s.itemSortKey(item.ID) {
+			return fmt.Errorf("%w: keys %s, %s do not match item %s of invoice %s",
+				ErrInvalidRow, pk, sk, item.ID, item.InvoiceID)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported sort key %q", ErrInvalidRow, sk)
+	}
+	return nil
+}
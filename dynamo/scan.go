@@ -0,0 +1,191 @@
+package dynamo
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/antklim/go-dynamodb/invoice"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// ScanOptions configures parallel scan of the table.
+type ScanOptions struct {
+	Workers      int     // number of segments scanned in parallel, defaults to 1
+	PageSize     int64   // maximum number of rows evaluated per request, 0 means DynamoDB default
+	ReadCapacity float64 // maximum read capacity units consumed per second by all workers, 0 means no limit
+
+	IncludeDeleted bool // ParallelScanItems also returns deleted items
+}
+
+// ScanFunc is called for every scanned row. It is called concurrently by
+// scan workers. Returning an error stops the scan.
+type ScanFunc func(ctx context.Context, row map[string]*dynamodb.AttributeValue) error
+
+// ParallelScan scans the whole table using opts.Workers segments in parallel
+// and calls fn for every row. It returns the first error returned by fn or
+// DynamoDB, or the context error when ctx is cancelled.
+func (r *Repository) ParallelScan(ctx context.Context, opts ScanOptions, fn ScanFunc) error {
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	var limiter *capacityLimiter
+	if opts.ReadCapacity > 0 {
+		limiter = newCapacityLimiter(opts.ReadCapacity)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+
+	for segment := 0; segment < workers; segment++ {
+		input := &dynamodb.ScanInput{TableName: r.table}
+		if workers > 1 {
+			input.Segment = aws.Int64(int64(segment))
+			input.TotalSegments = aws.Int64(int64(workers))
+		}
+		if opts.PageSize > 0 {
+			input.Limit = aws.Int64(opts.PageSize)
+		}
+		if limiter != nil {
+			input.ReturnConsumedCapacity = aws.String(dynamodb.ReturnConsumedCapacityTotal)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := r.scanSegment(ctx, input, limiter, fn); err != nil {
+				errOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}()
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
+// ParallelScanItems scans the whole table in parallel and calls fn for every
+// invoice item. Other rows and deleted items are skipped.
+func (r *Repository) ParallelScanItems(
+	ctx context.Context, opts ScanOptions, fn func(context.Context, invoice.Item) error) error {
+
+	r, err := r.scoped(ctx)
+	if err != nil {
+		return err
+	}
+
+	return r.ParallelScan(ctx, opts, func(ctx context.Context, row map[string]*dynamodb.AttributeValue) error {
+		if !r.keys.IsItemRow(row) {
+			return nil
+		}
+
+		item, err := toItem(row)
+		if err != nil {
+			return err
+		}
+
+		if item.Deleted() && !opts.IncludeDeleted {
+			return nil
+		}
+
+		return fn(ctx, *item)
+	})
+}
+
+func (r *Repository) scanSegment(
+	ctx context.Context, input *dynamodb.ScanInput, limiter *capacityLimiter, fn ScanFunc) error {
+
+	for {
+		if err := limiter.wait(ctx); err != nil {
+			return err
+		}
+
+		result, err := r.client.ScanWithContext(ctx, input)
+		if err != nil {
+			return err
+		}
+
+		if result.ConsumedCapacity != nil {
+			limiter.consume(aws.Float64Value(result.ConsumedCapacity.CapacityUnits))
+		}
+
+		for _, row := range result.Items {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(ctx, row); err != nil {
+				return err
+			}
+		}
+
+		if result.LastEvaluatedKey == nil {
+			return nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+// capacityLimiter paces requests of several workers so that consumed
+// capacity units do not exceed the configured rate per second. A nil
+// limiter does not limit anything.
+type capacityLimiter struct {
+	mu   sync.Mutex
+	rate float64   // capacity units per second
+	next time.Time // time when the next request is allowed
+}
+
+func newCapacityLimiter(rate float64) *capacityLimiter {
+	return &capacityLimiter{rate: rate}
+}
+
+// wait blocks until the next request is allowed or ctx is done.
+func (l *capacityLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+
+	l.mu.Lock()
+	d := time.Until(l.next)
+	l.mu.Unlock()
+
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// consume records consumed capacity units and delays subsequent requests.
+func (l *capacityLimiter) consume(units float64) {
+	if l == nil || units <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(units / l.rate * float64(time.Second)))
+}
@@ -0,0 +1,265 @@
+// Package stream consumes DynamoDB Streams records of the invoices table and
+// dispatches typed invoice and item changes to handlers.
+package stream
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/antklim/go-dynamodb/dynamo"
+	"github.com/antklim/go-dynamodb/invoice"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+)
+
+const defaultInterval = time.Second
+
+// ChangeType is the type of the table change.
+type ChangeType string
+
+const (
+	Insert ChangeType = dynamodbstreams.OperationTypeInsert
+	Modify ChangeType = dynamodbstreams.OperationTypeModify
+	Remove ChangeType = dynamodbstreams.OperationTypeRemove
+)
+
+// InvoiceChange describes a change of invoice row. Old is nil for inserted
+// invoices, New is nil for removed invoices.
+type InvoiceChange struct {
+	Type           ChangeType
+	SequenceNumber string
+	Old            *invoice.Invoice
+	New            *invoice.Invoice
+}
+
+// ItemChange describes a change of invoice item row. Old is nil for inserted
+// items, New is nil for removed items.
+type ItemChange struct {
+	Type           ChangeType
+	SequenceNumber string
+	Old            *invoice.Item
+	New            *invoice.Item
+}
+
+// Handler projects changes into read models.
+type Handler interface {
+	HandleInvoice(context.Context, InvoiceChange) error
+	HandleItem(context.Context, ItemChange) error
+}
+
+// Checkpointer stores sequence number of the last processed record per shard.
+type Checkpointer interface {
+	Checkpoint(ctx context.Context, shardID string) (string, error) // returns empty string when shard was not processed
+	SaveCheckpoint(ctx context.Context, shardID, sequenceNumber string) error
+}
+
+// MemoryCheckpointer keeps checkpoints in memory.
+type MemoryCheckpointer struct {
+	mu          sync.RWMutex
+	checkpoints map[string]string
+}
+
+// NewMemoryCheckpointer creates in memory checkpointer
+func NewMemoryCheckpointer() *MemoryCheckpointer {
+	return &MemoryCheckpointer{checkpoints: make(map[string]string)}
+}
+
+func (c *MemoryCheckpointer) Checkpoint(ctx context.Context, shardID string) (string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.checkpoints[shardID], nil
+}
+
+func (c *MemoryCheckpointer) SaveCheckpoint(ctx context.Context, shardID, sequenceNumber string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checkpoints[shardID] = sequenceNumber
+	return nil
+}
+
+// Option configures processor.
+type Option func(*Processor)
+
+// WithInterval sets the polling interval of Run.
+func WithInterval(d time.Duration) Option {
+	return func(p *Processor) {
+		p.interval = d
+	}
+}
+
+// WithErrorHandler sets the function called with errors of Run iterations.
+func WithErrorHandler(f func(error)) Option {
+	return func(p *Processor) {
+		p.onError = f
+	}
+}
+
+// WithKeySchema sets the key layout of the table, it must match the layout
+// of the repository writing the table. Defaults to the default layout.
+func WithKeySchema(keys dynamo.KeySchema) Option {
+	return func(p *Processor) {
+		p.keys = keys
+	}
+}
+
+// Processor reads stream records, decodes invoice and item images and
+// dispatches them to the handler. Records of other rows are skipped.
+// The checkpoint of a shard moves forward only after the record was handled,
+// so a record may be handled more than once after failures.
+type Processor struct {
+	src      Source
+	cp       Checkpointer
+	h        Handler
+	interval time.Duration
+	onError  func(error)
+	keys     dynamo.KeySchema
+}
+
+// NewProcessor creates a new instance of stream processor
+func NewProcessor(src Source, cp Checkpointer, h Handler, opts ...Option) *Processor {
+	p := &Processor{
+		src:      src,
+		cp:       cp,
+		h:        h,
+		interval: defaultInterval,
+		onError:  func(error) {},
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// Process reads new records of all shards once and dispatches them. It stops
+// on the first failed record, the record is retried by the next call. It
+// returns the number of processed records.
+func (p *Processor) Process(ctx context.Context) (int, error) {
+	shardIDs, err := p.src.Shards(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	processed := 0
+	for _, shardID := range shardIDs {
+		n, err := p.processShard(ctx, shardID)
+		processed += n
+		if err != nil {
+			return processed, err
+		}
+	}
+
+	return processed, nil
+}
+
+// Run processes the stream every interval until ctx is done. Failures are
+// reported to the error handler and retried on the next tick.
+func (p *Processor) Run(ctx context.Context) error {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := p.Process(ctx); err != nil && ctx.Err() == nil {
+			p.onError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Processor) processShard(ctx context.Context, shardID string) (int, error) {
+	after, err := p.cp.Checkpoint(ctx, shardID)
+	if err != nil {
+		return 0, err
+	}
+
+	records, err := p.src.Records(ctx, shardID, after)
+	if err != nil {
+		return 0, err
+	}
+
+	for idx, record := range records {
+		if err := p.dispatch(ctx, record); err != nil {
+			return idx, err
+		}
+
+		seq := aws.StringValue(record.Dynamodb.SequenceNumber)
+		if err := p.cp.SaveCheckpoint(ctx, shardID, seq); err != nil {
+			return idx, err
+		}
+	}
+
+	return len(records), nil
+}
+
+func (p *Processor) dispatch(ctx context.Context, record *dynamodbstreams.Record) error {
+	if record.Dynamodb == nil {
+		return nil
+	}
+
+	changeType := ChangeType(aws.StringValue(record.EventName))
+	seq := aws.StringValue(record.Dynamodb.SequenceNumber)
+	keys := record.Dynamodb.Keys
+
+	switch {
+	case p.keys.IsInvoiceRow(keys):
+		change := InvoiceChange{Type: changeType, SequenceNumber: seq}
+		var err error
+		if change.Old, err = decodeInvoice(record.Dynamodb.OldImage); err != nil {
+			return err
+		}
+		if change.New, err = decodeInvoice(record.Dynamodb.NewImage); err != nil {
+			return err
+		}
+		return p.h.HandleInvoice(ctx, change)
+	case p.keys.IsItemRow(keys):
+		change := ItemChange{Type: changeType, SequenceNumber: seq}
+		var err error
+		if change.Old, err = decodeItem(record.Dynamodb.OldImage); err != nil {
+			return err
+		}
+		if change.New, err = decodeItem(record.Dynamodb.NewImage); err != nil {
+			return err
+		}
+		return p.h.HandleItem(ctx, change)
+	}
+
+	return nil
+}
+
+func decodeInvoice(image map[string]*dynamodb.AttributeValue) (*invoice.Invoice, error) {
+	if len(image) == 0 {
+		return nil, nil
+	}
+
+	dbInvoice := dynamo.Invoice{}
+	if err := dynamodbattribute.UnmarshalMap(image, &dbInvoice); err != nil {
+		return nil, err
+	}
+
+	return dbInvoice.ToInvoice()
+}
+
+func decodeItem(image map[string]*dynamodb.AttributeValue) (*invoice.Item, error) {
+	if len(image) == 0 {
+		return nil, nil
+	}
+
+	dbItem := dynamo.Item{}
+	if err := dynamodbattribute.UnmarshalMap(image, &dbItem); err != nil {
+		return nil, err
+	}
+
+	item := dbItem.ToItem()
+	return &item, nil
+}
@@ -0,0 +1,146 @@
+package stream_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/antklim/go-dynamodb/dynamo"
+	"github.com/antklim/go-dynamodb/dynamo/stream"
+	"github.com/antklim/go-dynamodb/invoice"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSource serves records of a single shard.
+type fakeSource struct {
+	records []*dynamodbstreams.Record
+}
+
+func (s *fakeSource) Shards(context.Context) ([]string, error) {
+	return []string{"shard-1"}, nil
+}
+
+func (s *fakeSource) Records(_ context.Context, _, after string) ([]*dynamodbstreams.Record, error) {
+	for idx, record := range s.records {
+		if aws.StringValue(record.Dynamodb.SequenceNumber) == after {
+			return s.records[idx+1:], nil
+		}
+	}
+	return s.records, nil
+}
+
+func (s *fakeSource) add(t *testing.T, eventName string, oldImage, newImage interface{}) {
+	record := &dynamodbstreams.Record{
+		EventName: aws.String(eventName),
+		Dynamodb: &dynamodbstreams.StreamRecord{
+			SequenceNumber: aws.String(strconv.Itoa(len(s.records) + 1)),
+			OldImage:       marshal(t, oldImage),
+			NewImage:       marshal(t, newImage),
+		},
+	}
+
+	record.Dynamodb.Keys = record.Dynamodb.NewImage
+	if record.Dynamodb.Keys == nil {
+		record.Dynamodb.Keys = record.Dynamodb.OldImage
+	}
+	s.records = append(s.records, record)
+}
+
+func marshal(t *testing.T, image interface{}) map[string]*dynamodb.AttributeValue {
+	if image == nil {
+		return nil
+	}
+	raw, err := dynamodbattribute.MarshalMap(image)
+	require.NoError(t, err)
+	return raw
+}
+
+type recordingHandler struct {
+	invoices []stream.InvoiceChange
+	items    []stream.ItemChange
+	err      error
+}
+
+func (h *recordingHandler) HandleInvoice(_ context.Context, change stream.InvoiceChange) error {
+	if h.err != nil {
+		return h.err
+	}
+	h.invoices = append(h.invoices, change)
+	return nil
+}
+
+func (h *recordingHandler) HandleItem(_ context.Context, change stream.ItemChange) error {
+	if h.err != nil {
+		return h.err
+	}
+	h.items = append(h.items, change)
+	return nil
+}
+
+func TestProcessor(t *testing.T) {
+	ctx := context.Background()
+	inv := invoice.Invoice{ID: uuid.NewString(), Status: invoice.New, Date: time.Now()}
+	item := invoice.Item{ID: uuid.NewString(), InvoiceID: inv.ID, Status: invoice.New}
+	cancelled := item
+	cancelled.Status = invoice.Cancelled
+
+	src := &fakeSource{}
+	src.add(t, dynamodbstreams.OperationTypeInsert, nil, dynamo.NewInvoice(inv))
+	src.add(t, dynamodbstreams.OperationTypeInsert, nil, dynamo.NewItem(item))
+	src.add(t, dynamodbstreams.OperationTypeInsert, nil, dynamo.NewChange(invoice.Change{ID: "1", InvoiceID: inv.ID}))
+
+	cp := stream.NewMemoryCheckpointer()
+	h := &recordingHandler{}
+	p := stream.NewProcessor(src, cp, h)
+
+	t.Run("dispatches typed changes", func(t *testing.T) {
+		n, err := p.Process(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+
+		require.Len(t, h.invoices, 1)
+		assert.Equal(t, stream.Insert, h.invoices[0].Type)
+		assert.Nil(t, h.invoices[0].Old)
+		assert.Equal(t, inv.ID, h.invoices[0].New.ID)
+
+		require.Len(t, h.items, 1)
+		assert.Equal(t, item.ID, h.items[0].New.ID)
+
+		seq, err := cp.Checkpoint(ctx, "shard-1")
+		require.NoError(t, err)
+		assert.Equal(t, "3", seq)
+	})
+
+	t.Run("keeps checkpoint when handler fails", func(t *testing.T) {
+		src.add(t, dynamodbstreams.OperationTypeModify, dynamo.NewItem(item), dynamo.NewItem(cancelled))
+		h.err = errors.New("handler failed")
+
+		_, err := p.Process(ctx)
+		assert.ErrorIs(t, err, h.err)
+
+		seq, err := cp.Checkpoint(ctx, "shard-1")
+		require.NoError(t, err)
+		assert.Equal(t, "3", seq)
+	})
+
+	t.Run("resumes from checkpoint", func(t *testing.T) {
+		h.err = nil
+
+		n, err := p.Process(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		require.Len(t, h.items, 2)
+		assert.Equal(t, stream.Modify, h.items[1].Type)
+		assert.Equal(t, invoice.New, h.items[1].Old.Status)
+		assert.Equal(t, invoice.Cancelled, h.items[1].New.Status)
+	})
+}
@@ -0,0 +1,126 @@
+package stream
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams/dynamodbstreamsiface"
+)
+
+// maxEmptyPages is the number of empty pages of an open shard that Records
+// follows before it considers the shard caught up. Shards of DynamoDB Streams
+// return empty pages before the records that were trimmed or not yet written.
+const maxEmptyPages = 10
+
+// Source provides records of a table stream. StreamsSource reads them from
+// DynamoDB Streams, tests can drive the processor with a local fake.
+type Source interface {
+	// Shards returns IDs of the stream shards, parent shards go before their children.
+	Shards(context.Context) ([]string, error)
+	// Records returns records of the shard that follow the sequence number,
+	// or records from the beginning of the shard when sequence number is empty.
+	Records(ctx context.Context, shardID, afterSequenceNumber string) ([]*dynamodbstreams.Record, error)
+}
+
+// StreamsSource reads records from DynamoDB Streams.
+type StreamsSource struct {
+	client    dynamodbstreamsiface.DynamoDBStreamsAPI
+	streamARN *string
+}
+
+// NewStreamsSource creates a new instance of DynamoDB Streams source
+func NewStreamsSource(client dynamodbstreamsiface.DynamoDBStreamsAPI, streamARN string) *StreamsSource {
+	return &StreamsSource{client: client, streamARN: aws.String(streamARN)}
+}
+
+// Shards returns IDs of the stream shards. DescribeStream does not order
+// shards, so they are sorted to have parent shards before their children.
+func (s *StreamsSource) Shards(ctx context.Context) ([]string, error) {
+	input := &dynamodbstreams.DescribeStreamInput{StreamArn: s.streamARN}
+
+	var shards []*dynamodbstreams.Shard
+	for {
+		result, err := s.client.DescribeStreamWithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+
+		shards = append(shards, result.StreamDescription.Shards...)
+
+		if result.StreamDescription.LastEvaluatedShardId == nil {
+			return parentsFirst(shards), nil
+		}
+		input.ExclusiveStartShardId = result.StreamDescription.LastEvaluatedShardId
+	}
+}
+
+// parentsFirst returns IDs of shards ordered so that every shard goes after
+// its parent. Shards whose parent is not listed, e.g. trimmed, keep their
+// relative order.
+func parentsFirst(shards []*dynamodbstreams.Shard) []string {
+	parents := make(map[string]string, len(shards))
+	for _, shard := range shards {
+		parents[aws.StringValue(shard.ShardId)] = aws.StringValue(shard.ParentShardId)
+	}
+
+	shardIDs := make([]string, 0, len(shards))
+	added := make(map[string]bool, len(shards))
+	var add func(shardID string)
+	add = func(shardID string) {
+		if added[shardID] {
+			return
+		}
+		added[shardID] = true
+		if parent, ok := parents[shardID]; ok && parent != "" {
+			if _, listed := parents[parent]; listed {
+				add(parent)
+			}
+		}
+		shardIDs = append(shardIDs, shardID)
+	}
+
+	for _, shard := range shards {
+		add(aws.StringValue(shard.ShardId))
+	}
+	return shardIDs
+}
+
+// Records follows shard iterators until a page has records. It returns no
+// records when the shard is closed and read to the end, or when the shard
+// returned maxEmptyPages empty pages in a row.
+func (s *StreamsSource) Records(
+	ctx context.Context, shardID, afterSequenceNumber string) ([]*dynamodbstreams.Record, error) {
+
+	iterInput := &dynamodbstreams.GetShardIteratorInput{
+		StreamArn:         s.streamARN,
+		ShardId:           aws.String(shardID),
+		ShardIteratorType: aws.String(dynamodbstreams.ShardIteratorTypeTrimHorizon),
+	}
+	if afterSequenceNumber != "" {
+		iterInput.ShardIteratorType = aws.String(dynamodbstreams.ShardIteratorTypeAfterSequenceNumber)
+		iterInput.SequenceNumber = aws.String(afterSequenceNumber)
+	}
+
+	iter, err := s.client.GetShardIteratorWithContext(ctx, iterInput)
+	if err != nil {
+		return nil, err
+	}
+
+	shardIterator := iter.ShardIterator
+	for page := 0; shardIterator != nil && page < maxEmptyPages; page++ {
+		result, err := s.client.GetRecordsWithContext(ctx, &dynamodbstreams.GetRecordsInput{
+			ShardIterator: shardIterator,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if len(result.Records) > 0 {
+			return result.Records, nil
+		}
+		shardIterator = result.NextShardIterator
+	}
+
+	return nil, nil
+}
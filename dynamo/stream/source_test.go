@@ -0,0 +1,105 @@
+package stream_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/antklim/go-dynamodb/dynamo/stream"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams/dynamodbstreamsiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// streamsClient serves shards in the given order and pages of records, the
+// shard iterator is the index of the page.
+type streamsClient struct {
+	dynamodbstreamsiface.DynamoDBStreamsAPI
+	shards []*dynamodbstreams.Shard
+	pages  [][]*dynamodbstreams.Record
+	open   bool // open shards return the next iterator after the last page
+	calls  int
+}
+
+func (c *streamsClient) DescribeStreamWithContext(
+	aws.Context, *dynamodbstreams.DescribeStreamInput, ...request.Option) (*dynamodbstreams.DescribeStreamOutput, error) {
+
+	return &dynamodbstreams.DescribeStreamOutput{
+		StreamDescription: &dynamodbstreams.StreamDescription{Shards: c.shards},
+	}, nil
+}
+
+func (c *streamsClient) GetShardIteratorWithContext(
+	aws.Context, *dynamodbstreams.GetShardIteratorInput, ...request.Option) (
+	*dynamodbstreams.GetShardIteratorOutput, error) {
+
+	return &dynamodbstreams.GetShardIteratorOutput{ShardIterator: aws.String("0")}, nil
+}
+
+func (c *streamsClient) GetRecordsWithContext(
+	_ aws.Context, input *dynamodbstreams.GetRecordsInput, _ ...request.Option) (
+	*dynamodbstreams.GetRecordsOutput, error) {
+
+	c.calls++
+	page, err := strconv.Atoi(aws.StringValue(input.ShardIterator))
+	if err != nil {
+		return nil, err
+	}
+
+	out := &dynamodbstreams.GetRecordsOutput{}
+	if page < len(c.pages) {
+		out.Records = c.pages[page]
+	}
+	if page+1 < len(c.pages) || c.open {
+		out.NextShardIterator = aws.String(strconv.Itoa(page + 1))
+	}
+	return out, nil
+}
+
+func TestStreamsSource(t *testing.T) {
+	ctx := context.Background()
+	record := &dynamodbstreams.Record{Dynamodb: &dynamodbstreams.StreamRecord{SequenceNumber: aws.String("1")}}
+
+	t.Run("orders parent shards before children", func(t *testing.T) {
+		client := &streamsClient{shards: []*dynamodbstreams.Shard{
+			{ShardId: aws.String("grandchild"), ParentShardId: aws.String("child")},
+			{ShardId: aws.String("child"), ParentShardId: aws.String("parent")},
+			{ShardId: aws.String("orphan"), ParentShardId: aws.String("trimmed")},
+			{ShardId: aws.String("parent")},
+		}}
+
+		shardIDs, err := stream.NewStreamsSource(client, "arn").Shards(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"parent", "child", "grandchild", "orphan"}, shardIDs)
+	})
+
+	t.Run("follows iterators past empty pages", func(t *testing.T) {
+		client := &streamsClient{pages: [][]*dynamodbstreams.Record{nil, nil, {record}}}
+
+		records, err := stream.NewStreamsSource(client, "arn").Records(ctx, "shard-1", "")
+		require.NoError(t, err)
+		assert.Equal(t, []*dynamodbstreams.Record{record}, records)
+		assert.Equal(t, 3, client.calls)
+	})
+
+	t.Run("stops at the end of closed shard", func(t *testing.T) {
+		client := &streamsClient{pages: [][]*dynamodbstreams.Record{nil, nil}}
+
+		records, err := stream.NewStreamsSource(client, "arn").Records(ctx, "shard-1", "")
+		require.NoError(t, err)
+		assert.Empty(t, records)
+		assert.Equal(t, 2, client.calls)
+	})
+
+	t.Run("stops when open shard is caught up", func(t *testing.T) {
+		client := &streamsClient{open: true}
+
+		records, err := stream.NewStreamsSource(client, "arn").Records(ctx, "shard-1", "")
+		require.NoError(t, err)
+		assert.Empty(t, records)
+		assert.Positive(t, client.calls)
+	})
+}
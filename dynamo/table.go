@@ -0,0 +1,415 @@
+package dynamo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const tablePollInterval = time.Second
+
+// ErrSchemaMismatch is returned by EnsureTable when the existing table
+// differs from the schema in a way that cannot be updated, e.g. keys.
+var ErrSchemaMismatch = errors.New("table does not match schema")
+
+// TableSchema describes the table provisioned by EnsureTable. All keys are
+// string attributes.
+type TableSchema struct {
+	Name          string
+	PartitionKey  string
+	SortKey       string
+	GlobalIndexes []IndexSchema
+	// BillingMode is dynamodb.BillingModeProvisioned, which requires
+	// Capacity, or dynamodb.BillingModePayPerRequest.
+	BillingMode string
+	Capacity    Capacity
+	// StreamViewType enables the table stream, e.g.
+	// dynamodb.StreamViewTypeNewAndOldImages. Empty disables it.
+	StreamViewType string
+	TTLAttribute   string            // attribute with expiry time, empty disables TTL
+	Tags           map[string]string // set when the table is created
+}
+
+// IndexSchema describes a global secondary index projecting all attributes.
+type IndexSchema struct {
+	Name         string
+	PartitionKey string
+	SortKey      string
+	Capacity     Capacity // provisioned billing mode only
+}
+
+// Capacity is provisioned throughput of a table or an index.
+type Capacity struct {
+	Read  int64
+	Write int64
+}
+
+// DefaultSchema returns the schema of the invoices table used by
+// Repository with default key attributes: pk and sk keys, stream of new and
+// old images for the outbox and TTL on expiresAt for WithRetention. See
+// Repository.TableSchema for repositories with other key attributes.
+func DefaultSchema(table string) TableSchema {
+	return TableSchema{
+		Name:           table,
+		PartitionKey:   "pk",
+		SortKey:        "sk",
+		BillingMode:    dynamodb.BillingModeProvisioned,
+		Capacity:       Capacity{Read: 5, Write: 5},
+		StreamViewType: dynamodb.StreamViewTypeNewAndOldImages,
+		TTLAttribute:   "expiresAt",
+	}
+}
+
+// TableSchema returns DefaultSchema of the repository table with the key
+// attributes of the repository, see WithKeyAttributes.
+func (r *Repository) TableSchema() TableSchema {
+	schema := DefaultSchema(aws.StringValue(r.table))
+	schema.PartitionKey = r.keys.PartitionKey
+	schema.SortKey = r.keys.SortKey
+	return schema
+}
+
+// EnsureTable creates the table or updates the existing one to match the
+// schema, and waits until the table and its indexes are active. Billing
+// mode, throughput and stream are updated, missing indexes are created one
+// at a time. Indexes absent from the schema are kept. Keys and indexes are
+// never changed, ErrSchemaMismatch is returned when they differ.
+func EnsureTable(ctx context.Context, client dynamodbiface.DynamoDBAPI, schema TableSchema) error {
+	desc, err := describeTable(ctx, client, schema.Name)
+	if err != nil {
+		return err
+	}
+
+	if desc == nil {
+		if _, err := client.CreateTableWithContext(ctx, createTableInput(schema)); err != nil {
+			return err
+		}
+	} else if err := checkKeys(desc, schema); err != nil {
+		return err
+	}
+
+	// Every update changes one thing, which is the most DynamoDB allows.
+	maxUpdates := len(schema.GlobalIndexes) + 3
+	for n := 0; ; n++ {
+		desc, err := waitTableActive(ctx, client, schema.Name)
+		if err != nil {
+			return err
+		}
+
+		input := nextTableUpdate(desc, schema)
+		if input == nil {
+			break
+		}
+		if n == maxUpdates {
+			return fmt.Errorf("%w: table %s is not updated", ErrSchemaMismatch, schema.Name)
+		}
+		if _, err := client.UpdateTableWithContext(ctx, input); err != nil {
+			return err
+		}
+	}
+
+	return ensureTTL(ctx, client, schema)
+}
+
+// describeTable returns nil description when the table does not exist.
+func describeTable(ctx context.Context, client dynamodbiface.DynamoDBAPI, table string) (
+	*dynamodb.TableDescription, error) {
+
+	out, err := client.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)})
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out.Table, nil
+}
+
+// waitTableActive polls the table until it and all its indexes are active.
+func waitTableActive(ctx context.Context, client dynamodbiface.DynamoDBAPI, table string) (
+	*dynamodb.TableDescription, error) {
+
+	for {
+		desc, err := describeTable(ctx, client, table)
+		if err != nil {
+			return nil, err
+		}
+		if desc != nil && tableActive(desc) {
+			return desc, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(tablePollInterval):
+		}
+	}
+}
+
+func tableActive(desc *dynamodb.TableDescription) bool {
+	if aws.StringValue(desc.TableStatus) != dynamodb.TableStatusActive {
+		return false
+	}
+	for _, idx := range desc.GlobalSecondaryIndexes {
+		if aws.StringValue(idx.IndexStatus) != dynamodb.IndexStatusActive {
+			return false
+		}
+	}
+	return true
+}
+
+func createTableInput(schema TableSchema) *dynamodb.CreateTableInput {
+	input := &dynamodb.CreateTableInput{
+		TableName:             aws.String(schema.Name),
+		AttributeDefinitions:  attributeDefinitions(schema, schema.GlobalIndexes),
+		KeySchema:             keySchema(schema.PartitionKey, schema.SortKey),
+		BillingMode:           aws.String(schema.BillingMode),
+		ProvisionedThroughput: provisionedThroughput(schema, schema.Capacity),
+	}
+
+	for _, idx := range schema.GlobalIndexes {
+		input.GlobalSecondaryIndexes = append(input.GlobalSecondaryIndexes, globalIndex(schema, idx))
+	}
+
+	if schema.StreamViewType != "" {
+		input.StreamSpecification = &dynamodb.StreamSpecification{
+			StreamEnabled:  aws.Bool(true),
+			StreamViewType: aws.String(schema.StreamViewType),
+		}
+	}
+
+	keys := make([]string, 0, len(schema.Tags))
+	for k := range schema.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		input.Tags = append(input.Tags, &dynamodb.Tag{Key: aws.String(k), Value: aws.String(schema.Tags[k])})
+	}
+
+	return input
+}
+
+// nextTableUpdate returns the first update bringing the table closer to the
+// schema, or nil when the table matches it.
+func nextTableUpdate(desc *dynamodb.TableDescription, schema TableSchema) *dynamodb.UpdateTableInput {
+	table := aws.String(schema.Name)
+
+	if billingMode(desc) != schema.BillingMode || throughputChanged(desc, schema) {
+		input := &dynamodb.UpdateTableInput{
+			TableName:             table,
+			BillingMode:           aws.String(schema.BillingMode),
+			ProvisionedThroughput: provisionedThroughput(schema, schema.Capacity),
+		}
+		// Switching to provisioned mode requires throughput of existing indexes.
+		for _, idx := range schema.GlobalIndexes {
+			if findIndex(desc, idx.Name) == nil || schema.BillingMode != dynamodb.BillingModeProvisioned {
+				continue
+			}
+			input.GlobalSecondaryIndexUpdates = append(input.GlobalSecondaryIndexUpdates,
+				&dynamodb.GlobalSecondaryIndexUpdate{Update: &dynamodb.UpdateGlobalSecondaryIndexAction{
+					IndexName:             aws.String(idx.Name),
+					ProvisionedThroughput: provisionedThroughput(schema, idx.Capacity),
+				}})
+		}
+		return input
+	}
+
+	if streamViewType(desc) != schema.StreamViewType {
+		// View type of the enabled stream cannot be changed, it is disabled
+		// first and enabled with the new view type by the next update.
+		spec := &dynamodb.StreamSpecification{StreamEnabled: aws.Bool(false)}
+		if streamViewType(desc) == "" {
+			spec = &dynamodb.StreamSpecification{
+				StreamEnabled:  aws.Bool(true),
+				StreamViewType: aws.String(schema.StreamViewType),
+			}
+		}
+		return &dynamodb.UpdateTableInput{TableName: table, StreamSpecification: spec}
+	}
+
+	for _, idx := range schema.GlobalIndexes {
+		if findIndex(desc, idx.Name) != nil {
+			continue
+		}
+		return &dynamodb.UpdateTableInput{
+			TableName:            table,
+			AttributeDefinitions: attributeDefinitions(schema, []IndexSchema{idx}),
+			GlobalSecondaryIndexUpdates: []*dynamodb.GlobalSecondaryIndexUpdate{{
+				Create: &dynamodb.CreateGlobalSecondaryIndexAction{
+					IndexName:             aws.String(idx.Name),
+					KeySchema:             keySchema(idx.PartitionKey, idx.SortKey),
+					Projection:            &dynamodb.Projection{ProjectionType: aws.String(dynamodb.ProjectionTypeAll)},
+					ProvisionedThroughput: provisionedThroughput(schema, idx.Capacity),
+				},
+			}},
+		}
+	}
+
+	return nil
+}
+
+func checkKeys(desc *dynamodb.TableDescription, schema TableSchema) error {
+	if !sameKeys(desc.KeySchema, schema.PartitionKey, schema.SortKey) {
+		return fmt.Errorf("%w: keys of table %s differ", ErrSchemaMismatch, schema.Name)
+	}
+	for _, idx := range schema.GlobalIndexes {
+		existing := findIndex(desc, idx.Name)
+		if existing != nil && !sameKeys(existing.KeySchema, idx.PartitionKey, idx.SortKey) {
+			return fmt.Errorf("%w: keys of index %s differ", ErrSchemaMismatch, idx.Name)
+		}
+	}
+	return nil
+}
+
+func ensureTTL(ctx context.Context, client dynamodbiface.DynamoDBAPI, schema TableSchema) error {
+	out, err := client.DescribeTimeToLiveWithContext(ctx, &dynamodb.DescribeTimeToLiveInput{
+		TableName: aws.String(schema.Name),
+	})
+	if err != nil {
+		return err
+	}
+
+	var attr string
+	if d := out.TimeToLiveDescription; d != nil {
+		status := aws.StringValue(d.TimeToLiveStatus)
+		if status == dynamodb.TimeToLiveStatusEnabled || status == dynamodb.TimeToLiveStatusEnabling {
+			attr = aws.StringValue(d.AttributeName)
+		}
+	}
+
+	if attr == schema.TTLAttribute {
+		return nil
+	}
+	// DynamoDB does not allow enabling TTL for an hour after it was
+	// disabled, so the attribute is not switched silently.
+	if attr != "" && schema.TTLAttribute != "" {
+		return fmt.Errorf("%w: TTL of table %s is enabled on %s", ErrSchemaMismatch, schema.Name, attr)
+	}
+
+	spec := &dynamodb.TimeToLiveSpecification{AttributeName: aws.String(attr), Enabled: aws.Bool(false)}
+	if schema.TTLAttribute != "" {
+		spec = &dynamodb.TimeToLiveSpecification{
+			AttributeName: aws.String(schema.TTLAttribute),
+			Enabled:       aws.Bool(true),
+		}
+	}
+	_, err = client.UpdateTimeToLiveWithContext(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName:               aws.String(schema.Name),
+		TimeToLiveSpecification: spec,
+	})
+	return err
+}
+
+func attributeDefinitions(schema TableSchema, indexes []IndexSchema) []*dynamodb.AttributeDefinition {
+	names := []string{schema.PartitionKey, schema.SortKey}
+	for _, idx := range indexes {
+		names = append(names, idx.PartitionKey, idx.SortKey)
+	}
+
+	var defs []*dynamodb.AttributeDefinition
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		defs = append(defs, &dynamodb.AttributeDefinition{
+			AttributeName: aws.String(name),
+			AttributeType: aws.String(dynamodb.ScalarAttributeTypeS),
+		})
+	}
+	return defs
+}
+
+func keySchema(partitionKey, sortKey string) []*dynamodb.KeySchemaElement {
+	keys := []*dynamodb.KeySchemaElement{{
+		AttributeName: aws.String(partitionKey),
+		KeyType:       aws.String(dynamodb.KeyTypeHash),
+	}}
+	if sortKey != "" {
+		keys = append(keys, &dynamodb.KeySchemaElement{
+			AttributeName: aws.String(sortKey),
+			KeyType:       aws.String(dynamodb.KeyTypeRange),
+		})
+	}
+	return keys
+}
+
+func sameKeys(keys []*dynamodb.KeySchemaElement, partitionKey, sortKey string) bool {
+	var pk, sk string
+	for _, k := range keys {
+		switch aws.StringValue(k.KeyType) {
+		case dynamodb.KeyTypeHash:
+			pk = aws.StringValue(k.AttributeName)
+		case dynamodb.KeyTypeRange:
+			sk = aws.StringValue(k.AttributeName)
+		}
+	}
+	return pk == partitionKey && sk == sortKey
+}
+
+func globalIndex(schema TableSchema, idx IndexSchema) *dynamodb.GlobalSecondaryIndex {
+	return &dynamodb.GlobalSecondaryIndex{
+		IndexName:             aws.String(idx.Name),
+		KeySchema:             keySchema(idx.PartitionKey, idx.SortKey),
+		Projection:            &dynamodb.Projection{ProjectionType: aws.String(dynamodb.ProjectionTypeAll)},
+		ProvisionedThroughput: provisionedThroughput(schema, idx.Capacity),
+	}
+}
+
+func findIndex(desc *dynamodb.TableDescription, name string) *dynamodb.GlobalSecondaryIndexDescription {
+	for _, idx := range desc.GlobalSecondaryIndexes {
+		if aws.StringValue(idx.IndexName) == name {
+			return idx
+		}
+	}
+	return nil
+}
+
+// provisionedThroughput returns nil in on-demand mode, where throughput must
+// not be set.
+func provisionedThroughput(schema TableSchema, c Capacity) *dynamodb.ProvisionedThroughput {
+	if schema.BillingMode != dynamodb.BillingModeProvisioned {
+		return nil
+	}
+	return &dynamodb.ProvisionedThroughput{
+		ReadCapacityUnits:  aws.Int64(c.Read),
+		WriteCapacityUnits: aws.Int64(c.Write),
+	}
+}
+
+// billingMode of the table, tables created before on-demand mode have no
+// billing mode summary and are provisioned.
+func billingMode(desc *dynamodb.TableDescription) string {
+	if desc.BillingModeSummary == nil || desc.BillingModeSummary.BillingMode == nil {
+		return dynamodb.BillingModeProvisioned
+	}
+	return aws.StringValue(desc.BillingModeSummary.BillingMode)
+}
+
+func throughputChanged(desc *dynamodb.TableDescription, schema TableSchema) bool {
+	if schema.BillingMode != dynamodb.BillingModeProvisioned || desc.ProvisionedThroughput == nil {
+		return false
+	}
+	pt := desc.ProvisionedThroughput
+	return aws.Int64Value(pt.ReadCapacityUnits) != schema.Capacity.Read ||
+		aws.Int64Value(pt.WriteCapacityUnits) != schema.Capacity.Write
+}
+
+// streamViewType returns view type of the enabled stream, or empty string.
+func streamViewType(desc *dynamodb.TableDescription) string {
+	spec := desc.StreamSpecification
+	if spec == nil || !aws.BoolValue(spec.StreamEnabled) {
+		return ""
+	}
+	return aws.StringValue(spec.StreamViewType)
+}
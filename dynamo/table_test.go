@@ -0,0 +1,212 @@
+package dynamo_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/antklim/go-dynamodb/dynamo"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tableClient keeps a single table which becomes active immediately.
+type tableClient struct {
+	dynamodbiface.DynamoDBAPI
+	table   *dynamodb.TableDescription
+	ttl     *dynamodb.TimeToLiveDescription
+	updates []*dynamodb.UpdateTableInput
+}
+
+func (c *tableClient) DescribeTableWithContext(
+	_ aws.Context, _ *dynamodb.DescribeTableInput, _ ...request.Option) (*dynamodb.DescribeTableOutput, error) {
+
+	if c.table == nil {
+		return nil, awserr.New(dynamodb.ErrCodeResourceNotFoundException, "table not found", nil)
+	}
+	return &dynamodb.DescribeTableOutput{Table: c.table}, nil
+}
+
+func (c *tableClient) CreateTableWithContext(
+	_ aws.Context, input *dynamodb.CreateTableInput, _ ...request.Option) (*dynamodb.CreateTableOutput, error) {
+
+	c.table = &dynamodb.TableDescription{
+		TableName:             input.TableName,
+		TableStatus:           aws.String(dynamodb.TableStatusActive),
+		KeySchema:             input.KeySchema,
+		BillingModeSummary:    &dynamodb.BillingModeSummary{BillingMode: input.BillingMode},
+		StreamSpecification:   input.StreamSpecification,
+		ProvisionedThroughput: throughputDescription(input.ProvisionedThroughput),
+	}
+	for _, idx := range input.GlobalSecondaryIndexes {
+		c.table.GlobalSecondaryIndexes = append(c.table.GlobalSecondaryIndexes,
+			&dynamodb.GlobalSecondaryIndexDescription{
+				IndexName:   idx.IndexName,
+				IndexStatus: aws.String(dynamodb.IndexStatusActive),
+				KeySchema:   idx.KeySchema,
+			})
+	}
+	return &dynamodb.CreateTableOutput{TableDescription: c.table}, nil
+}
+
+func (c *tableClient) UpdateTableWithContext(
+	_ aws.Context, input *dynamodb.UpdateTableInput, _ ...request.Option) (*dynamodb.UpdateTableOutput, error) {
+
+	c.updates = append(c.updates, input)
+	if input.BillingMode != nil {
+		c.table.BillingModeSummary = &dynamodb.BillingModeSummary{BillingMode: input.BillingMode}
+		c.table.ProvisionedThroughput = throughputDescription(input.ProvisionedThroughput)
+	}
+	if input.StreamSpecification != nil {
+		c.table.StreamSpecification = input.StreamSpecification
+	}
+	for _, upd := range input.GlobalSecondaryIndexUpdates {
+		if upd.Create != nil {
+			c.table.GlobalSecondaryIndexes = append(c.table.GlobalSecondaryIndexes,
+				&dynamodb.GlobalSecondaryIndexDescription{
+					IndexName:   upd.Create.IndexName,
+					IndexStatus: aws.String(dynamodb.IndexStatusActive),
+					KeySchema:   upd.Create.KeySchema,
+				})
+		}
+	}
+	return &dynamodb.UpdateTableOutput{TableDescription: c.table}, nil
+}
+
+func (c *tableClient) DescribeTimeToLiveWithContext(
+	_ aws.Context, _ *dynamodb.DescribeTimeToLiveInput, _ ...request.Option) (
+	*dynamodb.DescribeTimeToLiveOutput, error) {
+
+	return &dynamodb.DescribeTimeToLiveOutput{TimeToLiveDescription: c.ttl}, nil
+}
+
+func (c *tableClient) UpdateTimeToLiveWithContext(
+	_ aws.Context, input *dynamodb.UpdateTimeToLiveInput, _ ...request.Option) (
+	*dynamodb.UpdateTimeToLiveOutput, error) {
+
+	status := dynamodb.TimeToLiveStatusDisabled
+	if aws.BoolValue(input.TimeToLiveSpecification.Enabled) {
+		status = dynamodb.TimeToLiveStatusEnabled
+	}
+	c.ttl = &dynamodb.TimeToLiveDescription{
+		AttributeName:    input.TimeToLiveSpecification.AttributeName,
+		TimeToLiveStatus: aws.String(status),
+	}
+	return &dynamodb.UpdateTimeToLiveOutput{}, nil
+}
+
+func throughputDescription(pt *dynamodb.ProvisionedThroughput) *dynamodb.ProvisionedThroughputDescription {
+	if pt == nil {
+		return nil
+	}
+	return &dynamodb.ProvisionedThroughputDescription{
+		ReadCapacityUnits:  pt.ReadCapacityUnits,
+		WriteCapacityUnits: pt.WriteCapacityUnits,
+	}
+}
+
+func TestEnsureTable(t *testing.T) {
+	ctx := context.Background()
+	statusIndex := dynamo.IndexSchema{Name: "status-index", PartitionKey: "status", SortKey: "sk"}
+
+	t.Run("creates missing table", func(t *testing.T) {
+		client := &tableClient{}
+		schema := dynamo.DefaultSchema("invoices")
+		schema.GlobalIndexes = []dynamo.IndexSchema{statusIndex}
+
+		require.NoError(t, dynamo.EnsureTable(ctx, client, schema))
+		require.NotNil(t, client.table)
+		assert.Len(t, client.table.GlobalSecondaryIndexes, 1)
+		assert.Equal(t, "expiresAt", aws.StringValue(client.ttl.AttributeName))
+		assert.Empty(t, client.updates)
+
+		require.NoError(t, dynamo.EnsureTable(ctx, client, schema), "existing table is not changed")
+		assert.Empty(t, client.updates)
+	})
+
+	t.Run("updates existing table one change at a time", func(t *testing.T) {
+		client := &tableClient{}
+		schema := dynamo.TableSchema{
+			Name:         "invoices",
+			PartitionKey: "pk",
+			SortKey:      "sk",
+			BillingMode:  dynamodb.BillingModePayPerRequest,
+		}
+		require.NoError(t, dynamo.EnsureTable(ctx, client, schema))
+
+		schema = dynamo.DefaultSchema("invoices")
+		schema.GlobalIndexes = []dynamo.IndexSchema{statusIndex}
+		schema.GlobalIndexes[0].Capacity = dynamo.Capacity{Read: 1, Write: 1}
+		require.NoError(t, dynamo.EnsureTable(ctx, client, schema))
+
+		require.Len(t, client.updates, 3)
+		assert.Equal(t, dynamodb.BillingModeProvisioned, aws.StringValue(client.updates[0].BillingMode))
+		assert.True(t, aws.BoolValue(client.updates[1].StreamSpecification.StreamEnabled))
+		assert.Equal(t, "status-index", aws.StringValue(client.updates[2].GlobalSecondaryIndexUpdates[0].Create.IndexName))
+		assert.Equal(t, "expiresAt", aws.StringValue(client.ttl.AttributeName))
+
+		schema.StreamViewType = dynamodb.StreamViewTypeKeysOnly
+		require.NoError(t, dynamo.EnsureTable(ctx, client, schema))
+		require.Len(t, client.updates, 5, "stream is disabled before view type changes")
+		assert.False(t, aws.BoolValue(client.updates[3].StreamSpecification.StreamEnabled))
+		assert.Equal(t, dynamodb.StreamViewTypeKeysOnly, aws.StringValue(client.table.StreamSpecification.StreamViewType))
+	})
+
+	t.Run("creates table with repository keys", func(t *testing.T) {
+		client := &tableClient{}
+		repo := dynamo.NewRepository(client, "invoices", dynamo.WithKeyAttributes("PK", "SK"))
+
+		require.NoError(t, dynamo.EnsureTable(ctx, client, repo.TableSchema()))
+		require.NotNil(t, client.table)
+		require.Len(t, client.table.KeySchema, 2)
+		assert.Equal(t, "PK", aws.StringValue(client.table.KeySchema[0].AttributeName))
+		assert.Equal(t, "SK", aws.StringValue(client.table.KeySchema[1].AttributeName))
+		assert.Equal(t, "invoices", aws.StringValue(client.table.TableName))
+	})
+
+	t.Run("rejects different keys", func(t *testing.T) {
+		client := &tableClient{}
+		require.NoError(t, dynamo.EnsureTable(ctx, client, dynamo.DefaultSchema("invoices")))
+
+		schema := dynamo.DefaultSchema("invoices")
+		schema.SortKey = "id"
+		err := dynamo.EnsureTable(ctx, client, schema)
+		assert.ErrorIs(t, err, dynamo.ErrSchemaMismatch)
+
+		schema = dynamo.DefaultSchema("invoices")
+		schema.TTLAttribute = "deletedAt"
+		err = dynamo.EnsureTable(ctx, client, schema)
+		assert.ErrorIs(t, err, dynamo.ErrSchemaMismatch, "TTL attribute is not switched")
+	})
+}
+
+// TestEnsureTableLocal provisions a table in DynamoDB Local, e.g.
+// TEST_DB_URL=http://localhost:8000 go test ./dynamo
+func TestEnsureTableLocal(t *testing.T) {
+	dburl := os.Getenv("TEST_DB_URL")
+	if dburl == "" {
+		t.Skip("TEST_DB_URL is not set")
+	}
+
+	cfg := aws.NewConfig().WithEndpoint(dburl).WithRegion("ap-southeast-2")
+	client := dynamodb.New(session.Must(session.NewSession(cfg)))
+	ctx := context.Background()
+
+	schema := dynamo.DefaultSchema("invoices-" + uuid.NewString())
+	require.NoError(t, dynamo.EnsureTable(ctx, client, schema))
+	defer client.DeleteTableWithContext(ctx, &dynamodb.DeleteTableInput{TableName: aws.String(schema.Name)})
+
+	require.NoError(t, dynamo.EnsureTable(ctx, client, schema), "ensuring existing table is no-op")
+
+	out, err := client.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(schema.Name)})
+	require.NoError(t, err)
+	assert.Equal(t, dynamodb.TableStatusActive, aws.StringValue(out.Table.TableStatus))
+	assert.Equal(t, dynamodb.StreamViewTypeNewAndOldImages, aws.StringValue(out.Table.StreamSpecification.StreamViewType))
+}
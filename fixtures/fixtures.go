@@ -0,0 +1,71 @@
+// Package fixtures loads invoices and items from JSON files and seeds them
+// into a repository. Files have the format of data/invoices.json and
+// data/items.json.
+package fixtures
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/antklim/go-dynamodb/invoice"
+)
+
+// Default fixture files.
+const (
+	InvoicesFile = "data/invoices.json"
+	ItemsFile    = "data/items.json"
+)
+
+// Fixtures holds invoices and items to seed.
+type Fixtures struct {
+	Invoices []invoice.Invoice
+	Items    []invoice.Item // items of the invoices, stored after invoices
+}
+
+// Load reads invoices and items from JSON files. Empty file name skips
+// the file.
+func Load(invoicesFile, itemsFile string) (Fixtures, error) {
+	var f Fixtures
+	if err := readJSON(invoicesFile, &f.Invoices); err != nil {
+		return Fixtures{}, err
+	}
+	if err := readJSON(itemsFile, &f.Items); err != nil {
+		return Fixtures{}, err
+	}
+	return f, nil
+}
+
+// Seed stores invoices and then items in the repository. It stops on the
+// first error, rows stored before the error are kept.
+func (f Fixtures) Seed(ctx context.Context, repo invoice.Repository) error {
+	for _, inv := range f.Invoices {
+		if err := repo.AddInvoice(ctx, inv); err != nil {
+			return fmt.Errorf("seed invoice %s: %w", inv.ID, err)
+		}
+	}
+
+	for _, item := range f.Items {
+		if err := repo.AddItem(ctx, item); err != nil {
+			return fmt.Errorf("seed item %s: %w", item.ID, err)
+		}
+	}
+
+	return nil
+}
+
+func readJSON(file string, v interface{}) error {
+	if file == "" {
+		return nil
+	}
+
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("read %s: %w", file, err)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package fixtures_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/antklim/go-dynamodb/fixtures"
+	"github.com/antklim/go-dynamodb/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeed(t *testing.T) {
+	fx, err := fixtures.Load("../"+fixtures.InvoicesFile, "../"+fixtures.ItemsFile)
+	require.NoError(t, err)
+	require.NotEmpty(t, fx.Invoices)
+	require.NotEmpty(t, fx.Items)
+
+	repo := memory.NewRepository()
+	err = fx.Seed(context.Background(), repo)
+	require.NoError(t, err)
+
+	for _, item := range fx.Items {
+		got, err := repo.GetItem(context.Background(), item.InvoiceID, item.ID)
+		require.NoError(t, err)
+		require.NotNil(t, got)
+		assert.Equal(t, item.Name, got.Name)
+	}
+
+	_, err = fixtures.Load("missing.json", "")
+	assert.Error(t, err)
+}
@@ -6,4 +6,6 @@ require (
 	github.com/aws/aws-sdk-go v1.37.17
 	github.com/google/uuid v1.2.0
 	github.com/stretchr/testify v1.7.0
+	google.golang.org/grpc v1.43.0
+	google.golang.org/protobuf v1.27.1
 )
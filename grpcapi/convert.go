@@ -0,0 +1,252 @@
+package grpcapi
+
+import (
+	"time"
+
+	"github.com/antklim/go-dynamodb/grpcapi/invoicepb"
+	"github.com/antklim/go-dynamodb/invoice"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+	toStatus = map[invoicepb.Status]invoice.Status{
+		invoicepb.Status_NEW:            invoice.New,
+		invoicepb.Status_PENDING:        invoice.Pending,
+		invoicepb.Status_CANCELLED:      invoice.Cancelled,
+		invoicepb.Status_PARTIALLY_PAID: invoice.PartiallyPaid,
+		invoicepb.Status_PAID:           invoice.Paid,
+	}
+	fromStatus = map[invoice.Status]invoicepb.Status{}
+)
+
+func init() {
+	for pb, s := range toStatus {
+		fromStatus[s] = pb
+	}
+}
+
+// timestamp returns nil for zero time, so that unset times stay unset.
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func timeOf(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
+
+func invoiceToPB(inv invoice.Invoice) *invoicepb.Invoice {
+	items := make([]*invoicepb.Item, len(inv.Items))
+	for idx, item := range inv.Items {
+		items[idx] = itemToPB(item)
+	}
+
+	return &invoicepb.Invoice{
+		Id:           inv.ID,
+		Number:       inv.Number,
+		CustomerName: inv.CustomerName,
+		Status:       fromStatus[inv.Status],
+		Date:         timestamp(inv.Date),
+		Items:        items,
+		ItemCount:    uint64(inv.ItemCount),
+		Total:        uint64(inv.Total),
+		CreatedAt:    timestamp(inv.CreatedAt),
+		UpdatedAt:    timestamp(inv.UpdatedAt),
+	}
+}
+
+func invoiceFromPB(inv *invoicepb.Invoice) invoice.Invoice {
+	return invoice.Invoice{
+		ID:           inv.GetId(),
+		Number:       inv.GetNumber(),
+		CustomerName: inv.GetCustomerName(),
+		Status:       toStatus[inv.GetStatus()],
+		Date:         timeOf(inv.GetDate()),
+		Items:        itemsFromPB(inv.GetItems()),
+	}
+}
+
+func itemToPB(item invoice.Item) *invoicepb.Item {
+	return &invoicepb.Item{
+		Id:        item.ID,
+		InvoiceId: item.InvoiceID,
+		Sku:       item.SKU,
+		Name:      item.Name,
+		Price:     uint64(item.Price),
+		Qty:       uint64(item.Qty),
+		Status:    fromStatus[item.Status],
+		Version:   uint64(item.Version),
+		CreatedAt: timestamp(item.CreatedAt),
+		UpdatedAt: timestamp(item.UpdatedAt),
+		DeletedAt: timestamp(item.DeletedAt),
+		DeletedBy: item.DeletedBy,
+	}
+}
+
+// itemFromPB converts fields clients are allowed to set, the rest is
+// maintained by repository.
+func itemFromPB(item *invoicepb.Item) invoice.Item {
+	return invoice.Item{
+		ID:        item.GetId(),
+		InvoiceID: item.GetInvoiceId(),
+		SKU:       item.GetSku(),
+		Name:      item.GetName(),
+		Price:     uint(item.GetPrice()),
+		Qty:       uint(item.GetQty()),
+		Status:    toStatus[item.GetStatus()],
+	}
+}
+
+func itemsFromPB(items []*invoicepb.Item) []invoice.Item {
+	if len(items) == 0 {
+		return nil
+	}
+	result := make([]invoice.Item, len(items))
+	for idx, item := range items {
+		result[idx] = itemFromPB(item)
+	}
+	return result
+}
+
+func productToPB(p invoice.Product) *invoicepb.Product {
+	return &invoicepb.Product{
+		Sku:   p.SKU,
+		Name:  p.Name,
+		Price: uint64(p.Price),
+	}
+}
+
+func itemUpdateFromPB(req *invoicepb.UpdateItemRequest) invoice.ItemUpdate {
+	var upd invoice.ItemUpdate
+	upd.Name = req.Name
+	if req.Qty != nil {
+		v := uint(req.GetQty())
+		upd.Qty = &v
+	}
+	if req.Price != nil {
+		v := uint(req.GetPrice())
+		upd.Price = &v
+	}
+	if req.ExpectedVersion != nil {
+		v := uint(req.GetExpectedVersion())
+		upd.ExpectedVersion = &v
+	}
+	return upd
+}
+
+func paymentToPB(p invoice.Payment) *invoicepb.Payment {
+	return &invoicepb.Payment{
+		Id:         p.ID,
+		InvoiceId:  p.InvoiceID,
+		Amount:     uint64(p.Amount),
+		Method:     p.Method,
+		Reference:  p.Reference,
+		ReceivedAt: timestamp(p.ReceivedAt),
+		ReversedAt: timestamp(p.ReversedAt),
+		CreatedAt:  timestamp(p.CreatedAt),
+		UpdatedAt:  timestamp(p.UpdatedAt),
+	}
+}
+
+// paymentFromPB converts fields clients are allowed to set, payments are
+// reversed with ReversePayment.
+func paymentFromPB(p *invoicepb.Payment) invoice.Payment {
+	return invoice.Payment{
+		ID:         p.GetId(),
+		InvoiceID:  p.GetInvoiceId(),
+		Amount:     uint(p.GetAmount()),
+		Method:     p.GetMethod(),
+		Reference:  p.GetReference(),
+		ReceivedAt: timeOf(p.GetReceivedAt()),
+	}
+}
+
+func balanceToPB(b invoice.Balance) *invoicepb.Balance {
+	return &invoicepb.Balance{
+		Total:       uint64(b.Total),
+		Paid:        uint64(b.Paid),
+		Outstanding: int64(b.Outstanding),
+	}
+}
+
+func creditNoteToPB(cn invoice.CreditNote) *invoicepb.CreditNote {
+	lines := make([]*invoicepb.CreditNoteLine, len(cn.Lines))
+	for idx, line := range cn.Lines {
+		lines[idx] = &invoicepb.CreditNoteLine{
+			ItemId: line.ItemID,
+			Qty:    uint64(line.Qty),
+			Amount: uint64(line.Amount),
+		}
+	}
+
+	return &invoicepb.CreditNote{
+		Id:        cn.ID,
+		Number:    cn.Number,
+		InvoiceId: cn.InvoiceID,
+		Reason:    cn.Reason,
+		Lines:     lines,
+		Date:      timestamp(cn.Date),
+		CreatedAt: timestamp(cn.CreatedAt),
+	}
+}
+
+// creditNoteFromPB converts fields clients are allowed to set, the number is
+// assigned by repository.
+func creditNoteFromPB(cn *invoicepb.CreditNote) invoice.CreditNote {
+	var lines []invoice.CreditNoteLine
+	for _, line := range cn.GetLines() {
+		lines = append(lines, invoice.CreditNoteLine{
+			ItemID: line.GetItemId(),
+			Qty:    uint(line.GetQty()),
+			Amount: uint(line.GetAmount()),
+		})
+	}
+
+	return invoice.CreditNote{
+		ID:        cn.GetId(),
+		InvoiceID: cn.GetInvoiceId(),
+		Reason:    cn.GetReason(),
+		Lines:     lines,
+		Date:      timeOf(cn.GetDate()),
+	}
+}
+
+// snapshotToPB returns nil for empty snapshots, so that they stay unset.
+func snapshotToPB(s invoice.Snapshot) *invoicepb.Snapshot {
+	if s.Invoice == nil && len(s.Items) == 0 && s.Payment == nil && s.CreditNote == nil {
+		return nil
+	}
+
+	snapshot := &invoicepb.Snapshot{}
+	if s.Invoice != nil {
+		snapshot.Invoice = invoiceToPB(*s.Invoice)
+	}
+	for _, item := range s.Items {
+		snapshot.Items = append(snapshot.Items, itemToPB(item))
+	}
+	if s.Payment != nil {
+		snapshot.Payment = paymentToPB(*s.Payment)
+	}
+	if s.CreditNote != nil {
+		snapshot.CreditNote = creditNoteToPB(*s.CreditNote)
+	}
+	return snapshot
+}
+
+func changeToPB(c invoice.Change) *invoicepb.Change {
+	return &invoicepb.Change{
+		Id:        c.ID,
+		Tenant:    c.Tenant,
+		InvoiceId: c.InvoiceID,
+		Operation: string(c.Operation),
+		Actor:     c.Actor,
+		At:        timestamp(c.At),
+		Before:    snapshotToPB(c.Before),
+		After:     snapshotToPB(c.After),
+	}
+}
@@ -0,0 +1,57 @@
+package grpcapi
+
+import (
+	"context"
+	"net"
+
+	"github.com/antklim/go-dynamodb/grpcapi/invoicepb"
+	"github.com/antklim/go-dynamodb/invoice/invoiceiface"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const bufSize = 1024 * 1024
+
+// InProcessClient is the client of the invoice service served in memory. It
+// goes through the whole gRPC stack without opening network ports, so that
+// clients can be tested against real service.
+type InProcessClient struct {
+	invoicepb.InvoiceServiceClient
+
+	conn *grpc.ClientConn
+	srv  *grpc.Server
+}
+
+// NewInProcessClient serves svc over in memory connection and returns the
+// client connected to it. Options are passed to ServerOptions.
+func NewInProcessClient(ctx context.Context, svc invoiceiface.Service, opts ...Option) (*InProcessClient, error) {
+	lis := bufconn.Listen(bufSize)
+
+	srv := grpc.NewServer(ServerOptions(opts...)...)
+	NewServer(svc).Register(srv)
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+
+	dialer := func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	}
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithContextDialer(dialer), grpc.WithInsecure())
+	if err != nil {
+		srv.Stop()
+		return nil, err
+	}
+
+	return &InProcessClient{
+		InvoiceServiceClient: invoicepb.NewInvoiceServiceClient(conn),
+		conn:                 conn,
+		srv:                  srv,
+	}, nil
+}
+
+// Close closes the connection and stops the server.
+func (c *InProcessClient) Close() error {
+	err := c.conn.Close()
+	c.srv.Stop()
+	return err
+}
@@ -0,0 +1,1003 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package invoicepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// InvoiceServiceClient is the client API for InvoiceService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type InvoiceServiceClient interface {
+	StoreInvoice(ctx context.Context, in *StoreInvoiceRequest, opts ...grpc.CallOption) (*Invoice, error)
+	GetInvoice(ctx context.Context, in *GetInvoiceRequest, opts ...grpc.CallOption) (*Invoice, error)
+	CancelInvoice(ctx context.Context, in *CancelInvoiceRequest, opts ...grpc.CallOption) (*Empty, error)
+	AddItem(ctx context.Context, in *AddItemRequest, opts ...grpc.CallOption) (*Item, error)
+	GetItem(ctx context.Context, in *GetItemRequest, opts ...grpc.CallOption) (*Item, error)
+	UpdateItem(ctx context.Context, in *UpdateItemRequest, opts ...grpc.CallOption) (*Empty, error)
+	DeleteItem(ctx context.Context, in *ItemRef, opts ...grpc.CallOption) (*Empty, error)
+	RestoreItem(ctx context.Context, in *ItemRef, opts ...grpc.CallOption) (*Empty, error)
+	CancelItem(ctx context.Context, in *ItemRef, opts ...grpc.CallOption) (*Empty, error)
+	GetItemProduct(ctx context.Context, in *GetItemRequest, opts ...grpc.CallOption) (*Product, error)
+	// ListInvoiceItems streams items of the invoice, optionally filtered by status.
+	ListInvoiceItems(ctx context.Context, in *ListInvoiceItemsRequest, opts ...grpc.CallOption) (InvoiceService_ListInvoiceItemsClient, error)
+	// ListItemsByStatus streams items of all invoices with the status.
+	ListItemsByStatus(ctx context.Context, in *ListItemsByStatusRequest, opts ...grpc.CallOption) (InvoiceService_ListItemsByStatusClient, error)
+	UpdateInvoiceItemsStatus(ctx context.Context, in *UpdateInvoiceItemsStatusRequest, opts ...grpc.CallOption) (*Empty, error)
+	ReplaceItems(ctx context.Context, in *ReplaceItemsRequest, opts ...grpc.CallOption) (*Empty, error)
+	RecordPayment(ctx context.Context, in *RecordPaymentRequest, opts ...grpc.CallOption) (*Empty, error)
+	ReversePayment(ctx context.Context, in *PaymentRef, opts ...grpc.CallOption) (*Empty, error)
+	// ListPayments streams payments of the invoice in order they were received.
+	ListPayments(ctx context.Context, in *ListPaymentsRequest, opts ...grpc.CallOption) (InvoiceService_ListPaymentsClient, error)
+	GetBalance(ctx context.Context, in *GetBalanceRequest, opts ...grpc.CallOption) (*Balance, error)
+	IssueCreditNote(ctx context.Context, in *IssueCreditNoteRequest, opts ...grpc.CallOption) (*CreditNote, error)
+	GetCreditNote(ctx context.Context, in *GetCreditNoteRequest, opts ...grpc.CallOption) (*CreditNote, error)
+	// ListCreditNotes streams credit notes of the invoice in order of their dates.
+	ListCreditNotes(ctx context.Context, in *ListCreditNotesRequest, opts ...grpc.CallOption) (InvoiceService_ListCreditNotesClient, error)
+	// ListInvoiceHistory streams changes of the invoice in order they were made.
+	ListInvoiceHistory(ctx context.Context, in *ListInvoiceHistoryRequest, opts ...grpc.CallOption) (InvoiceService_ListInvoiceHistoryClient, error)
+}
+
+type invoiceServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewInvoiceServiceClient(cc grpc.ClientConnInterface) InvoiceServiceClient {
+	return &invoiceServiceClient{cc}
+}
+
+func (c *invoiceServiceClient) StoreInvoice(ctx context.Context, in *StoreInvoiceRequest, opts ...grpc.CallOption) (*Invoice, error) {
+	out := new(Invoice)
+	err := c.cc.Invoke(ctx, "/invoice.v1.InvoiceService/StoreInvoice", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *invoiceServiceClient) GetInvoice(ctx context.Context, in *GetInvoiceRequest, opts ...grpc.CallOption) (*Invoice, error) {
+	out := new(Invoice)
+	err := c.cc.Invoke(ctx, "/invoice.v1.InvoiceService/GetInvoice", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *invoiceServiceClient) CancelInvoice(ctx context.Context, in *CancelInvoiceRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/invoice.v1.InvoiceService/CancelInvoice", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *invoiceServiceClient) AddItem(ctx context.Context, in *AddItemRequest, opts ...grpc.CallOption) (*Item, error) {
+	out := new(Item)
+	err := c.cc.Invoke(ctx, "/invoice.v1.InvoiceService/AddItem", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *invoiceServiceClient) GetItem(ctx context.Context, in *GetItemRequest, opts ...grpc.CallOption) (*Item, error) {
+	out := new(Item)
+	err := c.cc.Invoke(ctx, "/invoice.v1.InvoiceService/GetItem", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *invoiceServiceClient) UpdateItem(ctx context.Context, in *UpdateItemRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/invoice.v1.InvoiceService/UpdateItem", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *invoiceServiceClient) DeleteItem(ctx context.Context, in *ItemRef, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/invoice.v1.InvoiceService/DeleteItem", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *invoiceServiceClient) RestoreItem(ctx context.Context, in *ItemRef, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/invoice.v1.InvoiceService/RestoreItem", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *invoiceServiceClient) CancelItem(ctx context.Context, in *ItemRef, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/invoice.v1.InvoiceService/CancelItem", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *invoiceServiceClient) GetItemProduct(ctx context.Context, in *GetItemRequest, opts ...grpc.CallOption) (*Product, error) {
+	out := new(Product)
+	err := c.cc.Invoke(ctx, "/invoice.v1.InvoiceService/GetItemProduct", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *invoiceServiceClient) ListInvoiceItems(ctx context.Context, in *ListInvoiceItemsRequest, opts ...grpc.CallOption) (InvoiceService_ListInvoiceItemsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &InvoiceService_ServiceDesc.Streams[0], "/invoice.v1.InvoiceService/ListInvoiceItems", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &invoiceServiceListInvoiceItemsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type InvoiceService_ListInvoiceItemsClient interface {
+	Recv() (*Item, error)
+	grpc.ClientStream
+}
+
+type invoiceServiceListInvoiceItemsClient struct {
+	grpc.ClientStream
+}
+
+func (x *invoiceServiceListInvoiceItemsClient) Recv() (*Item, error) {
+	m := new(Item)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *invoiceServiceClient) ListItemsByStatus(ctx context.Context, in *ListItemsByStatusRequest, opts ...grpc.CallOption) (InvoiceService_ListItemsByStatusClient, error) {
+	stream, err := c.cc.NewStream(ctx, &InvoiceService_ServiceDesc.Streams[1], "/invoice.v1.InvoiceService/ListItemsByStatus", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &invoiceServiceListItemsByStatusClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type InvoiceService_ListItemsByStatusClient interface {
+	Recv() (*Item, error)
+	grpc.ClientStream
+}
+
+type invoiceServiceListItemsByStatusClient struct {
+	grpc.ClientStream
+}
+
+func (x *invoiceServiceListItemsByStatusClient) Recv() (*Item, error) {
+	m := new(Item)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *invoiceServiceClient) UpdateInvoiceItemsStatus(ctx context.Context, in *UpdateInvoiceItemsStatusRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/invoice.v1.InvoiceService/UpdateInvoiceItemsStatus", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *invoiceServiceClient) ReplaceItems(ctx context.Context, in *ReplaceItemsRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/invoice.v1.InvoiceService/ReplaceItems", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *invoiceServiceClient) RecordPayment(ctx context.Context, in *RecordPaymentRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/invoice.v1.InvoiceService/RecordPayment", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *invoiceServiceClient) ReversePayment(ctx context.Context, in *PaymentRef, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/invoice.v1.InvoiceService/ReversePayment", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *invoiceServiceClient) ListPayments(ctx context.Context, in *ListPaymentsRequest, opts ...grpc.CallOption) (InvoiceService_ListPaymentsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &InvoiceService_ServiceDesc.Streams[2], "/invoice.v1.InvoiceService/ListPayments", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &invoiceServiceListPaymentsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type InvoiceService_ListPaymentsClient interface {
+	Recv() (*Payment, error)
+	grpc.ClientStream
+}
+
+type invoiceServiceListPaymentsClient struct {
+	grpc.ClientStream
+}
+
+func (x *invoiceServiceListPaymentsClient) Recv() (*Payment, error) {
+	m := new(Payment)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *invoiceServiceClient) GetBalance(ctx context.Context, in *GetBalanceRequest, opts ...grpc.CallOption) (*Balance, error) {
+	out := new(Balance)
+	err := c.cc.Invoke(ctx, "/invoice.v1.InvoiceService/GetBalance", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *invoiceServiceClient) IssueCreditNote(ctx context.Context, in *IssueCreditNoteRequest, opts ...grpc.CallOption) (*CreditNote, error) {
+	out := new(CreditNote)
+	err := c.cc.Invoke(ctx, "/invoice.v1.InvoiceService/IssueCreditNote", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *invoiceServiceClient) GetCreditNote(ctx context.Context, in *GetCreditNoteRequest, opts ...grpc.CallOption) (*CreditNote, error) {
+	out := new(CreditNote)
+	err := c.cc.Invoke(ctx, "/invoice.v1.InvoiceService/GetCreditNote", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *invoiceServiceClient) ListCreditNotes(ctx context.Context, in *ListCreditNotesRequest, opts ...grpc.CallOption) (InvoiceService_ListCreditNotesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &InvoiceService_ServiceDesc.Streams[3], "/invoice.v1.InvoiceService/ListCreditNotes", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &invoiceServiceListCreditNotesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type InvoiceService_ListCreditNotesClient interface {
+	Recv() (*CreditNote, error)
+	grpc.ClientStream
+}
+
+type invoiceServiceListCreditNotesClient struct {
+	grpc.ClientStream
+}
+
+func (x *invoiceServiceListCreditNotesClient) Recv() (*CreditNote, error) {
+	m := new(CreditNote)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *invoiceServiceClient) ListInvoiceHistory(ctx context.Context, in *ListInvoiceHistoryRequest, opts ...grpc.CallOption) (InvoiceService_ListInvoiceHistoryClient, error) {
+	stream, err := c.cc.NewStream(ctx, &InvoiceService_ServiceDesc.Streams[4], "/invoice.v1.InvoiceService/ListInvoiceHistory", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &invoiceServiceListInvoiceHistoryClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type InvoiceService_ListInvoiceHistoryClient interface {
+	Recv() (*Change, error)
+	grpc.ClientStream
+}
+
+type invoiceServiceListInvoiceHistoryClient struct {
+	grpc.ClientStream
+}
+
+func (x *invoiceServiceListInvoiceHistoryClient) Recv() (*Change, error) {
+	m := new(Change)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// InvoiceServiceServer is the server API for InvoiceService service.
+// All implementations must embed UnimplementedInvoiceServiceServer
+// for forward compatibility
+type InvoiceServiceServer interface {
+	StoreInvoice(context.Context, *StoreInvoiceRequest) (*Invoice, error)
+	GetInvoice(context.Context, *GetInvoiceRequest) (*Invoice, error)
+	CancelInvoice(context.Context, *CancelInvoiceRequest) (*Empty, error)
+	AddItem(context.Context, *AddItemRequest) (*Item, error)
+	GetItem(context.Context, *GetItemRequest) (*Item, error)
+	UpdateItem(context.Context, *UpdateItemRequest) (*Empty, error)
+	DeleteItem(context.Context, *ItemRef) (*Empty, error)
+	RestoreItem(context.Context, *ItemRef) (*Empty, error)
+	CancelItem(context.Context, *ItemRef) (*Empty, error)
+	GetItemProduct(context.Context, *GetItemRequest) (*Product, error)
+	// ListInvoiceItems streams items of the invoice, optionally filtered by status.
+	ListInvoiceItems(*ListInvoiceItemsRequest, InvoiceService_ListInvoiceItemsServer) error
+	// ListItemsByStatus streams items of all invoices with the status.
+	ListItemsByStatus(*ListItemsByStatusRequest, InvoiceService_ListItemsByStatusServer) error
+	UpdateInvoiceItemsStatus(context.Context, *UpdateInvoiceItemsStatusRequest) (*Empty, error)
+	ReplaceItems(context.Context, *ReplaceItemsRequest) (*Empty, error)
+	RecordPayment(context.Context, *RecordPaymentRequest) (*Empty, error)
+	ReversePayment(context.Context, *PaymentRef) (*Empty, error)
+	// ListPayments streams payments of the invoice in order they were received.
+	ListPayments(*ListPaymentsRequest, InvoiceService_ListPaymentsServer) error
+	GetBalance(context.Context, *GetBalanceRequest) (*Balance, error)
+	IssueCreditNote(context.Context, *IssueCreditNoteRequest) (*CreditNote, error)
+	GetCreditNote(context.Context, *GetCreditNoteRequest) (*CreditNote, error)
+	// ListCreditNotes streams credit notes of the invoice in order of their dates.
+	ListCreditNotes(*ListCreditNotesRequest, InvoiceService_ListCreditNotesServer) error
+	// ListInvoiceHistory streams changes of the invoice in order they were made.
+	ListInvoiceHistory(*ListInvoiceHistoryRequest, InvoiceService_ListInvoiceHistoryServer) error
+	mustEmbedUnimplementedInvoiceServiceServer()
+}
+
+// UnimplementedInvoiceServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedInvoiceServiceServer struct {
+}
+
+func (UnimplementedInvoiceServiceServer) StoreInvoice(context.Context, *StoreInvoiceRequest) (*Invoice, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StoreInvoice not implemented")
+}
+func (UnimplementedInvoiceServiceServer) GetInvoice(context.Context, *GetInvoiceRequest) (*Invoice, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetInvoice not implemented")
+}
+func (UnimplementedInvoiceServiceServer) CancelInvoice(context.Context, *CancelInvoiceRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelInvoice not implemented")
+}
+func (UnimplementedInvoiceServiceServer) AddItem(context.Context, *AddItemRequest) (*Item, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddItem not implemented")
+}
+func (UnimplementedInvoiceServiceServer) GetItem(context.Context, *GetItemRequest) (*Item, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetItem not implemented")
+}
+func (UnimplementedInvoiceServiceServer) UpdateItem(context.Context, *UpdateItemRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateItem not implemented")
+}
+func (UnimplementedInvoiceServiceServer) DeleteItem(context.Context, *ItemRef) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteItem not implemented")
+}
+func (UnimplementedInvoiceServiceServer) RestoreItem(context.Context, *ItemRef) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreItem not implemented")
+}
+func (UnimplementedInvoiceServiceServer) CancelItem(context.Context, *ItemRef) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelItem not implemented")
+}
+func (UnimplementedInvoiceServiceServer) GetItemProduct(context.Context, *GetItemRequest) (*Product, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetItemProduct not implemented")
+}
+func (UnimplementedInvoiceServiceServer) ListInvoiceItems(*ListInvoiceItemsRequest, InvoiceService_ListInvoiceItemsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListInvoiceItems not implemented")
+}
+func (UnimplementedInvoiceServiceServer) ListItemsByStatus(*ListItemsByStatusRequest, InvoiceService_ListItemsByStatusServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListItemsByStatus not implemented")
+}
+func (UnimplementedInvoiceServiceServer) UpdateInvoiceItemsStatus(context.Context, *UpdateInvoiceItemsStatusRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateInvoiceItemsStatus not implemented")
+}
+func (UnimplementedInvoiceServiceServer) ReplaceItems(context.Context, *ReplaceItemsRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReplaceItems not implemented")
+}
+func (UnimplementedInvoiceServiceServer) RecordPayment(context.Context, *RecordPaymentRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RecordPayment not implemented")
+}
+func (UnimplementedInvoiceServiceServer) ReversePayment(context.Context, *PaymentRef) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReversePayment not implemented")
+}
+func (UnimplementedInvoiceServiceServer) ListPayments(*ListPaymentsRequest, InvoiceService_ListPaymentsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListPayments not implemented")
+}
+func (UnimplementedInvoiceServiceServer) GetBalance(context.Context, *GetBalanceRequest) (*Balance, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBalance not implemented")
+}
+func (UnimplementedInvoiceServiceServer) IssueCreditNote(context.Context, *IssueCreditNoteRequest) (*CreditNote, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method IssueCreditNote not implemented")
+}
+func (UnimplementedInvoiceServiceServer) GetCreditNote(context.Context, *GetCreditNoteRequest) (*CreditNote, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCreditNote not implemented")
+}
+func (UnimplementedInvoiceServiceServer) ListCreditNotes(*ListCreditNotesRequest, InvoiceService_ListCreditNotesServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListCreditNotes not implemented")
+}
+func (UnimplementedInvoiceServiceServer) ListInvoiceHistory(*ListInvoiceHistoryRequest, InvoiceService_ListInvoiceHistoryServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListInvoiceHistory not implemented")
+}
+func (UnimplementedInvoiceServiceServer) mustEmbedUnimplementedInvoiceServiceServer() {}
+
+// UnsafeInvoiceServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to InvoiceServiceServer will
+// result in compilation errors.
+type UnsafeInvoiceServiceServer interface {
+	mustEmbedUnimplementedInvoiceServiceServer()
+}
+
+func RegisterInvoiceServiceServer(s grpc.ServiceRegistrar, srv InvoiceServiceServer) {
+	s.RegisterService(&InvoiceService_ServiceDesc, srv)
+}
+
+func _InvoiceService_StoreInvoice_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StoreInvoiceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InvoiceServiceServer).StoreInvoice(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/invoice.v1.InvoiceService/StoreInvoice",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InvoiceServiceServer).StoreInvoice(ctx, req.(*StoreInvoiceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _InvoiceService_GetInvoice_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetInvoiceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InvoiceServiceServer).GetInvoice(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/invoice.v1.InvoiceService/GetInvoice",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InvoiceServiceServer).GetInvoice(ctx, req.(*GetInvoiceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _InvoiceService_CancelInvoice_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelInvoiceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InvoiceServiceServer).CancelInvoice(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/invoice.v1.InvoiceService/CancelInvoice",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InvoiceServiceServer).CancelInvoice(ctx, req.(*CancelInvoiceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _InvoiceService_AddItem_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddItemRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InvoiceServiceServer).AddItem(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/invoice.v1.InvoiceService/AddItem",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InvoiceServiceServer).AddItem(ctx, req.(*AddItemRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _InvoiceService_GetItem_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetItemRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InvoiceServiceServer).GetItem(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/invoice.v1.InvoiceService/GetItem",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InvoiceServiceServer).GetItem(ctx, req.(*GetItemRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _InvoiceService_UpdateItem_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateItemRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InvoiceServiceServer).UpdateItem(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/invoice.v1.InvoiceService/UpdateItem",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InvoiceServiceServer).UpdateItem(ctx, req.(*UpdateItemRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _InvoiceService_DeleteItem_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ItemRef)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InvoiceServiceServer).DeleteItem(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/invoice.v1.InvoiceService/DeleteItem",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InvoiceServiceServer).DeleteItem(ctx, req.(*ItemRef))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _InvoiceService_RestoreItem_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ItemRef)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InvoiceServiceServer).RestoreItem(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/invoice.v1.InvoiceService/RestoreItem",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InvoiceServiceServer).RestoreItem(ctx, req.(*ItemRef))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _InvoiceService_CancelItem_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ItemRef)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InvoiceServiceServer).CancelItem(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/invoice.v1.InvoiceService/CancelItem",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InvoiceServiceServer).CancelItem(ctx, req.(*ItemRef))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _InvoiceService_GetItemProduct_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetItemRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InvoiceServiceServer).GetItemProduct(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/invoice.v1.InvoiceService/GetItemProduct",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InvoiceServiceServer).GetItemProduct(ctx, req.(*GetItemRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _InvoiceService_ListInvoiceItems_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListInvoiceItemsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(InvoiceServiceServer).ListInvoiceItems(m, &invoiceServiceListInvoiceItemsServer{stream})
+}
+
+type InvoiceService_ListInvoiceItemsServer interface {
+	Send(*Item) error
+	grpc.ServerStream
+}
+
+type invoiceServiceListInvoiceItemsServer struct {
+	grpc.ServerStream
+}
+
+func (x *invoiceServiceListInvoiceItemsServer) Send(m *Item) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _InvoiceService_ListItemsByStatus_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListItemsByStatusRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(InvoiceServiceServer).ListItemsByStatus(m, &invoiceServiceListItemsByStatusServer{stream})
+}
+
+type InvoiceService_ListItemsByStatusServer interface {
+	Send(*Item) error
+	grpc.ServerStream
+}
+
+type invoiceServiceListItemsByStatusServer struct {
+	grpc.ServerStream
+}
+
+func (x *invoiceServiceListItemsByStatusServer) Send(m *Item) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _InvoiceService_UpdateInvoiceItemsStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateInvoiceItemsStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InvoiceServiceServer).UpdateInvoiceItemsStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/invoice.v1.InvoiceService/UpdateInvoiceItemsStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InvoiceServiceServer).UpdateInvoiceItemsStatus(ctx, req.(*UpdateInvoiceItemsStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _InvoiceService_ReplaceItems_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReplaceItemsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InvoiceServiceServer).ReplaceItems(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/invoice.v1.InvoiceService/ReplaceItems",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InvoiceServiceServer).ReplaceItems(ctx, req.(*ReplaceItemsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _InvoiceService_RecordPayment_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RecordPaymentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InvoiceServiceServer).RecordPayment(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/invoice.v1.InvoiceService/RecordPayment",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InvoiceServiceServer).RecordPayment(ctx, req.(*RecordPaymentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _InvoiceService_ReversePayment_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PaymentRef)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InvoiceServiceServer).ReversePayment(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/invoice.v1.InvoiceService/ReversePayment",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InvoiceServiceServer).ReversePayment(ctx, req.(*PaymentRef))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _InvoiceService_ListPayments_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListPaymentsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(InvoiceServiceServer).ListPayments(m, &invoiceServiceListPaymentsServer{stream})
+}
+
+type InvoiceService_ListPaymentsServer interface {
+	Send(*Payment) error
+	grpc.ServerStream
+}
+
+type invoiceServiceListPaymentsServer struct {
+	grpc.ServerStream
+}
+
+func (x *invoiceServiceListPaymentsServer) Send(m *Payment) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _InvoiceService_GetBalance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBalanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InvoiceServiceServer).GetBalance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/invoice.v1.InvoiceService/GetBalance",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InvoiceServiceServer).GetBalance(ctx, req.(*GetBalanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _InvoiceService_IssueCreditNote_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(IssueCreditNoteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InvoiceServiceServer).IssueCreditNote(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/invoice.v1.InvoiceService/IssueCreditNote",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InvoiceServiceServer).IssueCreditNote(ctx, req.(*IssueCreditNoteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _InvoiceService_GetCreditNote_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetCreditNoteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InvoiceServiceServer).GetCreditNote(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/invoice.v1.InvoiceService/GetCreditNote",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InvoiceServiceServer).GetCreditNote(ctx, req.(*GetCreditNoteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _InvoiceService_ListCreditNotes_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListCreditNotesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(InvoiceServiceServer).ListCreditNotes(m, &invoiceServiceListCreditNotesServer{stream})
+}
+
+type InvoiceService_ListCreditNotesServer interface {
+	Send(*CreditNote) error
+	grpc.ServerStream
+}
+
+type invoiceServiceListCreditNotesServer struct {
+	grpc.ServerStream
+}
+
+func (x *invoiceServiceListCreditNotesServer) Send(m *CreditNote) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _InvoiceService_ListInvoiceHistory_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListInvoiceHistoryRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(InvoiceServiceServer).ListInvoiceHistory(m, &invoiceServiceListInvoiceHistoryServer{stream})
+}
+
+type InvoiceService_ListInvoiceHistoryServer interface {
+	Send(*Change) error
+	grpc.ServerStream
+}
+
+type invoiceServiceListInvoiceHistoryServer struct {
+	grpc.ServerStream
+}
+
+func (x *invoiceServiceListInvoiceHistoryServer) Send(m *Change) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// InvoiceService_ServiceDesc is the grpc.ServiceDesc for InvoiceService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var InvoiceService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "invoice.v1.InvoiceService",
+	HandlerType: (*InvoiceServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "StoreInvoice",
+			Handler:    _InvoiceService_StoreInvoice_Handler,
+		},
+		{
+			MethodName: "GetInvoice",
+			Handler:    _InvoiceService_GetInvoice_Handler,
+		},
+		{
+			MethodName: "CancelInvoice",
+			Handler:    _InvoiceService_CancelInvoice_Handler,
+		},
+		{
+			MethodName: "AddItem",
+			Handler:    _InvoiceService_AddItem_Handler,
+		},
+		{
+			MethodName: "GetItem",
+			Handler:    _InvoiceService_GetItem_Handler,
+		},
+		{
+			MethodName: "UpdateItem",
+			Handler:    _InvoiceService_UpdateItem_Handler,
+		},
+		{
+			MethodName: "DeleteItem",
+			Handler:    _InvoiceService_DeleteItem_Handler,
+		},
+		{
+			MethodName: "RestoreItem",
+			Handler:    _InvoiceService_RestoreItem_Handler,
+		},
+		{
+			MethodName: "CancelItem",
+			Handler:    _InvoiceService_CancelItem_Handler,
+		},
+		{
+			MethodName: "GetItemProduct",
+			Handler:    _InvoiceService_GetItemProduct_Handler,
+		},
+		{
+			MethodName: "UpdateInvoiceItemsStatus",
+			Handler:    _InvoiceService_UpdateInvoiceItemsStatus_Handler,
+		},
+		{
+			MethodName: "ReplaceItems",
+			Handler:    _InvoiceService_ReplaceItems_Handler,
+		},
+		{
+			MethodName: "RecordPayment",
+			Handler:    _InvoiceService_RecordPayment_Handler,
+		},
+		{
+			MethodName: "ReversePayment",
+			Handler:    _InvoiceService_ReversePayment_Handler,
+		},
+		{
+			MethodName: "GetBalance",
+			Handler:    _InvoiceService_GetBalance_Handler,
+		},
+		{
+			MethodName: "IssueCreditNote",
+			Handler:    _InvoiceService_IssueCreditNote_Handler,
+		},
+		{
+			MethodName: "GetCreditNote",
+			Handler:    _InvoiceService_GetCreditNote_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ListInvoiceItems",
+			Handler:       _InvoiceService_ListInvoiceItems_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ListItemsByStatus",
+			Handler:       _InvoiceService_ListItemsByStatus_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ListPayments",
+			Handler:       _InvoiceService_ListPayments_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ListCreditNotes",
+			Handler:       _InvoiceService_ListCreditNotes_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ListInvoiceHistory",
+			Handler:       _InvoiceService_ListInvoiceHistory_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "invoice.proto",
+}
@@ -0,0 +1,473 @@
+// Package grpcapi exposes invoice service operations as gRPC service defined
+// in invoicepb/invoice.proto.
+package grpcapi
+
+import (
+	"context"
+	"errors"
+
+	"github.com/antklim/go-dynamodb/grpcapi/invoicepb"
+	"github.com/antklim/go-dynamodb/invoice"
+	"github.com/antklim/go-dynamodb/invoice/invoiceiface"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// ActorKey is the metadata key carrying the actor recorded in the invoice history.
+	ActorKey = "x-actor"
+	// TenantKey is the metadata key carrying the tenant the call is scoped to,
+	// see TenantFromMetadata.
+	TenantKey = "x-tenant"
+)
+
+// Server adapts invoice service to the gRPC service.
+type Server struct {
+	invoicepb.UnimplementedInvoiceServiceServer
+
+	svc invoiceiface.Service
+}
+
+// NewServer creates a new instance of gRPC server
+func NewServer(svc invoiceiface.Service) *Server {
+	return &Server{svc: svc}
+}
+
+// Register registers the invoice service in the gRPC server. The gRPC server
+// should be created with ServerOptions to record actors of changes.
+func (s *Server) Register(gs *grpc.Server) {
+	invoicepb.RegisterInvoiceServiceServer(gs, s)
+}
+
+// TenantFunc returns the tenant the call is scoped to, empty tenant leaves
+// the call unscoped. The tenant must come from the authenticated identity of
+// the call, e.g. the peer certificate or claims of a verified token, never
+// from values chosen by the client. Status errors, e.g. codes.Unauthenticated,
+// are returned as they are.
+type TenantFunc func(ctx context.Context) (string, error)
+
+// TenantFromMetadata returns the tenant of TenantKey metadata. The metadata
+// is set by the client, so the server may use it only behind an
+// authenticating proxy that sets it for authenticated calls and drops it from
+// others.
+func TenantFromMetadata(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	tenants := md.Get(TenantKey)
+	if len(tenants) == 0 {
+		return "", nil
+	}
+	if err := invoice.ValidateTenant(tenants[0]); err != nil {
+		return "", err
+	}
+	return tenants[0], nil
+}
+
+// Option configures ServerOptions.
+type Option func(*options)
+
+type options struct {
+	tenant TenantFunc
+}
+
+// WithTenant scopes all calls to the tenant, e.g. when a server runs per
+// tenant.
+func WithTenant(tenant string) Option {
+	return WithTenantFunc(func(context.Context) (string, error) {
+		return tenant, nil
+	})
+}
+
+// WithTenantFunc scopes calls to the tenant returned by f.
+func WithTenantFunc(f TenantFunc) Option {
+	return func(o *options) {
+		o.tenant = f
+	}
+}
+
+// ServerOptions returns options that put the actor from the request metadata
+// to the request context. Calls are scoped to tenants only with WithTenant or
+// WithTenantFunc.
+func ServerOptions(opts ...Option) []grpc.ServerOption {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(o.unaryActor),
+		grpc.StreamInterceptor(o.streamActor),
+	}
+}
+
+func (s *Server) StoreInvoice(ctx context.Context, req *invoicepb.StoreInvoiceRequest) (*invoicepb.Invoice, error) {
+	if req.GetInvoice() == nil {
+		return nil, status.Error(codes.InvalidArgument, "invoice is required")
+	}
+
+	inv := invoiceFromPB(req.GetInvoice())
+	if err := s.svc.StoreInvoice(ctx, inv); err != nil {
+		return nil, toStatusError(err)
+	}
+	return s.getInvoice(ctx, inv.ID)
+}
+
+func (s *Server) GetInvoice(ctx context.Context, req *invoicepb.GetInvoiceRequest) (*invoicepb.Invoice, error) {
+	return s.getInvoice(ctx, req.GetInvoiceId())
+}
+
+func (s *Server) getInvoice(ctx context.Context, invoiceID string) (*invoicepb.Invoice, error) {
+	inv, err := s.svc.GetInvoice(ctx, invoiceID)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	if inv == nil {
+		return nil, toStatusError(invoice.ErrInvoiceNotFound)
+	}
+	return invoiceToPB(*inv), nil
+}
+
+func (s *Server) CancelInvoice(ctx context.Context, req *invoicepb.CancelInvoiceRequest) (*invoicepb.Empty, error) {
+	return empty(s.svc.CancelInvoice(ctx, req.GetInvoiceId()))
+}
+
+func (s *Server) AddItem(ctx context.Context, req *invoicepb.AddItemRequest) (*invoicepb.Item, error) {
+	if req.GetItem() == nil {
+		return nil, status.Error(codes.InvalidArgument, "item is required")
+	}
+
+	item := itemFromPB(req.GetItem())
+	if err := s.svc.AddItem(ctx, item); err != nil {
+		return nil, toStatusError(err)
+	}
+	return s.getItem(ctx, item.InvoiceID, item.ID)
+}
+
+func (s *Server) GetItem(ctx context.Context, req *invoicepb.GetItemRequest) (*invoicepb.Item, error) {
+	return s.getItem(ctx, req.GetInvoiceId(), req.GetItemId(), readOptions(req.GetIncludeDeleted())...)
+}
+
+func (s *Server) getItem(
+	ctx context.Context, invoiceID, itemID string, opts ...invoice.ReadOption) (*invoicepb.Item, error) {
+
+	item, err := s.svc.GetItem(ctx, invoiceID, itemID, opts...)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	if item == nil {
+		return nil, toStatusError(invoice.ErrItemNotFound)
+	}
+	return itemToPB(*item), nil
+}
+
+func (s *Server) UpdateItem(ctx context.Context, req *invoicepb.UpdateItemRequest) (*invoicepb.Empty, error) {
+	return empty(s.svc.UpdateItem(ctx, req.GetInvoiceId(), req.GetItemId(), itemUpdateFromPB(req)))
+}
+
+func (s *Server) DeleteItem(ctx context.Context, req *invoicepb.ItemRef) (*invoicepb.Empty, error) {
+	return empty(s.svc.DeleteItem(ctx, req.GetInvoiceId(), req.GetItemId()))
+}
+
+func (s *Server) RestoreItem(ctx context.Context, req *invoicepb.ItemRef) (*invoicepb.Empty, error) {
+	return empty(s.svc.RestoreItem(ctx, req.GetInvoiceId(), req.GetItemId()))
+}
+
+func (s *Server) CancelItem(ctx context.Context, req *invoicepb.ItemRef) (*invoicepb.Empty, error) {
+	return empty(s.svc.CancelInvoiceItem(ctx, req.GetInvoiceId(), req.GetItemId()))
+}
+
+func (s *Server) GetItemProduct(ctx context.Context, req *invoicepb.GetItemRequest) (*invoicepb.Product, error) {
+	p, err := s.svc.GetItemProduct(ctx, req.GetInvoiceId(), req.GetItemId(), readOptions(req.GetIncludeDeleted())...)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	if p == nil {
+		return nil, toStatusError(invoice.ErrItemNotFound)
+	}
+	return productToPB(*p), nil
+}
+
+func (s *Server) ListInvoiceItems(
+	req *invoicepb.ListInvoiceItemsRequest, stream invoicepb.InvoiceService_ListInvoiceItemsServer) error {
+
+	ctx := stream.Context()
+	opts := readOptions(req.GetIncludeDeleted())
+
+	if req.GetStatus() == invoicepb.Status_STATUS_UNSPECIFIED {
+		return sendItems(s.svc.IterateInvoiceItems(ctx, req.GetInvoiceId(), opts...), stream.Send)
+	}
+
+	st, err := statusFromPB(req.GetStatus())
+	if err != nil {
+		return err
+	}
+	items, err := s.svc.GetInvoiceItemsByStatus(ctx, req.GetInvoiceId(), st, opts...)
+	if err != nil {
+		return toStatusError(err)
+	}
+	for _, item := range items {
+		if err := stream.Send(itemToPB(item)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) ListItemsByStatus(
+	req *invoicepb.ListItemsByStatusRequest, stream invoicepb.InvoiceService_ListItemsByStatusServer) error {
+
+	st, err := statusFromPB(req.GetStatus())
+	if err != nil {
+		return err
+	}
+
+	it := s.svc.IterateItemsByStatus(stream.Context(), st, readOptions(req.GetIncludeDeleted())...)
+	return sendItems(it, stream.Send)
+}
+
+func (s *Server) UpdateInvoiceItemsStatus(
+	ctx context.Context, req *invoicepb.UpdateInvoiceItemsStatusRequest) (*invoicepb.Empty, error) {
+
+	st, err := statusFromPB(req.GetStatus())
+	if err != nil {
+		return nil, err
+	}
+	return empty(s.svc.UpdateInvoiceItemsStatus(ctx, req.GetInvoiceId(), st))
+}
+
+func (s *Server) ReplaceItems(ctx context.Context, req *invoicepb.ReplaceItemsRequest) (*invoicepb.Empty, error) {
+	newItems := itemsFromPB(req.GetNewItems())
+	for idx := range newItems {
+		switch newItems[idx].InvoiceID {
+		case "":
+			newItems[idx].InvoiceID = req.GetInvoiceId()
+		case req.GetInvoiceId():
+		default:
+			return nil, status.Error(codes.InvalidArgument, "new items must belong to the invoice")
+		}
+	}
+	return empty(s.svc.ReplaceItems(ctx, req.GetInvoiceId(), req.GetOldItemIds(), newItems))
+}
+
+func (s *Server) RecordPayment(ctx context.Context, req *invoicepb.RecordPaymentRequest) (*invoicepb.Empty, error) {
+	if req.GetPayment() == nil {
+		return nil, status.Error(codes.InvalidArgument, "payment is required")
+	}
+	return empty(s.svc.RecordPayment(ctx, paymentFromPB(req.GetPayment())))
+}
+
+func (s *Server) ReversePayment(ctx context.Context, req *invoicepb.PaymentRef) (*invoicepb.Empty, error) {
+	return empty(s.svc.ReversePayment(ctx, req.GetInvoiceId(), req.GetPaymentId()))
+}
+
+func (s *Server) ListPayments(
+	req *invoicepb.ListPaymentsRequest, stream invoicepb.InvoiceService_ListPaymentsServer) error {
+
+	payments, err := s.svc.GetPayments(stream.Context(), req.GetInvoiceId())
+	if err != nil {
+		return toStatusError(err)
+	}
+	for _, p := range payments {
+		if err := stream.Send(paymentToPB(p)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) GetBalance(ctx context.Context, req *invoicepb.GetBalanceRequest) (*invoicepb.Balance, error) {
+	b, err := s.svc.GetBalance(ctx, req.GetInvoiceId())
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	return balanceToPB(*b), nil
+}
+
+func (s *Server) IssueCreditNote(
+	ctx context.Context, req *invoicepb.IssueCreditNoteRequest) (*invoicepb.CreditNote, error) {
+
+	if req.GetCreditNote() == nil {
+		return nil, status.Error(codes.InvalidArgument, "credit note is required")
+	}
+
+	cn, err := s.svc.IssueCreditNote(ctx, creditNoteFromPB(req.GetCreditNote()))
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	return creditNoteToPB(*cn), nil
+}
+
+func (s *Server) GetCreditNote(ctx context.Context, req *invoicepb.GetCreditNoteRequest) (*invoicepb.CreditNote, error) {
+	cn, err := s.svc.GetCreditNote(ctx, req.GetInvoiceId(), req.GetCreditNoteId())
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	if cn == nil {
+		return nil, toStatusError(invoice.ErrCreditNoteNotFound)
+	}
+	return creditNoteToPB(*cn), nil
+}
+
+func (s *Server) ListCreditNotes(
+	req *invoicepb.ListCreditNotesRequest, stream invoicepb.InvoiceService_ListCreditNotesServer) error {
+
+	creditNotes, err := s.svc.GetCreditNotes(stream.Context(), req.GetInvoiceId())
+	if err != nil {
+		return toStatusError(err)
+	}
+	for _, cn := range creditNotes {
+		if err := stream.Send(creditNoteToPB(cn)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) ListInvoiceHistory(
+	req *invoicepb.ListInvoiceHistoryRequest, stream invoicepb.InvoiceService_ListInvoiceHistoryServer) error {
+
+	changes, err := s.svc.GetInvoiceHistory(stream.Context(), req.GetInvoiceId())
+	if err != nil {
+		return toStatusError(err)
+	}
+	for _, c := range changes {
+		if err := stream.Send(changeToPB(c)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendItems streams items of the iterator and closes it.
+func sendItems(it invoice.ItemIterator, send func(*invoicepb.Item) error) error {
+	defer it.Close()
+
+	for it.Next() {
+		if err := send(itemToPB(it.Item())); err != nil {
+			return err
+		}
+	}
+	return toStatusError(it.Err())
+}
+
+func empty(err error) (*invoicepb.Empty, error) {
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	return &invoicepb.Empty{}, nil
+}
+
+func readOptions(includeDeleted bool) []invoice.ReadOption {
+	if includeDeleted {
+		return []invoice.ReadOption{invoice.IncludeDeleted()}
+	}
+	return nil
+}
+
+func statusFromPB(s invoicepb.Status) (invoice.Status, error) {
+	st, ok := toStatus[s]
+	if !ok {
+		return "", status.Errorf(codes.InvalidArgument, "unknown status %v", s)
+	}
+	return st, nil
+}
+
+// toStatusError maps domain errors to gRPC status errors.
+func toStatusError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var verr *invoice.ValidationError
+	switch {
+	case errors.As(err, &verr),
+		errors.Is(err, invoice.ErrInvalidItemUpdate),
+		errors.Is(err, invoice.ErrInvalidInvoice),
+		errors.Is(err, invoice.ErrInvalidItem),
+		errors.Is(err, invoice.ErrInvalidPayment),
+		errors.Is(err, invoice.ErrInvalidCreditNote),
+		errors.Is(err, invoice.ErrInvalidTenant):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, invoice.ErrInvoiceNotFound),
+		errors.Is(err, invoice.ErrItemNotFound),
+		errors.Is(err, invoice.ErrPaymentNotFound),
+		errors.Is(err, invoice.ErrCreditNoteNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, invoice.ErrVersionConflict),
+		errors.Is(err, invoice.ErrItemConflict):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, invoice.ErrCreditNoteConflict):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, invoice.ErrPaymentReversed),
+		errors.Is(err, invoice.ErrCreditExceedsInvoice),
+		errors.Is(err, invoice.ErrItemCancelled),
+		errors.Is(err, invoice.ErrInvoiceCancelled):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, invoice.ErrTenantMismatch):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	default:
+		// unexpected errors may describe internals of the storage
+		return status.Error(codes.Internal, "internal error")
+	}
+}
+
+// actorContext fails when the tenant cannot be resolved or is invalid.
+func (o options) actorContext(ctx context.Context) (context.Context, error) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if actors := md.Get(ActorKey); len(actors) > 0 && actors[0] != "" {
+			ctx = invoice.WithActor(ctx, actors[0])
+		}
+	}
+	if o.tenant == nil {
+		return ctx, nil
+	}
+
+	tenant, err := o.tenant(ctx)
+	if err == nil && tenant != "" {
+		err = invoice.ValidateTenant(tenant)
+	}
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		return nil, toStatusError(err)
+	}
+	if tenant != "" {
+		ctx = invoice.WithTenant(ctx, tenant)
+	}
+	return ctx, nil
+}
+
+func (o options) unaryActor(
+	ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+	ctx, err := o.actorContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+type actorStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *actorStream) Context() context.Context {
+	return s.ctx
+}
+
+func (o options) streamActor(
+	srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+
+	ctx, err := o.actorContext(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &actorStream{ServerStream: ss, ctx: ctx})
+}
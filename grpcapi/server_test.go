@@ -0,0 +1,342 @@
+package grpcapi_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/antklim/go-dynamodb/grpcapi"
+	"github.com/antklim/go-dynamodb/grpcapi/invoicepb"
+	"github.com/antklim/go-dynamodb/invoice"
+	"github.com/antklim/go-dynamodb/invoice/invoiceiface"
+	"github.com/antklim/go-dynamodb/memory"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var now = time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+
+func newClient(t *testing.T) (*grpcapi.InProcessClient, *memory.Repository) {
+	t.Helper()
+	repo := memory.NewRepository(memory.WithClock(invoice.FixedClock(now)))
+	client, err := grpcapi.NewInProcessClient(context.Background(), invoice.NewService(repo))
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	return client, repo
+}
+
+func TestInvoiceService(t *testing.T) {
+	client, repo := newClient(t)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), grpcapi.ActorKey, "tester")
+
+	invoiceID := uuid.NewString()
+	inv, err := client.StoreInvoice(ctx, &invoicepb.StoreInvoiceRequest{
+		Invoice: &invoicepb.Invoice{
+			Id:   invoiceID,
+			Date: timestamppb.New(now),
+			Items: []*invoicepb.Item{
+				{Id: "i1", InvoiceId: invoiceID, Price: 100, Qty: 2, Status: invoicepb.Status_NEW},
+			},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, invoiceID, inv.GetId())
+	assert.Equal(t, uint64(200), inv.GetTotal())
+	assert.Equal(t, now, inv.GetCreatedAt().AsTime())
+
+	_, err = client.GetInvoice(ctx, &invoicepb.GetInvoiceRequest{InvoiceId: uuid.NewString()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.AddItem(ctx, &invoicepb.AddItemRequest{Item: &invoicepb.Item{Id: "i2", InvoiceId: invoiceID}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	item, err := client.AddItem(ctx, &invoicepb.AddItemRequest{
+		Item: &invoicepb.Item{Id: "i2", InvoiceId: invoiceID, Qty: 1, Price: 10, Status: invoicepb.Status_NEW},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "i2", item.GetId())
+
+	qty, version := uint64(3), uint64(5)
+	_, err = client.UpdateItem(ctx, &invoicepb.UpdateItemRequest{
+		InvoiceId: invoiceID, ItemId: "i1", Qty: &qty, ExpectedVersion: &version,
+	})
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	_, err = client.UpdateItem(ctx, &invoicepb.UpdateItemRequest{InvoiceId: invoiceID, ItemId: "i1", Qty: &qty})
+	require.NoError(t, err)
+
+	_, err = client.UpdateInvoiceItemsStatus(ctx, &invoicepb.UpdateInvoiceItemsStatusRequest{
+		InvoiceId: invoiceID, Status: invoicepb.Status_STATUS_UNSPECIFIED,
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.ReplaceItems(ctx, &invoicepb.ReplaceItemsRequest{
+		InvoiceId:  invoiceID,
+		OldItemIds: []string{"i1"},
+		NewItems: []*invoicepb.Item{
+			{Id: "i3", InvoiceId: uuid.NewString(), Qty: 1, Status: invoicepb.Status_NEW},
+		},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "new items of other invoice")
+
+	_, err = client.CancelItem(ctx, &invoicepb.ItemRef{InvoiceId: invoiceID, ItemId: "i2"})
+	require.NoError(t, err)
+
+	got, err := client.GetItem(ctx, &invoicepb.GetItemRequest{InvoiceId: invoiceID, ItemId: "i1"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), got.GetQty())
+
+	product, err := client.GetItemProduct(ctx, &invoicepb.GetItemRequest{InvoiceId: invoiceID, ItemId: "i1"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(100), product.GetPrice())
+
+	_, err = client.CancelInvoice(ctx, &invoicepb.CancelInvoiceRequest{InvoiceId: invoiceID})
+	require.NoError(t, err)
+	cancelled, err := client.GetInvoice(ctx, &invoicepb.GetInvoiceRequest{InvoiceId: invoiceID})
+	require.NoError(t, err)
+	assert.Equal(t, invoicepb.Status_CANCELLED, cancelled.GetStatus())
+	_, err = client.CancelInvoice(ctx, &invoicepb.CancelInvoiceRequest{InvoiceId: uuid.NewString()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	changes, err := repo.GetInvoiceHistory(context.Background(), invoiceID)
+	require.NoError(t, err)
+	require.NotEmpty(t, changes)
+	for _, c := range changes {
+		assert.Equal(t, "tester", c.Actor)
+	}
+}
+
+// failingService fails every call with the error describing storage internals.
+type failingService struct {
+	invoiceiface.Service
+}
+
+func (failingService) GetInvoice(context.Context, string, ...invoice.ReadOption) (*invoice.Invoice, error) {
+	return nil, errors.New("dynamodb: table invoices-prod is throttled")
+}
+
+func TestInternalError(t *testing.T) {
+	client, err := grpcapi.NewInProcessClient(context.Background(), failingService{})
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = client.GetInvoice(context.Background(), &invoicepb.GetInvoiceRequest{InvoiceId: uuid.NewString()})
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.NotContains(t, status.Convert(err).Message(), "invoices-prod")
+}
+
+func TestTenant(t *testing.T) {
+	repo := memory.NewRepository(memory.WithClock(invoice.FixedClock(now)))
+	svc := invoice.NewService(repo)
+	newTenantClient := func(t *testing.T, opts ...grpcapi.Option) *grpcapi.InProcessClient {
+		t.Helper()
+		client, err := grpcapi.NewInProcessClient(context.Background(), svc, opts...)
+		require.NoError(t, err)
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+	store := func(t *testing.T, client *grpcapi.InProcessClient, tenant string) (string, error) {
+		t.Helper()
+		ctx := metadata.AppendToOutgoingContext(context.Background(), grpcapi.TenantKey, tenant)
+		invoiceID := uuid.NewString()
+		_, err := client.StoreInvoice(ctx, &invoicepb.StoreInvoiceRequest{
+			Invoice: &invoicepb.Invoice{Id: invoiceID, Date: timestamppb.New(now)},
+		})
+		return invoiceID, err
+	}
+
+	t.Run("ignores tenant metadata by default", func(t *testing.T) {
+		invoiceID, err := store(t, newTenantClient(t), "acme")
+		require.NoError(t, err)
+		inv, err := repo.GetInvoice(context.Background(), invoiceID)
+		require.NoError(t, err)
+		assert.NotNil(t, inv, "invoice is stored without tenant")
+	})
+
+	t.Run("scopes calls to the server tenant", func(t *testing.T) {
+		invoiceID, err := store(t, newTenantClient(t, grpcapi.WithTenant("acme")), "globex")
+		require.NoError(t, err)
+		inv, err := repo.GetInvoice(invoice.WithTenant(context.Background(), "acme"), invoiceID)
+		require.NoError(t, err)
+		assert.NotNil(t, inv)
+	})
+
+	t.Run("returns status errors of tenant func", func(t *testing.T) {
+		client := newTenantClient(t, grpcapi.WithTenantFunc(func(context.Context) (string, error) {
+			return "", status.Error(codes.Unauthenticated, "no credentials")
+		}))
+		_, err := store(t, client, "acme")
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("rejects invalid tenants", func(t *testing.T) {
+		client := newTenantClient(t, grpcapi.WithTenantFunc(grpcapi.TenantFromMetadata))
+		for _, tenant := range []string{"", "acme#x"} {
+			_, err := store(t, client, tenant)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err), "tenant %q", tenant)
+		}
+	})
+}
+
+func TestListItems(t *testing.T) {
+	client, _ := newClient(t)
+	ctx := context.Background()
+
+	invoiceID := uuid.NewString()
+	_, err := client.StoreInvoice(ctx, &invoicepb.StoreInvoiceRequest{
+		Invoice: &invoicepb.Invoice{
+			Id:   invoiceID,
+			Date: timestamppb.New(now),
+			Items: []*invoicepb.Item{
+				{Id: "i1", InvoiceId: invoiceID, Qty: 1, Status: invoicepb.Status_NEW},
+				{Id: "i2", InvoiceId: invoiceID, Qty: 1, Status: invoicepb.Status_PENDING},
+				{Id: "i3", InvoiceId: invoiceID, Qty: 1, Status: invoicepb.Status_PENDING},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	type receiver interface {
+		Recv() (*invoicepb.Item, error)
+	}
+	receive := func(stream receiver) []string {
+		t.Helper()
+		var ids []string
+		for {
+			item, err := stream.Recv()
+			if err == io.EOF {
+				return ids
+			}
+			require.NoError(t, err)
+			ids = append(ids, item.GetId())
+		}
+	}
+
+	byStatus, err := client.ListItemsByStatus(ctx, &invoicepb.ListItemsByStatusRequest{Status: invoicepb.Status_PENDING})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"i2", "i3"}, receive(byStatus))
+
+	all, err := client.ListInvoiceItems(ctx, &invoicepb.ListInvoiceItemsRequest{InvoiceId: invoiceID})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"i1", "i2", "i3"}, receive(all))
+
+	filtered, err := client.ListInvoiceItems(ctx, &invoicepb.ListInvoiceItemsRequest{
+		InvoiceId: invoiceID, Status: invoicepb.Status_NEW,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"i1"}, receive(filtered))
+
+	invalid, err := client.ListItemsByStatus(ctx, &invoicepb.ListItemsByStatusRequest{})
+	require.NoError(t, err)
+	_, err = invalid.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestPaymentsAndCreditNotes(t *testing.T) {
+	client, _ := newClient(t)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), grpcapi.ActorKey, "tester")
+
+	invoiceID := uuid.NewString()
+	_, err := client.StoreInvoice(ctx, &invoicepb.StoreInvoiceRequest{
+		Invoice: &invoicepb.Invoice{
+			Id:   invoiceID,
+			Date: timestamppb.New(now),
+			Items: []*invoicepb.Item{
+				{Id: "i1", InvoiceId: invoiceID, Price: 100, Qty: 2, Status: invoicepb.Status_NEW},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	_, err = client.RecordPayment(ctx, &invoicepb.RecordPaymentRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	paymentID := uuid.NewString()
+	_, err = client.RecordPayment(ctx, &invoicepb.RecordPaymentRequest{
+		Payment: &invoicepb.Payment{Id: paymentID, InvoiceId: invoiceID, Amount: 150, Method: "card"},
+	})
+	require.NoError(t, err)
+
+	balance, err := client.GetBalance(ctx, &invoicepb.GetBalanceRequest{InvoiceId: invoiceID})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(200), balance.GetTotal())
+	assert.Equal(t, uint64(150), balance.GetPaid())
+	assert.Equal(t, int64(50), balance.GetOutstanding())
+
+	_, err = client.ReversePayment(ctx, &invoicepb.PaymentRef{InvoiceId: invoiceID, PaymentId: paymentID})
+	require.NoError(t, err)
+	_, err = client.ReversePayment(ctx, &invoicepb.PaymentRef{InvoiceId: invoiceID, PaymentId: paymentID})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	payments, err := client.ListPayments(ctx, &invoicepb.ListPaymentsRequest{InvoiceId: invoiceID})
+	require.NoError(t, err)
+	payment, err := payments.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, paymentID, payment.GetId())
+	assert.Equal(t, now, payment.GetReversedAt().AsTime())
+	_, err = payments.Recv()
+	assert.Equal(t, io.EOF, err)
+
+	creditNoteID := uuid.NewString()
+	cn, err := client.IssueCreditNote(ctx, &invoicepb.IssueCreditNoteRequest{
+		CreditNote: &invoicepb.CreditNote{
+			Id:        creditNoteID,
+			InvoiceId: invoiceID,
+			Lines:     []*invoicepb.CreditNoteLine{{ItemId: "i1", Qty: 1}},
+		},
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, cn.GetNumber())
+	assert.Equal(t, uint64(100), cn.GetLines()[0].GetAmount())
+
+	_, err = client.IssueCreditNote(ctx, &invoicepb.IssueCreditNoteRequest{
+		CreditNote: &invoicepb.CreditNote{
+			Id:        uuid.NewString(),
+			InvoiceId: invoiceID,
+			Lines:     []*invoicepb.CreditNoteLine{{ItemId: "i1", Qty: 2}},
+		},
+	})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "credit exceeds invoice")
+
+	got, err := client.GetCreditNote(ctx, &invoicepb.GetCreditNoteRequest{
+		InvoiceId: invoiceID, CreditNoteId: creditNoteID,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, cn.GetNumber(), got.GetNumber())
+	_, err = client.GetCreditNote(ctx, &invoicepb.GetCreditNoteRequest{
+		InvoiceId: invoiceID, CreditNoteId: uuid.NewString(),
+	})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	creditNotes, err := client.ListCreditNotes(ctx, &invoicepb.ListCreditNotesRequest{InvoiceId: invoiceID})
+	require.NoError(t, err)
+	listed, err := creditNotes.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, creditNoteID, listed.GetId())
+
+	history, err := client.ListInvoiceHistory(ctx, &invoicepb.ListInvoiceHistoryRequest{InvoiceId: invoiceID})
+	require.NoError(t, err)
+	var operations []string
+	for {
+		c, err := history.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		assert.Equal(t, "tester", c.GetActor())
+		operations = append(operations, c.GetOperation())
+	}
+	assert.Equal(t, []string{
+		string(invoice.OpAddInvoice),
+		string(invoice.OpAddPayment),
+		string(invoice.OpReversePayment),
+		string(invoice.OpAddCreditNote),
+	}, operations)
+}
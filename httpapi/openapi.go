@@ -0,0 +1,176 @@
+package httpapi
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// OpenAPI returns the OpenAPI 3 document of the API. The document is built
+// from the route table, request and response schemas are derived from the
+// types of the route bodies.
+func (s *Server) OpenAPI() map[string]interface{} {
+	schemas := map[string]interface{}{}
+	errorRef := schemaOf(reflect.TypeOf(ErrorResponse{}), schemas)
+
+	paths := map[string]interface{}{}
+	for _, rt := range s.routes {
+		op := map[string]interface{}{
+			"summary":   rt.summary,
+			"responses": responses(rt, schemas, errorRef),
+		}
+		if p := parameters(rt); len(p) > 0 {
+			op["parameters"] = p
+		}
+		if rt.body != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(schemaOf(reflect.TypeOf(rt.body), schemas)),
+			}
+		}
+
+		path, ok := paths[rt.pattern].(map[string]interface{})
+		if !ok {
+			path = map[string]interface{}{}
+			paths[rt.pattern] = path
+		}
+		path[strings.ToLower(rt.method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Invoice API",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+		},
+	}
+}
+
+func parameters(rt route) []interface{} {
+	var params []interface{}
+	for _, seg := range splitPath(rt.pattern) {
+		if !isParam(seg) {
+			continue
+		}
+		params = append(params, map[string]interface{}{
+			"name":     strings.Trim(seg, "{}"),
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "string"},
+		})
+	}
+
+	query := rt.query
+	if rt.paged {
+		query = append(append([]queryParam{}, query...), pageQuery...)
+	}
+	for _, q := range query {
+		params = append(params, map[string]interface{}{
+			"name":        q.name,
+			"in":          "query",
+			"description": q.description,
+			"required":    q.required,
+			"schema":      map[string]interface{}{"type": "string"},
+		})
+	}
+
+	return params
+}
+
+func responses(rt route, schemas map[string]interface{}, errorRef interface{}) map[string]interface{} {
+	success := map[string]interface{}{"description": http.StatusText(rt.status)}
+	if rt.resp != nil {
+		schema := schemaOf(reflect.TypeOf(rt.resp), schemas)
+		if rt.paged {
+			schema = map[string]interface{}{
+				"type":     "object",
+				"required": []string{"Items"},
+				"properties": map[string]interface{}{
+					"Items":      schema,
+					"NextCursor": map[string]interface{}{"type": "string"},
+				},
+			}
+		}
+		success["content"] = jsonContent(schema)
+	}
+
+	failure := map[string]interface{}{
+		"description": "Error",
+		"content":     jsonContent(errorRef),
+	}
+
+	return map[string]interface{}{
+		strconv.Itoa(rt.status): success,
+		"default":               failure,
+	}
+}
+
+func jsonContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+	}
+}
+
+// schemaOf returns the schema of type t. Named structs are added to schemas
+// and referenced.
+func schemaOf(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaOf(t.Elem(), schemas)
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		if _, ok := schemas[t.Name()]; !ok {
+			schemas[t.Name()] = map[string]interface{}{} // placeholder breaks reference cycles
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func structSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	props := map[string]interface{}{}
+	for idx := 0; idx < t.NumField(); idx++ {
+		f := t.Field(idx)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag := strings.Split(f.Tag.Get("json"), ","); tag[0] == "-" {
+			continue
+		} else if tag[0] != "" {
+			name = tag[0]
+		}
+		props[name] = schemaOf(f.Type, schemas)
+	}
+	return map[string]interface{}{"type": "object", "properties": props}
+}
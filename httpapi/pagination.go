@@ -0,0 +1,101 @@
+package httpapi
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strconv"
+
+	"github.com/antklim/go-dynamodb/invoice"
+)
+
+const (
+	defaultLimit = 25
+	maxLimit     = 100
+)
+
+// Page is the body of list responses. NextCursor is empty on the last page.
+type Page struct {
+	Items      interface{}
+	NextCursor string `json:",omitempty"`
+}
+
+// pageQuery documents query parameters of paged routes.
+var pageQuery = []queryParam{
+	{name: "limit", description: "maximum number of returned entries, 1-100, defaults to 25"},
+	{name: "cursor", description: "NextCursor of the previous page"},
+}
+
+// pageParams describes requested page. Cursors encode offset of the page,
+// so they work the same for every repository.
+type pageParams struct {
+	limit  int
+	offset int
+}
+
+func parsePage(r *http.Request) (pageParams, error) {
+	pg := pageParams{limit: defaultLimit}
+	q := r.URL.Query()
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxLimit {
+			return pageParams{}, badRequest("limit must be a number from 1 to %d", maxLimit)
+		}
+		pg.limit = limit
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(v)
+		if err != nil {
+			return pageParams{}, badRequest("invalid cursor")
+		}
+		offset, err := strconv.Atoi(string(raw))
+		if err != nil || offset < 0 {
+			return pageParams{}, badRequest("invalid cursor")
+		}
+		pg.offset = offset
+	}
+
+	return pg, nil
+}
+
+func cursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+// bounds returns the page of a list of n entries.
+func (pg pageParams) bounds(n int) (start, end int, next string) {
+	start = pg.offset
+	if start > n {
+		start = n
+	}
+	end = start + pg.limit
+	if end >= n {
+		return start, n, ""
+	}
+	return start, end, cursor(end)
+}
+
+// itemsPage reads the page of items from the iterator and closes it.
+func itemsPage(it invoice.ItemIterator, pg pageParams) (*Page, error) {
+	defer it.Close()
+
+	items := []invoice.Item{}
+	next := ""
+	for idx := 0; it.Next(); idx++ {
+		if idx < pg.offset {
+			continue
+		}
+		if len(items) == pg.limit {
+			next = cursor(idx)
+			break
+		}
+		items = append(items, it.Item())
+	}
+
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	return &Page{Items: items, NextCursor: next}, nil
+}
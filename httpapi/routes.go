@@ -0,0 +1,448 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/antklim/go-dynamodb/invoice"
+)
+
+// StatusUpdate is the request body of items status update.
+type StatusUpdate struct {
+	Status invoice.Status
+}
+
+// ItemsReplacement is the request body of items replacement.
+type ItemsReplacement struct {
+	OldItemIDs []string
+	NewItems   []invoice.Item
+}
+
+var (
+	statusQuery = queryParam{
+		name:        "status",
+		description: "item status: NEW, PENDING or CANCELLED",
+	}
+	includeDeletedQuery = queryParam{
+		name:        "includeDeleted",
+		description: "return deleted items when true",
+	}
+)
+
+func (s *Server) serviceRoutes() []route {
+	return []route{
+		{
+			method: http.MethodPost, pattern: "/invoices", summary: "Store invoice with its items",
+			status: http.StatusCreated, body: invoice.Invoice{}, resp: invoice.Invoice{},
+			handle: s.storeInvoice,
+		},
+		{
+			method: http.MethodGet, pattern: "/invoices/{invoiceId}", summary: "Get invoice",
+			status: http.StatusOK, resp: invoice.Invoice{},
+			handle: s.getInvoice,
+		},
+		{
+			method: http.MethodPost, pattern: "/invoices/{invoiceId}/cancel", summary: "Cancel invoice and its items",
+			status: http.StatusNoContent,
+			handle: s.cancelInvoice,
+		},
+		{
+			method: http.MethodGet, pattern: "/invoices/{invoiceId}/history", summary: "List changes of invoice",
+			status: http.StatusOK, resp: []invoice.Change{}, paged: true,
+			handle: s.getInvoiceHistory,
+		},
+		{
+			method: http.MethodPost, pattern: "/invoices/{invoiceId}/items/replace",
+			summary: "Cancel old items and add new items",
+			status:  http.StatusNoContent, body: ItemsReplacement{},
+			handle: s.replaceItems,
+		},
+		{
+			method: http.MethodPut, pattern: "/invoices/{invoiceId}/items/status", summary: "Set status of invoice items",
+			status: http.StatusNoContent, body: StatusUpdate{},
+			handle: s.updateInvoiceItemsStatus,
+		},
+		{
+			method: http.MethodGet, pattern: "/invoices/{invoiceId}/items", summary: "List invoice items",
+			status: http.StatusOK, resp: []invoice.Item{},
+			query: []queryParam{statusQuery, includeDeletedQuery}, paged: true,
+			handle: s.getInvoiceItems,
+		},
+		{
+			method: http.MethodPost, pattern: "/invoices/{invoiceId}/items", summary: "Add invoice item",
+			status: http.StatusCreated, body: invoice.Item{}, resp: invoice.Item{},
+			handle: s.addItem,
+		},
+		{
+			method: http.MethodGet, pattern: "/invoices/{invoiceId}/items/{itemId}", summary: "Get invoice item",
+			status: http.StatusOK, resp: invoice.Item{}, query: []queryParam{includeDeletedQuery},
+			handle: s.getItem,
+		},
+		{
+			method: http.MethodPatch, pattern: "/invoices/{invoiceId}/items/{itemId}",
+			summary: "Change name, quantity or price of invoice item",
+			status:  http.StatusNoContent, body: invoice.ItemUpdate{},
+			handle: s.updateItem,
+		},
+		{
+			method: http.MethodDelete, pattern: "/invoices/{invoiceId}/items/{itemId}", summary: "Delete invoice item",
+			status: http.StatusNoContent,
+			handle: s.deleteItem,
+		},
+		{
+			method: http.MethodPost, pattern: "/invoices/{invoiceId}/items/{itemId}/restore",
+			summary: "Restore deleted invoice item",
+			status:  http.StatusNoContent,
+			handle:  s.restoreItem,
+		},
+		{
+			method: http.MethodPost, pattern: "/invoices/{invoiceId}/items/{itemId}/cancel",
+			summary: "Cancel invoice item",
+			status:  http.StatusNoContent,
+			handle:  s.cancelInvoiceItem,
+		},
+		{
+			method: http.MethodGet, pattern: "/invoices/{invoiceId}/items/{itemId}/product",
+			summary: "Get product of invoice item",
+			status:  http.StatusOK, resp: invoice.Product{}, query: []queryParam{includeDeletedQuery},
+			handle: s.getItemProduct,
+		},
+		{
+			method: http.MethodGet, pattern: "/items", summary: "List items of all invoices by status",
+			status: http.StatusOK, resp: []invoice.Item{},
+			query: []queryParam{
+				{name: statusQuery.name, description: statusQuery.description, required: true},
+				includeDeletedQuery,
+			},
+			paged:  true,
+			handle: s.getItemsByStatus,
+		},
+		{
+			method: http.MethodPost, pattern: "/invoices/{invoiceId}/payments", summary: "Record payment",
+			status: http.StatusNoContent, body: invoice.Payment{},
+			handle: s.recordPayment,
+		},
+		{
+			method: http.MethodGet, pattern: "/invoices/{invoiceId}/payments", summary: "List payments of invoice",
+			status: http.StatusOK, resp: []invoice.Payment{}, paged: true,
+			handle: s.getPayments,
+		},
+		{
+			method: http.MethodPost, pattern: "/invoices/{invoiceId}/payments/{paymentId}/reverse",
+			summary: "Reverse payment",
+			status:  http.StatusNoContent,
+			handle:  s.reversePayment,
+		},
+		{
+			method: http.MethodGet, pattern: "/invoices/{invoiceId}/balance", summary: "Get invoice balance",
+			status: http.StatusOK, resp: invoice.Balance{},
+			handle: s.getBalance,
+		},
+		{
+			method: http.MethodPost, pattern: "/invoices/{invoiceId}/credit-notes", summary: "Issue credit note",
+			status: http.StatusCreated, body: invoice.CreditNote{}, resp: invoice.CreditNote{},
+			handle: s.issueCreditNote,
+		},
+		{
+			method: http.MethodGet, pattern: "/invoices/{invoiceId}/credit-notes",
+			summary: "List credit notes of invoice",
+			status:  http.StatusOK, resp: []invoice.CreditNote{}, paged: true,
+			handle: s.getCreditNotes,
+		},
+		{
+			method: http.MethodGet, pattern: "/invoices/{invoiceId}/credit-notes/{creditNoteId}",
+			summary: "Get credit note",
+			status:  http.StatusOK, resp: invoice.CreditNote{},
+			handle: s.getCreditNote,
+		},
+	}
+}
+
+func (s *Server) storeInvoice(r *http.Request, _ params) (interface{}, error) {
+	var inv invoice.Invoice
+	if err := decode(r, &inv); err != nil {
+		return nil, err
+	}
+
+	if err := s.svc.StoreInvoice(r.Context(), inv); err != nil {
+		return nil, err
+	}
+	return s.svc.GetInvoice(r.Context(), inv.ID)
+}
+
+func (s *Server) getInvoice(r *http.Request, p params) (interface{}, error) {
+	inv, err := s.svc.GetInvoice(r.Context(), p["invoiceId"])
+	if err != nil {
+		return nil, err
+	}
+	if inv == nil {
+		return nil, invoice.ErrInvoiceNotFound
+	}
+	return inv, nil
+}
+
+func (s *Server) cancelInvoice(r *http.Request, p params) (interface{}, error) {
+	return nil, s.svc.CancelInvoice(r.Context(), p["invoiceId"])
+}
+
+func (s *Server) getInvoiceHistory(r *http.Request, p params) (interface{}, error) {
+	pg, err := parsePage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := s.svc.GetInvoiceHistory(r.Context(), p["invoiceId"])
+	if err != nil {
+		return nil, err
+	}
+
+	start, end, next := pg.bounds(len(changes))
+	return &Page{Items: append([]invoice.Change{}, changes[start:end]...), NextCursor: next}, nil
+}
+
+func (s *Server) replaceItems(r *http.Request, p params) (interface{}, error) {
+	var req ItemsReplacement
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+
+	invoiceID := p["invoiceId"]
+	for idx := range req.NewItems {
+		if err := pathMatches("InvoiceID", &req.NewItems[idx].InvoiceID, invoiceID); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, s.svc.ReplaceItems(r.Context(), invoiceID, req.OldItemIDs, req.NewItems)
+}
+
+func (s *Server) updateInvoiceItemsStatus(r *http.Request, p params) (interface{}, error) {
+	var req StatusUpdate
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+	if !validStatus(req.Status) {
+		return nil, badRequest("unknown status %q", req.Status)
+	}
+
+	return nil, s.svc.UpdateInvoiceItemsStatus(r.Context(), p["invoiceId"], req.Status)
+}
+
+func (s *Server) getInvoiceItems(r *http.Request, p params) (interface{}, error) {
+	pg, err := parsePage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := readOptions(r)
+	if err != nil {
+		return nil, err
+	}
+
+	status := invoice.Status(r.URL.Query().Get("status"))
+	if status == "" {
+		return itemsPage(s.svc.IterateInvoiceItems(r.Context(), p["invoiceId"], opts...), pg)
+	}
+	if !validStatus(status) {
+		return nil, badRequest("unknown status %q", status)
+	}
+
+	items, err := s.svc.GetInvoiceItemsByStatus(r.Context(), p["invoiceId"], status, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	start, end, next := pg.bounds(len(items))
+	return &Page{Items: append([]invoice.Item{}, items[start:end]...), NextCursor: next}, nil
+}
+
+func (s *Server) addItem(r *http.Request, p params) (interface{}, error) {
+	var item invoice.Item
+	if err := decode(r, &item); err != nil {
+		return nil, err
+	}
+	if err := pathMatches("InvoiceID", &item.InvoiceID, p["invoiceId"]); err != nil {
+		return nil, err
+	}
+
+	if err := s.svc.AddItem(r.Context(), item); err != nil {
+		return nil, err
+	}
+	return s.svc.GetItem(r.Context(), item.InvoiceID, item.ID)
+}
+
+func (s *Server) getItem(r *http.Request, p params) (interface{}, error) {
+	opts, err := readOptions(r)
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := s.svc.GetItem(r.Context(), p["invoiceId"], p["itemId"], opts...)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return nil, invoice.ErrItemNotFound
+	}
+	return item, nil
+}
+
+func (s *Server) updateItem(r *http.Request, p params) (interface{}, error) {
+	var upd invoice.ItemUpdate
+	if err := decode(r, &upd); err != nil {
+		return nil, err
+	}
+
+	return nil, s.svc.UpdateItem(r.Context(), p["invoiceId"], p["itemId"], upd)
+}
+
+func (s *Server) deleteItem(r *http.Request, p params) (interface{}, error) {
+	return nil, s.svc.DeleteItem(r.Context(), p["invoiceId"], p["itemId"])
+}
+
+func (s *Server) restoreItem(r *http.Request, p params) (interface{}, error) {
+	return nil, s.svc.RestoreItem(r.Context(), p["invoiceId"], p["itemId"])
+}
+
+func (s *Server) cancelInvoiceItem(r *http.Request, p params) (interface{}, error) {
+	return nil, s.svc.CancelInvoiceItem(r.Context(), p["invoiceId"], p["itemId"])
+}
+
+func (s *Server) getItemProduct(r *http.Request, p params) (interface{}, error) {
+	opts, err := readOptions(r)
+	if err != nil {
+		return nil, err
+	}
+
+	product, err := s.svc.GetItemProduct(r.Context(), p["invoiceId"], p["itemId"], opts...)
+	if err != nil {
+		return nil, err
+	}
+	if product == nil {
+		return nil, invoice.ErrItemNotFound
+	}
+	return product, nil
+}
+
+func (s *Server) getItemsByStatus(r *http.Request, _ params) (interface{}, error) {
+	pg, err := parsePage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := readOptions(r)
+	if err != nil {
+		return nil, err
+	}
+
+	status := invoice.Status(r.URL.Query().Get("status"))
+	if !validStatus(status) {
+		return nil, badRequest("status must be one of NEW, PENDING, CANCELLED")
+	}
+
+	return itemsPage(s.svc.IterateItemsByStatus(r.Context(), status, opts...), pg)
+}
+
+func (s *Server) recordPayment(r *http.Request, p params) (interface{}, error) {
+	var payment invoice.Payment
+	if err := decode(r, &payment); err != nil {
+		return nil, err
+	}
+	if err := pathMatches("InvoiceID", &payment.InvoiceID, p["invoiceId"]); err != nil {
+		return nil, err
+	}
+
+	return nil, s.svc.RecordPayment(r.Context(), payment)
+}
+
+func (s *Server) getPayments(r *http.Request, p params) (interface{}, error) {
+	pg, err := parsePage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	payments, err := s.svc.GetPayments(r.Context(), p["invoiceId"])
+	if err != nil {
+		return nil, err
+	}
+
+	start, end, next := pg.bounds(len(payments))
+	return &Page{Items: append([]invoice.Payment{}, payments[start:end]...), NextCursor: next}, nil
+}
+
+func (s *Server) reversePayment(r *http.Request, p params) (interface{}, error) {
+	return nil, s.svc.ReversePayment(r.Context(), p["invoiceId"], p["paymentId"])
+}
+
+func (s *Server) getBalance(r *http.Request, p params) (interface{}, error) {
+	return s.svc.GetBalance(r.Context(), p["invoiceId"])
+}
+
+func (s *Server) issueCreditNote(r *http.Request, p params) (interface{}, error) {
+	var cn invoice.CreditNote
+	if err := decode(r, &cn); err != nil {
+		return nil, err
+	}
+	if err := pathMatches("InvoiceID", &cn.InvoiceID, p["invoiceId"]); err != nil {
+		return nil, err
+	}
+
+	return s.svc.IssueCreditNote(r.Context(), cn)
+}
+
+func (s *Server) getCreditNotes(r *http.Request, p params) (interface{}, error) {
+	pg, err := parsePage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	creditNotes, err := s.svc.GetCreditNotes(r.Context(), p["invoiceId"])
+	if err != nil {
+		return nil, err
+	}
+
+	start, end, next := pg.bounds(len(creditNotes))
+	return &Page{Items: append([]invoice.CreditNote{}, creditNotes[start:end]...), NextCursor: next}, nil
+}
+
+func (s *Server) getCreditNote(r *http.Request, p params) (interface{}, error) {
+	cn, err := s.svc.GetCreditNote(r.Context(), p["invoiceId"], p["creditNoteId"])
+	if err != nil {
+		return nil, err
+	}
+	if cn == nil {
+		return nil, invoice.ErrCreditNoteNotFound
+	}
+	return cn, nil
+}
+
+// pathMatches sets empty body field to the path value, or fails when they differ.
+func pathMatches(field string, value *string, pathValue string) error {
+	switch *value {
+	case "":
+		*value = pathValue
+		return nil
+	case pathValue:
+		return nil
+	default:
+		return badRequest("%s must match the path", field)
+	}
+}
+
+func readOptions(r *http.Request) ([]invoice.ReadOption, error) {
+	switch r.URL.Query().Get("includeDeleted") {
+	case "", "false":
+		return nil, nil
+	case "true":
+		return []invoice.ReadOption{invoice.IncludeDeleted()}, nil
+	default:
+		return nil, badRequest("includeDeleted must be true or false")
+	}
+}
+
+func validStatus(status invoice.Status) bool {
+	switch status {
+	case invoice.New, invoice.Pending, invoice.Cancelled:
+		return true
+	}
+	return false
+}
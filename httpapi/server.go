@@ -0,0 +1,299 @@
+// Package httpapi exposes invoice service operations as JSON REST endpoints.
+// Domain types are encoded as they are, the same way as data fixtures.
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/antklim/go-dynamodb/invoice"
+	"github.com/antklim/go-dynamodb/invoice/invoiceiface"
+)
+
+const (
+	// ActorHeader carries the actor recorded in the invoice history.
+	ActorHeader = "X-Actor"
+	// TenantHeader carries the tenant the request is scoped to, see
+	// TenantFromHeader.
+	TenantHeader = "X-Tenant"
+)
+
+// params holds values of the path parameters.
+type params map[string]string
+
+// handler serves the request and returns the response body, nil body means
+// the response has no content.
+type handler func(r *http.Request, p params) (interface{}, error)
+
+// route describes an endpoint. Besides serving requests it documents the
+// endpoint in the OpenAPI document.
+type route struct {
+	method  string
+	pattern string // path segments, parameters are in braces, e.g. /invoices/{invoiceId}
+	summary string
+	status  int         // status of successful response
+	body    interface{} // request body, nil when request has no body
+	resp    interface{} // response body, nil when response has no content
+	paged   bool        // response is a Page of resp entries, resp is a slice
+	query   []queryParam
+	handle  handler
+}
+
+type queryParam struct {
+	name        string
+	description string
+	required    bool
+}
+
+// ErrorResponse is the body of failed responses.
+type ErrorResponse struct {
+	Error  string
+	Fields []invoice.FieldError `json:",omitempty"` // invalid fields of the request
+}
+
+// requestError describes invalid request.
+type requestError struct {
+	msg string
+}
+
+func (e *requestError) Error() string {
+	return e.msg
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &requestError{msg: fmt.Sprintf(format, args...)}
+}
+
+// TenantFunc returns the tenant the request is scoped to, empty tenant leaves
+// the request unscoped. The tenant must come from the authenticated identity
+// of the request, e.g. claims of a verified token, never from values chosen
+// by the client.
+type TenantFunc func(r *http.Request) (string, error)
+
+// TenantFromHeader returns the tenant of TenantHeader. The header is set by
+// the client, so the server may use it only behind an authenticating proxy
+// that sets the header of authenticated requests and drops it from others.
+func TenantFromHeader(r *http.Request) (string, error) {
+	tenants := r.Header.Values(TenantHeader)
+	if len(tenants) == 0 {
+		return "", nil
+	}
+	if err := invoice.ValidateTenant(tenants[0]); err != nil {
+		return "", err
+	}
+	return tenants[0], nil
+}
+
+// Option configures Server.
+type Option func(*Server)
+
+// WithTenant scopes all requests to the tenant, e.g. when a server runs per
+// tenant.
+func WithTenant(tenant string) Option {
+	return WithTenantFunc(func(*http.Request) (string, error) {
+		return tenant, nil
+	})
+}
+
+// WithTenantFunc scopes requests to the tenant returned by f. Errors of f are
+// responded as service errors.
+func WithTenantFunc(f TenantFunc) Option {
+	return func(s *Server) {
+		s.tenant = f
+	}
+}
+
+// Server routes requests to the invoice service. Requests are not scoped to
+// tenants unless the server is created with WithTenant or WithTenantFunc.
+type Server struct {
+	svc    invoiceiface.Service
+	routes []route
+	tenant TenantFunc
+}
+
+// NewServer creates a new instance of HTTP server
+func NewServer(svc invoiceiface.Service, opts ...Option) *Server {
+	s := &Server{svc: svc}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.routes = s.serviceRoutes()
+	s.routes = append(s.routes, route{
+		method:  http.MethodGet,
+		pattern: "/openapi.json",
+		summary: "OpenAPI document of the API",
+		status:  http.StatusOK,
+		resp:    map[string]interface{}{},
+		handle: func(*http.Request, params) (interface{}, error) {
+			return s.OpenAPI(), nil
+		},
+	})
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt, p, allowed := s.match(r.Method, r.URL.Path)
+	if rt == nil {
+		if len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method not allowed"})
+			return
+		}
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "not found"})
+		return
+	}
+
+	if actor := r.Header.Get(ActorHeader); actor != "" {
+		r = r.WithContext(invoice.WithActor(r.Context(), actor))
+	}
+	if s.tenant != nil {
+		tenant, err := s.tenant(r)
+		if err == nil && tenant != "" {
+			err = invoice.ValidateTenant(tenant)
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if tenant != "" {
+			r = r.WithContext(invoice.WithTenant(r.Context(), tenant))
+		}
+	}
+
+	resp, err := rt.handle(r, p)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if resp == nil {
+		w.WriteHeader(rt.status)
+		return
+	}
+	writeJSON(w, rt.status, resp)
+}
+
+// match returns the route of the request. When the path matches routes of
+// other methods only, it returns their methods.
+func (s *Server) match(method, path string) (*route, params, []string) {
+	segments := splitPath(path)
+
+	var allowed []string
+	for idx := range s.routes {
+		rt := &s.routes[idx]
+		p, ok := matchPattern(splitPath(rt.pattern), segments)
+		if !ok {
+			continue
+		}
+		if rt.method != method {
+			allowed = append(allowed, rt.method)
+			continue
+		}
+		return rt, p, nil
+	}
+
+	return nil, nil, allowed
+}
+
+func matchPattern(pattern, segments []string) (params, bool) {
+	if len(pattern) != len(segments) {
+		return nil, false
+	}
+
+	p := params{}
+	for idx, seg := range pattern {
+		if isParam(seg) {
+			if segments[idx] == "" {
+				return nil, false
+			}
+			p[strings.Trim(seg, "{}")] = segments[idx]
+			continue
+		}
+		if seg != segments[idx] {
+			return nil, false
+		}
+	}
+
+	return p, true
+}
+
+func isParam(segment string) bool {
+	return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+// decode reads JSON request body into v. Unknown fields are rejected.
+func decode(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return badRequest("invalid request body: %v", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes the error response. Unexpected errors may describe
+// internals of the storage, so their message is not sent.
+func writeError(w http.ResponseWriter, err error) {
+	status := errorStatus(err)
+	resp := ErrorResponse{Error: err.Error()}
+	if status == http.StatusInternalServerError {
+		resp.Error = http.StatusText(status)
+	}
+
+	var verr *invoice.ValidationError
+	if errors.As(err, &verr) {
+		resp.Fields = verr.Fields
+	}
+
+	writeJSON(w, status, resp)
+}
+
+// errorStatus maps domain errors to response status codes.
+func errorStatus(err error) int {
+	var (
+		verr *invoice.ValidationError
+		rerr *requestError
+	)
+
+	switch {
+	case errors.As(err, &rerr), errors.As(err, &verr):
+		return http.StatusBadRequest
+	case errors.Is(err, invoice.ErrInvoiceNotFound),
+		errors.Is(err, invoice.ErrItemNotFound),
+		errors.Is(err, invoice.ErrPaymentNotFound),
+		errors.Is(err, invoice.ErrCreditNoteNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, invoice.ErrInvalidItemUpdate),
+		errors.Is(err, invoice.ErrInvalidInvoice),
+		errors.Is(err, invoice.ErrInvalidItem),
+		errors.Is(err, invoice.ErrInvalidPayment),
+		errors.Is(err, invoice.ErrInvalidCreditNote),
+		errors.Is(err, invoice.ErrInvalidTenant):
+		return http.StatusBadRequest
+	case errors.Is(err, invoice.ErrVersionConflict),
+		errors.Is(err, invoice.ErrItemConflict),
+		errors.Is(err, invoice.ErrCreditNoteConflict),
+		errors.Is(err, invoice.ErrPaymentReversed),
+		errors.Is(err, invoice.ErrCreditExceedsInvoice),
+		errors.Is(err, invoice.ErrItemCancelled),
+		errors.Is(err, invoice.ErrInvoiceCancelled):
+		return http.StatusConflict
+	case errors.Is(err, invoice.ErrTenantMismatch):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
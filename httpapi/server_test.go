@@ -0,0 +1,262 @@
+package httpapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antklim/go-dynamodb/httpapi"
+	"github.com/antklim/go-dynamodb/invoice"
+	"github.com/antklim/go-dynamodb/invoice/invoiceiface"
+	"github.com/antklim/go-dynamodb/memory"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+
+func newServer() *httptest.Server {
+	repo := memory.NewRepository(memory.WithClock(invoice.FixedClock(now)))
+	return httptest.NewServer(httpapi.NewServer(invoice.NewService(repo)))
+}
+
+func do(t *testing.T, srv *httptest.Server, method, path, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set(httpapi.ActorHeader, "tester")
+	resp, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func decode(t *testing.T, resp *http.Response, v interface{}) {
+	t.Helper()
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+}
+
+func TestInvoiceEndpoints(t *testing.T) {
+	srv := newServer()
+	defer srv.Close()
+
+	invoiceID := uuid.NewString()
+	body := fmt.Sprintf(`{"ID":%q,"Date":"2021-03-01T00:00:00Z","Items":[
+		{"ID":"i1","InvoiceID":%q,"Price":100,"Qty":2,"Status":"NEW"}]}`, invoiceID, invoiceID)
+
+	resp := do(t, srv, http.MethodPost, "/invoices", body)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var created invoice.Invoice
+	decode(t, resp, &created)
+	assert.Equal(t, invoiceID, created.ID)
+	assert.Equal(t, uint(200), created.Total)
+
+	resp = do(t, srv, http.MethodGet, "/invoices/"+invoiceID, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var got invoice.Invoice
+	decode(t, resp, &got)
+	assert.Equal(t, created.ID, got.ID)
+	assert.Equal(t, uint(1), got.ItemCount)
+
+	resp = do(t, srv, http.MethodGet, "/invoices/"+uuid.NewString(), "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = do(t, srv, http.MethodPost, "/invoices/"+invoiceID+"/items", `{"ID":"i2","Price":10}`)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	var errResp httpapi.ErrorResponse
+	decode(t, resp, &errResp)
+	assert.Equal(t, []invoice.FieldError{{Field: "qty", Message: "must be greater than zero"}}, errResp.Fields)
+
+	resp = do(t, srv, http.MethodPatch, "/invoices/"+invoiceID+"/items/i1", `{"Qty":3,"ExpectedVersion":5}`)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	resp = do(t, srv, http.MethodPatch, "/invoices/"+invoiceID+"/items/i1", `{"Qty":3}`)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = do(t, srv, http.MethodPost, "/invoices/"+invoiceID+"/items/replace",
+		`{"OldItemIDs":["i1"],"NewItems":[{"ID":"i3","InvoiceID":"other","Qty":1,"Status":"NEW"}]}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "new items of other invoice")
+
+	resp = do(t, srv, http.MethodPut, "/invoices/"+invoiceID+"/items/status", `{"Status":"UNKNOWN"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = do(t, srv, http.MethodPut, "/invoices/"+invoiceID+"/items/status", `{"Status":"PENDING"}`)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = do(t, srv, http.MethodGet, "/invoices/"+invoiceID+"/items/i1", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var item invoice.Item
+	decode(t, resp, &item)
+	assert.Equal(t, uint(3), item.Qty)
+	assert.Equal(t, invoice.Pending, item.Status)
+
+	resp = do(t, srv, http.MethodPost, "/invoices/"+invoiceID+"/cancel", "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp = do(t, srv, http.MethodGet, "/invoices/"+invoiceID, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	decode(t, resp, &got)
+	assert.Equal(t, invoice.Cancelled, got.Status)
+	resp = do(t, srv, http.MethodPost, "/invoices/"+uuid.NewString()+"/cancel", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = do(t, srv, http.MethodPost, "/invoices", `{"Unknown":1}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = do(t, srv, http.MethodDelete, "/invoices/"+invoiceID, "")
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	assert.Equal(t, "GET", resp.Header.Get("Allow"))
+
+	resp = do(t, srv, http.MethodGet, "/unknown", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+// failingService fails every call with the error describing storage internals.
+type failingService struct {
+	invoiceiface.Service
+}
+
+func (failingService) GetInvoice(context.Context, string, ...invoice.ReadOption) (*invoice.Invoice, error) {
+	return nil, errors.New("dynamodb: table invoices-prod is throttled")
+}
+
+func TestErrorResponse(t *testing.T) {
+	srv := httptest.NewServer(httpapi.NewServer(failingService{}))
+	defer srv.Close()
+
+	resp := do(t, srv, http.MethodGet, "/invoices/"+uuid.NewString(), "")
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	var errResp httpapi.ErrorResponse
+	decode(t, resp, &errResp)
+	assert.Equal(t, "Internal Server Error", errResp.Error)
+}
+
+func TestTenant(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewRepository(memory.WithClock(invoice.FixedClock(now)))
+	svc := invoice.NewService(repo)
+
+	store := func(t *testing.T, srv *httptest.Server, tenant string) (string, int) {
+		t.Helper()
+		invoiceID := uuid.NewString()
+		body := fmt.Sprintf(`{"ID":%q,"Date":"2021-03-01T00:00:00Z"}`, invoiceID)
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/invoices", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set(httpapi.TenantHeader, tenant)
+		resp, err := srv.Client().Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return invoiceID, resp.StatusCode
+	}
+
+	t.Run("ignores tenant header by default", func(t *testing.T) {
+		srv := httptest.NewServer(httpapi.NewServer(svc))
+		defer srv.Close()
+
+		invoiceID, code := store(t, srv, "acme")
+		require.Equal(t, http.StatusCreated, code)
+		inv, err := repo.GetInvoice(ctx, invoiceID)
+		require.NoError(t, err)
+		assert.NotNil(t, inv, "invoice is stored without tenant")
+	})
+
+	t.Run("scopes requests to the server tenant", func(t *testing.T) {
+		srv := httptest.NewServer(httpapi.NewServer(svc, httpapi.WithTenant("acme")))
+		defer srv.Close()
+
+		invoiceID, code := store(t, srv, "globex")
+		require.Equal(t, http.StatusCreated, code)
+		inv, err := repo.GetInvoice(invoice.WithTenant(ctx, "acme"), invoiceID)
+		require.NoError(t, err)
+		assert.NotNil(t, inv)
+		inv, err = repo.GetInvoice(invoice.WithTenant(ctx, "globex"), invoiceID)
+		require.NoError(t, err)
+		assert.Nil(t, inv, "tenant header is ignored")
+	})
+
+	t.Run("rejects invalid tenants", func(t *testing.T) {
+		srv := httptest.NewServer(httpapi.NewServer(svc, httpapi.WithTenantFunc(httpapi.TenantFromHeader)))
+		defer srv.Close()
+
+		for _, tenant := range []string{"", "acme#x"} {
+			_, code := store(t, srv, tenant)
+			assert.Equal(t, http.StatusBadRequest, code, "tenant %q", tenant)
+		}
+	})
+}
+
+func TestPagination(t *testing.T) {
+	srv := newServer()
+	defer srv.Close()
+
+	invoiceID := uuid.NewString()
+	var items []string
+	for idx := 0; idx < 5; idx++ {
+		items = append(items, fmt.Sprintf(`{"ID":"i%d","InvoiceID":%q,"Qty":1,"Status":"NEW"}`, idx, invoiceID))
+	}
+	body := fmt.Sprintf(`{"ID":%q,"Date":"2021-03-01T00:00:00Z","Items":[%s]}`, invoiceID, strings.Join(items, ","))
+	resp := do(t, srv, http.MethodPost, "/invoices", body)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	type itemsPage struct {
+		Items      []invoice.Item
+		NextCursor string
+	}
+
+	seen := map[string]bool{}
+	path := "/invoices/" + invoiceID + "/items?limit=2"
+	pages := 0
+	for {
+		resp = do(t, srv, http.MethodGet, path, "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var page itemsPage
+		decode(t, resp, &page)
+		pages++
+		for _, item := range page.Items {
+			seen[item.ID] = true
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		path = "/invoices/" + invoiceID + "/items?limit=2&cursor=" + page.NextCursor
+	}
+	assert.Equal(t, 3, pages)
+	assert.Len(t, seen, 5)
+
+	resp = do(t, srv, http.MethodGet, "/invoices/"+invoiceID+"/items?limit=0", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = do(t, srv, http.MethodGet, "/invoices/"+invoiceID+"/items?cursor=!", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestOpenAPI(t *testing.T) {
+	srv := newServer()
+	defer srv.Close()
+
+	resp := do(t, srv, http.MethodGet, "/openapi.json", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var doc struct {
+		OpenAPI    string
+		Paths      map[string]map[string]json.RawMessage
+		Components struct {
+			Schemas map[string]json.RawMessage
+		}
+	}
+	decode(t, resp, &doc)
+
+	assert.Equal(t, "3.0.3", doc.OpenAPI)
+	assert.Contains(t, doc.Paths, "/invoices")
+	assert.Contains(t, doc.Paths["/invoices/{invoiceId}/items/{itemId}"], "patch")
+	assert.Contains(t, doc.Paths["/invoices/{invoiceId}/items"], "get")
+	assert.Contains(t, doc.Components.Schemas, "Invoice")
+	assert.Contains(t, doc.Components.Schemas, "ItemUpdate")
+	assert.Contains(t, doc.Components.Schemas, "ErrorResponse")
+}
@@ -0,0 +1,42 @@
+package invoice
+
+import "time"
+
+// Clock provides the current time. Repositories set createdAt and updatedAt
+// of written entities from their clock, tests use a fixed clock to freeze time.
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc is an adapter to allow the use of ordinary functions as clocks.
+type ClockFunc func() time.Time
+
+// Now calls f().
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// SystemClock returns the clock reading the system time.
+func SystemClock() Clock {
+	return ClockFunc(time.Now)
+}
+
+// FixedClock returns the clock that always reads t.
+func FixedClock(t time.Time) Clock {
+	return ClockFunc(func() time.Time { return t })
+}
+
+// Created returns copy of items with createdAt and updatedAt set to now.
+// Repositories stamp new items with it.
+func Created(items []Item, now time.Time) []Item {
+	if items == nil {
+		return nil
+	}
+
+	stamped := make([]Item, len(items))
+	for idx, item := range items {
+		item.CreatedAt, item.UpdatedAt = now, now
+		stamped[idx] = item
+	}
+	return stamped
+}
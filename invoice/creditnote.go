@@ -0,0 +1,96 @@
+package invoice
+
+import "time"
+
+// CreditNote is an accounting document that credits items of the issued invoice.
+type CreditNote struct {
+	ID        string // unique identifier, uuid format
+	Number    string // sequential credit note number, assigned when credit note is stored
+	InvoiceID string
+	Reason    string
+	Lines     []CreditNoteLine
+	Date      time.Time
+	CreatedAt time.Time
+}
+
+// CreditNoteLine credits quantity of the invoice item.
+type CreditNoteLine struct {
+	ItemID string
+	Qty    uint
+	Amount uint // credited amount, defaults to item price multiplied by quantity
+}
+
+// Total returns credited amount of all lines.
+func (cn CreditNote) Total() uint {
+	var total uint
+	for _, line := range cn.Lines {
+		total += line.Amount
+	}
+	return total
+}
+
+// Credit is quantity and amount of an item credited by credit notes.
+type Credit struct {
+	Qty    uint
+	Amount uint
+}
+
+// CoveredBy reports whether quantity and amount of the item cover the credit.
+func (c Credit) CoveredBy(item Item) bool {
+	return c.Qty <= item.Qty && c.Amount <= item.Price*item.Qty
+}
+
+// Credited returns credits of the credit notes by item ID.
+func Credited(notes []CreditNote) map[string]Credit {
+	credited := make(map[string]Credit)
+	for _, note := range notes {
+		for _, line := range note.Lines {
+			c := credited[line.ItemID]
+			credited[line.ItemID] = Credit{c.Qty + line.Qty, c.Amount + line.Amount}
+		}
+	}
+	return credited
+}
+
+// CheckCreditNote verifies that the credit note together with already issued
+// credit notes does not exceed quantities and amounts of the invoice items.
+// Cancelled items cannot be credited. It sets default amounts of the lines.
+// Repositories check credit notes again when they store them.
+func CheckCreditNote(cn *CreditNote, items []Item, issued []CreditNote) error {
+	if len(cn.Lines) == 0 {
+		return ErrInvalidCreditNote
+	}
+
+	credited := Credited(issued)
+
+	itemsByID := make(map[string]Item, len(items))
+	for _, item := range items {
+		itemsByID[item.ID] = item
+	}
+
+	for idx := range cn.Lines {
+		line := &cn.Lines[idx]
+		item, ok := itemsByID[line.ItemID]
+		if !ok {
+			return ErrItemNotFound
+		}
+		if item.Status == Cancelled {
+			return ErrItemCancelled
+		}
+		if line.Qty == 0 {
+			return ErrInvalidCreditNote
+		}
+		if line.Amount == 0 {
+			line.Amount = item.Price * line.Qty
+		}
+
+		c := credited[line.ItemID]
+		c = Credit{c.Qty + line.Qty, c.Amount + line.Amount}
+		if !c.CoveredBy(item) {
+			return ErrCreditExceedsInvoice
+		}
+		credited[line.ItemID] = c
+	}
+
+	return nil
+}
@@ -0,0 +1,22 @@
+package invoice
+
+import "errors"
+
+var (
+	ErrItemNotFound         = errors.New("item not found")
+	ErrVersionConflict      = errors.New("item version does not match expected version")
+	ErrItemConflict         = errors.New("item is not in NEW status or already exists")
+	ErrInvalidItemUpdate    = errors.New("invalid item update")
+	ErrInvalidInvoice       = errors.New("invalid invoice")
+	ErrInvalidItem          = errors.New("invalid item")
+	ErrInvalidCreditNote    = errors.New("credit note must have lines with quantity greater than zero")
+	ErrCreditExceedsInvoice = errors.New("credit exceeds invoiced quantity or amount")
+	ErrItemCancelled        = errors.New("item is cancelled")
+	ErrInvoiceNotFound      = errors.New("invoice not found")
+	ErrInvoiceCancelled     = errors.New("invoice is cancelled")
+	ErrPaymentNotFound      = errors.New("payment not found")
+	ErrCreditNoteNotFound   = errors.New("credit note not found")
+	ErrCreditNoteConflict   = errors.New("credit note already exists")
+	ErrPaymentReversed      = errors.New("payment already reversed")
+	ErrInvalidPayment       = errors.New("payment amount must be greater than zero")
+)
@@ -0,0 +1,78 @@
+package invoice
+
+import "time"
+
+// EventType names a domain event published when invoice changes.
+type EventType string
+
+const (
+	InvoiceCreated   EventType = "INVOICE_CREATED"
+	InvoiceCancelled EventType = "INVOICE_CANCELLED"
+	ItemAdded        EventType = "ITEM_ADDED"
+	ItemUpdated      EventType = "ITEM_UPDATED"
+	ItemsReplaced    EventType = "ITEMS_REPLACED"
+	ItemsCancelled   EventType = "ITEMS_CANCELLED"
+	PaymentRecorded  EventType = "PAYMENT_RECORDED"
+	PaymentReversed  EventType = "PAYMENT_REVERSED"
+	CreditNoteIssued EventType = "CREDIT_NOTE_ISSUED"
+)
+
+// Event is a domain event for downstream systems. Events are written to the
+// outbox together with the change that caused them.
+type Event struct {
+	ID         string // unique identifier, the same as ID of the change caused the event
+	Type       EventType
+	Tenant     string // tenant of the invoice, see Change
+	InvoiceID  string
+	OccurredAt time.Time
+	Data       Snapshot // state of the entities after the change
+}
+
+// EventFromChange returns the domain event caused by the change. It returns
+// false when the change is not published to downstream systems.
+func EventFromChange(c Change) (Event, bool) {
+	var eventType EventType
+	switch c.Operation {
+	case OpAddInvoice:
+		eventType = InvoiceCreated
+	case OpCancelInvoice:
+		eventType = InvoiceCancelled
+	case OpAddItem:
+		eventType = ItemAdded
+	case OpUpdateItem:
+		eventType = ItemUpdated
+	case OpReplaceItems:
+		eventType = ItemsReplaced
+	case OpAddPayment:
+		eventType = PaymentRecorded
+	case OpReversePayment:
+		eventType = PaymentReversed
+	case OpAddCreditNote:
+		eventType = CreditNoteIssued
+	case OpUpdateItemStatus, OpUpdateItemsStatus:
+		if !allCancelled(c.After.Items) {
+			return Event{}, false
+		}
+		eventType = ItemsCancelled
+	default:
+		return Event{}, false
+	}
+
+	return Event{
+		ID:         c.ID,
+		Type:       eventType,
+		Tenant:     c.Tenant,
+		InvoiceID:  c.InvoiceID,
+		OccurredAt: c.At,
+		Data:       c.After,
+	}, true
+}
+
+func allCancelled(items []Item) bool {
+	for _, item := range items {
+		if item.Status != Cancelled {
+			return false
+		}
+	}
+	return len(items) > 0
+}
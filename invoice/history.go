@@ -0,0 +1,58 @@
+package invoice
+
+import (
+	"context"
+	"time"
+)
+
+// Operation names a mutating repository operation recorded in invoice history.
+type Operation string
+
+const (
+	OpAddInvoice        Operation = "ADD_INVOICE"
+	OpCancelInvoice     Operation = "CANCEL_INVOICE"
+	OpAddItem           Operation = "ADD_ITEM"
+	OpUpdateItem        Operation = "UPDATE_ITEM"
+	OpDeleteItem        Operation = "DELETE_ITEM"
+	OpRestoreItem       Operation = "RESTORE_ITEM"
+	OpUpdateItemStatus  Operation = "UPDATE_ITEM_STATUS"
+	OpUpdateItemsStatus Operation = "UPDATE_ITEMS_STATUS"
+	OpReplaceItems      Operation = "REPLACE_ITEMS"
+	OpAddPayment        Operation = "ADD_PAYMENT"
+	OpReversePayment    Operation = "REVERSE_PAYMENT"
+	OpAddCreditNote     Operation = "ADD_CREDIT_NOTE"
+)
+
+// Snapshot holds the state of invoice entities affected by a change.
+type Snapshot struct {
+	Invoice    *Invoice // invoice without items
+	Items      []Item
+	Payment    *Payment
+	CreditNote *CreditNote
+}
+
+// Change is an immutable audit record of a mutating repository call.
+type Change struct {
+	ID        string // unique identifier, uuid format
+	Tenant    string // tenant of the invoice, empty for invoices stored without tenant
+	InvoiceID string
+	Operation Operation
+	Actor     string // who made the change, see WithActor
+	At        time.Time
+	Before    Snapshot // state before the change, empty for created entities
+	After     Snapshot // state after the change, empty for deleted entities
+}
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx that carries the actor recorded in the
+// history of changes made with this context.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor carried by ctx, or empty string.
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
@@ -8,15 +8,28 @@ import (
 
 type Service interface {
 	StoreInvoice(context.Context, invoice.Invoice) error
-	GetInvoice(context.Context, string) (*invoice.Invoice, error) // gets invoice and all its items
-	CancelInvoice(context.Context, string) error                  // cancels invoice and all its items
-	AddItem(context.Context, invoice.Item) error                  // adds invoice's item
-	GetItem(ctx context.Context, invoiceID, itemID string) (*invoice.Item, error)
-	DeleteItem(ctx context.Context, invoiceID, itemID string) error
-	GetItemProduct(ctx context.Context, invoiceID, itemID string) (*invoice.Product, error)
-	GetItemsByStatus(context.Context, invoice.Status) ([]invoice.Item, error)
-	GetInvoiceItemsByStatus(context.Context, string, invoice.Status) ([]invoice.Item, error)
+	GetInvoice(context.Context, string, ...invoice.ReadOption) (*invoice.Invoice, error) // gets invoice and all its items
+	CancelInvoice(context.Context, string) error                                         // cancels invoice and all its items
+	AddItem(context.Context, invoice.Item) error                                         // adds invoice's item
+	GetItem(ctx context.Context, invoiceID, itemID string, opts ...invoice.ReadOption) (*invoice.Item, error)
+	UpdateItem(ctx context.Context, invoiceID, itemID string, upd invoice.ItemUpdate) error
+	DeleteItem(ctx context.Context, invoiceID, itemID string) error  // marks item deleted
+	RestoreItem(ctx context.Context, invoiceID, itemID string) error // restores deleted item
+	GetItemProduct(ctx context.Context, invoiceID, itemID string, opts ...invoice.ReadOption) (*invoice.Product, error)
+	GetItemsByStatus(context.Context, invoice.Status, ...invoice.ReadOption) ([]invoice.Item, error)
+	IterateItemsByStatus(context.Context, invoice.Status, ...invoice.ReadOption) invoice.ItemIterator
+	IterateInvoiceItems(context.Context, string, ...invoice.ReadOption) invoice.ItemIterator
+	GetInvoiceItemsByStatus(context.Context, string, invoice.Status, ...invoice.ReadOption) ([]invoice.Item, error)
 	UpdateInvoiceItemsStatus(context.Context, string, invoice.Status) error
-	ReplaceItems(context.Context, string, []invoice.Item) error            // cancells all invoice items and adds new items
-	CancelInvoiceItem(ctx context.Context, invoiceID, itemID string) error // cancells invoice item
+	ReplaceItems(ctx context.Context, invoiceID string, oldItemIDs []string, newItems []invoice.Item) error
+	CancelInvoiceItem(ctx context.Context, invoiceID, itemID string) error                      // cancells invoice item
+	GetInvoiceHistory(context.Context, string, ...invoice.ReadOption) ([]invoice.Change, error) // returns ordered change log of invoice
+	RecordPayment(context.Context, invoice.Payment) error
+	ReversePayment(ctx context.Context, invoiceID, paymentID string) error
+	GetPayments(context.Context, string, ...invoice.ReadOption) ([]invoice.Payment, error)
+	GetBalance(context.Context, string) (*invoice.Balance, error)
+	IssueCreditNote(context.Context, invoice.CreditNote) (*invoice.CreditNote, error)
+	GetCreditNote(ctx context.Context, invoiceID, creditNoteID string, opts ...invoice.ReadOption) (
+		*invoice.CreditNote, error)
+	GetCreditNotes(context.Context, string, ...invoice.ReadOption) ([]invoice.CreditNote, error)
 }
@@ -0,0 +1,35 @@
+package invoice
+
+// ReadOptions configures repository reads.
+type ReadOptions struct {
+	IncludeDeleted bool // include soft deleted items
+	ConsistentRead bool // read the latest writes, see ConsistentRead
+}
+
+// ReadOption sets read options.
+type ReadOption func(*ReadOptions)
+
+// IncludeDeleted makes reads return soft deleted items.
+func IncludeDeleted() ReadOption {
+	return func(o *ReadOptions) {
+		o.IncludeDeleted = true
+	}
+}
+
+// ConsistentRead makes reads return the result of all writes completed
+// before the read. Repositories with eventually consistent reads, such as
+// DynamoDB, read the latest data at a higher cost.
+func ConsistentRead() ReadOption {
+	return func(o *ReadOptions) {
+		o.ConsistentRead = true
+	}
+}
+
+// NewReadOptions applies opts to the default read options.
+func NewReadOptions(opts ...ReadOption) ReadOptions {
+	var o ReadOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
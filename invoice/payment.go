@@ -0,0 +1,61 @@
+package invoice
+
+import "time"
+
+// Payment is money received against invoice.
+type Payment struct {
+	ID         string // unique identifier, uuid format
+	InvoiceID  string
+	Amount     uint
+	Method     string // e.g. card, bank transfer
+	Reference  string // reference of the payment in the payment system
+	ReceivedAt time.Time
+	ReversedAt time.Time // zero for payments that were not reversed
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// Reversed reports whether the payment was reversed.
+func (p Payment) Reversed() bool {
+	return !p.ReversedAt.IsZero()
+}
+
+// Balance describes amounts of invoice.
+type Balance struct {
+	Total       uint // price of all not cancelled items
+	Paid        uint // amount of not reversed payments
+	Outstanding int  // amount left to pay, negative when invoice is overpaid
+}
+
+// NewBalance returns balance of the invoice with total price of items and
+// payments. Reversed payments are not counted.
+func NewBalance(total uint, payments []Payment) Balance {
+	b := Balance{Total: total}
+	for _, p := range payments {
+		if !p.Reversed() {
+			b.Paid += p.Amount
+		}
+	}
+
+	b.Outstanding = int(b.Total) - int(b.Paid)
+	return b
+}
+
+// PaymentStatus returns invoice status for the balance. Invoices that were
+// not paid keep their status, invoices with all payments reversed become new,
+// cancelled invoices stay cancelled. Repositories set it in the same write as
+// the payment or the change of the invoice total.
+func PaymentStatus(current Status, b Balance) Status {
+	switch {
+	case current == Cancelled:
+		return current
+	case b.Paid > 0 && b.Outstanding <= 0:
+		return Paid
+	case b.Paid > 0:
+		return PartiallyPaid
+	case current == Paid || current == PartiallyPaid:
+		return New
+	default:
+		return current
+	}
+}
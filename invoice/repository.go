@@ -2,19 +2,52 @@ package invoice
 
 import "context"
 
-// Repository interface defines invoces repository methods
+// Repository interface defines invoces repository methods. Item reads
+// exclude deleted items unless IncludeDeleted option is set. Reads may be
+// eventually consistent unless ConsistentRead option is set.
 type Repository interface {
 	AddInvoice(context.Context, Invoice) error
-	GetInvoice(context.Context, string) (*Invoice, error) // gets invoice and all its items
-	AddItem(context.Context, Item) error                  // adds invoice's item
-	GetItem(ctx context.Context, invoiceID, itemID string) (*Item, error)
-	GetItemProduct(ctx context.Context, invoiceID, itemID string) (*Product, error)
-	DeleteItem(ctx context.Context, invoiceID, itemID string) error
-	GetItemsByStatus(context.Context, Status) ([]Item, error)
-	GetInvoiceItems(context.Context, string) ([]Item, error)
+	GetInvoice(context.Context, string, ...ReadOption) (*Invoice, error) // gets invoice and all its items
+	CancelInvoice(context.Context, string) error                         // cancels invoice and all its items
+	AddItem(context.Context, Item) error                                 // adds invoice's item
+	GetItem(ctx context.Context, invoiceID, itemID string, opts ...ReadOption) (*Item, error)
+	GetItemProduct(ctx context.Context, invoiceID, itemID string, opts ...ReadOption) (*Product, error)
+	UpdateItem(ctx context.Context, invoiceID, itemID string, upd ItemUpdate) error // partially updates item
+	DeleteItem(ctx context.Context, invoiceID, itemID string) error                 // marks item deleted
+	RestoreItem(ctx context.Context, invoiceID, itemID string) error                // restores deleted item
+	GetItemsByStatus(context.Context, Status, ...ReadOption) ([]Item, error)
+	GetInvoiceItems(context.Context, string, ...ReadOption) ([]Item, error)
 	// TODO: filter parameters should be optional, should be handled by GetInvoiceItems method
-	GetInvoiceItemsByStatus(context.Context, string, Status) ([]Item, error)
+	GetInvoiceItemsByStatus(context.Context, string, Status, ...ReadOption) ([]Item, error)
 	UpdateInvoiceItemStatus(ctx context.Context, invoiceID, itemID string, status Status) error
 	UpdateInvoiceItemsStatus(ctx context.Context, invoiceID string, itemIDs []string, status Status) error
-	ReplaceItems(context.Context, string, []Item) error // cancells all invoice items and adds new items
+	// cancels NEW items with old IDs and adds new items
+	ReplaceItems(ctx context.Context, invoiceID string, oldItemIDs []string, newItems []Item) error
+	GetInvoiceHistory(context.Context, string, ...ReadOption) ([]Change, error) // returns changes ordered by time
+
+	// adds payment and sets invoice status by the balance, see PaymentStatus
+	AddPayment(context.Context, Payment) error
+	GetPayment(ctx context.Context, invoiceID, paymentID string, opts ...ReadOption) (*Payment, error)
+	GetPayments(context.Context, string, ...ReadOption) ([]Payment, error)
+	// marks payment reversed and sets invoice status by the balance
+	ReversePayment(ctx context.Context, invoiceID, paymentID string) error
+
+	AddCreditNote(context.Context, CreditNote) (*CreditNote, error) // assigns sequential number and stores credit note
+	GetCreditNote(ctx context.Context, invoiceID, creditNoteID string, opts ...ReadOption) (*CreditNote, error)
+	GetCreditNotes(context.Context, string, ...ReadOption) ([]CreditNote, error)
+
+	// Streaming variants of the list methods. Items are fetched lazily,
+	// so callers can walk large result sets with bounded memory.
+	IterateItemsByStatus(context.Context, Status, ...ReadOption) ItemIterator
+	IterateInvoiceItems(context.Context, string, ...ReadOption) ItemIterator
+}
+
+// ItemIterator iterates over items returned by a repository. Iteration stops
+// when Next returns false, after that Err reports the error that stopped
+// iteration, if any. Callers must Close the iterator when done.
+type ItemIterator interface {
+	Next() bool // advances to the next item, returns false when there are no more items or an error occurred
+	Item() Item // returns the current item
+	Err() error // returns the error encountered during iteration
+	Close() error
 }
@@ -2,7 +2,6 @@ package invoice
 
 import (
 	"context"
-	"errors"
 	"time"
 )
 
@@ -10,9 +9,11 @@ import (
 type Status string
 
 const (
-	New       Status = "NEW"
-	Pending   Status = "PENDING"
-	Cancelled Status = "CANCELLED"
+	New           Status = "NEW"
+	Pending       Status = "PENDING"
+	Cancelled     Status = "CANCELLED"
+	PartiallyPaid Status = "PARTIALLY_PAID"
+	Paid          Status = "PAID"
 )
 
 // Invoice ...
@@ -23,6 +24,8 @@ type Invoice struct {
 	Status       Status
 	Date         time.Time
 	Items        []Item
+	ItemCount    uint // number of not cancelled items, maintained by repository
+	Total        uint // price of not cancelled items, maintained by repository
 	CreatedAt    time.Time
 	UpdatedAt    time.Time
 }
@@ -36,8 +39,33 @@ type Item struct {
 	Price     uint
 	Qty       uint
 	Status    Status
+	Version   uint // incremented on every item change
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	DeletedAt time.Time // zero for items that were not deleted
+	DeletedBy string
+}
+
+// Deleted reports whether the item was deleted.
+func (i Item) Deleted() bool {
+	return !i.DeletedAt.IsZero()
+}
+
+// counted reports whether the item counts towards invoice totals.
+func (i Item) counted() bool {
+	return i.Status != Cancelled && !i.Deleted()
+}
+
+// ItemTotals returns number and price of items that count towards invoice
+// totals. Cancelled and deleted items are not counted.
+func ItemTotals(items []Item) (count, total uint) {
+	for _, item := range items {
+		if item.counted() {
+			count++
+			total += item.Price * item.Qty
+		}
+	}
+	return count, total
 }
 
 // Product ...
@@ -48,53 +76,165 @@ type Product struct {
 }
 
 type Service struct {
-	repo Repository
+	repo      Repository
+	validator *Validator
+	clock     Clock
+	reads     []ReadOption // defaults of every read, see WithReadOptions
+}
+
+// ServiceOption configures service.
+type ServiceOption func(*Service)
+
+// WithInvoiceRules adds custom rules checked before invoices are stored.
+func WithInvoiceRules(rules ...InvoiceRule) ServiceOption {
+	return func(s *Service) {
+		for _, rule := range rules {
+			s.validator.AddInvoiceRule(rule)
+		}
+	}
+}
+
+// WithItemRules adds custom rules checked before items are stored.
+func WithItemRules(rules ...ItemRule) ServiceOption {
+	return func(s *Service) {
+		for _, rule := range rules {
+			s.validator.AddItemRule(rule)
+		}
+	}
+}
+
+// WithClock sets the clock used for dates the service fills in, such as
+// receive date of payments. Repositories have their own clocks for
+// createdAt and updatedAt.
+func WithClock(c Clock) ServiceOption {
+	return func(s *Service) {
+		s.clock = c
+	}
+}
+
+// WithReadOptions sets read options applied to every repository read of
+// the service, e.g. ConsistentRead for flows reading right after writes.
+// Options passed to service methods are applied after them.
+func WithReadOptions(opts ...ReadOption) ServiceOption {
+	return func(s *Service) {
+		s.reads = append(s.reads, opts...)
+	}
 }
 
 // NewService creates a new instance of invoice service
-func NewService(repo Repository) *Service {
-	return &Service{repo: repo}
+func NewService(repo Repository, opts ...ServiceOption) *Service {
+	s := &Service{repo: repo, validator: NewValidator(), clock: SystemClock()}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
+// StoreInvoice validates and stores the invoice with its items.
 func (s *Service) StoreInvoice(ctx context.Context, inv Invoice) error {
+	if err := s.validator.ValidateInvoice(inv); err != nil {
+		return err
+	}
 	return s.repo.AddInvoice(ctx, inv)
 }
 
-func (s *Service) GetInvoice(ctx context.Context, invoiceID string) (*Invoice, error) {
-	return s.repo.GetInvoice(ctx, invoiceID)
+func (s *Service) GetInvoice(ctx context.Context, invoiceID string, opts ...ReadOption) (*Invoice, error) {
+	return s.repo.GetInvoice(ctx, invoiceID, s.readOptions(opts)...)
 }
 
-func (s *Service) CancelInvoice(context.Context, string) error {
-	// TODO: implement
-	return errors.New("not implemented")
+// CancelInvoice cancels the invoice and all its items. Cancelled invoices do
+// not accept payments.
+func (s *Service) CancelInvoice(ctx context.Context, invoiceID string) error {
+	return s.repo.CancelInvoice(ctx, invoiceID)
 }
 
 func (s *Service) AddItem(ctx context.Context, item Item) error {
+	if err := s.validator.ValidateItem(item); err != nil {
+		return err
+	}
 	return s.repo.AddItem(ctx, item)
 }
 
-func (s *Service) GetItem(ctx context.Context, invoiceID, itemID string) (*Item, error) {
-	return s.repo.GetItem(ctx, invoiceID, itemID)
+func (s *Service) GetItem(ctx context.Context, invoiceID, itemID string, opts ...ReadOption) (*Item, error) {
+	return s.repo.GetItem(ctx, invoiceID, itemID, s.readOptions(opts)...)
+}
+
+// UpdateItem changes name, quantity or price of the item. Item rules are
+// checked on the updated item, and the update is written only when the item
+// did not change since it was checked. Quantity and amount of the item cannot
+// go below its credits.
+func (s *Service) UpdateItem(ctx context.Context, invoiceID, itemID string, upd ItemUpdate) error {
+	if err := upd.Validate(); err != nil {
+		return err
+	}
+
+	item, err := s.repo.GetItem(ctx, invoiceID, itemID, ConsistentRead())
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return ErrItemNotFound
+	}
+
+	if upd.ExpectedVersion == nil {
+		version := item.Version
+		upd.ExpectedVersion = &version
+	}
+
+	updated := *item
+	upd.Apply(&updated)
+	if err := s.validator.ValidateItemUpdate(updated); err != nil {
+		return err
+	}
+
+	if upd.Qty != nil || upd.Price != nil {
+		issued, err := s.repo.GetCreditNotes(ctx, invoiceID, ConsistentRead())
+		if err != nil {
+			return err
+		}
+		if !Credited(issued)[itemID].CoveredBy(updated) {
+			return ErrCreditExceedsInvoice
+		}
+	}
+
+	return s.repo.UpdateItem(ctx, invoiceID, itemID, upd)
 }
 
+// DeleteItem marks the item deleted. Deleted items can be restored.
 func (s *Service) DeleteItem(ctx context.Context, invoiceID, itemID string) error {
 	return s.repo.DeleteItem(ctx, invoiceID, itemID)
 }
 
-func (s *Service) GetItemProduct(ctx context.Context, invoiceID, itemID string) (*Product, error) {
-	return s.repo.GetItemProduct(ctx, invoiceID, itemID)
+func (s *Service) RestoreItem(ctx context.Context, invoiceID, itemID string) error {
+	return s.repo.RestoreItem(ctx, invoiceID, itemID)
+}
+
+func (s *Service) GetItemProduct(
+	ctx context.Context, invoiceID, itemID string, opts ...ReadOption) (*Product, error) {
+
+	return s.repo.GetItemProduct(ctx, invoiceID, itemID, s.readOptions(opts)...)
 }
 
-func (s *Service) GetItemsByStatus(ctx context.Context, status Status) ([]Item, error) {
-	return s.repo.GetItemsByStatus(ctx, status)
+func (s *Service) GetItemsByStatus(ctx context.Context, status Status, opts ...ReadOption) ([]Item, error) {
+	return s.repo.GetItemsByStatus(ctx, status, s.readOptions(opts)...)
 }
 
-func (s *Service) GetInvoiceItemsByStatus(ctx context.Context, invoiceID string, status Status) ([]Item, error) {
-	return s.repo.GetInvoiceItemsByStatus(ctx, invoiceID, status)
+func (s *Service) IterateItemsByStatus(ctx context.Context, status Status, opts ...ReadOption) ItemIterator {
+	return s.repo.IterateItemsByStatus(ctx, status, s.readOptions(opts)...)
+}
+
+func (s *Service) IterateInvoiceItems(ctx context.Context, invoiceID string, opts ...ReadOption) ItemIterator {
+	return s.repo.IterateInvoiceItems(ctx, invoiceID, s.readOptions(opts)...)
+}
+
+func (s *Service) GetInvoiceItemsByStatus(
+	ctx context.Context, invoiceID string, status Status, opts ...ReadOption) ([]Item, error) {
+
+	return s.repo.GetInvoiceItemsByStatus(ctx, invoiceID, status, s.readOptions(opts)...)
 }
 
 func (s *Service) UpdateInvoiceItemsStatus(ctx context.Context, invoiceID string, status Status) error {
-	items, err := s.repo.GetInvoiceItems(ctx, invoiceID)
+	items, err := s.repo.GetInvoiceItems(ctx, invoiceID, s.readOptions(nil)...)
 	if err != nil {
 		return err
 	}
@@ -111,8 +251,137 @@ func (s *Service) UpdateInvoiceItemsStatus(ctx context.Context, invoiceID string
 	return s.repo.UpdateInvoiceItemsStatus(ctx, invoiceID, itemIDs, status)
 }
 
-func (s *Service) ReplaceItems(ctx context.Context, invoiceID string, newItems []Item) error {
-	return s.repo.ReplaceItems(ctx, invoiceID, newItems)
+// ReplaceItems cancels old items and adds new items of the invoice. Old items
+// must be in NEW status.
+func (s *Service) ReplaceItems(ctx context.Context, invoiceID string, oldItemIDs []string, newItems []Item) error {
+	if err := s.validator.ValidateItems(invoiceID, newItems); err != nil {
+		return err
+	}
+	return s.repo.ReplaceItems(ctx, invoiceID, oldItemIDs, newItems)
+}
+
+func (s *Service) GetInvoiceHistory(ctx context.Context, invoiceID string, opts ...ReadOption) ([]Change, error) {
+	return s.repo.GetInvoiceHistory(ctx, invoiceID, s.readOptions(opts)...)
+}
+
+// RecordPayment stores the payment and updates invoice status according to
+// its outstanding balance. Payments without receive date are received now.
+// Cancelled invoices do not accept payments.
+func (s *Service) RecordPayment(ctx context.Context, p Payment) error {
+	if p.Amount == 0 {
+		return ErrInvalidPayment
+	}
+	if p.ReceivedAt.IsZero() {
+		p.ReceivedAt = s.clock.Now()
+	}
+
+	inv, err := s.repo.GetInvoice(ctx, p.InvoiceID, s.readOptions(nil)...)
+	if err != nil {
+		return err
+	}
+	if inv == nil {
+		return ErrInvoiceNotFound
+	}
+	if inv.Status == Cancelled {
+		return ErrInvoiceCancelled
+	}
+
+	return s.repo.AddPayment(ctx, p)
+}
+
+// ReversePayment marks the payment reversed and updates invoice status
+// according to its outstanding balance.
+func (s *Service) ReversePayment(ctx context.Context, invoiceID, paymentID string) error {
+	p, err := s.repo.GetPayment(ctx, invoiceID, paymentID, s.readOptions(nil)...)
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return ErrPaymentNotFound
+	}
+	if p.Reversed() {
+		return ErrPaymentReversed
+	}
+
+	return s.repo.ReversePayment(ctx, invoiceID, paymentID)
+}
+
+func (s *Service) GetPayments(ctx context.Context, invoiceID string, opts ...ReadOption) ([]Payment, error) {
+	return s.repo.GetPayments(ctx, invoiceID, s.readOptions(opts)...)
+}
+
+// GetBalance returns invoice total, paid and outstanding amounts.
+func (s *Service) GetBalance(ctx context.Context, invoiceID string) (*Balance, error) {
+	reads := s.readOptions(nil)
+	inv, err := s.repo.GetInvoice(ctx, invoiceID, reads...)
+	if err != nil {
+		return nil, err
+	}
+	if inv == nil {
+		return nil, ErrInvoiceNotFound
+	}
+
+	payments, err := s.repo.GetPayments(ctx, invoiceID, reads...)
+	if err != nil {
+		return nil, err
+	}
+
+	// the total kept by the repository sets the payment status as well
+	balance := NewBalance(inv.Total, payments)
+	return &balance, nil
+}
+
+// IssueCreditNote stores the credit note of the invoice. Credit notes of the
+// invoice cannot exceed quantities and amounts of its items. Credit notes
+// without date are dated today.
+func (s *Service) IssueCreditNote(ctx context.Context, cn CreditNote) (*CreditNote, error) {
+	if cn.Date.IsZero() {
+		cn.Date = s.clock.Now()
+	}
+
+	reads := s.readOptions(nil)
+	inv, err := s.repo.GetInvoice(ctx, cn.InvoiceID, reads...)
+	if err != nil {
+		return nil, err
+	}
+	if inv == nil {
+		return nil, ErrInvoiceNotFound
+	}
+
+	items, err := s.repo.GetInvoiceItems(ctx, cn.InvoiceID, reads...)
+	if err != nil {
+		return nil, err
+	}
+
+	issued, err := s.repo.GetCreditNotes(ctx, cn.InvoiceID, reads...)
+	if err != nil {
+		return nil, err
+	}
+
+	cn.Lines = append([]CreditNoteLine(nil), cn.Lines...)
+	if err := CheckCreditNote(&cn, items, issued); err != nil {
+		return nil, err
+	}
+
+	return s.repo.AddCreditNote(ctx, cn)
+}
+
+func (s *Service) GetCreditNote(
+	ctx context.Context, invoiceID, creditNoteID string, opts ...ReadOption) (*CreditNote, error) {
+
+	return s.repo.GetCreditNote(ctx, invoiceID, creditNoteID, s.readOptions(opts)...)
+}
+
+func (s *Service) GetCreditNotes(ctx context.Context, invoiceID string, opts ...ReadOption) ([]CreditNote, error) {
+	return s.repo.GetCreditNotes(ctx, invoiceID, s.readOptions(opts)...)
+}
+
+// readOptions returns read options of the service followed by opts.
+func (s *Service) readOptions(opts []ReadOption) []ReadOption {
+	if len(s.reads) == 0 {
+		return opts
+	}
+	return append(append([]ReadOption(nil), s.reads...), opts...)
 }
 
 func (s *Service) CancelInvoiceItem(ctx context.Context, invoiceID, itemID string) error {
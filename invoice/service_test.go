@@ -1,8 +1,11 @@
 package invoice_test
 
 import (
+	"context"
 	"os"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/antklim/go-dynamodb/dynamo"
 	"github.com/antklim/go-dynamodb/invoice"
@@ -10,6 +13,9 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // Test against in memory DB
@@ -30,7 +36,11 @@ func initRepo() invoice.Repository {
 	cfg.WithEndpoint(dburl).WithRegion("ap-southeast-2")
 	sess := session.Must(session.NewSession(cfg))
 	dbapi := dynamodb.New(sess)
-	return dynamo.NewRepository(dbapi, dbtable)
+	repo := dynamo.NewRepository(dbapi, dbtable)
+	if err := dynamo.EnsureTable(context.Background(), dbapi, repo.TableSchema()); err != nil {
+		panic(err)
+	}
+	return repo
 }
 
 func TestService(t *testing.T) {
@@ -64,3 +74,537 @@ func TestServicePropagatesStorageErrors(t *testing.T) {
 
 	t.Log("implements test")
 }
+
+func TestServicePayments(t *testing.T) {
+	ctx := context.Background()
+	service := invoice.NewService(initRepo())
+
+	now := time.Now()
+	inv := invoice.Invoice{
+		ID:     uuid.NewString(),
+		Status: invoice.New,
+		Date:   now,
+	}
+	inv.Items = []invoice.Item{
+		{ID: uuid.NewString(), InvoiceID: inv.ID, Price: 1000, Qty: 2, Status: invoice.New},
+		{ID: uuid.NewString(), InvoiceID: inv.ID, Price: 500, Qty: 1, Status: invoice.Cancelled},
+	}
+	require.NoError(t, service.StoreInvoice(ctx, inv))
+
+	pay := func(amount uint) invoice.Payment {
+		return invoice.Payment{
+			ID:         uuid.NewString(),
+			InvoiceID:  inv.ID,
+			Amount:     amount,
+			Method:     "card",
+			ReceivedAt: now,
+		}
+	}
+
+	t.Run("partial payment makes invoice partially paid", func(t *testing.T) {
+		require.NoError(t, service.RecordPayment(ctx, pay(500)))
+
+		balance, err := service.GetBalance(ctx, inv.ID)
+		require.NoError(t, err)
+		assert.Equal(t, invoice.Balance{Total: 2000, Paid: 500, Outstanding: 1500}, *balance)
+
+		got, err := service.GetInvoice(ctx, inv.ID)
+		require.NoError(t, err)
+		assert.Equal(t, invoice.PartiallyPaid, got.Status)
+	})
+
+	last := pay(1500)
+	t.Run("full payment makes invoice paid", func(t *testing.T) {
+		require.NoError(t, service.RecordPayment(ctx, last))
+
+		got, err := service.GetInvoice(ctx, inv.ID)
+		require.NoError(t, err)
+		assert.Equal(t, invoice.Paid, got.Status)
+	})
+
+	t.Run("reversal returns invoice to partially paid", func(t *testing.T) {
+		require.NoError(t, service.ReversePayment(ctx, inv.ID, last.ID))
+
+		balance, err := service.GetBalance(ctx, inv.ID)
+		require.NoError(t, err)
+		assert.Equal(t, 1500, balance.Outstanding)
+
+		got, err := service.GetInvoice(ctx, inv.ID)
+		require.NoError(t, err)
+		assert.Equal(t, invoice.PartiallyPaid, got.Status)
+
+		err = service.ReversePayment(ctx, inv.ID, last.ID)
+		assert.ErrorIs(t, err, invoice.ErrPaymentReversed)
+	})
+
+	t.Run("rejects invalid payments", func(t *testing.T) {
+		err := service.RecordPayment(ctx, pay(0))
+		assert.ErrorIs(t, err, invoice.ErrInvalidPayment)
+
+		p := pay(100)
+		p.InvoiceID = uuid.NewString()
+		err = service.RecordPayment(ctx, p)
+		assert.ErrorIs(t, err, invoice.ErrInvoiceNotFound)
+
+		err = service.ReversePayment(ctx, inv.ID, uuid.NewString())
+		assert.ErrorIs(t, err, invoice.ErrPaymentNotFound)
+	})
+
+	t.Run("item changes recompute payment status", func(t *testing.T) {
+		require.NoError(t, service.RecordPayment(ctx, pay(1500)))
+		got, err := service.GetInvoice(ctx, inv.ID)
+		require.NoError(t, err)
+		require.Equal(t, invoice.Paid, got.Status)
+
+		item := invoice.Item{ID: uuid.NewString(), InvoiceID: inv.ID, Price: 500, Qty: 1, Status: invoice.New}
+		require.NoError(t, service.AddItem(ctx, item))
+
+		got, err = service.GetInvoice(ctx, inv.ID)
+		require.NoError(t, err)
+		assert.Equal(t, invoice.PartiallyPaid, got.Status)
+
+		balance, err := service.GetBalance(ctx, inv.ID)
+		require.NoError(t, err)
+		assert.Equal(t, invoice.Balance{Total: got.Total, Paid: 2000, Outstanding: 500}, *balance)
+	})
+
+	t.Run("rejects payments of cancelled invoice", func(t *testing.T) {
+		cancelled := invoice.Invoice{ID: uuid.NewString(), Status: invoice.Cancelled, Date: now}
+		require.NoError(t, service.StoreInvoice(ctx, cancelled))
+
+		p := pay(100)
+		p.InvoiceID = cancelled.ID
+		err := service.RecordPayment(ctx, p)
+		assert.ErrorIs(t, err, invoice.ErrInvoiceCancelled)
+
+		payments, err := service.GetPayments(ctx, cancelled.ID)
+		require.NoError(t, err)
+		assert.Empty(t, payments)
+	})
+}
+
+func TestServiceCancelInvoice(t *testing.T) {
+	ctx := context.Background()
+	service := invoice.NewService(initRepo())
+
+	inv := invoice.Invoice{ID: uuid.NewString(), Status: invoice.New, Date: time.Now()}
+	inv.Items = []invoice.Item{
+		{ID: uuid.NewString(), InvoiceID: inv.ID, Price: 100, Qty: 2, Status: invoice.New},
+		{ID: uuid.NewString(), InvoiceID: inv.ID, Price: 50, Qty: 1, Status: invoice.Pending},
+	}
+	require.NoError(t, service.StoreInvoice(ctx, inv))
+
+	require.NoError(t, service.CancelInvoice(ctx, inv.ID))
+	got, err := service.GetInvoice(ctx, inv.ID)
+	require.NoError(t, err)
+	assert.Equal(t, invoice.Cancelled, got.Status)
+	assert.Zero(t, got.Total)
+	require.Len(t, got.Items, 2)
+	for _, item := range got.Items {
+		assert.Equal(t, invoice.Cancelled, item.Status)
+	}
+
+	require.NoError(t, service.CancelInvoice(ctx, inv.ID), "cancelled invoice is not changed")
+	err = service.CancelInvoice(ctx, uuid.NewString())
+	assert.ErrorIs(t, err, invoice.ErrInvoiceNotFound)
+}
+
+func TestServiceCreditNotes(t *testing.T) {
+	ctx := context.Background()
+	repo := initRepo()
+	service := invoice.NewService(repo)
+
+	inv := invoice.Invoice{
+		ID:     uuid.NewString(),
+		Status: invoice.New,
+		Date:   time.Now(),
+	}
+	inv.Items = []invoice.Item{
+		{ID: uuid.NewString(), InvoiceID: inv.ID, Price: 1000, Qty: 3, Status: invoice.New},
+	}
+	require.NoError(t, service.StoreInvoice(ctx, inv))
+
+	newCreditNote := func(qty uint) invoice.CreditNote {
+		return invoice.CreditNote{
+			ID:        uuid.NewString(),
+			InvoiceID: inv.ID,
+			Reason:    "damaged",
+			Lines:     []invoice.CreditNoteLine{{ItemID: inv.Items[0].ID, Qty: qty}},
+			Date:      time.Now(),
+		}
+	}
+
+	first, err := service.IssueCreditNote(ctx, newCreditNote(1))
+	require.NoError(t, err)
+	assert.Equal(t, uint(1000), first.Total())
+
+	second, err := service.IssueCreditNote(ctx, newCreditNote(2))
+	require.NoError(t, err)
+
+	firstNumber, err := strconv.Atoi(first.Number)
+	require.NoError(t, err)
+	secondNumber, err := strconv.Atoi(second.Number)
+	require.NoError(t, err)
+	assert.Equal(t, firstNumber+1, secondNumber)
+
+	t.Run("credit cannot exceed invoiced quantity", func(t *testing.T) {
+		_, err := service.IssueCreditNote(ctx, newCreditNote(1))
+		assert.ErrorIs(t, err, invoice.ErrCreditExceedsInvoice)
+
+		cn := newCreditNote(1)
+		cn.Lines[0].Amount = 1000
+		_, err = repo.AddCreditNote(ctx, cn)
+		assert.ErrorIs(t, err, invoice.ErrCreditExceedsInvoice, "repository checks credit notes it stores")
+	})
+
+	t.Run("cancelled items cannot be credited", func(t *testing.T) {
+		item := invoice.Item{ID: uuid.NewString(), InvoiceID: inv.ID, Price: 100, Qty: 1, Status: invoice.Cancelled}
+		require.NoError(t, service.AddItem(ctx, item))
+
+		cn := newCreditNote(1)
+		cn.Lines[0].ItemID = item.ID
+		_, err := service.IssueCreditNote(ctx, cn)
+		assert.ErrorIs(t, err, invoice.ErrItemCancelled)
+	})
+
+	t.Run("credit notes reference invoice items", func(t *testing.T) {
+		cn := newCreditNote(1)
+		cn.Lines[0].ItemID = uuid.NewString()
+		_, err := service.IssueCreditNote(ctx, cn)
+		assert.ErrorIs(t, err, invoice.ErrItemNotFound)
+
+		cn = newCreditNote(1)
+		cn.InvoiceID = uuid.NewString()
+		_, err = service.IssueCreditNote(ctx, cn)
+		assert.ErrorIs(t, err, invoice.ErrInvoiceNotFound)
+	})
+
+	t.Run("credit notes are queryable", func(t *testing.T) {
+		cn, err := service.GetCreditNote(ctx, inv.ID, second.ID)
+		require.NoError(t, err)
+		assert.Equal(t, second.Number, cn.Number)
+
+		cns, err := service.GetCreditNotes(ctx, inv.ID)
+		require.NoError(t, err)
+		assert.Len(t, cns, 2)
+	})
+
+	t.Run("item quantity cannot go below credits", func(t *testing.T) {
+		qty := uint(2)
+		err := service.UpdateItem(ctx, inv.ID, inv.Items[0].ID, invoice.ItemUpdate{Qty: &qty})
+		assert.ErrorIs(t, err, invoice.ErrCreditExceedsInvoice)
+
+		err = repo.UpdateItem(ctx, inv.ID, inv.Items[0].ID, invoice.ItemUpdate{Qty: &qty})
+		assert.ErrorIs(t, err, invoice.ErrCreditExceedsInvoice, "repository checks credits it updates")
+	})
+
+	t.Run("deleted items cannot be credited", func(t *testing.T) {
+		item := invoice.Item{ID: uuid.NewString(), InvoiceID: inv.ID, Price: 100, Qty: 1, Status: invoice.New}
+		require.NoError(t, service.AddItem(ctx, item))
+		require.NoError(t, service.DeleteItem(ctx, inv.ID, item.ID))
+
+		cn := newCreditNote(1)
+		cn.Lines[0].ItemID = item.ID
+		_, err := repo.AddCreditNote(ctx, cn)
+		assert.ErrorIs(t, err, invoice.ErrItemNotFound)
+	})
+
+	t.Run("credit note is stored once", func(t *testing.T) {
+		item := invoice.Item{ID: uuid.NewString(), InvoiceID: inv.ID, Price: 100, Qty: 5, Status: invoice.New}
+		require.NoError(t, service.AddItem(ctx, item))
+
+		cn := newCreditNote(1)
+		cn.Lines[0].ItemID = item.ID
+		_, err := service.IssueCreditNote(ctx, cn)
+		require.NoError(t, err)
+
+		_, err = service.IssueCreditNote(ctx, cn)
+		assert.ErrorIs(t, err, invoice.ErrCreditNoteConflict)
+	})
+}
+
+func TestServiceUpdateItem(t *testing.T) {
+	ctx := context.Background()
+	service := invoice.NewService(initRepo())
+
+	inv := invoice.Invoice{ID: uuid.NewString(), Status: invoice.New, Date: time.Now()}
+	item := invoice.Item{
+		ID:        uuid.NewString(),
+		InvoiceID: inv.ID,
+		Name:      "Guitar",
+		Price:     75000,
+		Qty:       1,
+		Status:    invoice.New,
+	}
+	inv.Items = []invoice.Item{item}
+	require.NoError(t, service.StoreInvoice(ctx, inv))
+
+	qty, name := uint(2), ""
+
+	t.Run("updates only changed fields", func(t *testing.T) {
+		err := service.UpdateItem(ctx, inv.ID, item.ID, invoice.ItemUpdate{Qty: &qty})
+		require.NoError(t, err)
+
+		got, err := service.GetItem(ctx, inv.ID, item.ID)
+		require.NoError(t, err)
+		assert.Equal(t, uint(2), got.Qty)
+		assert.Equal(t, item.Name, got.Name)
+		assert.Equal(t, item.Price, got.Price)
+		assert.Equal(t, uint(1), got.Version)
+	})
+
+	t.Run("checks expected version", func(t *testing.T) {
+		stale := uint(0)
+		err := service.UpdateItem(ctx, inv.ID, item.ID, invoice.ItemUpdate{Qty: &qty, ExpectedVersion: &stale})
+		assert.ErrorIs(t, err, invoice.ErrVersionConflict)
+
+		current := uint(1)
+		err = service.UpdateItem(ctx, inv.ID, item.ID, invoice.ItemUpdate{Qty: &qty, ExpectedVersion: &current})
+		assert.NoError(t, err)
+	})
+
+	t.Run("validates fields", func(t *testing.T) {
+		zero := uint(0)
+		err := service.UpdateItem(ctx, inv.ID, item.ID, invoice.ItemUpdate{Qty: &zero, Name: &name})
+		assert.ErrorIs(t, err, invoice.ErrInvalidItemUpdate)
+
+		err = service.UpdateItem(ctx, inv.ID, item.ID, invoice.ItemUpdate{})
+		assert.ErrorIs(t, err, invoice.ErrInvalidItemUpdate)
+	})
+
+	t.Run("fails for missing item", func(t *testing.T) {
+		err := service.UpdateItem(ctx, inv.ID, uuid.NewString(), invoice.ItemUpdate{Qty: &qty})
+		assert.ErrorIs(t, err, invoice.ErrItemNotFound)
+	})
+}
+
+func TestServiceReplaceItems(t *testing.T) {
+	ctx := context.Background()
+	service := invoice.NewService(initRepo())
+
+	inv := invoice.Invoice{ID: uuid.NewString(), Status: invoice.New, Date: time.Now()}
+	newItem := func(status invoice.Status) invoice.Item {
+		return invoice.Item{ID: uuid.NewString(), InvoiceID: inv.ID, Qty: 1, Status: status}
+	}
+	inv.Items = []invoice.Item{newItem(invoice.New), newItem(invoice.New), newItem(invoice.Pending)}
+	require.NoError(t, service.StoreInvoice(ctx, inv))
+
+	t.Run("cancels only listed items", func(t *testing.T) {
+		replacement := newItem(invoice.New)
+		err := service.ReplaceItems(ctx, inv.ID, []string{inv.Items[0].ID}, []invoice.Item{replacement})
+		require.NoError(t, err)
+
+		items, err := service.GetInvoiceItemsByStatus(ctx, inv.ID, invoice.New)
+		require.NoError(t, err)
+		ids := []string{}
+		for _, item := range items {
+			ids = append(ids, item.ID)
+		}
+		assert.ElementsMatch(t, []string{inv.Items[1].ID, replacement.ID}, ids)
+	})
+
+	t.Run("adds new items when there is nothing to cancel", func(t *testing.T) {
+		item := newItem(invoice.New)
+		err := service.ReplaceItems(ctx, inv.ID, nil, []invoice.Item{item})
+		require.NoError(t, err)
+
+		got, err := service.GetItem(ctx, inv.ID, item.ID)
+		require.NoError(t, err)
+		assert.NotNil(t, got)
+	})
+
+	t.Run("fails for items not in NEW status", func(t *testing.T) {
+		err := service.ReplaceItems(ctx, inv.ID, []string{inv.Items[2].ID}, nil)
+		assert.ErrorIs(t, err, invoice.ErrItemConflict)
+
+		err = service.ReplaceItems(ctx, inv.ID, []string{inv.Items[0].ID}, nil)
+		assert.ErrorIs(t, err, invoice.ErrItemConflict)
+	})
+
+	t.Run("fails for existing new items", func(t *testing.T) {
+		err := service.ReplaceItems(ctx, inv.ID, nil, []invoice.Item{inv.Items[1]})
+		assert.ErrorIs(t, err, invoice.ErrItemConflict)
+	})
+
+	t.Run("fails for missing old items", func(t *testing.T) {
+		err := service.ReplaceItems(ctx, inv.ID, []string{uuid.NewString()}, nil)
+		assert.ErrorIs(t, err, invoice.ErrItemNotFound)
+	})
+
+	t.Run("fails for repeated old items", func(t *testing.T) {
+		err := service.ReplaceItems(ctx, inv.ID, []string{inv.Items[1].ID, inv.Items[1].ID}, nil)
+		assert.ErrorIs(t, err, invoice.ErrInvalidItemUpdate)
+
+		got, err := service.GetItem(ctx, inv.ID, inv.Items[1].ID)
+		require.NoError(t, err)
+		assert.Equal(t, invoice.New, got.Status)
+	})
+}
+
+func TestServiceValidation(t *testing.T) {
+	ctx := context.Background()
+	noBundles := func(item invoice.Item) []invoice.FieldError {
+		if item.Qty > 10 {
+			return []invoice.FieldError{{Field: "qty", Message: "must not exceed 10"}}
+		}
+		return nil
+	}
+	service := invoice.NewService(initRepo(), invoice.WithItemRules(noBundles))
+
+	t.Run("rejects invoice with invalid items", func(t *testing.T) {
+		inv := invoice.Invoice{ID: uuid.NewString(), Status: invoice.New}
+		itemID := uuid.NewString()
+		inv.Items = []invoice.Item{
+			{ID: itemID, InvoiceID: inv.ID, Qty: 1},
+			{ID: itemID, InvoiceID: uuid.NewString(), Qty: 0},
+		}
+
+		err := service.StoreInvoice(ctx, inv)
+		assert.ErrorIs(t, err, invoice.ErrInvalidInvoice)
+
+		var verr *invoice.ValidationError
+		require.ErrorAs(t, err, &verr)
+		assert.ElementsMatch(t, []invoice.FieldError{
+			{Field: "date", Message: "must be set"},
+			{Field: "items[1].qty", Message: "must be greater than zero"},
+			{Field: "items[1].invoiceId", Message: "must match invoice id"},
+			{Field: "items[1].id", Message: "must be unique"},
+		}, verr.Fields)
+
+		got, err := service.GetInvoice(ctx, inv.ID)
+		require.NoError(t, err)
+		assert.Nil(t, got)
+	})
+
+	t.Run("applies custom rules", func(t *testing.T) {
+		item := invoice.Item{ID: uuid.NewString(), InvoiceID: uuid.NewString(), Qty: 11}
+
+		err := service.AddItem(ctx, item)
+		assert.ErrorIs(t, err, invoice.ErrInvalidItem)
+
+		var verr *invoice.ValidationError
+		require.ErrorAs(t, err, &verr)
+		assert.Equal(t, []invoice.FieldError{{Field: "qty", Message: "must not exceed 10"}}, verr.Fields)
+	})
+
+	t.Run("rejects replacement items of other invoice", func(t *testing.T) {
+		item := invoice.Item{ID: uuid.NewString(), InvoiceID: uuid.NewString(), Qty: 1}
+
+		err := service.ReplaceItems(ctx, uuid.NewString(), nil, []invoice.Item{item})
+		assert.ErrorIs(t, err, invoice.ErrInvalidItem)
+	})
+
+	t.Run("applies custom rules to updated items", func(t *testing.T) {
+		inv := invoice.Invoice{ID: uuid.NewString(), Status: invoice.New, Date: time.Now()}
+		item := invoice.Item{ID: uuid.NewString(), InvoiceID: inv.ID, Qty: 1}
+		inv.Items = []invoice.Item{item}
+		require.NoError(t, service.StoreInvoice(ctx, inv))
+
+		qty := uint(11)
+		err := service.UpdateItem(ctx, inv.ID, item.ID, invoice.ItemUpdate{Qty: &qty})
+		assert.ErrorIs(t, err, invoice.ErrInvalidItemUpdate)
+
+		var verr *invoice.ValidationError
+		require.ErrorAs(t, err, &verr)
+		assert.Equal(t, []invoice.FieldError{{Field: "qty", Message: "must not exceed 10"}}, verr.Fields)
+
+		got, err := service.GetItem(ctx, inv.ID, item.ID)
+		require.NoError(t, err)
+		assert.Equal(t, uint(1), got.Qty)
+	})
+
+	t.Run("reports invalid update fields", func(t *testing.T) {
+		zero := uint(0)
+		err := service.UpdateItem(ctx, uuid.NewString(), uuid.NewString(), invoice.ItemUpdate{Qty: &zero})
+
+		var verr *invoice.ValidationError
+		require.ErrorAs(t, err, &verr)
+		assert.Equal(t, []invoice.FieldError{{Field: "qty", Message: "must be greater than zero"}}, verr.Fields)
+	})
+}
+
+func TestServiceTenancy(t *testing.T) {
+	service := invoice.NewService(initRepo())
+	acme := invoice.WithTenant(context.Background(), "acme-"+uuid.NewString())
+	globex := invoice.WithTenant(context.Background(), "globex-"+uuid.NewString())
+
+	inv := invoice.Invoice{ID: uuid.NewString(), CustomerName: "Acme", Status: invoice.New, Date: time.Now()}
+	item := invoice.Item{ID: uuid.NewString(), InvoiceID: inv.ID, Price: 100, Qty: 1, Status: invoice.New}
+	inv.Items = []invoice.Item{item}
+	require.NoError(t, service.StoreInvoice(acme, inv))
+
+	t.Run("other tenants do not read the invoice", func(t *testing.T) {
+		for _, ctx := range []context.Context{globex, context.Background()} {
+			got, err := service.GetInvoice(ctx, inv.ID)
+			require.NoError(t, err)
+			assert.Nil(t, got)
+
+			gotItem, err := service.GetItem(ctx, inv.ID, item.ID)
+			require.NoError(t, err)
+			assert.Nil(t, gotItem)
+
+			items, err := service.GetItemsByStatus(ctx, invoice.New)
+			require.NoError(t, err)
+			for _, i := range items {
+				assert.NotEqual(t, item.ID, i.ID)
+			}
+		}
+	})
+
+	t.Run("other tenants do not write the invoice", func(t *testing.T) {
+		other := invoice.Item{ID: uuid.NewString(), InvoiceID: inv.ID, Price: 100, Qty: 1, Status: invoice.New}
+		err := service.AddItem(globex, other)
+		assert.ErrorIs(t, err, invoice.ErrInvoiceNotFound)
+
+		err = service.RecordPayment(globex, invoice.Payment{
+			ID: uuid.NewString(), InvoiceID: inv.ID, Amount: 100, Method: "card", ReceivedAt: time.Now(),
+		})
+		assert.ErrorIs(t, err, invoice.ErrInvoiceNotFound)
+	})
+
+	t.Run("tenants keep invoices with the same id apart", func(t *testing.T) {
+		theirs := invoice.Invoice{ID: inv.ID, CustomerName: "Globex", Status: invoice.New, Date: time.Now()}
+		require.NoError(t, service.StoreInvoice(globex, theirs))
+
+		got, err := service.GetInvoice(acme, inv.ID)
+		require.NoError(t, err)
+		require.NotNil(t, got)
+		assert.Equal(t, "Acme", got.CustomerName)
+		assert.Equal(t, uint(1), got.ItemCount)
+
+		got, err = service.GetInvoice(globex, inv.ID)
+		require.NoError(t, err)
+		require.NotNil(t, got)
+		assert.Equal(t, "Globex", got.CustomerName)
+		assert.Equal(t, uint(0), got.ItemCount)
+	})
+}
+
+// readRecorder records read options of GetInvoice.
+type readRecorder struct {
+	invoice.Repository
+	reads []invoice.ReadOptions
+}
+
+func (r *readRecorder) GetInvoice(ctx context.Context, invoiceID string, opts ...invoice.ReadOption) (
+	*invoice.Invoice, error) {
+
+	r.reads = append(r.reads, invoice.NewReadOptions(opts...))
+	return r.Repository.GetInvoice(ctx, invoiceID, opts...)
+}
+
+func TestServiceReadOptions(t *testing.T) {
+	ctx := context.Background()
+	repo := &readRecorder{Repository: memory.NewRepository()}
+	service := invoice.NewService(repo, invoice.WithReadOptions(invoice.ConsistentRead()))
+
+	_, err := service.GetInvoice(ctx, uuid.NewString(), invoice.IncludeDeleted())
+	require.NoError(t, err)
+	_, err = service.GetBalance(ctx, uuid.NewString())
+	assert.ErrorIs(t, err, invoice.ErrInvoiceNotFound)
+
+	assert.Equal(t, []invoice.ReadOptions{
+		{IncludeDeleted: true, ConsistentRead: true},
+		{ConsistentRead: true},
+	}, repo.reads)
+}
@@ -0,0 +1,70 @@
+package invoice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// TenantSeparator may not be used in tenants, it separates key segments of
+// tenant rows, so a tenant containing it would match keys of other tenants.
+const TenantSeparator = "#"
+
+var (
+	// ErrTenantMismatch is returned when the tenant of the context differs
+	// from the tenant the repository is bound to.
+	ErrTenantMismatch = errors.New("tenant does not match repository tenant")
+	// ErrInvalidTenant is returned for empty tenants and tenants containing
+	// TenantSeparator.
+	ErrInvalidTenant = errors.New("invalid tenant")
+)
+
+type tenantKey struct{}
+
+// WithTenant returns a copy of ctx that scopes repository operations made
+// with this context to the tenant. Invoices of other tenants are neither
+// read nor written. Operations with invalid tenant fail with
+// ErrInvalidTenant, see ValidateTenant.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant carried by ctx, or empty string.
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// ValidateTenant returns ErrInvalidTenant when the tenant is empty or
+// contains TenantSeparator.
+func ValidateTenant(tenant string) error {
+	if tenant == "" || strings.Contains(tenant, TenantSeparator) {
+		return fmt.Errorf("%w: %q", ErrInvalidTenant, tenant)
+	}
+	return nil
+}
+
+// ResolveTenant returns the tenant of the operation made with ctx by a
+// repository bound to the tenant, empty bound means the repository is not
+// bound. Empty result means no tenant, the default scope of invoices
+// stored without tenant.
+func ResolveTenant(ctx context.Context, bound string) (string, error) {
+	if bound != "" {
+		if err := ValidateTenant(bound); err != nil {
+			return "", err
+		}
+	}
+
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	if !ok {
+		return bound, nil
+	}
+	if err := ValidateTenant(tenant); err != nil {
+		return "", err
+	}
+	if bound != "" && tenant != bound {
+		return "", ErrTenantMismatch
+	}
+	return tenant, nil
+}
@@ -0,0 +1,46 @@
+package invoice
+
+import "strings"
+
+// ItemUpdate describes partial update of invoice item. Only not nil fields
+// are changed.
+type ItemUpdate struct {
+	Name  *string
+	Qty   *uint
+	Price *uint
+
+	// ExpectedVersion makes update conditional. When set, the update fails
+	// with ErrVersionConflict if the item version differs.
+	ExpectedVersion *uint
+}
+
+// Validate checks values of the changed fields. It returns *ValidationError
+// wrapping ErrInvalidItemUpdate.
+func (u ItemUpdate) Validate() error {
+	var fields []FieldError
+	if u.Name == nil && u.Qty == nil && u.Price == nil {
+		fields = append(fields, FieldError{"", "nothing to update"})
+	}
+	if u.Name != nil && strings.TrimSpace(*u.Name) == "" {
+		fields = append(fields, FieldError{"name", "must not be empty"})
+	}
+	if u.Qty != nil && *u.Qty == 0 {
+		fields = append(fields, FieldError{"qty", "must be greater than zero"})
+	}
+
+	return validationError(ErrInvalidItemUpdate, fields)
+}
+
+// Apply sets changed fields of the item and increments its version.
+func (u ItemUpdate) Apply(item *Item) {
+	if u.Name != nil {
+		item.Name = *u.Name
+	}
+	if u.Qty != nil {
+		item.Qty = *u.Qty
+	}
+	if u.Price != nil {
+		item.Price = *u.Price
+	}
+	item.Version++
+}
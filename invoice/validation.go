@@ -0,0 +1,176 @@
+package invoice
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FieldError describes invalid value of a field. Field is the path of the
+// field, for example "items[1].qty".
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e FieldError) String() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+// ValidationError lists invalid fields. It wraps the error describing what
+// was validated, so errors.Is(err, ErrInvalidItemUpdate) keeps working.
+type ValidationError struct {
+	Err    error
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, len(e.Fields))
+	for idx, f := range e.Fields {
+		problems[idx] = f.String()
+	}
+	return fmt.Sprintf("%s: %s", e.Err, strings.Join(problems, ", "))
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// validationError returns nil when there are no field errors.
+func validationError(err error, fields []FieldError) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	return &ValidationError{Err: err, Fields: fields}
+}
+
+// InvoiceRule checks the invoice. Items of the invoice are checked by item rules.
+type InvoiceRule func(Invoice) []FieldError
+
+// ItemRule checks the item.
+type ItemRule func(Item) []FieldError
+
+// Validator checks invoices and items before they are stored. Default rules
+// are always applied, custom rules are added per deployment.
+type Validator struct {
+	invoiceRules []InvoiceRule
+	itemRules    []ItemRule
+}
+
+// NewValidator creates validator with default rules.
+func NewValidator() *Validator {
+	return &Validator{
+		invoiceRules: []InvoiceRule{requiredInvoiceFields},
+		itemRules:    []ItemRule{requiredItemFields},
+	}
+}
+
+// AddInvoiceRule adds custom invoice rule.
+func (v *Validator) AddInvoiceRule(rule InvoiceRule) {
+	v.invoiceRules = append(v.invoiceRules, rule)
+}
+
+// AddItemRule adds custom item rule.
+func (v *Validator) AddItemRule(rule ItemRule) {
+	v.itemRules = append(v.itemRules, rule)
+}
+
+// ValidateInvoice checks the invoice and its items. Items must belong to the
+// invoice and have unique IDs.
+func (v *Validator) ValidateInvoice(inv Invoice) error {
+	var fields []FieldError
+	for _, rule := range v.invoiceRules {
+		fields = append(fields, rule(inv)...)
+	}
+	fields = append(fields, v.checkItems("items", inv.ID, inv.Items)...)
+
+	return validationError(ErrInvalidInvoice, fields)
+}
+
+// ValidateItem checks the item.
+func (v *Validator) ValidateItem(item Item) error {
+	return validationError(ErrInvalidItem, v.checkItem("", item))
+}
+
+// ValidateItemUpdate checks the item with the update applied. It returns
+// *ValidationError wrapping ErrInvalidItemUpdate.
+func (v *Validator) ValidateItemUpdate(item Item) error {
+	return validationError(ErrInvalidItemUpdate, v.checkItem("", item))
+}
+
+// ValidateItems checks items added to the invoice.
+func (v *Validator) ValidateItems(invoiceID string, items []Item) error {
+	return validationError(ErrInvalidItem, v.checkItems("items", invoiceID, items))
+}
+
+// ValidateItemIDs checks that item IDs are not repeated, e.g. IDs of items
+// replaced with ReplaceItems. It returns *ValidationError wrapping
+// ErrInvalidItemUpdate.
+func ValidateItemIDs(path string, itemIDs []string) error {
+	var fields []FieldError
+	seen := make(map[string]bool, len(itemIDs))
+	for idx, itemID := range itemIDs {
+		if seen[itemID] {
+			fields = append(fields, FieldError{fmt.Sprintf("%s[%d]", path, idx), "must be unique"})
+		}
+		seen[itemID] = true
+	}
+
+	return validationError(ErrInvalidItemUpdate, fields)
+}
+
+func (v *Validator) checkItems(path, invoiceID string, items []Item) []FieldError {
+	var fields []FieldError
+	seen := make(map[string]bool, len(items))
+	for idx, item := range items {
+		prefix := fmt.Sprintf("%s[%d].", path, idx)
+		fields = append(fields, v.checkItem(prefix, item)...)
+
+		if item.InvoiceID != "" && item.InvoiceID != invoiceID {
+			fields = append(fields, FieldError{prefix + "invoiceId", "must match invoice id"})
+		}
+		if item.ID != "" && seen[item.ID] {
+			fields = append(fields, FieldError{prefix + "id", "must be unique"})
+		}
+		seen[item.ID] = true
+	}
+	return fields
+}
+
+func (v *Validator) checkItem(prefix string, item Item) []FieldError {
+	var fields []FieldError
+	for _, rule := range v.itemRules {
+		for _, f := range rule(item) {
+			f.Field = prefix + f.Field
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+func requiredInvoiceFields(inv Invoice) []FieldError {
+	var fields []FieldError
+	if strings.TrimSpace(inv.ID) == "" {
+		fields = append(fields, FieldError{"id", "must not be empty"})
+	}
+	if inv.Date.IsZero() {
+		fields = append(fields, FieldError{"date", "must be set"})
+	}
+	return fields
+}
+
+func requiredItemFields(item Item) []FieldError {
+	var fields []FieldError
+	if strings.TrimSpace(item.ID) == "" {
+		fields = append(fields, FieldError{"id", "must not be empty"})
+	}
+	if strings.TrimSpace(item.InvoiceID) == "" {
+		fields = append(fields, FieldError{"invoiceId", "must not be empty"})
+	}
+	if item.Qty == 0 {
+		fields = append(fields, FieldError{"qty", "must be greater than zero"})
+	}
+	return fields
+}
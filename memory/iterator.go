@@ -0,0 +1,48 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/antklim/go-dynamodb/invoice"
+)
+
+// itemIterator implements invoice.ItemIterator over a snapshot of items
+// taken when the iterator was created.
+type itemIterator struct {
+	ctx   context.Context
+	items []invoice.Item
+	item  invoice.Item
+	err   error
+}
+
+func newItemIterator(ctx context.Context, items []invoice.Item, err error) *itemIterator {
+	return &itemIterator{ctx: ctx, items: items, err: err}
+}
+
+func (it *itemIterator) Next() bool {
+	if it.err != nil || len(it.items) == 0 {
+		return false
+	}
+
+	if err := it.ctx.Err(); err != nil {
+		it.err = err
+		return false
+	}
+
+	it.item = it.items[0]
+	it.items = it.items[1:]
+	return true
+}
+
+func (it *itemIterator) Item() invoice.Item {
+	return it.item
+}
+
+func (it *itemIterator) Err() error {
+	return it.err
+}
+
+func (it *itemIterator) Close() error {
+	it.items = nil
+	return nil
+}
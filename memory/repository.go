@@ -2,10 +2,13 @@ package memory
 
 import (
 	"context"
-	"errors"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/antklim/go-dynamodb/invoice"
+	"github.com/google/uuid"
 )
 
 type invoices struct {
@@ -35,30 +38,61 @@ func (i *invoices) get(invoiceID string) (*invoice.Invoice, error) {
 	return nil, nil
 }
 
+func (i *invoices) update(invoiceID string, f func(*invoice.Invoice)) (before, after *invoice.Invoice) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	inv, ok := i.table[invoiceID]
+	if !ok {
+		return nil, nil
+	}
+
+	prev := inv
+	f(&inv)
+	i.table[invoiceID] = inv
+	return &prev, &inv
+}
+
 type itemFilter func(invoice.Item) bool
 
+// itemKey identifies the item, item IDs are unique within their invoice
+// only, as DynamoDB keys of items are.
+type itemKey struct {
+	invoiceID string
+	itemID    string
+}
+
+func keyOf(item invoice.Item) itemKey {
+	return itemKey{invoiceID: item.InvoiceID, itemID: item.ID}
+}
+
 type items struct {
 	mu    sync.RWMutex
-	table map[string]invoice.Item
+	table map[itemKey]invoice.Item
 }
 
+// create stores the item. It returns invoice.ErrItemConflict when the item
+// exists, including deleted one.
 func (i *items) create(item invoice.Item) error {
 	i.mu.Lock()
 	defer i.mu.Unlock()
 
 	if i.table == nil {
-		i.table = make(map[string]invoice.Item)
+		i.table = make(map[itemKey]invoice.Item)
+	}
+	if _, ok := i.table[keyOf(item)]; ok {
+		return invoice.ErrItemConflict
 	}
 
-	i.table[item.ID] = item
+	i.table[keyOf(item)] = item
 	return nil
 }
 
-func (i *items) get(itemID string) (*invoice.Item, error) {
+func (i *items) get(invoiceID, itemID string) (*invoice.Item, error) {
 	i.mu.RLock()
 	defer i.mu.RUnlock()
 
-	if item, ok := i.table[itemID]; ok {
+	if item, ok := i.table[itemKey{invoiceID: invoiceID, itemID: itemID}]; ok {
 		return &item, nil
 	}
 	return nil, nil
@@ -80,15 +114,63 @@ func (i *items) scan(s itemFilter) ([]invoice.Item, error) {
 		return nil, nil
 	}
 
+	// Sort like DynamoDB sorts items of an invoice, so that readers can page
+	// through items by offset.
+	sort.Slice(acc, func(a, b int) bool {
+		if acc[a].InvoiceID != acc[b].InvoiceID {
+			return acc[a].InvoiceID < acc[b].InvoiceID
+		}
+		return acc[a].ID < acc[b].ID
+	})
+
 	return acc, nil
 }
 
-func (i *items) del(itemID string) error {
+// update applies f to the item. Deleted items are not updated.
+func (i *items) update(invoiceID, itemID string, f func(*invoice.Item)) (before, after *invoice.Item) {
 	i.mu.Lock()
 	defer i.mu.Unlock()
 
-	delete(i.table, itemID)
-	return nil
+	key := itemKey{invoiceID: invoiceID, itemID: itemID}
+	item, ok := i.table[key]
+	if !ok || item.Deleted() {
+		return nil, nil
+	}
+
+	prev := item
+	f(&item)
+	i.table[key] = item
+	return &prev, &item
+}
+
+func (i *items) restore(invoiceID, itemID string, at time.Time) (before, after *invoice.Item) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	key := itemKey{invoiceID: invoiceID, itemID: itemID}
+	item, ok := i.table[key]
+	if !ok || !item.Deleted() {
+		return nil, nil
+	}
+
+	prev := item
+	item.DeletedAt = time.Time{}
+	item.DeletedBy = ""
+	item.UpdatedAt = at
+	item.Version++
+	i.table[key] = item
+	return &prev, &item
+}
+
+// readable wraps the filter to exclude deleted items unless deleted items
+// were requested.
+func readable(f itemFilter, opts []invoice.ReadOption) itemFilter {
+	if invoice.NewReadOptions(opts...).IncludeDeleted {
+		return f
+	}
+	return func(item invoice.Item) bool {
+		return !item.Deleted() && f(item)
+	}
 }
 
 func itemsByStatus(status invoice.Status) itemFilter {
@@ -109,36 +191,402 @@ func invoiceItemsByStatus(invoiceID string, status invoice.Status) itemFilter {
 	}
 }
 
-type Repository struct {
+type payments struct {
+	mu    sync.RWMutex
+	table map[string]invoice.Payment
+}
+
+func (p *payments) create(payment invoice.Payment) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.table == nil {
+		p.table = make(map[string]invoice.Payment)
+	}
+
+	p.table[payment.ID] = payment
+	return nil
+}
+
+func (p *payments) get(paymentID string) (*invoice.Payment, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if payment, ok := p.table[paymentID]; ok {
+		return &payment, nil
+	}
+	return nil, nil
+}
+
+func (p *payments) update(paymentID string, f func(*invoice.Payment)) (before, after *invoice.Payment) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	payment, ok := p.table[paymentID]
+	if !ok {
+		return nil, nil
+	}
+
+	prev := payment
+	f(&payment)
+	p.table[paymentID] = payment
+	return &prev, &payment
+}
+
+// list returns payments of the invoice in the order they were received,
+// payments received at the same time are ordered by ID. The order is stable,
+// so that pages of the list do not overlap.
+func (p *payments) list(invoiceID string) ([]invoice.Payment, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var acc []invoice.Payment
+	for _, payment := range p.table {
+		if payment.InvoiceID == invoiceID {
+			acc = append(acc, payment)
+		}
+	}
+
+	sort.Slice(acc, func(a, b int) bool {
+		if !acc[a].ReceivedAt.Equal(acc[b].ReceivedAt) {
+			return acc[a].ReceivedAt.Before(acc[b].ReceivedAt)
+		}
+		return acc[a].ID < acc[b].ID
+	})
+	return acc, nil
+}
+
+type creditNotes struct {
+	mu     sync.RWMutex
+	table  map[string]invoice.CreditNote
+	number int // number of the last stored credit note
+}
+
+// create assigns the next number to the credit note and stores it.
+func (c *creditNotes) create(cn invoice.CreditNote) (*invoice.CreditNote, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.table == nil {
+		c.table = make(map[string]invoice.CreditNote)
+	}
+	if _, ok := c.table[cn.ID]; ok {
+		return nil, invoice.ErrCreditNoteConflict
+	}
+
+	c.number++
+	cn.Number = strconv.Itoa(c.number)
+	c.table[cn.ID] = cn
+	return &cn, nil
+}
+
+func (c *creditNotes) get(creditNoteID string) (*invoice.CreditNote, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if cn, ok := c.table[creditNoteID]; ok {
+		return &cn, nil
+	}
+	return nil, nil
+}
+
+// list returns credit notes of the invoice in the order they were issued,
+// credit notes of the same date are ordered by ID, see payments.list.
+func (c *creditNotes) list(invoiceID string) ([]invoice.CreditNote, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var acc []invoice.CreditNote
+	for _, cn := range c.table {
+		if cn.InvoiceID == invoiceID {
+			acc = append(acc, cn)
+		}
+	}
+
+	sort.Slice(acc, func(a, b int) bool {
+		if !acc[a].Date.Equal(acc[b].Date) {
+			return acc[a].Date.Before(acc[b].Date)
+		}
+		return acc[a].ID < acc[b].ID
+	})
+	return acc, nil
+}
+
+type history struct {
+	mu    sync.RWMutex
+	table map[string][]invoice.Change // changes by invoice ID
+}
+
+func (h *history) append(change invoice.Change) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.table == nil {
+		h.table = make(map[string][]invoice.Change)
+	}
+
+	h.table[change.InvoiceID] = append(h.table[change.InvoiceID], change)
+	return nil
+}
+
+func (h *history) list(invoiceID string) ([]invoice.Change, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	changes := h.table[invoiceID]
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	acc := make([]invoice.Change, len(changes))
+	copy(acc, changes)
+	return acc, nil
+}
+
+type outbox struct {
+	mu     sync.RWMutex
+	events []invoice.Event // events in order they were written
+}
+
+func (o *outbox) append(event invoice.Event) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.events = append(o.events, event)
+	return nil
+}
+
+func (o *outbox) pending(limit int) ([]invoice.Event, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	if limit < 1 {
+		return nil, nil
+	}
+	if limit > len(o.events) {
+		limit = len(o.events)
+	}
+
+	acc := make([]invoice.Event, limit)
+	copy(acc, o.events)
+	return acc, nil
+}
+
+func (o *outbox) del(eventID string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for idx, event := range o.events {
+		if event.ID == eventID {
+			o.events = append(o.events[:idx], o.events[idx+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// store holds entities of one tenant.
+type store struct {
+	tenant string
+	bal    sync.Mutex // serializes writes checked against or changing invoice balance or credits
+
 	invs invoices
 	itms items
+	pays payments
+	crns creditNotes
+	hist history
+}
+
+type Repository struct {
+	mu     sync.Mutex
+	stores map[string]*store // by tenant, empty tenant holds entities stored without tenant
+	outb   outbox
+
+	clock  invoice.Clock
+	tenant string
+}
+
+// Option configures repository.
+type Option func(*Repository)
+
+// WithClock sets the clock used for createdAt and updatedAt of stored entities.
+func WithClock(c invoice.Clock) Option {
+	return func(r *Repository) {
+		r.clock = c
+	}
 }
 
-var errNotImplemented = errors.New("not implemented")
+// WithState starts the repository with the state, e.g. loaded from a dump
+// of the DynamoDB table.
+func WithState(st State) Option {
+	return func(r *Repository) {
+		r.Restore(st)
+	}
+}
+
+// WithTenant binds the repository to the tenant. Operations with context
+// of another tenant fail with invoice.ErrTenantMismatch.
+func WithTenant(tenant string) Option {
+	return func(r *Repository) {
+		r.tenant = tenant
+	}
+}
 
 // NewRepository creates in memory implementation of the repository
-func NewRepository() *Repository {
-	return &Repository{}
+func NewRepository(opts ...Option) *Repository {
+	r := &Repository{clock: invoice.SystemClock()}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
 func (r *Repository) AddInvoice(ctx context.Context, inv invoice.Invoice) error {
-	return r.invs.create(inv)
+	s, err := r.scope(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := r.clock.Now()
+	inv.CreatedAt, inv.UpdatedAt = now, now
+	items := invoice.Created(inv.Items, now)
+	inv.Items = nil
+	inv.ItemCount, inv.Total = invoice.ItemTotals(items)
+	if err := s.invs.create(inv); err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if err := s.itms.create(item); err != nil {
+			return err
+		}
+	}
+
+	after := invoice.Snapshot{Invoice: &inv, Items: items}
+	return r.record(ctx, s, inv.ID, invoice.OpAddInvoice, invoice.Snapshot{}, after)
+}
+
+// GetInvoice gets the invoice with its items, deleted items are skipped
+// unless requested. Reads of the memory repository are always consistent.
+func (r *Repository) GetInvoice(
+	ctx context.Context, invoiceID string, opts ...invoice.ReadOption) (*invoice.Invoice, error) {
+
+	s, err := r.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	inv, err := s.invs.get(invoiceID)
+	if err != nil || inv == nil {
+		return nil, err
+	}
+
+	if inv.Items, err = s.itms.scan(readable(invoiceItems(invoiceID), opts)); err != nil {
+		return nil, err
+	}
+	return inv, nil
 }
 
-func (r *Repository) GetInvoice(ctx context.Context, invoiceID string) (*invoice.Invoice, error) {
-	return r.invs.get(invoiceID)
+// CancelInvoice sets status of the invoice and its items cancelled. It
+// returns invoice.ErrInvoiceNotFound when the invoice does not exist.
+func (r *Repository) CancelInvoice(ctx context.Context, invoiceID string) error {
+	s, err := r.scope(ctx)
+	if err != nil {
+		return err
+	}
+
+	// payments are checked against status of the invoice
+	s.bal.Lock()
+	defer s.bal.Unlock()
+
+	inv, err := s.invs.get(invoiceID)
+	if err != nil {
+		return err
+	}
+	if inv == nil {
+		return invoice.ErrInvoiceNotFound
+	}
+	if inv.Status == invoice.Cancelled {
+		return nil
+	}
+
+	items, err := s.itms.scan(invoiceItems(invoiceID))
+	if err != nil {
+		return err
+	}
+
+	now := r.clock.Now()
+	var before, after invoice.Snapshot
+	for _, item := range items {
+		if item.Status == invoice.Cancelled {
+			continue
+		}
+		prev, next := s.itms.update(invoiceID, item.ID, setStatus(invoice.Cancelled, now))
+		if prev == nil {
+			continue
+		}
+		before.Items = append(before.Items, *prev)
+		after.Items = append(after.Items, *next)
+	}
+
+	if items, err = s.itms.scan(invoiceItems(invoiceID)); err != nil {
+		return err
+	}
+	count, total := invoice.ItemTotals(items)
+	before.Invoice, after.Invoice = s.invs.update(invoiceID, func(inv *invoice.Invoice) {
+		inv.Status = invoice.Cancelled
+		inv.ItemCount = count
+		inv.Total = total
+		inv.UpdatedAt = now
+	})
+
+	return r.record(ctx, s, invoiceID, invoice.OpCancelInvoice, before, after)
 }
 
 func (r *Repository) AddItem(ctx context.Context, item invoice.Item) error {
-	return r.itms.create(item)
+	s, err := r.scope(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkInvoice(item.InvoiceID); err != nil {
+		return err
+	}
+
+	now := r.clock.Now()
+	item.CreatedAt, item.UpdatedAt = now, now
+	if err := s.itms.create(item); err != nil {
+		return err
+	}
+
+	after := invoice.Snapshot{Items: []invoice.Item{item}}
+	return r.recordItems(ctx, s, item.InvoiceID, invoice.OpAddItem, invoice.Snapshot{}, after, now)
 }
 
-func (r *Repository) GetItem(ctx context.Context, invoiceID, itemID string) (*invoice.Item, error) {
-	return r.itms.get(itemID)
+func (r *Repository) GetItem(
+	ctx context.Context, invoiceID, itemID string, opts ...invoice.ReadOption) (*invoice.Item, error) {
+
+	s, err := r.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := s.itms.get(invoiceID, itemID)
+	if item == nil || err != nil {
+		return nil, err
+	}
+
+	if item.Deleted() && !invoice.NewReadOptions(opts...).IncludeDeleted {
+		return nil, nil
+	}
+
+	return item, nil
 }
 
-func (r *Repository) GetItemProduct(ctx context.Context, invoiceID, itemID string) (*invoice.Product, error) {
-	item, err := r.itms.get(itemID)
+func (r *Repository) GetItemProduct(
+	ctx context.Context, invoiceID, itemID string, opts ...invoice.ReadOption) (*invoice.Product, error) {
+
+	item, err := r.GetItem(ctx, invoiceID, itemID, opts...)
 	if item == nil || err != nil {
 		return nil, err
 	}
@@ -152,36 +600,595 @@ func (r *Repository) GetItemProduct(ctx context.Context, invoiceID, itemID strin
 	return &p, nil
 }
 
+func (r *Repository) UpdateItem(
+	ctx context.Context, invoiceID, itemID string, upd invoice.ItemUpdate) error {
+
+	s, err := r.scope(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkInvoice(invoiceID); err != nil {
+		return err
+	}
+
+	// credits are checked against the updated item under the balance lock
+	s.bal.Lock()
+	issued, err := s.crns.list(invoiceID)
+	if err != nil {
+		s.bal.Unlock()
+		return err
+	}
+	credit := invoice.Credited(issued)[itemID]
+
+	var conflict, exceeds bool
+	now := r.clock.Now()
+	before, after := s.itms.update(invoiceID, itemID, func(item *invoice.Item) {
+		if upd.ExpectedVersion != nil && *upd.ExpectedVersion != item.Version {
+			conflict = true
+			return
+		}
+		updated := *item
+		upd.Apply(&updated)
+		if !credit.CoveredBy(updated) {
+			exceeds = true
+			return
+		}
+		*item = updated
+		item.UpdatedAt = now
+	})
+	s.bal.Unlock()
+
+	if before == nil {
+		return invoice.ErrItemNotFound
+	}
+	if conflict {
+		return invoice.ErrVersionConflict
+	}
+	if exceeds {
+		return invoice.ErrCreditExceedsInvoice
+	}
+
+	return r.recordItems(ctx, s, invoiceID, invoice.OpUpdateItem,
+		invoice.Snapshot{Items: []invoice.Item{*before}},
+		invoice.Snapshot{Items: []invoice.Item{*after}}, now)
+}
+
 func (r *Repository) DeleteItem(ctx context.Context, invoiceID, itemID string) error {
-	return r.itms.del(itemID)
+	s, err := r.scope(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkInvoice(invoiceID); err != nil {
+		return err
+	}
+
+	now := r.clock.Now()
+	actor := invoice.ActorFromContext(ctx)
+	before, after := s.itms.update(invoiceID, itemID, func(item *invoice.Item) {
+		item.DeletedAt = now
+		item.DeletedBy = actor
+		item.UpdatedAt = now
+		item.Version++
+	})
+	if before == nil {
+		return nil
+	}
+
+	return r.recordItems(ctx, s, invoiceID, invoice.OpDeleteItem,
+		invoice.Snapshot{Items: []invoice.Item{*before}},
+		invoice.Snapshot{Items: []invoice.Item{*after}}, now)
+}
+
+func (r *Repository) RestoreItem(ctx context.Context, invoiceID, itemID string) error {
+	s, err := r.scope(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkInvoice(invoiceID); err != nil {
+		return err
+	}
+
+	item, err := s.itms.get(invoiceID, itemID)
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return invoice.ErrItemNotFound
+	}
+
+	now := r.clock.Now()
+	before, after := s.itms.restore(invoiceID, itemID, now)
+	if before == nil {
+		return nil
+	}
+
+	return r.recordItems(ctx, s, invoiceID, invoice.OpRestoreItem,
+		invoice.Snapshot{Items: []invoice.Item{*before}},
+		invoice.Snapshot{Items: []invoice.Item{*after}}, now)
 }
 
-func (r *Repository) GetItemsByStatus(ctx context.Context, status invoice.Status) ([]invoice.Item, error) {
-	return r.itms.scan(itemsByStatus(status))
+func (r *Repository) GetItemsByStatus(
+	ctx context.Context, status invoice.Status, opts ...invoice.ReadOption) ([]invoice.Item, error) {
+
+	s, err := r.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.itms.scan(readable(itemsByStatus(status), opts))
 }
 
-func (r *Repository) GetInvoiceItems(ctx context.Context, invoiceID string) ([]invoice.Item, error) {
-	return r.itms.scan(invoiceItems(invoiceID))
+func (r *Repository) IterateItemsByStatus(
+	ctx context.Context, status invoice.Status, opts ...invoice.ReadOption) invoice.ItemIterator {
+
+	s, err := r.scope(ctx)
+	if err != nil {
+		return newItemIterator(ctx, nil, err)
+	}
+
+	items, err := s.itms.scan(readable(itemsByStatus(status), opts))
+	return newItemIterator(ctx, items, err)
+}
+
+func (r *Repository) GetInvoiceItems(
+	ctx context.Context, invoiceID string, opts ...invoice.ReadOption) ([]invoice.Item, error) {
+
+	s, err := r.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.itms.scan(readable(invoiceItems(invoiceID), opts))
+}
+
+func (r *Repository) IterateInvoiceItems(
+	ctx context.Context, invoiceID string, opts ...invoice.ReadOption) invoice.ItemIterator {
+
+	s, err := r.scope(ctx)
+	if err != nil {
+		return newItemIterator(ctx, nil, err)
+	}
+
+	items, err := s.itms.scan(readable(invoiceItems(invoiceID), opts))
+	return newItemIterator(ctx, items, err)
 }
 
 func (r *Repository) GetInvoiceItemsByStatus(
-	ctx context.Context, invoiceID string, status invoice.Status) ([]invoice.Item, error) {
+	ctx context.Context, invoiceID string, status invoice.Status, opts ...invoice.ReadOption) ([]invoice.Item, error) {
+
+	s, err := r.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-	return r.itms.scan(invoiceItemsByStatus(invoiceID, status))
+	return s.itms.scan(readable(invoiceItemsByStatus(invoiceID, status), opts))
 }
 
 func (r *Repository) UpdateInvoiceItemStatus(
 	ctx context.Context, invoiceID, itemID string, status invoice.Status) error {
 
-	return errNotImplemented
+	s, err := r.scope(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkInvoice(invoiceID); err != nil {
+		return err
+	}
+
+	now := r.clock.Now()
+	before, after := s.itms.update(invoiceID, itemID, setStatus(status, now))
+	if before == nil {
+		return nil
+	}
+
+	return r.recordItems(ctx, s, invoiceID, invoice.OpUpdateItemStatus,
+		invoice.Snapshot{Items: []invoice.Item{*before}},
+		invoice.Snapshot{Items: []invoice.Item{*after}}, now)
 }
 
 func (r *Repository) UpdateInvoiceItemsStatus(
 	ctx context.Context, invoiceID string, itemIDs []string, status invoice.Status) error {
 
-	return errNotImplemented
+	s, err := r.scope(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkInvoice(invoiceID); err != nil {
+		return err
+	}
+
+	var before, after invoice.Snapshot
+	now := r.clock.Now()
+	for _, itemID := range itemIDs {
+		prev, next := s.itms.update(invoiceID, itemID, setStatus(status, now))
+		if prev == nil {
+			continue
+		}
+		before.Items = append(before.Items, *prev)
+		after.Items = append(after.Items, *next)
+	}
+
+	if len(before.Items) == 0 {
+		return nil
+	}
+
+	return r.recordItems(ctx, s, invoiceID, invoice.OpUpdateItemsStatus, before, after, now)
+}
+
+func (r *Repository) ReplaceItems(
+	ctx context.Context, invoiceID string, oldItemIDs []string, newItems []invoice.Item) error {
+
+	s, err := r.scope(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(oldItemIDs) == 0 && len(newItems) == 0 {
+		return nil
+	}
+	if err := invoice.ValidateItemIDs("oldItemIds", oldItemIDs); err != nil {
+		return err
+	}
+
+	if err := s.checkInvoice(invoiceID); err != nil {
+		return err
+	}
+
+	for _, itemID := range oldItemIDs {
+		item, err := s.itms.get(invoiceID, itemID)
+		if err != nil {
+			return err
+		}
+		if item == nil || item.Deleted() {
+			return invoice.ErrItemNotFound
+		}
+		if item.Status != invoice.New {
+			return invoice.ErrItemConflict
+		}
+	}
+
+	for _, item := range newItems {
+		existing, err := s.itms.get(item.InvoiceID, item.ID)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			return invoice.ErrItemConflict
+		}
+	}
+
+	now := r.clock.Now()
+	newItems = invoice.Created(newItems, now)
+	var before, after invoice.Snapshot
+	for _, itemID := range oldItemIDs {
+		prev, next := s.itms.update(invoiceID, itemID, setStatus(invoice.Cancelled, now))
+		if prev == nil {
+			continue
+		}
+		before.Items = append(before.Items, *prev)
+		after.Items = append(after.Items, *next)
+	}
+
+	for _, item := range newItems {
+		if err := s.itms.create(item); err != nil {
+			return err
+		}
+	}
+	after.Items = append(after.Items, newItems...)
+
+	return r.recordItems(ctx, s, invoiceID, invoice.OpReplaceItems, before, after, now)
 }
 
-func (r *Repository) ReplaceItems(ctx context.Context, invoiceID string, newItems []invoice.Item) error {
-	return errNotImplemented
+// AddPayment stores the payment and sets invoice status by its balance.
+func (r *Repository) AddPayment(ctx context.Context, p invoice.Payment) error {
+	s, err := r.scope(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.bal.Lock()
+	defer s.bal.Unlock()
+
+	if err := s.checkPayable(p.InvoiceID); err != nil {
+		return err
+	}
+
+	payments, err := s.pays.list(p.InvoiceID)
+	if err != nil {
+		return err
+	}
+
+	now := r.clock.Now()
+	p.CreatedAt, p.UpdatedAt = now, now
+	before, after := s.invs.update(p.InvoiceID, setPaymentStatus(append(payments, p), now))
+	if before == nil {
+		return invoice.ErrInvoiceNotFound
+	}
+
+	if err := s.pays.create(p); err != nil {
+		return err
+	}
+
+	return r.record(ctx, s, p.InvoiceID, invoice.OpAddPayment,
+		invoice.Snapshot{Invoice: before},
+		invoice.Snapshot{Invoice: after, Payment: &p})
+}
+
+func (r *Repository) GetPayment(
+	ctx context.Context, invoiceID, paymentID string, opts ...invoice.ReadOption) (*invoice.Payment, error) {
+
+	s, err := r.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.pays.get(paymentID)
+}
+
+func (r *Repository) GetPayments(
+	ctx context.Context, invoiceID string, opts ...invoice.ReadOption) ([]invoice.Payment, error) {
+
+	s, err := r.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.pays.list(invoiceID)
+}
+
+// ReversePayment marks the payment reversed and sets invoice status by its
+// balance.
+func (r *Repository) ReversePayment(ctx context.Context, invoiceID, paymentID string) error {
+	s, err := r.scope(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.bal.Lock()
+	defer s.bal.Unlock()
+
+	if err := s.checkInvoice(invoiceID); err != nil {
+		return err
+	}
+
+	p, err := s.pays.get(paymentID)
+	if err != nil {
+		return err
+	}
+	if p == nil || p.InvoiceID != invoiceID {
+		return invoice.ErrPaymentNotFound
+	}
+	if p.Reversed() {
+		return invoice.ErrPaymentReversed
+	}
+
+	now := r.clock.Now()
+	prevPayment, payment := s.pays.update(paymentID, func(p *invoice.Payment) {
+		p.ReversedAt = now
+		p.UpdatedAt = now
+	})
+
+	payments, err := s.pays.list(invoiceID)
+	if err != nil {
+		return err
+	}
+
+	prevInvoice, inv := s.invs.update(invoiceID, setPaymentStatus(payments, now))
+
+	return r.record(ctx, s, invoiceID, invoice.OpReversePayment,
+		invoice.Snapshot{Invoice: prevInvoice, Payment: prevPayment},
+		invoice.Snapshot{Invoice: inv, Payment: payment})
+}
+
+// AddCreditNote stores the credit note with the next sequential number.
+// Credit notes cannot exceed quantities and amounts of the invoice items,
+// see invoice.CheckCreditNote.
+func (r *Repository) AddCreditNote(ctx context.Context, cn invoice.CreditNote) (*invoice.CreditNote, error) {
+	s, err := r.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.bal.Lock()
+	defer s.bal.Unlock()
+
+	// deleted items cannot be credited
+	items, err := s.itms.scan(readable(invoiceItems(cn.InvoiceID), nil))
+	if err != nil {
+		return nil, err
+	}
+
+	issued, err := s.crns.list(cn.InvoiceID)
+	if err != nil {
+		return nil, err
+	}
+
+	cn.Lines = append([]invoice.CreditNoteLine(nil), cn.Lines...)
+	if err := invoice.CheckCreditNote(&cn, items, issued); err != nil {
+		return nil, err
+	}
+
+	cn.CreatedAt = r.clock.Now()
+	stored, err := s.crns.create(cn)
+	if err != nil {
+		return nil, err
+	}
+
+	after := invoice.Snapshot{CreditNote: stored}
+	if err := r.record(ctx, s, cn.InvoiceID, invoice.OpAddCreditNote, invoice.Snapshot{}, after); err != nil {
+		return nil, err
+	}
+
+	return stored, nil
+}
+
+func (r *Repository) GetCreditNote(
+	ctx context.Context, invoiceID, creditNoteID string, opts ...invoice.ReadOption) (*invoice.CreditNote, error) {
+
+	s, err := r.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.crns.get(creditNoteID)
+}
+
+func (r *Repository) GetCreditNotes(
+	ctx context.Context, invoiceID string, opts ...invoice.ReadOption) ([]invoice.CreditNote, error) {
+
+	s, err := r.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.crns.list(invoiceID)
+}
+
+func (r *Repository) GetInvoiceHistory(
+	ctx context.Context, invoiceID string, opts ...invoice.ReadOption) ([]invoice.Change, error) {
+
+	s, err := r.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.hist.list(invoiceID)
+}
+
+// PendingEvents returns up to limit oldest events from the outbox. The
+// outbox is shared by all tenants, events carry their tenant.
+func (r *Repository) PendingEvents(ctx context.Context, limit int) ([]invoice.Event, error) {
+	return r.outb.pending(limit)
+}
+
+// AckEvent removes published event from the outbox.
+func (r *Repository) AckEvent(ctx context.Context, event invoice.Event) error {
+	return r.outb.del(event.ID)
+}
+
+// scope returns the store of the tenant of ctx, see invoice.WithTenant.
+func (r *Repository) scope(ctx context.Context) (*store, error) {
+	tenant, err := invoice.ResolveTenant(ctx, r.tenant)
+	if err != nil {
+		return nil, err
+	}
+	return r.tenantStore(tenant), nil
+}
+
+func (r *Repository) tenantStore(tenant string) *store {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stores == nil {
+		r.stores = make(map[string]*store)
+	}
+	s, ok := r.stores[tenant]
+	if !ok {
+		s = &store{tenant: tenant}
+		r.stores[tenant] = s
+	}
+	return s
+}
+
+// checkInvoice returns invoice.ErrInvoiceNotFound when the invoice does not exist.
+func (s *store) checkInvoice(invoiceID string) error {
+	inv, err := s.invs.get(invoiceID)
+	if err != nil {
+		return err
+	}
+	if inv == nil {
+		return invoice.ErrInvoiceNotFound
+	}
+	return nil
+}
+
+// checkPayable returns invoice.ErrInvoiceNotFound when the invoice does not
+// exist and invoice.ErrInvoiceCancelled when it is cancelled.
+func (s *store) checkPayable(invoiceID string) error {
+	inv, err := s.invs.get(invoiceID)
+	if err != nil {
+		return err
+	}
+	if inv == nil {
+		return invoice.ErrInvoiceNotFound
+	}
+	if inv.Status == invoice.Cancelled {
+		return invoice.ErrInvoiceCancelled
+	}
+	return nil
+}
+
+// recordItems updates item count, total, payment status and updatedAt of the
+// invoice of changed items and records the change.
+func (r *Repository) recordItems(ctx context.Context, s *store, invoiceID string, op invoice.Operation,
+	before, after invoice.Snapshot, at time.Time) error {
+
+	s.bal.Lock()
+	defer s.bal.Unlock()
+
+	items, err := s.itms.scan(invoiceItems(invoiceID))
+	if err != nil {
+		return err
+	}
+	payments, err := s.pays.list(invoiceID)
+	if err != nil {
+		return err
+	}
+
+	count, total := invoice.ItemTotals(items)
+	s.invs.update(invoiceID, func(inv *invoice.Invoice) {
+		inv.ItemCount = count
+		inv.Total = total
+		setPaymentStatus(payments, at)(inv)
+	})
+
+	return r.record(ctx, s, invoiceID, op, before, after)
+}
+
+// record appends the change of the invoice to its history and the event
+// caused by the change to the outbox.
+func (r *Repository) record(ctx context.Context, s *store, invoiceID string, op invoice.Operation,
+	before, after invoice.Snapshot) error {
+
+	change := invoice.Change{
+		ID:        uuid.NewString(),
+		Tenant:    s.tenant,
+		InvoiceID: invoiceID,
+		Operation: op,
+		Actor:     invoice.ActorFromContext(ctx),
+		At:        r.clock.Now(),
+		Before:    before,
+		After:     after,
+	}
+
+	if err := s.hist.append(change); err != nil {
+		return err
+	}
+
+	if event, ok := invoice.EventFromChange(change); ok {
+		return r.outb.append(event)
+	}
+	return nil
+}
+
+// setPaymentStatus sets invoice status by the balance of its total and the
+// payments.
+func setPaymentStatus(payments []invoice.Payment, at time.Time) func(*invoice.Invoice) {
+	return func(inv *invoice.Invoice) {
+		inv.Status = invoice.PaymentStatus(inv.Status, invoice.NewBalance(inv.Total, payments))
+		inv.UpdatedAt = at
+	}
+}
+
+func setStatus(status invoice.Status, at time.Time) func(*invoice.Item) {
+	return func(item *invoice.Item) {
+		item.Status = status
+		item.UpdatedAt = at
+		item.Version++
+	}
 }
@@ -2,7 +2,10 @@ package memory_test
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/antklim/go-dynamodb/invoice"
 	"github.com/antklim/go-dynamodb/memory"
@@ -11,7 +14,10 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-var repo = memory.NewRepository()
+var (
+	now  = time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	repo = memory.NewRepository(memory.WithClock(invoice.FixedClock(now)))
+)
 
 func TestInvoiceGet(t *testing.T) {
 	inv1, err := repo.GetInvoice(context.Background(), "")
@@ -19,7 +25,9 @@ func TestInvoiceGet(t *testing.T) {
 	assert.Nil(t, inv1)
 
 	inv2 := invoice.Invoice{
-		ID: uuid.NewString(),
+		ID:        uuid.NewString(),
+		CreatedAt: now,
+		UpdatedAt: now,
 	}
 	err = repo.AddInvoice(context.Background(), inv2)
 	require.NoError(t, err)
@@ -29,25 +37,54 @@ func TestInvoiceGet(t *testing.T) {
 	assert.Equal(t, inv2, *inv3)
 }
 
+func TestInvoiceGetWithItems(t *testing.T) {
+	ctx := context.Background()
+	invoiceID := uuid.NewString()
+	addInvoices(t, repo, invoiceID)
+
+	items := []invoice.Item{
+		{ID: "1", InvoiceID: invoiceID, CreatedAt: now, UpdatedAt: now},
+		{ID: "2", InvoiceID: invoiceID, CreatedAt: now, UpdatedAt: now},
+	}
+	for _, item := range items {
+		require.NoError(t, repo.AddItem(ctx, item))
+	}
+	require.NoError(t, repo.DeleteItem(ctx, invoiceID, "2"))
+
+	inv, err := repo.GetInvoice(ctx, invoiceID)
+	require.NoError(t, err)
+	assert.Equal(t, items[:1], inv.Items)
+
+	inv, err = repo.GetInvoice(ctx, invoiceID, invoice.IncludeDeleted())
+	require.NoError(t, err)
+	assert.Len(t, inv.Items, 2)
+}
+
 func TestItemGet(t *testing.T) {
 	item1, err := repo.GetItem(context.Background(), "", "")
 	require.NoError(t, err)
 	assert.Nil(t, item1)
 
+	invoiceID := uuid.NewString()
+	addInvoices(t, repo, invoiceID)
+
 	item2 := invoice.Item{
-		ID: uuid.NewString(),
+		ID:        uuid.NewString(),
+		InvoiceID: invoiceID,
+		CreatedAt: now,
+		UpdatedAt: now,
 	}
 	err = repo.AddItem(context.Background(), item2)
 	require.NoError(t, err)
 
-	item3, err := repo.GetItem(context.Background(), "", item2.ID)
+	item3, err := repo.GetItem(context.Background(), invoiceID, item2.ID)
 	require.NoError(t, err)
 	assert.Equal(t, item2, *item3)
 
-	err = repo.DeleteItem(context.Background(), "", item2.ID)
+	err = repo.DeleteItem(context.Background(), invoiceID, item2.ID)
 	require.NoError(t, err)
 
-	item4, err := repo.GetItem(context.Background(), "", item2.ID)
+	item4, err := repo.GetItem(context.Background(), invoiceID, item2.ID)
 	require.NoError(t, err)
 	assert.Nil(t, item4)
 }
@@ -71,6 +108,7 @@ func TestItemsScanners(t *testing.T) {
 		},
 	}
 
+	addInvoices(t, repo, "1", "2")
 	for _, item := range itms {
 		err := repo.AddItem(context.Background(), item)
 		require.NoError(t, err)
@@ -110,3 +148,412 @@ func TestItemsScanners(t *testing.T) {
 		assert.Empty(t, items)
 	})
 }
+
+func TestItemsIterators(t *testing.T) {
+	repo := memory.NewRepository()
+	itms := []invoice.Item{
+		{ID: uuid.NewString(), InvoiceID: "1", Status: invoice.New},
+		{ID: uuid.NewString(), InvoiceID: "1", Status: invoice.Pending},
+		{ID: uuid.NewString(), InvoiceID: "2", Status: invoice.New},
+	}
+
+	addInvoices(t, repo, "1", "2")
+	for _, item := range itms {
+		err := repo.AddItem(context.Background(), item)
+		require.NoError(t, err)
+	}
+
+	t.Run("iterates items by status", func(t *testing.T) {
+		it := repo.IterateItemsByStatus(context.Background(), invoice.New)
+		defer it.Close()
+
+		var ids []string
+		for it.Next() {
+			assert.Equal(t, invoice.New, it.Item().Status)
+			ids = append(ids, it.Item().ID)
+		}
+		require.NoError(t, it.Err())
+		assert.ElementsMatch(t, []string{itms[0].ID, itms[2].ID}, ids)
+	})
+
+	t.Run("iterates invoice items", func(t *testing.T) {
+		it := repo.IterateInvoiceItems(context.Background(), "1")
+		defer it.Close()
+
+		n := 0
+		for it.Next() {
+			n++
+		}
+		require.NoError(t, it.Err())
+		assert.Equal(t, 2, n)
+	})
+
+	t.Run("stops when context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		it := repo.IterateInvoiceItems(ctx, "1")
+		defer it.Close()
+
+		cancel()
+		assert.False(t, it.Next())
+		assert.ErrorIs(t, it.Err(), context.Canceled)
+	})
+}
+
+func TestInvoiceHistory(t *testing.T) {
+	repo := memory.NewRepository()
+	ctx := invoice.WithActor(context.Background(), "auditor")
+
+	inv := invoice.Invoice{
+		ID: uuid.NewString(),
+		Items: []invoice.Item{
+			{ID: uuid.NewString(), Status: invoice.New},
+		},
+	}
+	inv.Items[0].InvoiceID = inv.ID
+
+	err := repo.AddInvoice(ctx, inv)
+	require.NoError(t, err)
+
+	item := invoice.Item{ID: uuid.NewString(), InvoiceID: inv.ID, Status: invoice.New}
+	err = repo.AddItem(ctx, item)
+	require.NoError(t, err)
+
+	err = repo.UpdateInvoiceItemStatus(ctx, inv.ID, item.ID, invoice.Cancelled)
+	require.NoError(t, err)
+
+	err = repo.DeleteItem(ctx, inv.ID, inv.Items[0].ID)
+	require.NoError(t, err)
+
+	changes, err := repo.GetInvoiceHistory(ctx, inv.ID)
+	require.NoError(t, err)
+	require.Len(t, changes, 4)
+
+	ops := make([]invoice.Operation, len(changes))
+	for idx, change := range changes {
+		ops[idx] = change.Operation
+		assert.Equal(t, "auditor", change.Actor)
+		assert.Equal(t, inv.ID, change.InvoiceID)
+	}
+	assert.Equal(t, []invoice.Operation{
+		invoice.OpAddInvoice,
+		invoice.OpAddItem,
+		invoice.OpUpdateItemStatus,
+		invoice.OpDeleteItem,
+	}, ops)
+
+	statusChange := changes[2]
+	assert.Equal(t, invoice.New, statusChange.Before.Items[0].Status)
+	assert.Equal(t, invoice.Cancelled, statusChange.After.Items[0].Status)
+
+	deletion := changes[3]
+	assert.Equal(t, inv.Items[0].ID, deletion.Before.Items[0].ID)
+	require.Len(t, deletion.After.Items, 1)
+	assert.True(t, deletion.After.Items[0].Deleted())
+	assert.Equal(t, "auditor", deletion.After.Items[0].DeletedBy)
+
+	changes, err = repo.GetInvoiceHistory(ctx, uuid.NewString())
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+}
+
+func TestSoftDelete(t *testing.T) {
+	repo := memory.NewRepository()
+	ctx := invoice.WithActor(context.Background(), "auditor")
+
+	item := invoice.Item{ID: uuid.NewString(), InvoiceID: uuid.NewString(), Status: invoice.New}
+	addInvoices(t, repo, item.InvoiceID)
+	err := repo.AddItem(ctx, item)
+	require.NoError(t, err)
+
+	err = repo.DeleteItem(ctx, item.InvoiceID, item.ID)
+	require.NoError(t, err)
+
+	got, err := repo.GetItem(ctx, item.InvoiceID, item.ID)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+
+	items, err := repo.GetInvoiceItems(ctx, item.InvoiceID)
+	require.NoError(t, err)
+	assert.Empty(t, items)
+
+	items, err = repo.GetItemsByStatus(ctx, invoice.New, invoice.IncludeDeleted())
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, "auditor", items[0].DeletedBy)
+
+	err = repo.UpdateItem(ctx, item.InvoiceID, item.ID, invoice.ItemUpdate{})
+	assert.ErrorIs(t, err, invoice.ErrItemNotFound)
+
+	err = repo.RestoreItem(ctx, item.InvoiceID, item.ID)
+	require.NoError(t, err)
+
+	got, err = repo.GetItem(ctx, item.InvoiceID, item.ID)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.False(t, got.Deleted())
+	assert.Equal(t, uint(2), got.Version)
+
+	err = repo.RestoreItem(ctx, item.InvoiceID, uuid.NewString())
+	assert.ErrorIs(t, err, invoice.ErrItemNotFound)
+}
+
+func TestReplaceItemsTimestamps(t *testing.T) {
+	current := now
+	clock := invoice.ClockFunc(func() time.Time { return current })
+	repo := memory.NewRepository(memory.WithClock(clock))
+	ctx := context.Background()
+
+	old := invoice.Item{ID: uuid.NewString(), InvoiceID: uuid.NewString(), Status: invoice.New}
+	addInvoices(t, repo, old.InvoiceID)
+	err := repo.AddItem(ctx, old)
+	require.NoError(t, err)
+
+	current = now.Add(time.Hour)
+	replacement := invoice.Item{ID: uuid.NewString(), InvoiceID: old.InvoiceID, Status: invoice.New}
+	err = repo.ReplaceItems(ctx, old.InvoiceID, []string{old.ID}, []invoice.Item{replacement})
+	require.NoError(t, err)
+
+	cancelled, err := repo.GetItem(ctx, old.InvoiceID, old.ID)
+	require.NoError(t, err)
+	assert.Equal(t, now, cancelled.CreatedAt)
+	assert.Equal(t, current, cancelled.UpdatedAt)
+
+	added, err := repo.GetItem(ctx, old.InvoiceID, replacement.ID)
+	require.NoError(t, err)
+	assert.Equal(t, current, added.CreatedAt)
+	assert.Equal(t, current, added.UpdatedAt)
+}
+
+func TestInvoiceTotals(t *testing.T) {
+	current := now
+	clock := invoice.ClockFunc(func() time.Time { return current })
+	repo := memory.NewRepository(memory.WithClock(clock))
+	ctx := context.Background()
+
+	inv := invoice.Invoice{ID: uuid.NewString()}
+	inv.Items = []invoice.Item{
+		{ID: uuid.NewString(), InvoiceID: inv.ID, Price: 100, Qty: 2, Status: invoice.New},
+	}
+	err := repo.AddInvoice(ctx, inv)
+	require.NoError(t, err)
+
+	assertInvoice := func(count, total uint, updatedAt time.Time) {
+		t.Helper()
+		got, err := repo.GetInvoice(ctx, inv.ID)
+		require.NoError(t, err)
+		assert.Equal(t, count, got.ItemCount)
+		assert.Equal(t, total, got.Total)
+		assert.Equal(t, updatedAt, got.UpdatedAt)
+	}
+	assertInvoice(1, 200, now)
+
+	current = now.Add(time.Minute)
+	item := invoice.Item{ID: uuid.NewString(), InvoiceID: inv.ID, Price: 50, Qty: 1, Status: invoice.New}
+	err = repo.AddItem(ctx, item)
+	require.NoError(t, err)
+	assertInvoice(2, 250, current)
+
+	current = now.Add(2 * time.Minute)
+	err = repo.UpdateInvoiceItemStatus(ctx, inv.ID, inv.Items[0].ID, invoice.Cancelled)
+	require.NoError(t, err)
+	assertInvoice(1, 50, current)
+
+	current = now.Add(3 * time.Minute)
+	err = repo.DeleteItem(ctx, inv.ID, item.ID)
+	require.NoError(t, err)
+	assertInvoice(0, 0, current)
+
+	err = repo.AddItem(ctx, invoice.Item{ID: uuid.NewString(), InvoiceID: uuid.NewString()})
+	assert.ErrorIs(t, err, invoice.ErrInvoiceNotFound)
+}
+
+func TestItemsOfInvoices(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewRepository(memory.WithClock(invoice.FixedClock(now)))
+
+	a := invoice.Invoice{ID: uuid.NewString()}
+	b := invoice.Invoice{ID: uuid.NewString()}
+	b.Items = []invoice.Item{{ID: "i1", InvoiceID: b.ID, Price: 10, Qty: 1, Status: invoice.New}}
+	require.NoError(t, repo.AddInvoice(ctx, a))
+	require.NoError(t, repo.AddInvoice(ctx, b))
+
+	require.NoError(t, repo.UpdateInvoiceItemStatus(ctx, a.ID, "i1", invoice.Cancelled))
+	err := repo.UpdateItem(ctx, a.ID, "i1", invoice.ItemUpdate{})
+	assert.ErrorIs(t, err, invoice.ErrItemNotFound, "item of other invoice")
+	got, err := repo.GetInvoice(ctx, b.ID)
+	require.NoError(t, err)
+	assert.Equal(t, uint(10), got.Total)
+	require.Len(t, got.Items, 1)
+	assert.Equal(t, invoice.New, got.Items[0].Status)
+
+	item := invoice.Item{ID: "i1", InvoiceID: a.ID, Price: 5, Qty: 1, Status: invoice.New}
+	require.NoError(t, repo.AddItem(ctx, item), "item IDs are unique within invoice")
+	assert.ErrorIs(t, repo.AddItem(ctx, item), invoice.ErrItemConflict)
+
+	restored := memory.NewRepository(memory.WithState(repo.State()))
+	for _, inv := range []invoice.Invoice{a, b} {
+		items, err := restored.GetInvoiceItems(ctx, inv.ID)
+		require.NoError(t, err)
+		assert.Len(t, items, 1, "items with the same ID are kept on load")
+	}
+}
+
+func addInvoices(t *testing.T, repo *memory.Repository, invoiceIDs ...string) {
+	t.Helper()
+	for _, invoiceID := range invoiceIDs {
+		err := repo.AddInvoice(context.Background(), invoice.Invoice{ID: invoiceID})
+		require.NoError(t, err)
+	}
+}
+
+// creditNote credits one unit of the first item of the invoice.
+func creditNote(inv invoice.Invoice) invoice.CreditNote {
+	return invoice.CreditNote{
+		ID:        uuid.NewString(),
+		InvoiceID: inv.ID,
+		Lines:     []invoice.CreditNoteLine{{ItemID: inv.Items[0].ID, Qty: 1}},
+	}
+}
+
+func TestListOrder(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewRepository(memory.WithClock(invoice.FixedClock(now)))
+
+	inv := invoice.Invoice{ID: uuid.NewString()}
+	inv.Items = []invoice.Item{{ID: uuid.NewString(), InvoiceID: inv.ID, Price: 10, Qty: 5, Status: invoice.New}}
+	require.NoError(t, repo.AddInvoice(ctx, inv))
+
+	for _, p := range []struct {
+		id   string
+		days int
+	}{{"p4", 2}, {"p3", 1}, {"p1", 0}, {"p2", 1}} {
+		require.NoError(t, repo.AddPayment(ctx, invoice.Payment{
+			ID: p.id, InvoiceID: inv.ID, Amount: 1, ReceivedAt: now.AddDate(0, 0, p.days),
+		}))
+
+		cn := creditNote(inv)
+		cn.ID = "c" + p.id[1:]
+		cn.Date = now.AddDate(0, 0, p.days)
+		_, err := repo.AddCreditNote(ctx, cn)
+		require.NoError(t, err)
+	}
+
+	payments, err := repo.GetPayments(ctx, inv.ID)
+	require.NoError(t, err)
+	var ids []string
+	for _, p := range payments {
+		ids = append(ids, p.ID)
+	}
+	assert.Equal(t, []string{"p1", "p2", "p3", "p4"}, ids, "by receive time, then ID")
+
+	creditNotes, err := repo.GetCreditNotes(ctx, inv.ID)
+	require.NoError(t, err)
+	ids = nil
+	for _, cn := range creditNotes {
+		ids = append(ids, cn.ID)
+	}
+	assert.Equal(t, []string{"c1", "c2", "c3", "c4"}, ids, "by issue date, then ID")
+}
+
+func TestSaveLoad(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewRepository(memory.WithClock(invoice.FixedClock(now)))
+
+	inv := invoice.Invoice{ID: uuid.NewString()}
+	inv.Items = []invoice.Item{{ID: uuid.NewString(), InvoiceID: inv.ID, Price: 10, Qty: 2, Status: invoice.New}}
+	require.NoError(t, repo.AddInvoice(ctx, inv))
+	require.NoError(t, repo.AddPayment(ctx, invoice.Payment{ID: uuid.NewString(), InvoiceID: inv.ID, Amount: 5}))
+	_, err := repo.AddCreditNote(ctx, creditNote(inv))
+	require.NoError(t, err)
+
+	for _, name := range []string{"state.json", "state.gob"} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			file := filepath.Join(dir, name)
+			require.NoError(t, repo.Save(file))
+			require.NoError(t, repo.Save(file), "save replaces the existing file")
+
+			files, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			assert.Len(t, files, 1, "temporary files are removed")
+
+			loaded := memory.NewRepository()
+			require.NoError(t, loaded.Load(file))
+			assert.Equal(t, repo.State(), loaded.State())
+
+			cn, err := loaded.AddCreditNote(ctx, creditNote(inv))
+			require.NoError(t, err)
+			assert.Equal(t, "2", cn.Number, "credit note numbers continue")
+		})
+	}
+
+	restored := memory.NewRepository(memory.WithState(repo.State()))
+	got, err := restored.GetInvoiceItems(ctx, inv.ID)
+	require.NoError(t, err)
+	assert.Len(t, got, 1)
+
+	err = memory.NewRepository().Load(filepath.Join(t.TempDir(), "missing.json"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestAutosave(t *testing.T) {
+	repo := memory.NewRepository()
+	file := filepath.Join(t.TempDir(), "state.json")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- repo.Autosave(ctx, file, time.Hour)
+	}()
+
+	addInvoices(t, repo, uuid.NewString())
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+
+	loaded := memory.NewRepository()
+	require.NoError(t, loaded.Load(file))
+	assert.Len(t, loaded.State().Invoices, 1, "state is saved when autosave stops")
+}
+
+func TestTenant(t *testing.T) {
+	acme := invoice.WithTenant(context.Background(), "acme")
+	repo := memory.NewRepository(memory.WithTenant("acme"))
+
+	inv := invoice.Invoice{ID: uuid.NewString()}
+	inv.Items = []invoice.Item{{ID: uuid.NewString(), InvoiceID: inv.ID, Price: 10, Qty: 2, Status: invoice.New}}
+	require.NoError(t, repo.AddInvoice(context.Background(), inv))
+	_, err := repo.AddCreditNote(acme, creditNote(inv))
+	require.NoError(t, err)
+
+	got, err := repo.GetInvoice(acme, inv.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, got, "bound repository stores invoices of its tenant")
+
+	globex := invoice.WithTenant(context.Background(), "globex")
+	_, err = repo.GetInvoice(globex, inv.ID)
+	assert.ErrorIs(t, err, invoice.ErrTenantMismatch)
+	err = repo.AddInvoice(globex, invoice.Invoice{ID: uuid.NewString()})
+	assert.ErrorIs(t, err, invoice.ErrTenantMismatch)
+
+	st := repo.State()
+	assert.Empty(t, st.Invoices)
+	require.Contains(t, st.Tenants, "acme")
+	assert.Len(t, st.Tenants["acme"].Invoices, 1)
+	assert.Len(t, st.Outbox, 2, "outbox is shared by tenants")
+	for _, event := range st.Outbox {
+		assert.Equal(t, "acme", event.Tenant)
+	}
+
+	for _, tenant := range []string{"", "acme#x"} {
+		_, err = memory.NewRepository().GetInvoice(invoice.WithTenant(context.Background(), tenant), inv.ID)
+		assert.ErrorIs(t, err, invoice.ErrInvalidTenant, "tenant %q", tenant)
+	}
+
+	restored := memory.NewRepository(memory.WithState(st))
+	got, err = restored.GetInvoice(acme, inv.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, got)
+	cn, err := restored.AddCreditNote(acme, creditNote(inv))
+	require.NoError(t, err)
+	assert.Equal(t, "2", cn.Number, "credit note numbers are kept per tenant")
+}
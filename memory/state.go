@@ -0,0 +1,251 @@
+package memory
+
+import (
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/antklim/go-dynamodb/invoice"
+)
+
+// State is the content of the repository. It is written to and read from
+// files by Save and Load.
+type State struct {
+	Invoices         []invoice.Invoice
+	Items            []invoice.Item
+	Payments         []invoice.Payment
+	CreditNotes      []invoice.CreditNote
+	CreditNoteNumber int // number of the last stored credit note
+	History          []invoice.Change
+	Outbox           []invoice.Event // shared by all tenants
+	// Tenants holds states of tenants without outbox, the fields above
+	// hold entities stored without tenant.
+	Tenants map[string]State `json:",omitempty"`
+}
+
+// State returns the content of the repository. Entities are sorted by their
+// IDs, changes and events keep their order. Tables are copied one by one,
+// so the state of the repository being written concurrently may be torn.
+func (r *Repository) State() State {
+	r.mu.Lock()
+	stores := make(map[string]*store, len(r.stores))
+	for tenant, s := range r.stores {
+		stores[tenant] = s
+	}
+	r.mu.Unlock()
+
+	var st State
+	if s, ok := stores[""]; ok {
+		st = s.state()
+	}
+	for tenant, s := range stores {
+		if tenant == "" {
+			continue
+		}
+		// stores of tenants which were only read are empty
+		if ts := s.state(); !reflect.DeepEqual(ts, State{}) {
+			if st.Tenants == nil {
+				st.Tenants = make(map[string]State)
+			}
+			st.Tenants[tenant] = ts
+		}
+	}
+
+	r.outb.mu.RLock()
+	st.Outbox = append(st.Outbox, r.outb.events...)
+	r.outb.mu.RUnlock()
+
+	return st
+}
+
+func (s *store) state() State {
+	var st State
+
+	s.invs.mu.RLock()
+	for _, inv := range s.invs.table {
+		st.Invoices = append(st.Invoices, inv)
+	}
+	s.invs.mu.RUnlock()
+	sort.Slice(st.Invoices, func(a, b int) bool { return st.Invoices[a].ID < st.Invoices[b].ID })
+
+	s.itms.mu.RLock()
+	for _, item := range s.itms.table {
+		st.Items = append(st.Items, item)
+	}
+	s.itms.mu.RUnlock()
+	sort.Slice(st.Items, func(a, b int) bool {
+		if st.Items[a].InvoiceID != st.Items[b].InvoiceID {
+			return st.Items[a].InvoiceID < st.Items[b].InvoiceID
+		}
+		return st.Items[a].ID < st.Items[b].ID
+	})
+
+	s.pays.mu.RLock()
+	for _, p := range s.pays.table {
+		st.Payments = append(st.Payments, p)
+	}
+	s.pays.mu.RUnlock()
+	sort.Slice(st.Payments, func(a, b int) bool { return st.Payments[a].ID < st.Payments[b].ID })
+
+	s.crns.mu.RLock()
+	for _, cn := range s.crns.table {
+		st.CreditNotes = append(st.CreditNotes, cn)
+	}
+	st.CreditNoteNumber = s.crns.number
+	s.crns.mu.RUnlock()
+	sort.Slice(st.CreditNotes, func(a, b int) bool { return st.CreditNotes[a].ID < st.CreditNotes[b].ID })
+
+	s.hist.mu.RLock()
+	invoiceIDs := make([]string, 0, len(s.hist.table))
+	for invoiceID := range s.hist.table {
+		invoiceIDs = append(invoiceIDs, invoiceID)
+	}
+	sort.Strings(invoiceIDs)
+	for _, invoiceID := range invoiceIDs {
+		st.History = append(st.History, s.hist.table[invoiceID]...)
+	}
+	s.hist.mu.RUnlock()
+
+	return st
+}
+
+// Restore replaces the content of the repository with the state.
+func (r *Repository) Restore(st State) {
+	stores := map[string]*store{"": newStore("", st)}
+	for tenant, ts := range st.Tenants {
+		stores[tenant] = newStore(tenant, ts)
+	}
+
+	r.mu.Lock()
+	r.stores = stores
+	r.mu.Unlock()
+
+	r.outb.mu.Lock()
+	r.outb.events = append([]invoice.Event(nil), st.Outbox...)
+	r.outb.mu.Unlock()
+}
+
+// newStore creates the store of tenant entities of the state.
+func newStore(tenant string, st State) *store {
+	s := &store{tenant: tenant}
+
+	s.invs.table = make(map[string]invoice.Invoice, len(st.Invoices))
+	for _, inv := range st.Invoices {
+		s.invs.table[inv.ID] = inv
+	}
+
+	s.itms.table = make(map[itemKey]invoice.Item, len(st.Items))
+	for _, item := range st.Items {
+		s.itms.table[keyOf(item)] = item
+	}
+
+	s.pays.table = make(map[string]invoice.Payment, len(st.Payments))
+	for _, p := range st.Payments {
+		s.pays.table[p.ID] = p
+	}
+
+	s.crns.table = make(map[string]invoice.CreditNote, len(st.CreditNotes))
+	for _, cn := range st.CreditNotes {
+		s.crns.table[cn.ID] = cn
+	}
+	s.crns.number = st.CreditNoteNumber
+
+	s.hist.table = make(map[string][]invoice.Change)
+	for _, change := range st.History {
+		s.hist.table[change.InvoiceID] = append(s.hist.table[change.InvoiceID], change)
+	}
+
+	return s
+}
+
+// Save writes the state of the repository to the file. Files with .gob
+// extension are gob encoded, other files are JSON. The file is replaced
+// atomically: the state is written to a temporary file in the same
+// directory which is then renamed.
+func (r *Repository) Save(file string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after successful rename
+
+	if err := encodeState(tmp, file, r.State()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), file)
+}
+
+// Load replaces the content of the repository with the state read from the
+// file written by Save.
+func (r *Repository) Load(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var s State
+	if isGob(file) {
+		err = gob.NewDecoder(f).Decode(&s)
+	} else {
+		err = json.NewDecoder(f).Decode(&s)
+	}
+	if err != nil {
+		return err
+	}
+
+	r.Restore(s)
+	return nil
+}
+
+// Autosave saves the repository to the file every interval until ctx is
+// done, then saves it the last time. It returns the first save error, or
+// the context error after the last save.
+func (r *Repository) Autosave(ctx context.Context, file string, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := r.Save(file); err != nil {
+				return err
+			}
+			return ctx.Err()
+		case <-ticker.C:
+			if err := r.Save(file); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func encodeState(w io.Writer, file string, s State) error {
+	if isGob(file) {
+		return gob.NewEncoder(w).Encode(s)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+func isGob(file string) bool {
+	return filepath.Ext(file) == ".gob"
+}
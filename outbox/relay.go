@@ -0,0 +1,136 @@
+// Package outbox relays invoice domain events written to the transactional
+// outbox to downstream systems.
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/antklim/go-dynamodb/invoice"
+)
+
+const (
+	defaultBatchSize = 25
+	defaultInterval  = time.Second
+)
+
+// Store provides access to the events written to the outbox.
+type Store interface {
+	PendingEvents(ctx context.Context, limit int) ([]invoice.Event, error) // returns oldest events first
+	AckEvent(context.Context, invoice.Event) error                         // removes published event from the outbox
+}
+
+// Publisher publishes events to downstream systems.
+type Publisher interface {
+	Publish(context.Context, invoice.Event) error
+}
+
+// PublisherFunc is an adapter to allow the use of ordinary functions as publishers.
+type PublisherFunc func(context.Context, invoice.Event) error
+
+// Publish calls f(ctx, event).
+func (f PublisherFunc) Publish(ctx context.Context, event invoice.Event) error {
+	return f(ctx, event)
+}
+
+// Option configures relay.
+type Option func(*Relay)
+
+// WithBatchSize sets the number of events read from the outbox at once.
+// Values below 1 are ignored.
+func WithBatchSize(n int) Option {
+	return func(r *Relay) {
+		if n > 0 {
+			r.batchSize = n
+		}
+	}
+}
+
+// WithInterval sets the polling interval of Run.
+func WithInterval(d time.Duration) Option {
+	return func(r *Relay) {
+		r.interval = d
+	}
+}
+
+// WithErrorHandler sets the function called with errors of Run iterations.
+func WithErrorHandler(f func(error)) Option {
+	return func(r *Relay) {
+		r.onError = f
+	}
+}
+
+// Relay reads events from the outbox and publishes them. An event is removed
+// from the outbox only after it was published, so every event is delivered
+// at least once. Publishers should be ready to receive duplicates.
+type Relay struct {
+	store     Store
+	pub       Publisher
+	batchSize int
+	interval  time.Duration
+	onError   func(error)
+}
+
+// NewRelay creates a new instance of outbox relay
+func NewRelay(store Store, pub Publisher, opts ...Option) *Relay {
+	r := &Relay{
+		store:     store,
+		pub:       pub,
+		batchSize: defaultBatchSize,
+		interval:  defaultInterval,
+		onError:   func(error) {},
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// Process publishes pending events in the order they were written. It stops
+// on the first failed event to preserve ordering, the event is retried by the
+// next call. It returns the number of published events.
+func (r *Relay) Process(ctx context.Context) (int, error) {
+	published := 0
+	for {
+		events, err := r.store.PendingEvents(ctx, r.batchSize)
+		if err != nil {
+			return published, err
+		}
+
+		for _, event := range events {
+			if err := r.pub.Publish(ctx, event); err != nil {
+				return published, err
+			}
+
+			if err := r.store.AckEvent(ctx, event); err != nil {
+				return published, err
+			}
+			published++
+		}
+
+		if len(events) < r.batchSize {
+			return published, nil
+		}
+	}
+}
+
+// Run processes the outbox every interval until ctx is done. Failures are
+// reported to the error handler and retried on the next tick.
+func (r *Relay) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.Process(ctx); err != nil && ctx.Err() == nil {
+			r.onError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
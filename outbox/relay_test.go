@@ -0,0 +1,92 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/antklim/go-dynamodb/invoice"
+	"github.com/antklim/go-dynamodb/memory"
+	"github.com/antklim/go-dynamodb/outbox"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelay(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewRepository()
+
+	inv := invoice.Invoice{ID: uuid.NewString()}
+	require.NoError(t, repo.AddInvoice(ctx, inv))
+
+	item := invoice.Item{ID: uuid.NewString(), InvoiceID: inv.ID, Status: invoice.New}
+	require.NoError(t, repo.AddItem(ctx, item))
+	require.NoError(t, repo.UpdateInvoiceItemStatus(ctx, inv.ID, item.ID, invoice.Pending))
+	require.NoError(t, repo.UpdateInvoiceItemStatus(ctx, inv.ID, item.ID, invoice.Cancelled))
+
+	t.Run("stops on publish failure and keeps event", func(t *testing.T) {
+		errPublish := errors.New("publish failed")
+		pub := outbox.PublisherFunc(func(context.Context, invoice.Event) error {
+			return errPublish
+		})
+
+		n, err := outbox.NewRelay(repo, pub).Process(ctx)
+		assert.ErrorIs(t, err, errPublish)
+		assert.Zero(t, n)
+
+		events, err := repo.PendingEvents(ctx, 10)
+		require.NoError(t, err)
+		assert.Len(t, events, 3)
+	})
+
+	t.Run("publishes events in order and acknowledges them", func(t *testing.T) {
+		var published []invoice.EventType
+		pub := outbox.PublisherFunc(func(_ context.Context, event invoice.Event) error {
+			published = append(published, event.Type)
+			return nil
+		})
+
+		n, err := outbox.NewRelay(repo, pub, outbox.WithBatchSize(2)).Process(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+		assert.Equal(t, []invoice.EventType{
+			invoice.InvoiceCreated,
+			invoice.ItemAdded,
+			invoice.ItemsCancelled,
+		}, published)
+
+		events, err := repo.PendingEvents(ctx, 10)
+		require.NoError(t, err)
+		assert.Empty(t, events)
+	})
+	t.Run("ignores batch size below one", func(t *testing.T) {
+		require.NoError(t, repo.AddItem(ctx, invoice.Item{ID: uuid.NewString(), InvoiceID: inv.ID}))
+
+		pub := outbox.PublisherFunc(func(context.Context, invoice.Event) error { return nil })
+		for _, size := range []int{0, -1} {
+			done := make(chan struct{})
+			var (
+				n   int
+				err error
+			)
+			go func() {
+				defer close(done)
+				n, err = outbox.NewRelay(repo, pub, outbox.WithBatchSize(size)).Process(ctx)
+			}()
+
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatalf("process with batch size %d does not return", size)
+			}
+			require.NoError(t, err)
+			assert.LessOrEqual(t, n, 1)
+		}
+
+		events, err := repo.PendingEvents(ctx, 10)
+		require.NoError(t, err)
+		assert.Empty(t, events)
+	})
+}